@@ -0,0 +1,127 @@
+package actions
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/gobuffalo/envy"
+	"github.com/gobuffalo/pop/v6"
+	"github.com/jbhicks/sound-cistern/models"
+	"github.com/jbhicks/sound-cistern/pkg/logging"
+	"github.com/jbhicks/sound-cistern/src/services"
+)
+
+// AccountsIndex lists the Soundcloud accounts linked to the current user
+// with when each last synced and which need to be connected again
+func AccountsIndex(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	user := c.Value("current_user").(*models.User)
+
+	accounts, err := services.NewAccountService(tx).Accounts(user.ID.String())
+	if err != nil {
+		logging.Error("Error loading Soundcloud accounts", err, logging.Fields{"user_id": user.ID.String()})
+		return c.Error(http.StatusInternalServerError, errors.New("failed to load accounts"))
+	}
+
+	c.Set("accounts", accounts)
+	return c.Render(http.StatusOK, r.HTML("accounts/index.html"))
+}
+
+// AccountsDisconnect renders the confirmation for disconnecting an
+// account, listing what will be erased, into the accounts page's modal or
+// as a page of its own without HTMX
+func AccountsDisconnect(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	user := c.Value("current_user").(*models.User)
+
+	erasure, err := services.NewAccountService(tx).PlanDisconnect(user.ID.String(), c.Param("account_id"))
+	if err != nil {
+		return c.Error(http.StatusNotFound, err)
+	}
+
+	c.Set("erasure", erasure)
+	if IsHTMX(c.Request()) {
+		return c.Render(http.StatusOK, rHTMX.HTML("accounts/_disconnect.html"))
+	}
+	return c.Render(http.StatusOK, r.HTML("accounts/disconnect.html"))
+}
+
+// AccountsDestroy disconnects a Soundcloud account: it erases the
+// account's stored tracks, presets filtered to it and playback of the
+// erased tracks, then revokes its token with Soundcloud
+func AccountsDestroy(c buffalo.Context) error {
+	user := c.Value("current_user").(*models.User)
+
+	// Commit the erasure before revoking the token, so a failed erasure
+	// leaves a working account behind
+	var erasure *services.Erasure
+	err := models.DB.Transaction(func(tx *pop.Connection) error {
+		var err error
+		erasure, err = services.NewAccountService(tx).Disconnect(requestContext(c), user.ID.String(), c.Param("account_id"))
+		return err
+	})
+	if errors.Is(err, services.ErrAccountNotFound) {
+		return c.Error(http.StatusNotFound, err)
+	}
+	if err != nil {
+		logging.Error("Error disconnecting Soundcloud account", err, logging.Fields{"user_id": user.ID.String()})
+		return c.Error(http.StatusInternalServerError, errors.New("failed to disconnect account"))
+	}
+	account := erasure.Account
+
+	revoked := true
+	if err := newSoundcloudService().RevokeToken(requestContext(c), account.AccessToken); err != nil {
+		revoked = false
+		logging.Warn("Could not revoke Soundcloud token", logging.Fields{"account_id": account.ID.String(), "error": err.Error()})
+	}
+
+	// Stop the session using the disconnected account's token
+	if token, _ := c.Session().Get("soundcloud_access_token").(string); token == account.AccessToken {
+		remaining, err := services.NewAccountService(models.DB).Accounts(user.ID.String())
+		if err == nil && len(remaining) > 0 {
+			c.Session().Set("soundcloud_access_token", remaining[0].AccessToken)
+			c.Session().Set("soundcloud_user_id", remaining[0].SoundcloudID)
+		} else {
+			c.Session().Delete("soundcloud_access_token")
+			c.Session().Delete("soundcloud_user_id")
+		}
+	}
+
+	fields := logging.Fields{
+		"user_id":       user.ID.String(),
+		"account_id":    account.ID.String(),
+		"soundcloud_id": account.SoundcloudID,
+		"last_account":  erasure.Last,
+		"token_revoked": revoked,
+		"tracks":        erasure.Tracks,
+		"presets":       erasure.Presets,
+		"listens":       erasure.Listens,
+		"queue_items":   erasure.QueueItems,
+		"followings":    erasure.Followings,
+	}
+	logging.Audit("soundcloud_account_disconnect", fields)
+	logging.UserAction(c, user.Email, "soundcloud_account_disconnect", "Disconnected a Soundcloud account and erased its data", fields)
+
+	c.Flash().Add("success", fmt.Sprintf("Disconnected %s and erased %d tracks", account.DisplayName(), erasure.Tracks))
+	return c.Redirect(http.StatusSeeOther, "/accounts")
+}
+
+// tokenEncryptionKeys encrypts stored Soundcloud tokens: comma-separated
+// id:base64-key pairs, newest first. Older keys stay listed until
+// tokens:rotate has re-encrypted everything under the newest.
+var tokenEncryptionKeys = envy.Get("TOKEN_ENCRYPTION_KEYS", "")
+
+// useTokenEncryption configures encryption of stored tokens. Tokens are
+// only stored unencrypted when no keys are configured at all; invalid keys
+// are an error rather than a reason to fall back to plain text.
+func useTokenEncryption() error {
+	if tokenEncryptionKeys == "" {
+		if ENV == "production" {
+			logging.Warn("TOKEN_ENCRYPTION_KEYS is not set, Soundcloud tokens are stored unencrypted", logging.Fields{})
+		}
+		return nil
+	}
+	return services.UseTokenKeys(tokenEncryptionKeys)
+}
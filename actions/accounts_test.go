@@ -0,0 +1,269 @@
+package actions
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	srcmodels "github.com/jbhicks/sound-cistern/src/models"
+	"github.com/jbhicks/sound-cistern/src/services"
+)
+
+func (as *ActionSuite) Test_AccountsIndex_PromptsReauth() {
+	user := as.createAndLoginUser("accounts@example.com", "user")
+	accountService := services.NewAccountService(as.DB)
+	_, err := accountService.SaveAccount(user.ID.String(), "111", "personal", "token-a")
+	as.NoError(err)
+	label, err := accountService.SaveAccount(user.ID.String(), "222", "label-crew", "token-b")
+	as.NoError(err)
+	as.NoError(accountService.RecordFailure(label, services.ErrUnauthorized))
+
+	res := as.HTML("/accounts").Get()
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), "personal")
+	as.Contains(res.Body.String(), "label-crew")
+	as.Contains(res.Body.String(), "Reconnect")
+
+	token, err := accountService.Token(user.ID.String())
+	as.NoError(err)
+	as.Equal("token-a", token)
+}
+
+// rejectedToken answers every Soundcloud call as it does for an expired or
+// revoked token
+type rejectedToken struct{}
+
+func (rejectedToken) RoundTrip(req *http.Request) (*http.Response, error) {
+	return &http.Response{
+		StatusCode: http.StatusUnauthorized,
+		Header:     http.Header{},
+		Body:       io.NopCloser(strings.NewReader(`{"error":"invalid_token"}`)),
+		Request:    req,
+	}, nil
+}
+
+func (as *ActionSuite) Test_FeedIndex_FlagsRejectedOnlyAccount() {
+	defer services.UseSoundcloudTransport(services.UseSoundcloudTransport(rejectedToken{}))
+
+	user := as.createAndLoginUser("expired@example.com", "user")
+	accountService := services.NewAccountService(as.DB)
+	account, err := accountService.SaveLink(user.ID.String(), "333", "expired-token")
+	as.NoError(err)
+	as.Session.Set("soundcloud_access_token", "expired-token")
+
+	res := as.HTML("/feed").Get()
+	as.Equal(http.StatusInternalServerError, res.Code)
+
+	// The failed sync rolled back, but not the record of why it failed
+	account, err = accountService.Account(user.ID.String(), account.ID.String())
+	as.NoError(err)
+	as.True(account.NeedsReauth)
+	as.True(account.LastError.Valid)
+}
+
+func (as *ActionSuite) Test_FeedIndex_FiltersByAccount() {
+	user := as.createAndLoginUser("merged@example.com", "user")
+	accountService := services.NewAccountService(as.DB)
+	personal, err := accountService.SaveAccount(user.ID.String(), "111", "personal", "token")
+	as.NoError(err)
+	label, err := accountService.SaveAccount(user.ID.String(), "222", "label-crew", "token-b")
+	as.NoError(err)
+
+	feedService := services.NewFeedService(as.DB)
+	_, err = feedService.StoreAccountTracks(context.Background(), personal, []interface{}{
+		map[string]interface{}{"id": float64(1), "title": "Shared track", "duration": float64(300000)},
+		map[string]interface{}{"id": float64(2), "title": "Personal pick", "duration": float64(300000)},
+	})
+	as.NoError(err)
+	_, err = feedService.StoreAccountTracks(context.Background(), label, []interface{}{
+		map[string]interface{}{"id": float64(1), "title": "Shared track", "duration": float64(300000)},
+		map[string]interface{}{"id": float64(3), "title": "Label release", "duration": float64(300000)},
+	})
+	as.NoError(err)
+	as.Session.Set("soundcloud_access_token", "token")
+
+	res := as.HTML("/feed").Get()
+	as.Equal(http.StatusOK, res.Code)
+	as.Equal(1, strings.Count(res.Body.String(), ">Shared track<"))
+	as.Contains(res.Body.String(), "via label-crew")
+
+	res = as.HTML("/feed?accounts=" + label.ID.String()).Get()
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), "Label release")
+	as.Contains(res.Body.String(), "Shared track")
+	as.NotContains(res.Body.String(), "Personal pick")
+}
+
+func (as *ActionSuite) Test_SeedUser_StoresDemoData() {
+	user := as.createAndLoginUser("seeded@example.com", "user")
+	seed := services.SeedUser{
+		Accounts:  []services.SeedAccount{{SoundcloudID: "6000990", Username: "seeded_listener"}},
+		Presets:   []services.SeedPreset{{Title: "Long mixes", Filters: "kind=mix&sort=longest"}},
+		Bookmarks: []services.SeedBookmark{{Label: "Favourites", Color: "#e8590c", Limit: 5, Note: "Play this out"}},
+	}
+	seeder := services.NewSeedService(as.DB, services.NewDemoGenerator(services.DefaultDemoSeed, time.Now()))
+	result, err := seeder.SeedUser(context.Background(), user.ID.String(), seed)
+	as.NoError(err)
+	as.Equal(1, result.Accounts)
+	as.Equal(1, result.Presets)
+	as.Equal(5, result.Bookmarks)
+	as.Greater(result.Tracks, 0)
+
+	// Seeding again refreshes instead of duplicating
+	again, err := seeder.SeedUser(context.Background(), user.ID.String(), seed)
+	as.NoError(err)
+	as.Equal(0, again.Presets)
+
+	res := as.HTML("/accounts").Get()
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), "seeded_listener")
+}
+
+func (as *ActionSuite) Test_AccountTokens_AreEncryptedAtRest() {
+	key, err := services.NewTokenKey()
+	as.NoError(err)
+	as.NoError(services.UseTokenKeys("k1:" + key))
+	defer services.UseTokenKeys("")
+
+	user := as.createAndLoginUser("encrypted@example.com", "user")
+	accountService := services.NewAccountService(as.DB)
+	account, err := accountService.SaveAccount(user.ID.String(), "111", "personal", "live-token")
+	as.NoError(err)
+	as.Equal("live-token", account.AccessToken)
+
+	var stored []string
+	as.NoError(as.DB.RawQuery("SELECT access_token FROM soundcloud_accounts WHERE id = ? UNION ALL SELECT access_token FROM soundcloud_users WHERE id = ?", account.ID, user.ID).All(&stored))
+	as.Len(stored, 2)
+	for _, value := range stored {
+		as.True(services.TokenEncrypted(value))
+		as.NotContains(value, "live-token")
+	}
+
+	token, err := accountService.Token(user.ID.String())
+	as.NoError(err)
+	as.Equal("live-token", token)
+
+	// Rotating to a new key keeps the old one readable until re-encrypted
+	newKey, err := services.NewTokenKey()
+	as.NoError(err)
+	as.NoError(services.UseTokenKeys("k2:" + newKey + ",k1:" + key))
+	rotated, err := accountService.RotateTokens()
+	as.NoError(err)
+	as.Equal(2, rotated)
+	as.NoError(services.UseTokenKeys("k2:" + newKey))
+	token, err = accountService.Token(user.ID.String())
+	as.NoError(err)
+	as.Equal("live-token", token)
+}
+
+func (as *ActionSuite) Test_AccountsDestroy_ErasesAccountData() {
+	generator := services.NewDemoGenerator(services.DefaultDemoSeed, time.Now())
+	defer services.UseSoundcloudTransport(services.UseSoundcloudTransport(services.NewDemoSoundcloud(generator)))
+
+	user := as.createAndLoginUser("disconnect@example.com", "user")
+	seeder := services.NewSeedService(as.DB, generator)
+	_, err := seeder.SeedUser(context.Background(), user.ID.String(), services.SeedUser{
+		Accounts: []services.SeedAccount{{SoundcloudID: "6000980", Username: "personal"}, {SoundcloudID: "6000981", Username: "label-crew"}},
+	})
+	as.NoError(err)
+	accountService := services.NewAccountService(as.DB)
+	accounts, err := accountService.Accounts(user.ID.String())
+	as.NoError(err)
+	personal, label := accounts[0], accounts[1]
+
+	_, err = services.NewPlaylistExportService(as.DB, nil).Create(user.ID.String(), "Personal only",
+		map[string]interface{}{"accounts": []interface{}{personal.ID.String()}}, "newest")
+	as.NoError(err)
+	_, err = services.NewPlaylistExportService(as.DB, nil).Create(user.ID.String(), "Everything", map[string]interface{}{}, "newest")
+	as.NoError(err)
+
+	res := as.HTML("/accounts/%s/disconnect", personal.ID).Get()
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), "Disconnect and erase")
+
+	res = as.HTML("/accounts/%s", personal.ID).Delete()
+	as.Equal(http.StatusSeeOther, res.Code)
+
+	_, err = accountService.Account(user.ID.String(), personal.ID.String())
+	as.ErrorIs(err, services.ErrAccountNotFound)
+	orphaned, err := as.DB.Where("user_id = ? AND id NOT IN (SELECT track_id FROM track_sources WHERE account_id = ?)", user.ID, label.ID).Count(&srcmodels.Track{})
+	as.NoError(err)
+	as.Equal(0, orphaned, "only tracks the remaining account brought in are kept")
+	exports, err := services.NewPlaylistExportService(as.DB, nil).Exports(user.ID.String())
+	as.NoError(err)
+	as.Len(exports, 1)
+	as.Equal("Everything", exports[0].Title)
+	link, err := accountService.GetLink(user.ID.String())
+	as.NoError(err)
+	as.Equal(label.SoundcloudID, link.SoundcloudID)
+
+	// Disconnecting the last account erases everything from Soundcloud,
+	// but not the user's own settings
+	recommendationService := services.NewRecommendationService(as.DB)
+	_, err = recommendationService.UpdateSimilarities(context.Background(), user.ID.String())
+	as.NoError(err)
+	kept := srcmodels.Track{}
+	as.NoError(as.DB.Where("user_id = ? AND artist_soundcloud_id <> ''", user.ID).First(&kept))
+	_, err = recommendationService.ExcludeArtist(user.ID.String(), kept.ArtistID)
+	as.NoError(err)
+	as.NoError(as.DB.Create(&srcmodels.PodcastSubscription{UserID: user.ID, URL: "https://example.com/feed.rss"}))
+
+	res = as.HTML("/accounts/%s/disconnect", label.ID).Get()
+	as.Contains(res.Body.String(), "podcast subscriptions and other settings are kept")
+	res = as.HTML("/accounts/%s", label.ID).Delete()
+	as.Equal(http.StatusSeeOther, res.Code)
+	tracks, err := as.DB.Where("user_id = ?", user.ID).Count(&srcmodels.Track{})
+	as.NoError(err)
+	as.Equal(0, tracks)
+	similarities, err := as.DB.Where("user_id = ?", user.ID).Count(&srcmodels.TrackSimilarity{})
+	as.NoError(err)
+	as.Equal(0, similarities)
+	for _, model := range []interface{}{&srcmodels.RecommendationExclusion{}, &srcmodels.PodcastSubscription{}} {
+		left, err := as.DB.Where("user_id = ?", user.ID).Count(model)
+		as.NoError(err)
+		as.Equal(1, left)
+	}
+	_, err = accountService.GetLink(user.ID.String())
+	as.Error(err)
+}
+
+// revokeWatcher answers Soundcloud's sign out, noting whether the account
+// being disconnected was still stored when its token was revoked
+type revokeWatcher struct {
+	as          *ActionSuite
+	accountID   string
+	revoked     bool
+	stillStored bool
+}
+
+func (w *revokeWatcher) RoundTrip(req *http.Request) (*http.Response, error) {
+	w.revoked = true
+	count, err := w.as.DB.Where("id = ?", w.accountID).Count(&srcmodels.SoundcloudAccount{})
+	w.stillStored = err != nil || count > 0
+	return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader("{}")), Request: req}, nil
+}
+
+func (as *ActionSuite) Test_AccountsDestroy_RevokesAfterErasure() {
+	user := as.createAndLoginUser("revoke@example.com", "user")
+	account, err := services.NewAccountService(as.DB).SaveLink(user.ID.String(), "444", "revoke-token")
+	as.NoError(err)
+	watcher := &revokeWatcher{as: as, accountID: account.ID.String()}
+	defer services.UseSoundcloudTransport(services.UseSoundcloudTransport(watcher))
+
+	res := as.HTML("/accounts/%s", account.ID).Delete()
+	as.Equal(http.StatusSeeOther, res.Code)
+	as.True(watcher.revoked)
+	as.False(watcher.stillStored, "the token is revoked once the erasure is committed")
+}
+
+func (as *ActionSuite) Test_UseTokenEncryption_RejectsInvalidKeys() {
+	defer func(keys string) { tokenEncryptionKeys = keys }(tokenEncryptionKeys)
+
+	tokenEncryptionKeys = "k1:not-a-key"
+	as.Error(useTokenEncryption())
+
+	tokenEncryptionKeys = ""
+	as.NoError(useTokenEncryption())
+}
@@ -0,0 +1,158 @@
+package actions
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/gobuffalo/nulls"
+	"github.com/gobuffalo/pop/v6"
+	"github.com/gofrs/uuid"
+	"github.com/jbhicks/sound-cistern/models"
+	"github.com/jbhicks/sound-cistern/pkg/logging"
+	srcmodels "github.com/jbhicks/sound-cistern/src/models"
+	"github.com/jbhicks/sound-cistern/src/services"
+)
+
+// AlertsIndex lists the current user's alerts
+func AlertsIndex(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	user := c.Value("current_user").(*models.User)
+
+	alerts := srcmodels.Alerts{}
+	if err := tx.Where("user_id = ?", user.ID).Order("created_at desc").All(&alerts); err != nil {
+		return err
+	}
+
+	c.Set("alerts", alerts)
+	c.Set("alert", srcmodels.Alert{Channel: srcmodels.AlertChannelEmail, Target: user.Email})
+	if IsHTMX(c.Request()) {
+		return c.Render(http.StatusOK, rHTMX.HTML("alerts/index.html"))
+	}
+	return c.Render(http.StatusOK, r.HTML("alerts/index.html"))
+}
+
+// AlertsCreate saves a new alert built from the same fields as the feed filter
+func AlertsCreate(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	user := c.Value("current_user").(*models.User)
+
+	if err := c.Request().ParseForm(); err != nil {
+		return c.Error(http.StatusBadRequest, errors.New("invalid alert form"))
+	}
+	params := c.Request().Form
+
+	criteria := services.CriteriaFromValues(params)
+	criteriaJSON, err := json.Marshal(criteria)
+	if err != nil {
+		return err
+	}
+
+	alert := &srcmodels.Alert{
+		ID:       uuid.Must(uuid.NewV4()),
+		UserID:   user.ID,
+		Name:     strings.TrimSpace(params.Get("name")),
+		Criteria: string(criteriaJSON),
+		Channel:  params.Get("channel"),
+		Target:   strings.TrimSpace(params.Get("target")),
+		Active:   true,
+	}
+
+	if msg := validateAlert(alert); msg != "" {
+		c.Flash().Add("danger", msg)
+		return c.Redirect(http.StatusSeeOther, "/alerts")
+	}
+
+	if alert.Channel == srcmodels.AlertChannelWebhook {
+		secret, err := services.GenerateSecret()
+		if err != nil {
+			return err
+		}
+		alert.Secret = nulls.NewString(secret)
+	}
+
+	if err := tx.Create(alert); err != nil {
+		return err
+	}
+
+	logging.UserAction(c, user.Email, "alert_create", "Created feed alert", logging.Fields{
+		"alert_id": alert.ID.String(),
+		"channel":  alert.Channel,
+	})
+
+	c.Flash().Add("success", "Alert created")
+	return c.Redirect(http.StatusSeeOther, "/alerts")
+}
+
+// AlertsDestroy deletes one of the current user's alerts
+func AlertsDestroy(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	user := c.Value("current_user").(*models.User)
+
+	alert := &srcmodels.Alert{}
+	if err := tx.Where("id = ? AND user_id = ?", c.Param("alert_id"), user.ID).First(alert); err != nil {
+		return c.Error(http.StatusNotFound, errors.New("alert not found"))
+	}
+
+	if err := tx.Destroy(alert); err != nil {
+		return err
+	}
+
+	logging.UserAction(c, user.Email, "alert_delete", "Deleted feed alert", logging.Fields{
+		"alert_id": alert.ID.String(),
+	})
+
+	c.Flash().Add("success", "Alert deleted")
+	return c.Redirect(http.StatusSeeOther, "/alerts")
+}
+
+// AlertDeliveries shows recent delivery attempts for one alert so users can
+// see failures
+func AlertDeliveries(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	user := c.Value("current_user").(*models.User)
+
+	alert := &srcmodels.Alert{}
+	if err := tx.Where("id = ? AND user_id = ?", c.Param("alert_id"), user.ID).First(alert); err != nil {
+		return c.Error(http.StatusNotFound, errors.New("alert not found"))
+	}
+
+	deliveries, err := services.NewNotificationService(tx).DeliveriesForAlert(alert.ID, 50)
+	if err != nil {
+		return err
+	}
+
+	c.Set("alert", alert)
+	c.Set("deliveries", deliveries)
+	if IsHTMX(c.Request()) {
+		return c.Render(http.StatusOK, rHTMX.HTML("alerts/deliveries.html"))
+	}
+	return c.Render(http.StatusOK, r.HTML("alerts/deliveries.html"))
+}
+
+// validateAlert returns a user-facing message when the alert is incomplete
+func validateAlert(alert *srcmodels.Alert) string {
+	if alert.Name == "" {
+		return "Alert name is required"
+	}
+	switch alert.Channel {
+	case srcmodels.AlertChannelEmail:
+		if !strings.Contains(alert.Target, "@") {
+			return "A valid email address is required"
+		}
+	case srcmodels.AlertChannelWebhook:
+		u, err := url.Parse(alert.Target)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return "A valid webhook URL is required"
+		}
+		if !services.PublicHost(u.Hostname()) {
+			return "The webhook URL must be on the public internet"
+		}
+	default:
+		return "Unknown delivery channel"
+	}
+	return ""
+}
@@ -0,0 +1,109 @@
+package actions
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+
+	"github.com/gobuffalo/nulls"
+	srcmodels "github.com/jbhicks/sound-cistern/src/models"
+	"github.com/jbhicks/sound-cistern/src/services"
+)
+
+func (as *ActionSuite) Test_AlertsIndex_RequiresAuth() {
+	res := as.HTML("/alerts").Get()
+	as.Equal(http.StatusFound, res.Code)
+	as.Equal("/auth/new", res.Location())
+}
+
+func (as *ActionSuite) Test_AlertsCreate_Email() {
+	user := as.createAndLoginUser("alerts@example.com", "user")
+
+	res := as.HTML("/alerts").Post(url.Values{
+		"name":       {"Long mixes"},
+		"min_length": {"3600"},
+		"genres":     {"Techno, House"},
+		"channel":    {"email"},
+		"target":     {"alerts@example.com"},
+	})
+	as.Equal(http.StatusSeeOther, res.Code)
+
+	alert := &srcmodels.Alert{}
+	as.NoError(as.DB.Where("user_id = ?", user.ID).First(alert))
+	as.Equal("Long mixes", alert.Name)
+	as.Contains(alert.Criteria, `"min_length":3600`)
+	as.Contains(alert.Criteria, `"genres":["Techno","House"]`)
+	as.False(alert.Secret.Valid)
+}
+
+func (as *ActionSuite) Test_AlertsCreate_WebhookGetsSecret() {
+	user := as.createAndLoginUser("hooks@example.com", "user")
+
+	res := as.HTML("/alerts").Post(url.Values{
+		"name":    {"Everything"},
+		"channel": {"webhook"},
+		"target":  {"https://example.com/hook"},
+	})
+	as.Equal(http.StatusSeeOther, res.Code)
+
+	alert := &srcmodels.Alert{}
+	as.NoError(as.DB.Where("user_id = ?", user.ID).First(alert))
+	as.True(alert.Secret.Valid)
+	as.Len(alert.Secret.String, 64)
+}
+
+func (as *ActionSuite) Test_AlertsCreate_RejectsBadTarget() {
+	user := as.createAndLoginUser("badhook@example.com", "user")
+
+	res := as.HTML("/alerts").Post(url.Values{
+		"name":    {"Broken"},
+		"channel": {"webhook"},
+		"target":  {"not a url"},
+	})
+	as.Equal(http.StatusSeeOther, res.Code)
+
+	count, err := as.DB.Where("user_id = ?", user.ID).Count(&srcmodels.Alert{})
+	as.NoError(err)
+	as.Equal(0, count)
+}
+
+func (as *ActionSuite) Test_AlertsCreate_RejectsPrivateWebhook() {
+	user := as.createAndLoginUser("privatehook@example.com", "user")
+
+	res := as.HTML("/alerts").Post(url.Values{
+		"name":    {"Metadata"},
+		"channel": {"webhook"},
+		"target":  {"http://169.254.169.254/latest"},
+	})
+	as.Equal(http.StatusSeeOther, res.Code)
+
+	count, err := as.DB.Where("user_id = ?", user.ID).Count(&srcmodels.Alert{})
+	as.NoError(err)
+	as.Equal(0, count)
+}
+
+func (as *ActionSuite) Test_DeliverWebhook_CarriesRequestID() {
+	user := as.createAndLoginUser("hookid@example.com", "user")
+	var requestID string
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID = r.Header.Get(services.RequestIDHeader)
+	}))
+	defer hook.Close()
+
+	alert := &srcmodels.Alert{UserID: user.ID, Name: "Everything", Criteria: "{}", Channel: srcmodels.AlertChannelWebhook,
+		Target: hook.URL, Secret: nulls.NewString("secret"), Active: true}
+	as.NoError(as.DB.Create(alert))
+	notification := &srcmodels.Notification{AlertID: alert.ID, UserID: user.ID, Tracks: "[]", Status: srcmodels.NotificationPending}
+	as.NoError(as.DB.Create(notification))
+
+	ctx := services.WithRequestID(context.Background(), "sync-123")
+	ns := services.NewNotificationService(as.DB)
+	ns.Client = hook.Client()
+	retryIn, err := ns.DeliverWebhook(ctx, notification.ID.String())
+	as.NoError(err)
+	as.Zero(retryIn)
+	as.Equal("sync-123", requestID)
+	as.NoError(as.DB.Reload(notification))
+	as.Equal(srcmodels.NotificationDelivered, notification.Status)
+}
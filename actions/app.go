@@ -70,6 +70,25 @@ func App() *buffalo.App {
 			Host:        "0.0.0.0:3000",
 		})
 
+		// Background jobs for notification delivery
+		registerJobs(app.Worker)
+
+		// Encrypt stored Soundcloud tokens
+		if err := useTokenEncryption(); err != nil {
+			logging.Fatal("Invalid TOKEN_ENCRYPTION_KEYS, refusing to start", logging.Fields{"error": err.Error()})
+		}
+
+		// Serve Soundcloud from generated data, or record or replay its
+		// traffic, in development
+		if demoMode {
+			useSoundcloudDemo()
+		} else {
+			if demoModeRequested {
+				logging.Warn("DEMO_MODE is ignored in production", logging.Fields{})
+			}
+			useSoundcloudCassette()
+		}
+
 		// Automatically redirect to SSL
 		app.Use(forceSSL())
 
@@ -124,6 +143,12 @@ func App() *buffalo.App {
 		app.POST("/profile", ProfileUpdate)
 		app.GET("/account", AccountSettings)
 		app.POST("/account", AccountUpdate)
+		app.GET("/account/export", DataExportShow)
+		app.POST("/account/export", DataExportCreate)
+
+		// Data export downloads are authorized by their signed link
+		app.Middleware.Skip(Authorize, DataExportDownload)
+		app.GET("/exports/{export_id}/download", DataExportDownload)
 
 		// Admin-only routes
 		adminGroup := app.Group("/admin")
@@ -155,7 +180,76 @@ func App() *buffalo.App {
 
 		// Protected Sound Cistern routes
 		app.GET("/feed", FeedIndex)
+		app.GET("/feed/page", FeedNextPage)
+		app.GET("/filter", FeedFilter)
 		app.POST("/filter", FeedFilter)
+		app.POST("/feed/sync", FeedSync)
+
+		// Live feed updates hold their connection open, so they must not
+		// run inside a request transaction
+		app.Middleware.Skip(popmw.Transaction(models.DB), FeedEvents)
+		app.GET("/feed/events", FeedEvents)
+
+		// Feed alerts
+		app.GET("/alerts", AlertsIndex)
+		app.POST("/alerts", AlertsCreate)
+		app.DELETE("/alerts/{alert_id}", AlertsDestroy)
+		app.GET("/alerts/{alert_id}/deliveries", AlertDeliveries)
+
+		// Rules run on newly synced tracks. The preview is registered
+		// before the rule routes so it is not taken for a rule id.
+		app.GET("/rules", RulesIndex)
+		app.POST("/rules", RulesCreate)
+		app.POST("/rules/preview", RulesPreview)
+		app.DELETE("/rules/{rule_id}", RulesDestroy)
+		app.GET("/rules/{rule_id}/log", RuleLog)
+
+		// User-owned labels. Bulk labeling applies the submitted feed filter.
+		app.GET("/labels", LabelsIndex)
+		app.POST("/labels", LabelsCreate)
+		app.DELETE("/labels/{label_id}", LabelsDestroy)
+		app.POST("/labels/selection", LabelSelection)
+
+		// Podcast and RSS feeds followed alongside Soundcloud
+		app.GET("/podcasts", PodcastsIndex)
+		app.POST("/podcasts", PodcastsCreate)
+		app.DELETE("/podcasts/{subscription_id}", PodcastsDestroy)
+
+		// Linked Soundcloud accounts. New accounts are connected through
+		// the Soundcloud OAuth flow.
+		app.GET("/accounts", AccountsIndex)
+		app.GET("/accounts/{account_id}/disconnect", AccountsDisconnect)
+		app.DELETE("/accounts/{account_id}", AccountsDestroy)
+
+		// Followed artists. The inactive report is registered before the
+		// artist page so it is not taken for an artist id.
+		app.GET("/artists", ArtistsIndex)
+		app.GET("/artists/inactive", ArtistsInactive)
+		app.GET("/artists/{artist_id}", ArtistShow)
+		app.POST("/artists/{artist_id}/filter", ArtistFilterUpdate)
+
+		// Filters pushed to Soundcloud playlists
+		app.GET("/playlists", PlaylistsIndex)
+		app.POST("/playlists", PlaylistsCreate)
+		app.POST("/playlists/{export_id}/push", PlaylistPush)
+		app.DELETE("/playlists/{export_id}", PlaylistsDestroy)
+
+		// Persistent player and its queue
+		app.GET("/player", PlayerShow)
+		app.POST("/player/tracks/{track_id}/play", PlayerPlay)
+		app.POST("/player/tracks/{track_id}/queue", PlayerEnqueue)
+		app.POST("/player/tracks/{track_id}/position", PlayerPosition)
+		app.POST("/player/next", PlayerNext)
+		app.DELETE("/player/queue/{item_id}", PlayerRemove)
+		app.GET("/tracks/{track_id}", TrackShow)
+		app.POST("/tracks/{track_id}/kind", TrackKindUpdate)
+		app.POST("/tracks/{track_id}/labels", TrackLabelsUpdate)
+		app.POST("/tracks/{track_id}/note", TrackNoteUpdate)
+		app.GET("/tracks/{track_id}/stream", TrackStream)
+
+		// Artists kept out of similar track recommendations
+		app.POST("/recommendations/exclusions", RecommendationExclusionsCreate)
+		app.DELETE("/recommendations/exclusions/{artist_id}", RecommendationExclusionsDestroy)
 
 		// Add no-cache headers for static files in development
 		if ENV == "development" {
@@ -0,0 +1,113 @@
+package actions
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/gobuffalo/pop/v6"
+	"github.com/jbhicks/sound-cistern/models"
+	"github.com/jbhicks/sound-cistern/pkg/logging"
+	"github.com/jbhicks/sound-cistern/src/services"
+)
+
+// defaultInactiveMonths is how long an artist must have been quiet to show
+// in the inactive report when no period is given
+const defaultInactiveMonths = 6
+
+// ArtistsIndex lists the artists the user follows on Soundcloud with their
+// feed filters
+func ArtistsIndex(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	user := c.Value("current_user").(*models.User)
+
+	artists, err := services.NewFollowingService(tx).Followings(user.ID.String())
+	if err != nil {
+		logging.Error("Error loading followed artists", err, logging.Fields{"user_id": user.ID.String()})
+		return c.Error(http.StatusInternalServerError, errors.New("failed to load artists"))
+	}
+
+	c.Set("artists", artists)
+	return c.Render(http.StatusOK, r.HTML("artists/index.html"))
+}
+
+// ArtistsInactive reports the followed artists that have not posted a
+// track in the last N months
+func ArtistsInactive(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	user := c.Value("current_user").(*models.User)
+
+	months, err := strconv.Atoi(c.Param("months"))
+	if err != nil || months < 1 {
+		months = defaultInactiveMonths
+	}
+
+	since := time.Now().AddDate(0, -months, 0)
+	artists, err := services.NewFollowingService(tx).Inactive(user.ID.String(), since)
+	if err != nil {
+		logging.Error("Error loading inactive artists", err, logging.Fields{"user_id": user.ID.String()})
+		return c.Error(http.StatusInternalServerError, errors.New("failed to load artists"))
+	}
+
+	c.Set("artists", artists)
+	c.Set("months", months)
+	return c.Render(http.StatusOK, r.HTML("artists/inactive.html"))
+}
+
+// ArtistShow displays a followed artist with the tracks of theirs stored
+// from the user's feed, paged like the feed itself
+func ArtistShow(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	user := c.Value("current_user").(*models.User)
+
+	artist, err := services.NewFollowingService(tx).Artist(user.ID.String(), c.Param("artist_id"))
+	if errors.Is(err, services.ErrArtistNotFound) {
+		return c.Error(http.StatusNotFound, err)
+	}
+	if err != nil {
+		logging.Error("Error loading artist", err, logging.Fields{"user_id": user.ID.String()})
+		return c.Error(http.StatusInternalServerError, errors.New("failed to load artist"))
+	}
+
+	criteria := map[string]interface{}{"artist": artist.Artist.SoundcloudID}
+	page, err := services.NewFeedService(tx).Page(requestContext(c), user.ID.String(), criteria, services.SortNewest, "", services.FeedPageSize)
+	if err != nil {
+		logging.Error("Error loading artist tracks", err, logging.Fields{"user_id": user.ID.String()})
+		return c.Error(http.StatusInternalServerError, errors.New("failed to load artist"))
+	}
+
+	setFeedPage(c, page, criteria, services.SortNewest, "")
+	c.Set("artist", artist)
+	return c.Render(http.StatusOK, r.HTML("artists/show.html"))
+}
+
+// ArtistFilterUpdate includes a followed artist in or excludes them from
+// the feed. HTMX requests get the updated filter controls back.
+func ArtistFilterUpdate(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	user := c.Value("current_user").(*models.User)
+
+	artist, err := services.NewFollowingService(tx).SetFilter(user.ID.String(), c.Param("artist_id"), c.Param("filter"))
+	switch {
+	case errors.Is(err, services.ErrUnknownArtistFilter):
+		return c.Error(http.StatusBadRequest, err)
+	case errors.Is(err, services.ErrArtistNotFound):
+		return c.Error(http.StatusNotFound, err)
+	case err != nil:
+		logging.Error("Error updating artist filter", err, logging.Fields{"user_id": user.ID.String()})
+		return c.Error(http.StatusInternalServerError, errors.New("failed to update artist"))
+	}
+
+	logging.UserAction(c, user.Email, "set_artist_filter", "Changed an artist's feed filter", logging.Fields{
+		"artist": artist.Artist.SoundcloudID,
+		"filter": artist.Filter,
+	})
+
+	if IsHTMX(c.Request()) {
+		c.Set("artist", artist)
+		return c.Render(http.StatusOK, rHTMX.HTML("artists/_filter.html"))
+	}
+	return c.Redirect(http.StatusSeeOther, "/artists")
+}
@@ -0,0 +1,114 @@
+package actions
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/jbhicks/sound-cistern/src/services"
+)
+
+// seedFollowings stores followed artists for a user as a sync would
+func (as *ActionSuite) seedFollowings(userID string, accounts []interface{}) {
+	_, err := services.NewFollowingService(as.DB).Store(userID, accounts)
+	as.NoError(err)
+}
+
+var artistTracks = []interface{}{
+	map[string]interface{}{
+		"id": float64(1), "title": "Archangel", "duration": float64(240000),
+		"created_at": "2025/03/01 10:00:00 +0000", "user": map[string]interface{}{"id": float64(55), "username": "burial"},
+	},
+	map[string]interface{}{
+		"id": float64(2), "title": "Hyph Mngo", "duration": float64(300000),
+		"created_at": "2025/03/02 10:00:00 +0000", "user": map[string]interface{}{"id": float64(56), "username": "joy orbison"},
+	},
+}
+
+var followedAccounts = []interface{}{
+	map[string]interface{}{"id": float64(55), "username": "burial", "followers_count": float64(1200)},
+	map[string]interface{}{"id": float64(56), "username": "joy orbison"},
+	map[string]interface{}{"id": float64(57), "username": "gone quiet"},
+}
+
+func (as *ActionSuite) Test_ArtistsIndex_ListsFollowings() {
+	user := as.createAndLoginUser("artists@example.com", "user")
+	as.seedCachedFeed(user.ID.String(), artistTracks)
+	as.seedFollowings(user.ID.String(), followedAccounts)
+
+	res := as.HTML("/artists").Get()
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), "burial")
+	as.Contains(res.Body.String(), "1200 followers")
+	as.Contains(res.Body.String(), "Mar 1, 2025")
+}
+
+func (as *ActionSuite) Test_ArtistShow_ListsTheirTracks() {
+	user := as.createAndLoginUser("artistshow@example.com", "user")
+	as.seedCachedFeed(user.ID.String(), artistTracks)
+	as.seedFollowings(user.ID.String(), followedAccounts)
+
+	res := as.HTML("/artists/55").Get()
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), "Archangel")
+	as.NotContains(res.Body.String(), "Hyph Mngo")
+
+	res = as.HTML("/artists/999").Get()
+	as.Equal(http.StatusNotFound, res.Code)
+}
+
+func (as *ActionSuite) Test_ArtistFilterUpdate_ExcludesFromFeed() {
+	user := as.createAndLoginUser("artistfilter@example.com", "user")
+	as.seedCachedFeed(user.ID.String(), artistTracks)
+	as.seedFollowings(user.ID.String(), followedAccounts)
+
+	res := as.HTML("/artists/55/filter").Post(url.Values{"filter": {"exclude"}})
+	as.Equal(http.StatusSeeOther, res.Code)
+
+	res = as.HTML("/feed").Get()
+	as.Equal(http.StatusOK, res.Code)
+	as.NotContains(res.Body.String(), "Archangel")
+	as.Contains(res.Body.String(), "Hyph Mngo")
+
+	res = as.HTML("/artists/56/filter").Post(url.Values{"filter": {"include"}})
+	as.Equal(http.StatusSeeOther, res.Code)
+	res = as.HTML("/artists/55/filter").Post(url.Values{"filter": {""}})
+	as.Equal(http.StatusSeeOther, res.Code)
+
+	res = as.HTML("/feed").Get()
+	as.NotContains(res.Body.String(), "Archangel")
+	as.Contains(res.Body.String(), "Hyph Mngo")
+
+	res = as.HTML("/artists/55/filter").Post(url.Values{"filter": {"sometimes"}})
+	as.Equal(http.StatusBadRequest, res.Code)
+}
+
+func (as *ActionSuite) Test_ArtistFilterUpdate_KeepsPodcastEpisodes() {
+	user := as.createAndLoginUser("artistepisodes@example.com", "user")
+	episode := map[string]interface{}{
+		"id": "rss-0123456789abcdef", "title": "Episode One", "duration": float64(1800000),
+		"created_at": "2025/03/03 10:00:00 +0000", "enclosure_url": "https://example.com/episode-1.mp3",
+		"user": map[string]interface{}{"id": "rss-fedcba9876543210", "username": "A Podcast"},
+	}
+	as.seedCachedFeed(user.ID.String(), append([]interface{}{episode}, artistTracks...))
+	as.seedFollowings(user.ID.String(), followedAccounts)
+
+	res := as.HTML("/artists/56/filter").Post(url.Values{"filter": {"include"}})
+	as.Equal(http.StatusSeeOther, res.Code)
+
+	res = as.HTML("/feed").Get()
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), "Hyph Mngo")
+	as.Contains(res.Body.String(), "Episode One")
+	as.NotContains(res.Body.String(), "Archangel")
+}
+
+func (as *ActionSuite) Test_ArtistsInactive_ReportsQuietArtists() {
+	user := as.createAndLoginUser("inactive@example.com", "user")
+	as.seedCachedFeed(user.ID.String(), artistTracks)
+	as.seedFollowings(user.ID.String(), followedAccounts)
+
+	res := as.HTML("/artists/inactive?months=1200").Get()
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), "gone quiet")
+	as.NotContains(res.Body.String(), "burial")
+}
@@ -0,0 +1,197 @@
+package actions
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/gobuffalo/buffalo/worker"
+	"github.com/gobuffalo/envy"
+	"github.com/gobuffalo/pop/v6"
+	"github.com/jbhicks/sound-cistern/models"
+	"github.com/jbhicks/sound-cistern/pkg/logging"
+	srcmodels "github.com/jbhicks/sound-cistern/src/models"
+	"github.com/jbhicks/sound-cistern/src/services"
+)
+
+// dataExportDir is where built data exports are kept until they expire
+var dataExportDir = envy.Get("DATA_EXPORT_DIR", "tmp/exports")
+
+// exportSigningKey signs data export download links. Without a configured
+// key one is made at startup, so links stop working on restart.
+var exportSigningKey = downloadSigningKey()
+
+// downloadSigningKey is EXPORT_SIGNING_KEY, or otherwise a key derived from
+// SESSION_SECRET so the session secret itself signs nothing but sessions
+func downloadSigningKey() []byte {
+	if key := envy.Get("EXPORT_SIGNING_KEY", ""); key != "" {
+		return []byte(key)
+	}
+	if secret := envy.Get("SESSION_SECRET", ""); secret != "" {
+		return deriveKey(secret, "data-export")
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return key
+}
+
+// deriveKey derives a key for one purpose from a secret
+func deriveKey(secret, purpose string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+// DataExportCreate starts building an archive of the user's data in the
+// background. The account page polls DataExportShow until it is ready.
+func DataExportCreate(c buffalo.Context) error {
+	user := c.Value("current_user").(*models.User)
+
+	// Commit before the build job can run so it finds the export
+	var export *srcmodels.DataExport
+	err := models.DB.Transaction(func(tx *pop.Connection) error {
+		var err error
+		export, err = services.NewDataExportService(tx, dataExportDir).Request(user.ID.String())
+		return err
+	})
+	if err != nil {
+		logging.Error("Error requesting data export", err, logging.Fields{"user_id": user.ID.String()})
+		return c.Error(http.StatusInternalServerError, errors.New("failed to start data export"))
+	}
+
+	err = app.Worker.Perform(worker.Job{
+		Handler: jobBuildDataExport,
+		Args:    worker.Args{"export_id": export.ID.String(), "request_id": c.Value("request_id")},
+	})
+	if err != nil {
+		logging.Error("Error enqueueing data export", err, logging.Fields{"export_id": export.ID.String()})
+		return c.Error(http.StatusServiceUnavailable, errors.New("data exports are unavailable right now"))
+	}
+
+	logging.UserAction(c, user.Email, "data_export_request", "Requested an export of their data", logging.Fields{
+		"export_id": export.ID.String(),
+	})
+
+	if IsHTMX(c.Request()) {
+		setDataExport(c, export)
+		return c.Render(http.StatusAccepted, rHTMX.HTML("users/_data_export.html"))
+	}
+	c.Flash().Add("success", "Your data export is being built")
+	return c.Redirect(http.StatusSeeOther, "/account")
+}
+
+// DataExportShow renders the state of the user's latest data export
+func DataExportShow(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	user := c.Value("current_user").(*models.User)
+
+	export, err := services.NewDataExportService(tx, dataExportDir).Latest(user.ID.String())
+	if err != nil {
+		return err
+	}
+	setDataExport(c, export)
+	return c.Render(http.StatusOK, rHTMX.HTML("users/_data_export.html"))
+}
+
+// DataExportDownload serves a built data export to anyone holding its
+// signed link, until the link expires
+func DataExportDownload(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	exportID := c.Param("export_id")
+
+	export, path, err := services.NewDataExportService(tx, dataExportDir).Download(exportSigningKey, exportID, c.Param("expires"), c.Param("signature"))
+	if errors.Is(err, services.ErrDownloadLinkExpired) {
+		return c.Error(http.StatusGone, err)
+	}
+	if err != nil {
+		logging.SecurityEvent(c, "data_export_link_rejected", "failure", "invalid_signature", logging.Fields{
+			"export_id": exportID,
+		})
+		return c.Error(http.StatusNotFound, err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		logging.Error("Error opening data export", err, logging.Fields{"export_id": exportID})
+		return c.Error(http.StatusNotFound, services.ErrDataExportNotFound)
+	}
+	defer file.Close()
+
+	logging.Audit("data_export_download", logging.Fields{
+		"export_id": exportID,
+		"user_id":   export.UserID.String(),
+		"remote_ip": c.Request().RemoteAddr,
+	})
+	name := fmt.Sprintf("sound-cistern-export-%s.zip", export.CreatedAt.Format("2006-01-02"))
+	return c.Render(http.StatusOK, r.Download(c, name, file))
+}
+
+// setDataExport sets the export the account page shows, and its signed
+// download link once it is built
+func setDataExport(c buffalo.Context, export *srcmodels.DataExport) {
+	c.Set("export", export)
+	c.Set("exportURL", "")
+	if export != nil && export.Downloadable() {
+		expires, signature := services.SignDownloadLink(exportSigningKey, export.ID.String(), export.ExpiresAt.Time)
+		c.Set("exportURL", "/exports/"+export.ID.String()+"/download?"+url.Values{
+			"expires":   {expires},
+			"signature": {signature},
+		}.Encode())
+	}
+}
+
+// dataExportFiles are the files of a user's export that come from outside
+// the feed: their profile, blog posts and logged account events
+func dataExportFiles(tx *pop.Connection, userID string) ([]services.ExportFile, error) {
+	user := &models.User{}
+	if err := tx.Find(user, userID); err != nil {
+		return nil, err
+	}
+	profile, err := json.MarshalIndent(map[string]interface{}{
+		"id":         user.ID,
+		"email":      user.Email,
+		"first_name": user.FirstName,
+		"last_name":  user.LastName,
+		"role":       user.Role,
+		"created_at": user.CreatedAt,
+		"updated_at": user.UpdatedAt,
+	}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	posts := models.Posts{}
+	if err := tx.Where("author_id = ?", user.ID).Order("created_at asc").All(&posts); err != nil {
+		return nil, err
+	}
+	postsJSON, err := json.MarshalIndent(posts, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	events, err := logging.Events(user.Email, user.ID.String())
+	if err != nil {
+		return nil, err
+	}
+	if events == nil {
+		events = []logging.Fields{}
+	}
+	eventsJSON, err := json.MarshalIndent(events, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return []services.ExportFile{
+		{Name: "profile.json", Data: profile},
+		{Name: "posts.json", Data: postsJSON},
+		{Name: "events.json", Data: eventsJSON},
+	}, nil
+}
@@ -0,0 +1,66 @@
+package actions
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gobuffalo/envy"
+	"github.com/jbhicks/sound-cistern/src/services"
+)
+
+func (as *ActionSuite) Test_DataExport_DownloadsWithSignedLink() {
+	defer func(dir string) { dataExportDir = dir }(dataExportDir)
+	dataExportDir = as.T().TempDir()
+
+	user := as.createAndLoginUser("export@example.com", "user")
+	exportService := services.NewDataExportService(as.DB, dataExportDir)
+	export, err := exportService.Request(user.ID.String())
+	as.NoError(err)
+
+	res := as.HTML("/account/export").Get()
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), "Building your export")
+
+	files, err := dataExportFiles(as.DB, user.ID.String())
+	as.NoError(err)
+	_, err = exportService.Build(context.Background(), export.ID.String(), files)
+	as.NoError(err)
+
+	res = as.HTML("/account/export").Get()
+	as.Equal(http.StatusOK, res.Code)
+	link := regexp.MustCompile(`href="(/exports/[^"]+)"`).FindStringSubmatch(res.Body.String())
+	as.Require().Len(link, 2, "expected a download link")
+	downloadURL := strings.ReplaceAll(link[1], "&amp;", "&")
+
+	res = as.HTML(downloadURL).Get()
+	as.Equal(http.StatusOK, res.Code)
+	archive, err := zip.NewReader(bytes.NewReader(res.Body.Bytes()), int64(res.Body.Len()))
+	as.NoError(err)
+	names := map[string]bool{}
+	for _, file := range archive.File {
+		names[file.Name] = true
+	}
+	for _, name := range []string{"profile.json", "posts.json", "events.json", "soundcloud.json", "feed.json", "tracks.csv"} {
+		as.True(names[name], "expected %s in the export", name)
+	}
+
+	res = as.HTML(strings.Replace(downloadURL, "signature=", "signature=x", 1)).Get()
+	as.Equal(http.StatusNotFound, res.Code)
+}
+
+func (as *ActionSuite) Test_DataExport_SigningKeyDerivedFromSessionSecret() {
+	envy.Temp(func() {
+		envy.Set("EXPORT_SIGNING_KEY", "")
+		envy.Set("SESSION_SECRET", "session-secret")
+		key := downloadSigningKey()
+		as.NotEqual([]byte("session-secret"), key)
+		as.Equal(key, downloadSigningKey(), "the derived key survives restarts")
+
+		envy.Set("EXPORT_SIGNING_KEY", "export-key")
+		as.Equal([]byte("export-key"), downloadSigningKey())
+	})
+}
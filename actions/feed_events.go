@@ -0,0 +1,143 @@
+package actions
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/gobuffalo/buffalo/render"
+	"github.com/gobuffalo/envy"
+	"github.com/jbhicks/sound-cistern/models"
+	"github.com/jbhicks/sound-cistern/pkg/logging"
+	"github.com/jbhicks/sound-cistern/src/services"
+)
+
+const (
+	// sseHeartbeatInterval keeps idle live feed connections open through proxies
+	sseHeartbeatInterval = 15 * time.Second
+	// sseRetryMillis tells browsers how long to wait before reconnecting
+	sseRetryMillis = 5000
+	// sseBacklogSize is how many events per user are kept for Last-Event-ID replay
+	sseBacklogSize = 100
+)
+
+// feedEvents fans out sync progress and new tracks to live feed connections
+var feedEvents = services.NewFeedBroker(sseMaxConnections(), sseBacklogSize)
+
+// sseMaxConnections reads the per-user live feed connection cap
+func sseMaxConnections() int {
+	n, err := strconv.Atoi(envy.Get("SSE_MAX_CONNECTIONS", "3"))
+	if err != nil || n < 1 {
+		return 3
+	}
+	return n
+}
+
+// FeedEvents streams newly synced tracks and sync status changes to the
+// current user as Server-Sent Events. It runs outside the request
+// transaction because the connection stays open indefinitely.
+func FeedEvents(c buffalo.Context) error {
+	user, ok := c.Value("current_user").(*models.User)
+	if !ok || user == nil {
+		return c.Error(http.StatusUnauthorized, errors.New("user not authenticated"))
+	}
+
+	flusher, ok := c.Response().(http.Flusher)
+	if !ok {
+		return c.Error(http.StatusInternalServerError, errors.New("streaming not supported"))
+	}
+
+	lastEventID, _ := strconv.ParseUint(c.Request().Header.Get("Last-Event-ID"), 10, 64)
+
+	sub, replay, err := feedEvents.Subscribe(user.ID.String(), lastEventID)
+	if err != nil {
+		logging.Warn("Live feed connection refused", logging.Fields{
+			"user_id": user.ID.String(),
+			"reason":  err.Error(),
+		})
+		return c.Error(http.StatusTooManyRequests, err)
+	}
+	defer feedEvents.Unsubscribe(sub)
+
+	w := c.Response()
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	fmt.Fprintf(w, "retry: %d\n\n", sseRetryMillis)
+	for _, event := range replay {
+		if err := writeFeedEvent(w, event); err != nil {
+			return nil
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(sseHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	done := c.Request().Context().Done()
+	for {
+		select {
+		case <-done:
+			return nil
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return nil
+			}
+			flusher.Flush()
+		case event, open := <-sub.C:
+			if !open {
+				return nil
+			}
+			if err := writeFeedEvent(w, event); err != nil {
+				return nil
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// writeFeedEvent renders an event with the feed partials and writes it in
+// Server-Sent Events format
+func writeFeedEvent(w http.ResponseWriter, event services.FeedEvent) error {
+	var body string
+	var err error
+	switch event.Type {
+	case services.FeedEventTrack:
+		body, err = renderPartial("feed/_track.html", render.Data{"track": event.Track})
+	case services.FeedEventSyncStatus:
+		body, err = renderPartial("feed/_sync_status.html", render.Data{"status": event.Status, "message": event.Message})
+	default:
+		return nil
+	}
+	if err != nil {
+		logging.Error("Error rendering live feed event", err, logging.Fields{"event_type": event.Type})
+		return nil
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "id: %d\nevent: %s\n", event.ID, event.Type)
+	for _, line := range strings.Split(strings.TrimSpace(body), "\n") {
+		fmt.Fprintf(&buf, "data: %s\n", line)
+	}
+	buf.WriteString("\n")
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+// renderPartial renders a template outside of a request, for content that
+// is pushed rather than returned
+func renderPartial(name string, data render.Data) (string, error) {
+	var buf bytes.Buffer
+	if err := rHTMX.HTML(name).Render(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
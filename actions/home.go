@@ -1,10 +1,15 @@
 package actions
 
 import (
+	"errors"
 	"github.com/jbhicks/sound-cistern/models"
+	"github.com/jbhicks/sound-cistern/pkg/logging"
+	"github.com/jbhicks/sound-cistern/src/services"
 	"net/http"
+	"strconv"
 
 	"github.com/gobuffalo/buffalo"
+	"github.com/gobuffalo/pop/v6"
 )
 
 // HomeHandler serves the public landing page
@@ -41,7 +46,8 @@ func HomeHandler(c buffalo.Context) error {
 	return c.Render(http.StatusOK, r.HTML("home/index.plush.html"))
 }
 
-// DashboardHandler serves the protected dashboard for authenticated users
+// DashboardHandler serves the protected dashboard for authenticated users,
+// with statistics on their feed and listening over the chosen weeks
 func DashboardHandler(c buffalo.Context) error {
 	// Get current_user
 	currentUser, ok := c.Value("current_user").(*models.User)
@@ -53,6 +59,22 @@ func DashboardHandler(c buffalo.Context) error {
 	// You can pass additional data to the template if needed
 	c.Set("user", currentUser) // This is the same as current_user, but explicit for template
 
+	weeks := services.DefaultStatsWeeks
+	if n, err := strconv.Atoi(c.Param("weeks")); err == nil {
+		for _, option := range services.StatsRanges {
+			if n == option {
+				weeks = n
+			}
+		}
+	}
+	tx := c.Value("tx").(*pop.Connection)
+	stats, err := services.NewStatsService(tx).Stats(currentUser.ID.String(), weeks)
+	if err != nil {
+		logging.Error("Error computing dashboard statistics", err, logging.Fields{"user_id": currentUser.ID.String()})
+		return c.Error(http.StatusInternalServerError, errors.New("failed to load statistics"))
+	}
+	c.Set("stats", stats)
+
 	// Check if this is an HTMX request for partial content
 	if c.Request().Header.Get("HX-Request") == "true" {
 		return c.Render(http.StatusOK, r.HTML("home/dashboard.plush.html"))
@@ -62,13 +84,20 @@ func DashboardHandler(c buffalo.Context) error {
 	return c.Render(http.StatusOK, r.HTML("home/dashboard_full.plush.html"))
 }
 
-// HealthCheck provides a simple health check endpoint for deployment monitoring
+// HealthCheck provides a simple health check endpoint for deployment monitoring.
+// The app reports degraded while calls to Soundcloud are being failed fast.
 func HealthCheck(c buffalo.Context) error {
+	soundcloud := services.SoundcloudStatus()
+	status := "healthy"
+	if soundcloud.Open() {
+		status = "degraded"
+	}
 	health := map[string]interface{}{
-		"status":    "healthy",
-		"service":   "sound-cistern",
-		"version":   "1.0.0",
-		"timestamp": c.Request().Header.Get("Date"),
+		"status":     status,
+		"service":    "sound-cistern",
+		"version":    "1.0.0",
+		"timestamp":  c.Request().Header.Get("Date"),
+		"soundcloud": soundcloud,
 	}
 
 	return c.Render(http.StatusOK, r.JSON(health))
@@ -1,9 +1,13 @@
 package actions
 
 import (
+	"context"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/jbhicks/sound-cistern/models"
+	"github.com/jbhicks/sound-cistern/src/services"
 )
 
 func (as *ActionSuite) Test_HomeHandler() {
@@ -77,3 +81,40 @@ func (as *ActionSuite) Test_HomeHandler_LoggedIn() {
 	as.Equal(http.StatusOK, res.Code)
 	as.Contains(res.Body.String(), "Dashboard")
 }
+
+func (as *ActionSuite) Test_HealthCheck_ReportsSoundcloudBreaker() {
+	res := as.JSON("/health").Get()
+	as.Equal(http.StatusOK, res.Code)
+
+	health := map[string]interface{}{}
+	res.Bind(&health)
+	as.Equal("healthy", health["status"])
+	soundcloud, ok := health["soundcloud"].(map[string]interface{})
+	as.True(ok)
+	as.Equal("closed", soundcloud["state"])
+}
+
+func (as *ActionSuite) Test_DashboardHandler_ChartsFeedStatistics() {
+	generator := services.NewDemoGenerator(services.DefaultDemoSeed, time.Now())
+	defer services.UseSoundcloudTransport(services.UseSoundcloudTransport(services.NewDemoSoundcloud(generator)))
+
+	user := as.createAndLoginUser("stats@example.com", "user")
+	_, err := services.NewSeedService(as.DB, generator).SeedUser(context.Background(), user.ID.String(), services.SeedUser{
+		Accounts: []services.SeedAccount{{SoundcloudID: "6000990", Username: "stats"}},
+	})
+	as.NoError(err)
+
+	res := as.HTML("/dashboard?weeks=12").Get()
+	as.Equal(http.StatusOK, res.Code)
+	body := res.Body.String()
+	as.Contains(body, "Last 12 weeks")
+	as.Contains(body, "Feed Volume per Week")
+	as.Contains(body, "Most-Posting Artists")
+	as.GreaterOrEqual(strings.Count(body, `class="chart"`), 6)
+	as.NotContains(body, "No tracks posted in this period")
+
+	// Unknown periods fall back to the default
+	res = as.HTML("/dashboard?weeks=1000").Get()
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), "Last 26 weeks")
+}
@@ -0,0 +1,353 @@
+package actions
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gobuffalo/buffalo/worker"
+	"github.com/gobuffalo/envy"
+	"github.com/gobuffalo/pop/v6"
+	"github.com/gofrs/uuid"
+	"github.com/jbhicks/sound-cistern/models"
+	"github.com/jbhicks/sound-cistern/pkg/logging"
+	srcmodels "github.com/jbhicks/sound-cistern/src/models"
+	"github.com/jbhicks/sound-cistern/src/services"
+)
+
+// Background job names
+const (
+	jobDeliverWebhook  = "deliver_webhook"
+	jobSendDigests     = "send_digests"
+	jobSyncFeed        = "sync_feed"
+	jobReclassify      = "reclassify_tracks"
+	jobPushPlaylist    = "push_playlist"
+	jobSyncPlaylists   = "sync_playlists"
+	jobBuildDataExport = "build_data_export"
+)
+
+// jobTimeout caps how long a sync or playlist push may run, so a stalled
+// upstream call cannot hold a worker forever
+const jobTimeout = 10 * time.Minute
+
+// digestInterval is how often pending email notifications are sent as a digest
+var digestInterval = envy.Get("DIGEST_INTERVAL", "1h")
+
+// playlistSyncInterval is how often exported playlists are refreshed from
+// their filters
+var playlistSyncInterval = envy.Get("PLAYLIST_SYNC_INTERVAL", "6h")
+
+// registerJobs registers the background job handlers with the app's worker
+func registerJobs(w worker.Worker) {
+	if err := w.Register(jobDeliverWebhook, deliverWebhookJob); err != nil {
+		logging.Error("Failed to register job", err, logging.Fields{"job": jobDeliverWebhook})
+	}
+	if err := w.Register(jobSendDigests, sendDigestsJob); err != nil {
+		logging.Error("Failed to register job", err, logging.Fields{"job": jobSendDigests})
+	}
+	if err := w.Register(jobSyncFeed, syncFeedJob); err != nil {
+		logging.Error("Failed to register job", err, logging.Fields{"job": jobSyncFeed})
+	}
+	if err := w.Register(jobReclassify, reclassifyJob); err != nil {
+		logging.Error("Failed to register job", err, logging.Fields{"job": jobReclassify})
+	}
+	if err := w.Register(jobPushPlaylist, pushPlaylistJob); err != nil {
+		logging.Error("Failed to register job", err, logging.Fields{"job": jobPushPlaylist})
+	}
+	if err := w.Register(jobSyncPlaylists, syncPlaylistsJob); err != nil {
+		logging.Error("Failed to register job", err, logging.Fields{"job": jobSyncPlaylists})
+	}
+	if err := w.Register(jobBuildDataExport, buildDataExportJob); err != nil {
+		logging.Error("Failed to register job", err, logging.Fields{"job": jobBuildDataExport})
+	}
+}
+
+// ScheduleJobs starts the recurring background jobs. It is called once from
+// main before the app starts serving.
+func ScheduleJobs() {
+	interval, err := time.ParseDuration(digestInterval)
+	if err != nil {
+		logging.Error("Invalid DIGEST_INTERVAL, digests disabled", err, logging.Fields{"value": digestInterval})
+	} else if err := App().Worker.PerformIn(worker.Job{Handler: jobSendDigests}, interval); err != nil {
+		logging.Error("Failed to schedule digest job", err)
+	}
+
+	interval, err = time.ParseDuration(playlistSyncInterval)
+	if err != nil {
+		logging.Error("Invalid PLAYLIST_SYNC_INTERVAL, playlist syncs disabled", err, logging.Fields{"value": playlistSyncInterval})
+	} else if err := App().Worker.PerformIn(worker.Job{Handler: jobSyncPlaylists}, interval); err != nil {
+		logging.Error("Failed to schedule playlist sync job", err)
+	}
+}
+
+// deliverWebhookJob delivers a single webhook notification and re-enqueues
+// itself with exponential backoff while the delivery keeps failing
+func deliverWebhookJob(args worker.Args) error {
+	notificationID := fmt.Sprintf("%v", args["notification_id"])
+	ctx, cancel := jobContext(args, jobDeliverWebhook)
+	defer cancel()
+
+	// Delivery runs its own short transactions around the POST
+	retryIn, err := services.NewNotificationService(models.DB).DeliverWebhook(ctx, notificationID)
+	if err != nil {
+		logging.Error("Webhook delivery job failed", err, logging.Fields{"notification_id": notificationID, "request_id": services.RequestID(ctx)})
+		return err
+	}
+
+	if retryIn > 0 {
+		logging.Warn("Webhook delivery failed, retrying", logging.Fields{
+			"notification_id": notificationID,
+			"retry_in":        retryIn.String(),
+			"request_id":      services.RequestID(ctx),
+		})
+		return app.Worker.PerformIn(worker.Job{
+			Handler: jobDeliverWebhook,
+			Args:    worker.Args{"notification_id": notificationID, "request_id": services.RequestID(ctx)},
+		}, retryIn)
+	}
+	return nil
+}
+
+// sendDigestsJob emails pending notifications and schedules the next run
+func sendDigestsJob(args worker.Args) error {
+	err := models.DB.Transaction(func(tx *pop.Connection) error {
+		return services.NewNotificationService(tx).SendDigests()
+	})
+	if err != nil {
+		logging.Error("Digest job failed", err)
+	}
+
+	interval, perr := time.ParseDuration(digestInterval)
+	if perr != nil {
+		return perr
+	}
+	if serr := app.Worker.PerformIn(worker.Job{Handler: jobSendDigests}, interval); serr != nil {
+		return serr
+	}
+	return err
+}
+
+// syncFeedJob refreshes a user's feed with the stored tokens of each of
+// their linked accounts and queues alerts for any newly seen tracks
+func syncFeedJob(args worker.Args) error {
+	userID := fmt.Sprintf("%v", args["user_id"])
+	ctx, cancel := jobContext(args, jobSyncFeed)
+	defer cancel()
+
+	var result *services.SyncResult
+	err := models.DB.Transaction(func(tx *pop.Connection) error {
+		var err error
+		syncService := services.NewSyncService(tx, newSoundcloudService(), feedEvents)
+		syncService.FailureDB = models.DB
+		result, err = syncService.Sync(ctx, userID)
+		return err
+	})
+	if err != nil {
+		logging.Error("Feed sync job failed", err, logging.Fields{"user_id": userID, "request_id": services.RequestID(ctx)})
+		return err
+	}
+
+	logging.Info("Feed synced", logging.Fields{"user_id": userID, "new_tracks": len(result.NewTracks), "request_id": services.RequestID(ctx)})
+	queueAlertMatches(ctx, userID, result)
+	updateSimilarities(ctx, userID)
+	return nil
+}
+
+// updateSimilarities works out the similar tracks of the tracks a sync
+// stored. A failure is only logged: the tracks are scored after the next
+// sync instead.
+func updateSimilarities(ctx context.Context, userID string) {
+	var scored int
+	err := models.DB.Transaction(func(tx *pop.Connection) error {
+		var err error
+		scored, err = services.NewRecommendationService(tx).UpdateSimilarities(ctx, userID)
+		return err
+	})
+	if err != nil {
+		logging.Error("Error updating similar tracks", err, logging.Fields{"user_id": userID, "request_id": services.RequestID(ctx)})
+		return
+	}
+	logging.Info("Similar tracks updated", logging.Fields{"user_id": userID, "scored_tracks": scored})
+}
+
+// reclassifyJob reclassifies a user's tracks after they have taught the
+// classifier with an override
+func reclassifyJob(args worker.Args) error {
+	userID := fmt.Sprintf("%v", args["user_id"])
+
+	var changed int
+	err := models.DB.Transaction(func(tx *pop.Connection) error {
+		var err error
+		changed, err = services.NewClassifierService(tx).Reclassify(userID)
+		return err
+	})
+	if err != nil {
+		logging.Error("Reclassify job failed", err, logging.Fields{"user_id": userID})
+		return err
+	}
+
+	logging.Info("Tracks reclassified", logging.Fields{"user_id": userID, "changed": changed})
+	return nil
+}
+
+// pushPlaylistJob fills an exported playlist on Soundcloud from its
+// filter, recording the error on the export when the push fails
+func pushPlaylistJob(args worker.Args) error {
+	exportID := fmt.Sprintf("%v", args["export_id"])
+	ctx, cancel := jobContext(args, jobPushPlaylist)
+	defer cancel()
+
+	var count int
+	err := models.DB.Transaction(func(tx *pop.Connection) error {
+		export, err := services.NewPlaylistExportService(tx, newSoundcloudService()).Push(ctx, exportID)
+		if err == nil {
+			count = export.TrackCount
+		}
+		return err
+	})
+	if err != nil {
+		logging.Error("Playlist push failed", err, logging.Fields{"export_id": exportID, "request_id": services.RequestID(ctx)})
+		if rerr := models.DB.Transaction(func(tx *pop.Connection) error {
+			return services.NewPlaylistExportService(tx, nil).RecordFailure(exportID, err)
+		}); rerr != nil {
+			logging.Error("Error recording playlist push failure", rerr, logging.Fields{"export_id": exportID})
+		}
+		return err
+	}
+
+	logging.Info("Playlist pushed", logging.Fields{"export_id": exportID, "track_count": count})
+	return nil
+}
+
+// syncPlaylistsJob queues a push for every exported playlist and schedules
+// the next run
+func syncPlaylistsJob(args worker.Args) error {
+	var exportIDs []string
+	err := models.DB.Transaction(func(tx *pop.Connection) error {
+		var err error
+		exportIDs, err = services.NewPlaylistExportService(tx, nil).ExportIDs()
+		return err
+	})
+	if err != nil {
+		logging.Error("Playlist sync job failed", err)
+	}
+	for _, id := range exportIDs {
+		queuePlaylistPush(id)
+	}
+
+	interval, perr := time.ParseDuration(playlistSyncInterval)
+	if perr != nil {
+		return perr
+	}
+	if serr := app.Worker.PerformIn(worker.Job{Handler: jobSyncPlaylists}, interval); serr != nil {
+		return serr
+	}
+	return err
+}
+
+// buildDataExportJob writes the archive of a user's data, recording the
+// error on the export when the build fails
+func buildDataExportJob(args worker.Args) error {
+	exportID := fmt.Sprintf("%v", args["export_id"])
+	ctx, cancel := jobContext(args, jobBuildDataExport)
+	defer cancel()
+
+	var size int64
+	err := models.DB.Transaction(func(tx *pop.Connection) error {
+		export := &srcmodels.DataExport{}
+		if err := tx.Find(export, exportID); err != nil {
+			return err
+		}
+		files, err := dataExportFiles(tx, export.UserID.String())
+		if err != nil {
+			return err
+		}
+		export, err = services.NewDataExportService(tx, dataExportDir).Build(ctx, exportID, files)
+		if err == nil {
+			size = export.Size
+		}
+		return err
+	})
+	if err != nil {
+		logging.Error("Data export failed", err, logging.Fields{"export_id": exportID, "request_id": services.RequestID(ctx)})
+		if rerr := models.DB.Transaction(func(tx *pop.Connection) error {
+			return services.NewDataExportService(tx, dataExportDir).RecordFailure(exportID, err)
+		}); rerr != nil {
+			logging.Error("Error recording data export failure", rerr, logging.Fields{"export_id": exportID})
+		}
+		return err
+	}
+
+	logging.Info("Data export built", logging.Fields{"export_id": exportID, "size": size})
+	return nil
+}
+
+// jobContext returns the context a job's service calls run under. It times
+// out after jobTimeout and carries the id of the request that queued the
+// job, or a new one, so the job's Soundcloud calls can be traced.
+func jobContext(args worker.Args, job string) (context.Context, context.CancelFunc) {
+	requestID, _ := args["request_id"].(string)
+	if requestID == "" {
+		requestID = job + "-" + uuid.Must(uuid.NewV4()).String()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), jobTimeout)
+	return services.WithRequestID(ctx, requestID), cancel
+}
+
+// queuePlaylistPush hands a playlist export to the background worker
+func queuePlaylistPush(exportID string) {
+	err := app.Worker.Perform(worker.Job{
+		Handler: jobPushPlaylist,
+		Args:    worker.Args{"export_id": exportID},
+	})
+	if err != nil {
+		logging.Error("Error enqueueing playlist push", err, logging.Fields{"export_id": exportID})
+	}
+}
+
+// queueAlertMatches queues notifications for new tracks that match the
+// user's alerts or their notify rules, and hands webhook notifications to
+// the background worker under the request id of ctx. It uses its own
+// transaction so the worker never sees uncommitted notifications.
+func queueAlertMatches(ctx context.Context, userID string, result *services.SyncResult) {
+	var webhookIDs []string
+	err := models.DB.Transaction(func(tx *pop.Connection) error {
+		notificationService := services.NewNotificationService(tx)
+		notifications, err := notificationService.QueueMatches(userID, result.NewTracks)
+		if err != nil {
+			return err
+		}
+		ruleNotifications, err := notificationService.QueueRuleMatches(userID, result.RuleMatches)
+		if err != nil {
+			return err
+		}
+		notifications = append(notifications, ruleNotifications...)
+		for _, n := range notifications {
+			alert := &srcmodels.Alert{}
+			if err := tx.Find(alert, n.AlertID); err != nil {
+				return err
+			}
+			if alert.Channel == srcmodels.AlertChannelWebhook {
+				webhookIDs = append(webhookIDs, n.ID.String())
+			}
+		}
+		if len(notifications) > 0 {
+			logging.Info("Queued alert notifications", logging.Fields{"user_id": userID, "count": len(notifications)})
+		}
+		return nil
+	})
+	if err != nil {
+		logging.Error("Error queueing alert notifications", err, logging.Fields{"user_id": userID})
+		return
+	}
+
+	for _, id := range webhookIDs {
+		err := app.Worker.Perform(worker.Job{
+			Handler: jobDeliverWebhook,
+			Args:    worker.Args{"notification_id": id, "request_id": services.RequestID(ctx)},
+		})
+		if err != nil {
+			logging.Error("Error enqueueing webhook delivery", err, logging.Fields{"notification_id": id})
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package actions
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/gobuffalo/pop/v6"
+	"github.com/jbhicks/sound-cistern/models"
+	"github.com/jbhicks/sound-cistern/pkg/logging"
+	"github.com/jbhicks/sound-cistern/src/services"
+)
+
+// LabelsIndex lists the current user's labels with how many tracks carry
+// each
+func LabelsIndex(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	user := c.Value("current_user").(*models.User)
+
+	labelService := services.NewLabelService(tx)
+	labels, err := labelService.Labels(user.ID.String())
+	if err != nil {
+		logging.Error("Error loading labels", err, logging.Fields{"user_id": user.ID.String()})
+		return c.Error(http.StatusInternalServerError, errors.New("failed to load labels"))
+	}
+	counts, err := labelService.Counts(user.ID.String())
+	if err != nil {
+		logging.Error("Error counting labeled tracks", err, logging.Fields{"user_id": user.ID.String()})
+		return c.Error(http.StatusInternalServerError, errors.New("failed to load labels"))
+	}
+
+	c.Set("labels", labels)
+	c.Set("counts", counts)
+	c.Set("defaultColor", services.DefaultLabelColor)
+	return c.Render(http.StatusOK, r.HTML("labels/index.html"))
+}
+
+// LabelsCreate adds a label for the current user
+func LabelsCreate(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	user := c.Value("current_user").(*models.User)
+
+	label, err := services.NewLabelService(tx).Create(user.ID.String(), c.Param("name"), c.Param("color"))
+	switch {
+	case errors.Is(err, services.ErrInvalidLabel), errors.Is(err, services.ErrDuplicateLabel):
+		c.Flash().Add("danger", err.Error())
+		return c.Redirect(http.StatusSeeOther, "/labels")
+	case err != nil:
+		logging.Error("Error creating label", err, logging.Fields{"user_id": user.ID.String()})
+		return c.Error(http.StatusInternalServerError, errors.New("failed to create label"))
+	}
+
+	logging.UserAction(c, user.Email, "label_create", "Created a track label", logging.Fields{
+		"label_id": label.ID.String(),
+	})
+
+	c.Flash().Add("success", "Label created")
+	return c.Redirect(http.StatusSeeOther, "/labels")
+}
+
+// LabelsDestroy deletes one of the current user's labels and takes it off
+// every track
+func LabelsDestroy(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	user := c.Value("current_user").(*models.User)
+
+	label, err := services.NewLabelService(tx).Label(user.ID.String(), c.Param("label_id"))
+	if err != nil {
+		return c.Error(http.StatusNotFound, err)
+	}
+	if err := tx.Destroy(label); err != nil {
+		return err
+	}
+
+	logging.UserAction(c, user.Email, "label_delete", "Deleted a track label", logging.Fields{
+		"label_id": label.ID.String(),
+	})
+
+	c.Flash().Add("success", "Label deleted")
+	return c.Redirect(http.StatusSeeOther, "/labels")
+}
+
+// LabelSelection puts a label on every stored track the submitted feed
+// filter matches, then returns to the filtered feed
+func LabelSelection(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	user := c.Value("current_user").(*models.User)
+
+	if err := c.Request().ParseForm(); err != nil {
+		return c.Error(http.StatusBadRequest, errors.New("invalid label form"))
+	}
+	params := c.Request().Form
+	criteria := services.CriteriaFromValues(params)
+	sort := services.NormalizeSort(params.Get("sort"))
+
+	labelService := services.NewLabelService(tx)
+	label, err := labelService.Label(user.ID.String(), c.Param("label_id"))
+	if err != nil {
+		return c.Error(http.StatusNotFound, err)
+	}
+	labeled, err := labelService.LabelSelection(user.ID.String(), label, criteria)
+	if err != nil {
+		logging.Error("Error labeling tracks", err, logging.Fields{"user_id": user.ID.String()})
+		return c.Error(http.StatusInternalServerError, errors.New("failed to label tracks"))
+	}
+
+	logging.UserAction(c, user.Email, "label_selection", "Labeled the filtered tracks", logging.Fields{
+		"label_id": label.ID.String(),
+		"count":    labeled,
+	})
+
+	c.Flash().Add("success", fmt.Sprintf("Labeled %d tracks as %s", labeled, label.Name))
+	feedURL := "/feed"
+	if query := feedValues(criteria, sort).Encode(); query != "" {
+		feedURL += "?" + query
+	}
+	return c.Redirect(http.StatusSeeOther, feedURL)
+}
+
+// TrackLabelsUpdate replaces the labels on a track. HTMX requests get the
+// updated labels and note form back.
+func TrackLabelsUpdate(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	user := c.Value("current_user").(*models.User)
+
+	track, err := services.NewFeedService(tx).Track(requestContext(c), user.ID.String(), c.Param("track_id"))
+	if err != nil {
+		return c.Error(http.StatusNotFound, err)
+	}
+	if err := c.Request().ParseForm(); err != nil {
+		return c.Error(http.StatusBadRequest, errors.New("invalid label form"))
+	}
+	if err := services.NewLabelService(tx).SetTrackLabels(user.ID.String(), track, c.Request().Form["labels"]); err != nil {
+		logging.Error("Error labeling track", err, logging.Fields{"user_id": user.ID.String()})
+		return c.Error(http.StatusInternalServerError, errors.New("failed to update track"))
+	}
+
+	return renderTrackAnnotations(c, tx, user, track.SoundcloudID)
+}
+
+// TrackNoteUpdate saves the user's note on a track, or removes it when
+// the note is empty
+func TrackNoteUpdate(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	user := c.Value("current_user").(*models.User)
+
+	track, err := services.NewFeedService(tx).Track(requestContext(c), user.ID.String(), c.Param("track_id"))
+	if err != nil {
+		return c.Error(http.StatusNotFound, err)
+	}
+	if err := services.NewLabelService(tx).SetNote(track, c.Param("note")); err != nil {
+		logging.Error("Error saving track note", err, logging.Fields{"user_id": user.ID.String()})
+		return c.Error(http.StatusInternalServerError, errors.New("failed to update track"))
+	}
+
+	return renderTrackAnnotations(c, tx, user, track.SoundcloudID)
+}
+
+// renderTrackAnnotations answers a label or note change with the updated
+// form for HTMX requests and a redirect to the track otherwise
+func renderTrackAnnotations(c buffalo.Context, tx *pop.Connection, user *models.User, soundcloudID string) error {
+	if !IsHTMX(c.Request()) {
+		return c.Redirect(http.StatusSeeOther, "/tracks/"+soundcloudID)
+	}
+
+	feedService := services.NewFeedService(tx)
+	ctx := requestContext(c)
+	track, err := feedService.Track(ctx, user.ID.String(), soundcloudID)
+	if err != nil {
+		return c.Error(http.StatusNotFound, err)
+	}
+	trackMap, err := feedService.TrackMap(ctx, track)
+	if err != nil {
+		return err
+	}
+	labels, err := services.NewLabelService(tx).Labels(user.ID.String())
+	if err != nil {
+		return err
+	}
+
+	c.Set("track", trackMap)
+	c.Set("labels", labels)
+	return c.Render(http.StatusOK, rHTMX.HTML("tracks/_annotations.html"))
+}
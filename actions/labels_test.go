@@ -0,0 +1,92 @@
+package actions
+
+import (
+	"net/http"
+	"net/url"
+
+	srcmodels "github.com/jbhicks/sound-cistern/src/models"
+)
+
+func (as *ActionSuite) Test_LabelsCreate_ValidatesColor() {
+	user := as.createAndLoginUser("labels@example.com", "user")
+
+	res := as.HTML("/labels").Post(url.Values{"name": {"Warm-up"}, "color": {"#FF8800"}})
+	as.Equal(http.StatusSeeOther, res.Code)
+	res = as.HTML("/labels").Post(url.Values{"name": {"Broken"}, "color": {"orange"}})
+	as.Equal(http.StatusSeeOther, res.Code)
+	res = as.HTML("/labels").Post(url.Values{"name": {"warm-up"}})
+	as.Equal(http.StatusSeeOther, res.Code)
+
+	labels := srcmodels.Labels{}
+	as.NoError(as.DB.Where("user_id = ?", user.ID).All(&labels))
+	as.Len(labels, 1)
+	as.Equal("#ff8800", labels[0].Color)
+}
+
+func (as *ActionSuite) Test_LabelSelection_LabelsFilteredTracks() {
+	user := as.createAndLoginUser("bulk@example.com", "user")
+	as.seedCachedFeed(user.ID.String(), []interface{}{
+		map[string]interface{}{"id": float64(1), "title": "Short edit", "duration": float64(180000)},
+		map[string]interface{}{"id": float64(2), "title": "Long mix", "duration": float64(3600000)},
+	})
+	as.HTML("/labels").Post(url.Values{"name": {"Marathon"}})
+	label := &srcmodels.Label{}
+	as.NoError(as.DB.First(label))
+
+	res := as.HTML("/labels/selection").Post(url.Values{"label_id": {label.ID.String()}, "min_length": {"600"}})
+	as.Equal(http.StatusSeeOther, res.Code)
+	as.Equal("/feed?min_length=600", res.Location())
+
+	res = as.HTML("/feed?labels=" + label.ID.String()).Get()
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), "Long mix")
+	as.NotContains(res.Body.String(), "Short edit")
+
+	jsonRes := as.JSON("/filter").Post(map[string]interface{}{"labels": []interface{}{label.ID.String()}})
+	as.Equal(http.StatusOK, jsonRes.Code)
+	tracks := []map[string]interface{}{}
+	jsonRes.Bind(&tracks)
+	as.Len(tracks, 1)
+	as.Equal("Long mix", tracks[0]["title"])
+	as.NotEmpty(tracks[0]["labels"])
+}
+
+func (as *ActionSuite) Test_TrackLabelsAndNote() {
+	user := as.createAndLoginUser("annotate@example.com", "user")
+	as.seedCachedFeed(user.ID.String(), []interface{}{
+		map[string]interface{}{"id": float64(7), "title": "Opener", "duration": float64(300000)},
+	})
+	as.HTML("/labels").Post(url.Values{"name": {"Warm-up"}, "color": {"#336699"}})
+	label := &srcmodels.Label{}
+	as.NoError(as.DB.First(label))
+
+	req := as.HTML("/tracks/7/labels")
+	req.Headers["HX-Request"] = "true"
+	res := req.Post(url.Values{"labels": {label.ID.String()}})
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), "checked")
+
+	res = as.HTML("/tracks/7/note").Post(url.Values{"note": {"Play before midnight"}})
+	as.Equal(http.StatusSeeOther, res.Code)
+
+	res = as.HTML("/tracks/7").Get()
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), "Play before midnight")
+	as.Contains(res.Body.String(), "Warm-up")
+
+	as.HTML("/tracks/7/note").Post(url.Values{"note": {"  "}})
+	count, err := as.DB.Count(&srcmodels.TrackNote{})
+	as.NoError(err)
+	as.Equal(0, count)
+}
+
+func (as *ActionSuite) Test_LabelsDestroy_OnlyOwnLabels() {
+	as.createAndLoginUser("labelowner@example.com", "user")
+	as.HTML("/labels").Post(url.Values{"name": {"Mine"}})
+	label := &srcmodels.Label{}
+	as.NoError(as.DB.First(label))
+
+	as.createAndLoginUser("labelother@example.com", "user")
+	res := as.HTML("/labels/" + label.ID.String()).Delete()
+	as.Equal(http.StatusNotFound, res.Code)
+}
@@ -0,0 +1,159 @@
+package actions
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/gobuffalo/pop/v6"
+	"github.com/jbhicks/sound-cistern/models"
+	"github.com/jbhicks/sound-cistern/pkg/logging"
+	"github.com/jbhicks/sound-cistern/src/services"
+)
+
+// PlayerShow renders the persistent player with the current user's queue
+func PlayerShow(c buffalo.Context) error {
+	return renderPlayer(c, -1)
+}
+
+// PlayerPlay moves a track to the front of the queue so it plays now. An
+// optional start, in seconds, seeks to that point, such as a tracklist entry.
+func PlayerPlay(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	user := c.Value("current_user").(*models.User)
+
+	if err := services.NewPlayerService(tx).PlayNow(user.ID.String(), c.Param("track_id")); err != nil {
+		return playerError(c, err)
+	}
+
+	if c.Param("start") == "" {
+		return renderPlayer(c, -1)
+	}
+
+	start, err := strconv.Atoi(c.Param("start"))
+	if err != nil || start < 0 {
+		return c.Error(http.StatusBadRequest, errors.New("invalid start"))
+	}
+	// Saving the position makes the player resume from the start point
+	if _, err := services.NewListenService(tx).RecordPosition(user.ID.String(), c.Param("track_id"), start, 0, false); err != nil {
+		return playerError(c, err)
+	}
+	return renderPlayer(c, start)
+}
+
+// PlayerEnqueue adds a track to the end of the queue
+func PlayerEnqueue(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	user := c.Value("current_user").(*models.User)
+
+	if err := services.NewPlayerService(tx).Enqueue(user.ID.String(), c.Param("track_id")); err != nil {
+		return playerError(c, err)
+	}
+	return renderPlayer(c, -1)
+}
+
+// PlayerNext skips to the next track in the queue
+func PlayerNext(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	user := c.Value("current_user").(*models.User)
+
+	if err := services.NewPlayerService(tx).Next(user.ID.String()); err != nil {
+		return playerError(c, err)
+	}
+	return renderPlayer(c, -1)
+}
+
+// PlayerRemove takes a track out of the queue
+func PlayerRemove(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	user := c.Value("current_user").(*models.User)
+
+	if err := services.NewPlayerService(tx).Remove(user.ID.String(), c.Param("item_id")); err != nil {
+		return playerError(c, err)
+	}
+	return renderPlayer(c, -1)
+}
+
+// PlayerPosition records how far the user has got through a track. The
+// player reports periodically while playing, on pause and when a track
+// ends, which marks it as heard.
+func PlayerPosition(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	user := c.Value("current_user").(*models.User)
+
+	position, err := strconv.ParseFloat(c.Param("position"), 64)
+	if err != nil {
+		return c.Error(http.StatusBadRequest, errors.New("invalid position"))
+	}
+	duration, _ := strconv.ParseFloat(c.Param("duration"), 64)
+	ended := c.Param("ended") == "true"
+
+	_, err = services.NewListenService(tx).RecordPosition(user.ID.String(), c.Param("track_id"), int(position), int(duration), ended)
+	if err != nil {
+		return playerError(c, err)
+	}
+	return c.Render(http.StatusNoContent, nil)
+}
+
+// TrackStream resolves a track to a playable stream with the user's stored
+// Soundcloud token and redirects the audio element to it. Podcast episodes
+// play straight from their enclosure.
+func TrackStream(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	user := c.Value("current_user").(*models.User)
+
+	if track, err := services.NewFeedService(tx).Track(requestContext(c), user.ID.String(), c.Param("track_id")); err == nil {
+		if enclosure := services.EnclosureURL(track.Map()); enclosure != "" {
+			return c.Redirect(http.StatusFound, enclosure)
+		}
+	}
+
+	token, err := services.NewAccountService(tx).Token(user.ID.String())
+	if err != nil {
+		return c.Error(http.StatusUnauthorized, errors.New("Soundcloud account not connected"))
+	}
+
+	streamURL, err := newSoundcloudService().ResolveStream(requestContext(c), token, c.Param("track_id"))
+	if errors.Is(err, services.ErrStreamUnavailable) {
+		return c.Error(http.StatusNotFound, err)
+	}
+	if err != nil {
+		logging.Error("Error resolving track stream", err, logging.Fields{
+			"user_id":  user.ID.String(),
+			"track_id": c.Param("track_id"),
+		})
+		return c.Error(http.StatusBadGateway, errors.New("could not reach Soundcloud"))
+	}
+
+	// Signed stream URLs expire, so never let the redirect be cached
+	c.Response().Header().Set("Cache-Control", "no-store")
+	return c.Redirect(http.StatusFound, streamURL)
+}
+
+// renderPlayer renders the player partial for the current user's queue.
+// A seek of zero or more moves the playing track to that position.
+func renderPlayer(c buffalo.Context, seek int) error {
+	tx := c.Value("tx").(*pop.Connection)
+	user := c.Value("current_user").(*models.User)
+
+	queue, err := services.NewPlayerService(tx).Queue(user.ID.String())
+	if err != nil {
+		logging.Error("Error loading play queue", err, logging.Fields{"user_id": user.ID.String()})
+		return c.Error(http.StatusInternalServerError, errors.New("failed to load queue"))
+	}
+
+	c.Set("queue", queue)
+	c.Set("seek", seek)
+	return c.Render(http.StatusOK, rHTMX.HTML("player/show.html"))
+}
+
+// playerError maps a queue change failure to a response
+func playerError(c buffalo.Context, err error) error {
+	if errors.Is(err, services.ErrTrackNotFound) {
+		return c.Error(http.StatusNotFound, err)
+	}
+	user := c.Value("current_user").(*models.User)
+	logging.Error("Error updating play queue", err, logging.Fields{"user_id": user.ID.String()})
+	return c.Error(http.StatusInternalServerError, errors.New("failed to update queue"))
+}
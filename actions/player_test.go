@@ -0,0 +1,78 @@
+package actions
+
+import (
+	"net/http"
+	"net/url"
+)
+
+func (as *ActionSuite) Test_Player_QueueAndSkip() {
+	user := as.createAndLoginUser("player@example.com", "user")
+	as.seedCachedFeed(user.ID.String(), []interface{}{
+		map[string]interface{}{"id": float64(1), "title": "First track"},
+		map[string]interface{}{"id": float64(2), "title": "Second track"},
+	})
+
+	res := as.HTML("/player/tracks/1/queue").Post(url.Values{})
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), `data-stream="/tracks/1/stream"`)
+
+	res = as.HTML("/player/tracks/2/play").Post(url.Values{})
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), `data-stream="/tracks/2/stream"`)
+	as.Contains(res.Body.String(), "First track")
+
+	res = as.HTML("/player/next").Post(url.Values{})
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), `data-stream="/tracks/1/stream"`)
+	as.NotContains(res.Body.String(), "Second track")
+
+	res = as.HTML("/player").Get()
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), "First track")
+}
+
+func (as *ActionSuite) Test_Player_UnknownTrack() {
+	as.createAndLoginUser("playermissing@example.com", "user")
+
+	res := as.HTML("/player/tracks/999/play").Post(url.Values{})
+	as.Equal(http.StatusNotFound, res.Code)
+}
+
+func (as *ActionSuite) Test_TrackStream_RequiresSoundcloudLink() {
+	as.createAndLoginUser("stream@example.com", "user")
+
+	res := as.HTML("/tracks/1/stream").Get()
+	as.Equal(http.StatusUnauthorized, res.Code)
+}
+
+func (as *ActionSuite) Test_PlayerPosition_ResumeAndHeard() {
+	user := as.createAndLoginUser("resume@example.com", "user")
+	as.seedCachedFeed(user.ID.String(), []interface{}{
+		map[string]interface{}{"id": float64(1), "title": "Long mix", "duration": float64(7200000)},
+		map[string]interface{}{"id": float64(2), "title": "Short edit", "duration": float64(180000)},
+	})
+
+	res := as.HTML("/player/tracks/1/position").Post(url.Values{"position": {"5025"}, "duration": {"7200"}})
+	as.Equal(http.StatusNoContent, res.Code)
+
+	res = as.HTML("/feed").Get()
+	as.Contains(res.Body.String(), "Resume at 1:23:45")
+
+	res = as.HTML("/feed?partial=true").Get()
+	as.Contains(res.Body.String(), "Long mix")
+	as.NotContains(res.Body.String(), "Short edit")
+
+	res = as.HTML("/player/tracks/1/position").Post(url.Values{"position": {"7190"}, "duration": {"7200"}})
+	as.Equal(http.StatusNoContent, res.Code)
+
+	res = as.HTML("/feed").Get()
+	as.NotContains(res.Body.String(), "Resume at")
+	as.Contains(res.Body.String(), "Heard")
+}
+
+func (as *ActionSuite) Test_PlayerPosition_RejectsBadPosition() {
+	as.createAndLoginUser("badposition@example.com", "user")
+
+	res := as.HTML("/player/tracks/1/position").Post(url.Values{"position": {"soon"}})
+	as.Equal(http.StatusBadRequest, res.Code)
+}
@@ -0,0 +1,111 @@
+package actions
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/gobuffalo/pop/v6"
+	"github.com/jbhicks/sound-cistern/models"
+	"github.com/jbhicks/sound-cistern/pkg/logging"
+	srcmodels "github.com/jbhicks/sound-cistern/src/models"
+	"github.com/jbhicks/sound-cistern/src/services"
+)
+
+// PlaylistsIndex lists the filters the user has pushed to Soundcloud
+// playlists
+func PlaylistsIndex(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	user := c.Value("current_user").(*models.User)
+
+	exports, err := services.NewPlaylistExportService(tx, nil).Exports(user.ID.String())
+	if err != nil {
+		logging.Error("Error loading playlist exports", err, logging.Fields{"user_id": user.ID.String()})
+		return c.Error(http.StatusInternalServerError, errors.New("failed to load playlists"))
+	}
+
+	c.Set("exports", exports)
+	return c.Render(http.StatusOK, r.HTML("playlists/index.html"))
+}
+
+// PlaylistsCreate saves the current feed filter as a preset and pushes it
+// to a new Soundcloud playlist in the background
+func PlaylistsCreate(c buffalo.Context) error {
+	user := c.Value("current_user").(*models.User)
+
+	if err := c.Request().ParseForm(); err != nil {
+		return c.Error(http.StatusBadRequest, errors.New("invalid playlist form"))
+	}
+	params := c.Request().Form
+	criteria := services.CriteriaFromValues(params)
+	sort := services.NormalizeSort(params.Get("sort"))
+
+	title := strings.TrimSpace(params.Get("title"))
+	if title == "" {
+		c.Flash().Add("danger", "Playlist title is required")
+		feedURL := "/feed"
+		if query := feedValues(criteria, sort).Encode(); query != "" {
+			feedURL += "?" + query
+		}
+		return c.Redirect(http.StatusSeeOther, feedURL)
+	}
+
+	// Commit before the push job can run so it finds the export
+	var export *srcmodels.PlaylistExport
+	err := models.DB.Transaction(func(tx *pop.Connection) error {
+		var err error
+		export, err = services.NewPlaylistExportService(tx, nil).Create(user.ID.String(), title, criteria, sort)
+		return err
+	})
+	if err != nil {
+		logging.Error("Error saving playlist export", err, logging.Fields{"user_id": user.ID.String()})
+		return c.Error(http.StatusInternalServerError, errors.New("failed to save playlist"))
+	}
+
+	logging.UserAction(c, user.Email, "playlist_export_create", "Pushed a filter to a Soundcloud playlist", logging.Fields{
+		"export_id": export.ID.String(),
+	})
+	queuePlaylistPush(export.ID.String())
+
+	c.Flash().Add("success", "Your playlist is being pushed to Soundcloud")
+	return c.Redirect(http.StatusSeeOther, "/playlists")
+}
+
+// PlaylistPush re-syncs one exported playlist with its filter now rather
+// than waiting for the schedule
+func PlaylistPush(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	user := c.Value("current_user").(*models.User)
+
+	export, err := services.NewPlaylistExportService(tx, nil).Export(user.ID.String(), c.Param("export_id"))
+	if err != nil {
+		return c.Error(http.StatusNotFound, err)
+	}
+	queuePlaylistPush(export.ID.String())
+
+	c.Flash().Add("success", "Your playlist is being pushed to Soundcloud")
+	return c.Redirect(http.StatusSeeOther, "/playlists")
+}
+
+// PlaylistsDestroy stops syncing a filter to its playlist. The playlist
+// itself stays on Soundcloud.
+func PlaylistsDestroy(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	user := c.Value("current_user").(*models.User)
+
+	export, err := services.NewPlaylistExportService(tx, nil).Export(user.ID.String(), c.Param("export_id"))
+	if err != nil {
+		return c.Error(http.StatusNotFound, err)
+	}
+	if err := tx.Destroy(export); err != nil {
+		return err
+	}
+
+	logging.UserAction(c, user.Email, "playlist_export_delete", "Stopped syncing a Soundcloud playlist", logging.Fields{
+		"export_id": export.ID.String(),
+	})
+
+	c.Flash().Add("success", "Playlist will no longer be synced")
+	return c.Redirect(http.StatusSeeOther, "/playlists")
+}
@@ -0,0 +1,54 @@
+package actions
+
+import (
+	"net/http"
+	"net/url"
+
+	srcmodels "github.com/jbhicks/sound-cistern/src/models"
+)
+
+func (as *ActionSuite) Test_PlaylistsCreate_SavesPreset() {
+	user := as.createAndLoginUser("playlists@example.com", "user")
+
+	res := as.HTML("/playlists").Post(url.Values{
+		"title":      {"Long mixes"},
+		"min_length": {"3600"},
+		"sort":       {"longest"},
+	})
+	as.Equal(http.StatusSeeOther, res.Code)
+	as.Equal("/playlists", res.Location())
+
+	export := &srcmodels.PlaylistExport{}
+	as.NoError(as.DB.Where("user_id = ?", user.ID).First(export))
+	as.Equal("Long mixes", export.Title)
+	as.Equal("longest", export.Sort)
+	as.Contains(export.Criteria, `"min_length":3600`)
+
+	res = as.HTML("/playlists").Get()
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), "Long mixes")
+	as.Contains(res.Body.String(), "Pending")
+}
+
+func (as *ActionSuite) Test_PlaylistsCreate_RequiresTitle() {
+	as.createAndLoginUser("notitle@example.com", "user")
+
+	res := as.HTML("/playlists").Post(url.Values{"min_length": {"600"}})
+	as.Equal(http.StatusSeeOther, res.Code)
+	as.Equal("/feed?min_length=600", res.Location())
+
+	count, err := as.DB.Count(&srcmodels.PlaylistExport{})
+	as.NoError(err)
+	as.Equal(0, count)
+}
+
+func (as *ActionSuite) Test_PlaylistsDestroy_OnlyOwnExports() {
+	as.createAndLoginUser("owner@example.com", "user")
+	as.HTML("/playlists").Post(url.Values{"title": {"Mine"}})
+	export := &srcmodels.PlaylistExport{}
+	as.NoError(as.DB.First(export))
+
+	as.createAndLoginUser("other@example.com", "user")
+	res := as.HTML("/playlists/" + export.ID.String()).Delete()
+	as.Equal(http.StatusNotFound, res.Code)
+}
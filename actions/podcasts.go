@@ -0,0 +1,91 @@
+package actions
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/gobuffalo/pop/v6"
+	"github.com/jbhicks/sound-cistern/models"
+	"github.com/jbhicks/sound-cistern/pkg/logging"
+	"github.com/jbhicks/sound-cistern/src/services"
+)
+
+// PodcastsIndex lists the feeds the current user follows alongside
+// Soundcloud
+func PodcastsIndex(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	user := c.Value("current_user").(*models.User)
+
+	subscriptions, err := services.NewPodcastService(tx).Subscriptions(user.ID.String())
+	if err != nil {
+		logging.Error("Error loading podcast subscriptions", err, logging.Fields{"user_id": user.ID.String()})
+		return c.Error(http.StatusInternalServerError, errors.New("failed to load podcasts"))
+	}
+
+	c.Set("subscriptions", subscriptions)
+	return c.Render(http.StatusOK, r.HTML("podcasts/index.html"))
+}
+
+// PodcastsCreate subscribes the current user to a feed and stores its
+// episodes. New episodes go through the user's rules and alerts like newly
+// synced tracks.
+func PodcastsCreate(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	user := c.Value("current_user").(*models.User)
+
+	subscription, newTracks, err := services.NewPodcastService(tx).Subscribe(requestContext(c), user.ID.String(), c.Param("url"))
+	switch {
+	case errors.Is(err, services.ErrInvalidFeedURL), errors.Is(err, services.ErrDuplicateSubscription):
+		c.Flash().Add("danger", err.Error())
+		return c.Redirect(http.StatusSeeOther, "/podcasts")
+	case errors.Is(err, services.ErrFeedUnavailable):
+		c.Flash().Add("danger", "Could not read an RSS, Atom or podcast feed at that address")
+		return c.Redirect(http.StatusSeeOther, "/podcasts")
+	case errors.Is(err, services.ErrNoUsableAccount):
+		c.Flash().Add("danger", "Connect a Soundcloud account before subscribing to podcasts")
+		return c.Redirect(http.StatusSeeOther, "/podcasts")
+	case err != nil:
+		logging.Error("Error subscribing to podcast", err, logging.Fields{"user_id": user.ID.String()})
+		return c.Error(http.StatusInternalServerError, errors.New("failed to subscribe"))
+	}
+
+	rules, err := services.NewRuleService(tx).Apply(user.ID.String(), newTracks)
+	if err != nil {
+		logging.Error("Error applying rules to podcast episodes", err, logging.Fields{"user_id": user.ID.String()})
+		return c.Error(http.StatusInternalServerError, errors.New("failed to subscribe"))
+	}
+	queueAlertMatches(requestContext(c), user.ID.String(), &services.SyncResult{NewTracks: rules.Visible, RuleMatches: rules.Notify})
+
+	logging.UserAction(c, user.Email, "podcast_subscribe", "Subscribed to a podcast feed", logging.Fields{
+		"subscription_id": subscription.ID.String(),
+		"episodes":        len(newTracks),
+	})
+
+	c.Flash().Add("success", fmt.Sprintf("Subscribed to %s with %d episodes", subscription.DisplayName(), len(newTracks)))
+	return c.Redirect(http.StatusSeeOther, "/podcasts")
+}
+
+// PodcastsDestroy unsubscribes the current user from a feed. Episodes
+// already stored stay in the feed.
+func PodcastsDestroy(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	user := c.Value("current_user").(*models.User)
+
+	podcastService := services.NewPodcastService(tx)
+	subscription, err := podcastService.Subscription(user.ID.String(), c.Param("subscription_id"))
+	if err != nil {
+		return c.Error(http.StatusNotFound, err)
+	}
+	if err := podcastService.Unsubscribe(subscription); err != nil {
+		return err
+	}
+
+	logging.UserAction(c, user.Email, "podcast_unsubscribe", "Unsubscribed from a podcast feed", logging.Fields{
+		"subscription_id": subscription.ID.String(),
+	})
+
+	c.Flash().Add("success", "Unsubscribed from "+subscription.DisplayName())
+	return c.Redirect(http.StatusSeeOther, "/podcasts")
+}
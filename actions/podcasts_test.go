@@ -0,0 +1,70 @@
+package actions
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+
+	srcmodels "github.com/jbhicks/sound-cistern/src/models"
+	"github.com/jbhicks/sound-cistern/src/services"
+)
+
+func (as *ActionSuite) Test_PodcastsCreate_StoresEpisodes() {
+	user := as.createAndLoginUser("podcasts@example.com", "user")
+	as.seedCachedFeed(user.ID.String(), []interface{}{
+		map[string]interface{}{"id": float64(1), "title": "Soundcloud track", "duration": float64(300000)},
+	})
+	// The test feed is served on loopback, which feeds are not fetched from
+	defer services.UseFeedTransport(services.UseFeedTransport(http.DefaultTransport))
+	feed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<rss version="2.0" xmlns:itunes="http://www.itunes.com/dtds/podcast-1.0.dtd"><channel>
+  <title>Deep Mix Series</title>
+  <item>
+    <guid>episode-1</guid>
+    <title>Warehouse episode</title>
+    <link>https://example.com/episodes/1</link>
+    <itunes:duration>3600</itunes:duration>
+    <enclosure url="https://cdn.example.com/1.mp3" type="audio/mpeg"/>
+  </item>
+  <item>
+    <guid>episode-2</guid>
+    <title>Rooftop episode</title>
+    <link>javascript:alert(document.cookie)</link>
+    <enclosure url="https://cdn.example.com/2.mp3" type="audio/mpeg"/>
+  </item>
+</channel></rss>`))
+	}))
+	defer feed.Close()
+
+	res := as.HTML("/podcasts").Post(url.Values{"url": {feed.URL}})
+	as.Equal(http.StatusSeeOther, res.Code)
+	res = as.HTML("/podcasts").Post(url.Values{"url": {feed.URL}})
+	as.Equal(http.StatusSeeOther, res.Code)
+
+	subscriptions := srcmodels.PodcastSubscriptions{}
+	as.NoError(as.DB.Where("user_id = ?", user.ID).All(&subscriptions))
+	as.Len(subscriptions, 1)
+	as.Equal("Deep Mix Series", subscriptions[0].Title)
+
+	res = as.HTML("/feed?sources=podcasts").Get()
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), "Warehouse episode")
+	as.Contains(res.Body.String(), "Episode of Deep Mix Series")
+	as.NotContains(res.Body.String(), "Soundcloud track")
+	as.Contains(res.Body.String(), "Rooftop episode")
+	as.NotContains(res.Body.String(), "javascript:")
+	as.Contains(res.Body.String(), "Open episode page")
+	as.NotContains(res.Body.String(), "Listen on Soundcloud")
+}
+
+func (as *ActionSuite) Test_PodcastsCreate_RejectsInvalidURL() {
+	user := as.createAndLoginUser("badfeed@example.com", "user")
+	as.seedCachedFeed(user.ID.String(), []interface{}{})
+
+	res := as.HTML("/podcasts").Post(url.Values{"url": {"ftp://example.com/feed"}})
+	as.Equal(http.StatusSeeOther, res.Code)
+
+	count, err := as.DB.Count(&srcmodels.PodcastSubscription{})
+	as.NoError(err)
+	as.Equal(0, count)
+}
@@ -0,0 +1,115 @@
+package actions
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/gobuffalo/pop/v6"
+	"github.com/jbhicks/sound-cistern/models"
+	"github.com/jbhicks/sound-cistern/pkg/logging"
+	srcmodels "github.com/jbhicks/sound-cistern/src/models"
+	"github.com/jbhicks/sound-cistern/src/services"
+)
+
+// recommendationLimit is how many similar tracks a track page shows
+const recommendationLimit = 8
+
+// RecommendationExclusionsCreate stops recommending an artist's tracks
+func RecommendationExclusionsCreate(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	user := c.Value("current_user").(*models.User)
+
+	exclusion, err := services.NewRecommendationService(tx).ExcludeArtist(user.ID.String(), c.Param("artist_id"))
+	if errors.Is(err, services.ErrArtistNotFound) {
+		return c.Error(http.StatusNotFound, err)
+	}
+	if err != nil {
+		logging.Error("Error excluding artist from recommendations", err, logging.Fields{"user_id": user.ID.String()})
+		return c.Error(http.StatusInternalServerError, errors.New("failed to update recommendations"))
+	}
+
+	logging.UserAction(c, user.Email, "recommendation_artist_exclude", "Stopped recommending an artist", logging.Fields{
+		"artist": exclusion.ArtistID,
+	})
+	return renderRecommendations(c, tx, user)
+}
+
+// RecommendationExclusionsDestroy recommends an excluded artist's tracks
+// again
+func RecommendationExclusionsDestroy(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	user := c.Value("current_user").(*models.User)
+
+	if err := services.NewRecommendationService(tx).IncludeArtist(user.ID.String(), c.Param("artist_id")); err != nil {
+		logging.Error("Error including artist in recommendations", err, logging.Fields{"user_id": user.ID.String()})
+		return c.Error(http.StatusInternalServerError, errors.New("failed to update recommendations"))
+	}
+
+	logging.UserAction(c, user.Email, "recommendation_artist_include", "Recommended an excluded artist again", logging.Fields{
+		"artist": c.Param("artist_id"),
+	})
+	return renderRecommendations(c, tx, user)
+}
+
+// renderRecommendations shows the recommendations of the track the change
+// was made from, or goes back to it
+func renderRecommendations(c buffalo.Context, tx *pop.Connection, user *models.User) error {
+	trackID := c.Param("track_id")
+	track, err := services.NewFeedService(tx).Track(requestContext(c), user.ID.String(), trackID)
+	if err != nil {
+		return c.Redirect(http.StatusSeeOther, "/feed")
+	}
+	if !IsHTMX(c.Request()) {
+		return c.Redirect(http.StatusSeeOther, "/tracks/"+trackID)
+	}
+	if err := setRecommendations(c, tx, track); err != nil {
+		return err
+	}
+	c.Set("track", map[string]interface{}{"id": track.SoundcloudID})
+	return c.Render(http.StatusOK, rHTMX.HTML("tracks/_recommendations.html"))
+}
+
+// setRecommendations sets the tracks similar to a track and the artists
+// the user has kept out of them
+func setRecommendations(c buffalo.Context, tx *pop.Connection, track *srcmodels.Track) error {
+	recommendationService := services.NewRecommendationService(tx)
+	recommendations, err := recommendationService.Similar(requestContext(c), track, recommendationLimit)
+	if err != nil {
+		logging.Error("Error loading similar tracks", err, logging.Fields{"track_id": track.ID.String()})
+		return c.Error(http.StatusInternalServerError, errors.New("failed to load recommendations"))
+	}
+	exclusions, err := recommendationService.Exclusions(track.UserID.String())
+	if err != nil {
+		logging.Error("Error loading recommendation exclusions", err, logging.Fields{"user_id": track.UserID.String()})
+		return c.Error(http.StatusInternalServerError, errors.New("failed to load recommendations"))
+	}
+	c.Set("recommendations", recommendations)
+	c.Set("exclusions", exclusions)
+	return nil
+}
+
+// updateRequestSimilarities scores the tracks a request stored, in the
+// request's transaction since they are not committed yet. The scoring runs
+// under a savepoint so a failure only loses the scores, which are worked
+// out after the next sync instead.
+func updateRequestSimilarities(ctx context.Context, tx *pop.Connection, userID string) {
+	if err := tx.RawQuery("SAVEPOINT similarities").Exec(); err != nil {
+		logging.Error("Error updating similar tracks", err, logging.Fields{"user_id": userID})
+		return
+	}
+	scored, err := services.NewRecommendationService(tx).UpdateSimilarities(ctx, userID)
+	if err != nil {
+		logging.Error("Error updating similar tracks", err, logging.Fields{"user_id": userID, "request_id": services.RequestID(ctx)})
+		if err := tx.RawQuery("ROLLBACK TO SAVEPOINT similarities").Exec(); err != nil {
+			logging.Error("Error rolling back similar tracks", err, logging.Fields{"user_id": userID})
+		}
+		return
+	}
+	if err := tx.RawQuery("RELEASE SAVEPOINT similarities").Exec(); err != nil {
+		logging.Error("Error updating similar tracks", err, logging.Fields{"user_id": userID})
+		return
+	}
+	logging.Info("Similar tracks updated", logging.Fields{"user_id": userID, "scored_tracks": scored})
+}
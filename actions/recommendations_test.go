@@ -0,0 +1,85 @@
+package actions
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	srcmodels "github.com/jbhicks/sound-cistern/src/models"
+	"github.com/jbhicks/sound-cistern/src/services"
+)
+
+func (as *ActionSuite) Test_TrackShow_RecommendsSimilarTracks() {
+	user := as.createAndLoginUser("similar@example.com", "user")
+	as.seedCachedFeed(user.ID.String(), []interface{}{
+		map[string]interface{}{"id": float64(21), "title": "Deep techno mix", "duration": float64(3600000), "genre": "Techno",
+			"tag_list": `berlin "dub techno"`, "user": map[string]interface{}{"id": float64(501), "username": "Basement"}},
+		map[string]interface{}{"id": float64(22), "title": "Dub techno hour", "duration": float64(3400000), "genre": "Techno",
+			"tag_list": `"dub techno"`, "user": map[string]interface{}{"id": float64(502), "username": "Echo Chamber"}},
+		map[string]interface{}{"id": float64(23), "title": "Acoustic folk", "duration": float64(240000), "genre": "Folk",
+			"tag_list": "guitar", "user": map[string]interface{}{"id": float64(503), "username": "Strummer"}},
+	})
+
+	recommendationService := services.NewRecommendationService(as.DB)
+	updated, err := recommendationService.UpdateSimilarities(context.Background(), user.ID.String())
+	as.NoError(err)
+	as.Equal(3, updated)
+	// Tracks already scored are not scored again on the next sync
+	updated, err = recommendationService.UpdateSimilarities(context.Background(), user.ID.String())
+	as.NoError(err)
+	as.Equal(0, updated)
+
+	res := as.HTML("/tracks/21").Get()
+	as.Equal(http.StatusOK, res.Code)
+	body := res.Body.String()
+	as.Contains(body, "Similar Tracks")
+	as.Contains(body, "Dub techno hour")
+	as.Contains(body, "dub techno")
+	as.NotContains(body, "Acoustic folk")
+
+	req := as.HTML("/recommendations/exclusions")
+	req.Headers["HX-Request"] = "true"
+	res = req.Post(url.Values{"artist_id": {"502"}, "track_id": {"21"}})
+	as.Equal(http.StatusOK, res.Code)
+	body = res.Body.String()
+	as.NotContains(body, "Dub techno hour")
+	as.Contains(body, "Echo Chamber")
+	as.Contains(body, "Recommend again")
+
+	exclusions := srcmodels.RecommendationExclusions{}
+	as.NoError(as.DB.Where("user_id = ?", user.ID).All(&exclusions))
+	as.Len(exclusions, 1)
+	as.Equal("Echo Chamber", exclusions[0].ArtistName)
+
+	req = as.HTML("/recommendations/exclusions/502?track_id=21")
+	req.Headers["HX-Request"] = "true"
+	res = req.Delete()
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), "Dub techno hour")
+}
+
+func (as *ActionSuite) Test_FeedIndex_ScoresFirstLoad() {
+	user := as.createAndLoginUser("firstload@example.com", "user")
+	_, err := services.NewAccountService(as.DB).SaveLink(user.ID.String(), "12345", "token")
+	as.NoError(err)
+	as.NoError(services.NewFeedService(as.DB).CacheFeed(context.Background(), user.ID.String(), []interface{}{
+		map[string]interface{}{"id": float64(31), "title": "Dub techno one", "duration": float64(3600000), "tag_list": `"dub techno"`},
+		map[string]interface{}{"id": float64(32), "title": "Dub techno two", "duration": float64(3600000), "tag_list": `"dub techno"`},
+	}))
+	as.Session.Set("soundcloud_access_token", "token")
+
+	res := as.HTML("/feed").Get()
+	as.Equal(http.StatusOK, res.Code)
+
+	res = as.HTML("/tracks/31").Get()
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), "Dub techno two")
+}
+
+func (as *ActionSuite) Test_RecommendationExclusionsCreate_UnknownArtist() {
+	user := as.createAndLoginUser("noartist@example.com", "user")
+	as.seedCachedFeed(user.ID.String(), []interface{}{tracklistMix})
+
+	res := as.HTML("/recommendations/exclusions").Post(url.Values{"artist_id": {"999"}, "track_id": {"7"}})
+	as.Equal(http.StatusNotFound, res.Code)
+}
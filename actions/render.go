@@ -1,9 +1,13 @@
 package actions
 
 import (
+	"fmt"
 	"github.com/jbhicks/sound-cistern/public"
+	"github.com/jbhicks/sound-cistern/src/services"
 	"github.com/jbhicks/sound-cistern/templates"
+	"math"
 	"net/http"
+	"net/url"
 
 	"github.com/gobuffalo/buffalo/render"
 	"github.com/gobuffalo/helpers/forms"
@@ -15,8 +19,26 @@ var rHTMX *render.Engine // New engine for HTMX requests
 func init() {
 	// Common helpers for both render engines
 	commonHelpers := render.Helpers{
-		forms.FormKey:    forms.Form,
-		forms.FormForKey: forms.FormFor,
+		forms.FormKey:        forms.Form,
+		forms.FormForKey:     forms.FormFor,
+		"formatDuration":     formatDuration,
+		"formatPosition":     formatPosition,
+		"trackID":            services.FormatID,
+		"trackKinds":         func() []string { return services.Kinds },
+		"kindLabel":          func(kind string) string { return services.KindLabels[kind] },
+		"percent":            percent,
+		"formatSize":         formatSize,
+		"formatHours":        func(hours float64) string { return fmt.Sprintf("%.1f", hours) },
+		"statsRanges":        func() []int { return services.StatsRanges },
+		"barChart":           services.BarChart,
+		"lineChart":          services.LineChart,
+		"horizontalBarChart": services.HorizontalBarChart,
+		"trackSources":       func() []string { return services.Sources },
+		"sourceLabel":        func(source string) string { return services.SourceLabels[source] },
+		"hasValue":           hasValue,
+		"ruleActions":        func() []string { return services.RuleActions },
+		"ruleActionLabel":    func(action string) string { return services.RuleActionLabels[action] },
+		"demoMode":           func() bool { return demoMode },
 		// You can add other common helpers here
 	}
 
@@ -37,7 +59,59 @@ func init() {
 	})
 }
 
-// IsHTMX checks if the current request is an HTMX request.
+// IsHTMX checks if the current request is an HTMX request. Boosted
+// navigation is excluded because it swaps in whole pages.
 func IsHTMX(r *http.Request) bool {
-	return r.Header.Get("HX-Request") == "true"
+	return r.Header.Get("HX-Request") == "true" && r.Header.Get("HX-Boosted") != "true"
+}
+
+// formatDuration formats a Soundcloud duration in milliseconds as m:ss, or
+// h:mm:ss for long mixes. Durations decoded from JSON arrive as float64.
+func formatDuration(ms interface{}) string {
+	switch v := ms.(type) {
+	case float64:
+		return formatClock(int64(v) / 1000)
+	case int:
+		return formatClock(int64(v) / 1000)
+	case int64:
+		return formatClock(v / 1000)
+	}
+	return ""
+}
+
+// formatPosition formats a playback position in seconds like formatDuration
+func formatPosition(seconds int) string {
+	return formatClock(int64(seconds))
+}
+
+// percent formats a 0-1 fraction as a whole percentage
+func percent(fraction float64) string {
+	return fmt.Sprintf("%.0f%%", fraction*100)
+}
+
+// formatSize formats a file size in bytes as KB or MB
+func formatSize(bytes int64) string {
+	if bytes < 1024*1024 {
+		return fmt.Sprintf("%.0f KB", math.Ceil(float64(bytes)/1024))
+	}
+	return fmt.Sprintf("%.1f MB", float64(bytes)/(1024*1024))
+}
+
+// hasValue reports whether a multi-valued form field includes value
+func hasValue(values url.Values, key, value string) bool {
+	for _, v := range values[key] {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// formatClock formats seconds as m:ss, or h:mm:ss from an hour up
+func formatClock(total int64) string {
+	hours, minutes, seconds := total/3600, total%3600/60, total%60
+	if hours > 0 {
+		return fmt.Sprintf("%d:%02d:%02d", hours, minutes, seconds)
+	}
+	return fmt.Sprintf("%d:%02d", minutes, seconds)
 }
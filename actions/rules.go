@@ -0,0 +1,206 @@
+package actions
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/gobuffalo/pop/v6"
+	"github.com/gofrs/uuid"
+	"github.com/jbhicks/sound-cistern/models"
+	"github.com/jbhicks/sound-cistern/pkg/logging"
+	srcmodels "github.com/jbhicks/sound-cistern/src/models"
+	"github.com/jbhicks/sound-cistern/src/services"
+)
+
+// ruleLogSize is how many recent actions a rule's log shows
+const ruleLogSize = 100
+
+// tagPattern limits rule tags to words that read well as badges and links
+var tagPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// RulesIndex lists the current user's rules in the order they run
+func RulesIndex(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	user := c.Value("current_user").(*models.User)
+
+	rules, err := services.NewRuleService(tx).Rules(user.ID.String())
+	if err != nil {
+		logging.Error("Error loading rules", err, logging.Fields{"user_id": user.ID.String()})
+		return c.Error(http.StatusInternalServerError, errors.New("failed to load rules"))
+	}
+	alerts := srcmodels.Alerts{}
+	if err := tx.Where("user_id = ?", user.ID).Order("name asc").All(&alerts); err != nil {
+		return err
+	}
+
+	c.Set("rules", rules)
+	c.Set("alerts", alerts)
+	return c.Render(http.StatusOK, r.HTML("rules/index.html"))
+}
+
+// RulesCreate saves a new rule built from the same fields as the feed
+// filter
+func RulesCreate(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	user := c.Value("current_user").(*models.User)
+
+	if err := c.Request().ParseForm(); err != nil {
+		return c.Error(http.StatusBadRequest, errors.New("invalid rule form"))
+	}
+	rule, err := ruleFromForm(user, c.Request().Form)
+	if err != nil {
+		return err
+	}
+	if msg := validateRule(tx, rule); msg != "" {
+		c.Flash().Add("danger", msg)
+		return c.Redirect(http.StatusSeeOther, "/rules")
+	}
+
+	if err := tx.Create(rule); err != nil {
+		return err
+	}
+
+	logging.UserAction(c, user.Email, "rule_create", "Created feed rule", logging.Fields{
+		"rule_id": rule.ID.String(),
+		"action":  rule.Action,
+	})
+
+	c.Flash().Add("success", "Rule created")
+	return c.Redirect(http.StatusSeeOther, "/rules")
+}
+
+// RulesPreview dry-runs the rule in the form against the cached feed and
+// shows which tracks it would act on
+func RulesPreview(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	user := c.Value("current_user").(*models.User)
+
+	if err := c.Request().ParseForm(); err != nil {
+		return c.Error(http.StatusBadRequest, errors.New("invalid rule form"))
+	}
+	rule, err := ruleFromForm(user, c.Request().Form)
+	if err != nil {
+		return err
+	}
+
+	tracks, err := services.NewFeedService(tx).GetCachedFeed(requestContext(c), user.ID.String())
+	if err != nil {
+		logging.Error("Error getting cached feed", err, logging.Fields{"user_id": user.ID.String()})
+		return c.Error(http.StatusInternalServerError, errors.New("failed to preview rule"))
+	}
+	preview, err := services.NewRuleService(tx).Preview(user.ID.String(), *rule, tracks)
+	if err != nil {
+		logging.Error("Error previewing rule", err, logging.Fields{"user_id": user.ID.String()})
+		return c.Error(http.StatusInternalServerError, errors.New("failed to preview rule"))
+	}
+
+	c.Set("rule", rule)
+	c.Set("preview", preview)
+	c.Set("checked", len(tracks))
+	return c.Render(http.StatusOK, rHTMX.HTML("rules/_preview.html"))
+}
+
+// RulesDestroy deletes one of the current user's rules along with its log.
+// Tags, hides and pins it already made stay in place.
+func RulesDestroy(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	user := c.Value("current_user").(*models.User)
+
+	rule, err := services.NewRuleService(tx).Rule(user.ID.String(), c.Param("rule_id"))
+	if err != nil {
+		return c.Error(http.StatusNotFound, err)
+	}
+	if err := tx.Destroy(rule); err != nil {
+		return err
+	}
+
+	logging.UserAction(c, user.Email, "rule_delete", "Deleted feed rule", logging.Fields{
+		"rule_id": rule.ID.String(),
+	})
+
+	c.Flash().Add("success", "Rule deleted")
+	return c.Redirect(http.StatusSeeOther, "/rules")
+}
+
+// RuleLog shows the tracks a rule has recently acted on
+func RuleLog(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	user := c.Value("current_user").(*models.User)
+
+	ruleService := services.NewRuleService(tx)
+	rule, err := ruleService.Rule(user.ID.String(), c.Param("rule_id"))
+	if err != nil {
+		return c.Error(http.StatusNotFound, err)
+	}
+	executions, err := ruleService.Executions(rule.ID, ruleLogSize)
+	if err != nil {
+		return err
+	}
+
+	c.Set("rule", rule)
+	c.Set("executions", executions)
+	if IsHTMX(c.Request()) {
+		return c.Render(http.StatusOK, rHTMX.HTML("rules/log.html"))
+	}
+	return c.Render(http.StatusOK, r.HTML("rules/log.html"))
+}
+
+// ruleFromForm builds an unsaved rule for the user from the rule form
+func ruleFromForm(user *models.User, params url.Values) (*srcmodels.Rule, error) {
+	criteriaJSON, err := json.Marshal(services.CriteriaFromValues(params))
+	if err != nil {
+		return nil, err
+	}
+	priority, _ := strconv.Atoi(params.Get("priority"))
+	stop, _ := strconv.ParseBool(params.Get("stop_processing"))
+
+	rule := &srcmodels.Rule{
+		ID:             uuid.Must(uuid.NewV4()),
+		UserID:         user.ID,
+		Name:           strings.TrimSpace(params.Get("name")),
+		Priority:       priority,
+		Criteria:       string(criteriaJSON),
+		Action:         params.Get("action"),
+		StopProcessing: stop,
+		Active:         true,
+	}
+	switch rule.Action {
+	case srcmodels.RuleActionTag:
+		rule.ActionValue = strings.ToLower(strings.TrimSpace(params.Get("tag_name")))
+	case srcmodels.RuleActionNotify:
+		rule.ActionValue = params.Get("alert_id")
+	}
+	return rule, nil
+}
+
+// validateRule returns a user-facing message when the rule is incomplete
+func validateRule(tx *pop.Connection, rule *srcmodels.Rule) string {
+	if rule.Name == "" {
+		return "Rule name is required"
+	}
+	switch rule.Action {
+	case srcmodels.RuleActionTag:
+		if !tagPattern.MatchString(rule.ActionValue) {
+			return "Tags can only use letters, numbers, dashes and underscores"
+		}
+	case srcmodels.RuleActionHide, srcmodels.RuleActionPin:
+	case srcmodels.RuleActionNotify:
+		alertID, err := uuid.FromString(rule.ActionValue)
+		if err != nil {
+			return "Choose an alert to notify through"
+		}
+		count, err := tx.Where("id = ? AND user_id = ?", alertID, rule.UserID).Count(&srcmodels.Alert{})
+		if err != nil || count == 0 {
+			return "Choose an alert to notify through"
+		}
+	default:
+		return "Unknown rule action"
+	}
+	return ""
+}
@@ -0,0 +1,110 @@
+package actions
+
+import (
+	"net/http"
+	"net/url"
+
+	srcmodels "github.com/jbhicks/sound-cistern/src/models"
+	"github.com/jbhicks/sound-cistern/src/services"
+)
+
+func (as *ActionSuite) Test_RulesCreate_Tag() {
+	user := as.createAndLoginUser("rules@example.com", "user")
+
+	res := as.HTML("/rules").Post(url.Values{
+		"name":     {"Tag bootlegs"},
+		"priority": {"5"},
+		"keywords": {"bootleg, edit"},
+		"action":   {"tag"},
+		"tag_name": {" Bootleg "},
+	})
+	as.Equal(http.StatusSeeOther, res.Code)
+
+	rule := &srcmodels.Rule{}
+	as.NoError(as.DB.Where("user_id = ?", user.ID).First(rule))
+	as.Equal("Tag bootlegs", rule.Name)
+	as.Equal(5, rule.Priority)
+	as.Equal("bootleg", rule.ActionValue)
+	as.Contains(rule.Criteria, `"keywords":["bootleg","edit"]`)
+}
+
+func (as *ActionSuite) Test_RulesCreate_NotifyNeedsOwnAlert() {
+	as.createAndLoginUser("notify@example.com", "user")
+
+	res := as.HTML("/rules").Post(url.Values{
+		"name":     {"Ping me"},
+		"action":   {"notify"},
+		"alert_id": {"6ba7b810-9dad-11d1-80b4-00c04fd430c8"},
+	})
+	as.Equal(http.StatusSeeOther, res.Code)
+
+	count, err := as.DB.Count(&srcmodels.Rule{})
+	as.NoError(err)
+	as.Equal(0, count)
+}
+
+func (as *ActionSuite) Test_RulesPreview_ShowsBlockedTracks() {
+	user := as.createAndLoginUser("preview@example.com", "user")
+	as.seedCachedFeed(user.ID.String(), []interface{}{
+		map[string]interface{}{"id": float64(1), "title": "Club Edit", "duration": float64(180000)},
+		map[string]interface{}{"id": float64(2), "title": "Radio Edit", "duration": float64(3600000)},
+		map[string]interface{}{"id": float64(3), "title": "Long mix", "duration": float64(3600000)},
+	})
+	as.HTML("/rules").Post(url.Values{
+		"name":            {"Hide short"},
+		"max_length":      {"600"},
+		"action":          {"hide"},
+		"stop_processing": {"true"},
+	})
+
+	req := as.HTML("/rules/preview")
+	req.Headers["HX-Request"] = "true"
+	res := req.Post(url.Values{"name": {"Pin edits"}, "priority": {"1"}, "query": {"edit"}, "action": {"pin"}})
+
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), "Radio Edit")
+	as.NotContains(res.Body.String(), "Club Edit")
+	as.Contains(res.Body.String(), "Hide short")
+	as.NotContains(res.Body.String(), "<html")
+}
+
+func (as *ActionSuite) Test_Rules_HideAndTagNewTracks() {
+	user := as.createAndLoginUser("applied@example.com", "user")
+	as.HTML("/rules").Post(url.Values{"name": {"Tag edits"}, "query": {"edit"}, "action": {"tag"}, "tag_name": {"edit"}})
+	as.HTML("/rules").Post(url.Values{"name": {"Hide previews"}, "query": {"preview"}, "action": {"hide"}})
+
+	tracks := []interface{}{
+		map[string]interface{}{"id": float64(1), "title": "Club Edit", "duration": float64(180000)},
+		map[string]interface{}{"id": float64(2), "title": "Album preview", "duration": float64(180000)},
+	}
+	as.seedCachedFeed(user.ID.String(), tracks)
+	result, err := services.NewRuleService(as.DB).Apply(user.ID.String(), tracks)
+	as.NoError(err)
+	as.Len(result.Visible, 1)
+	as.Equal(2, result.Executions)
+
+	res := as.HTML("/feed").Get()
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), "Club Edit")
+	as.Contains(res.Body.String(), "/feed?tag=edit")
+	as.NotContains(res.Body.String(), "Album preview")
+
+	rule := &srcmodels.Rule{}
+	as.NoError(as.DB.Where("name = ?", "Tag edits").First(rule))
+	res = as.HTML("/rules/" + rule.ID.String() + "/log").Get()
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), "Club Edit")
+}
+
+func (as *ActionSuite) Test_RulesDestroy_OnlyOwnRules() {
+	as.createAndLoginUser("ruleowner@example.com", "user")
+	as.HTML("/rules").Post(url.Values{"name": {"Mine"}, "action": {"pin"}})
+	rule := &srcmodels.Rule{}
+	as.NoError(as.DB.First(rule))
+
+	as.createAndLoginUser("ruleother@example.com", "user")
+	res := as.HTML("/rules/" + rule.ID.String()).Delete()
+	as.Equal(http.StatusNotFound, res.Code)
+	res = as.HTML("/rules/" + rule.ID.String() + "/log").Get()
+	as.Equal(http.StatusNotFound, res.Code)
+}
@@ -1,16 +1,22 @@
 package actions
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gobuffalo/buffalo"
+	"github.com/gobuffalo/buffalo/worker"
 	"github.com/gobuffalo/envy"
 	"github.com/gobuffalo/pop/v6"
 	"github.com/jbhicks/sound-cistern/models"
 	"github.com/jbhicks/sound-cistern/pkg/logging"
+	srcmodels "github.com/jbhicks/sound-cistern/src/models"
 	"github.com/jbhicks/sound-cistern/src/services"
 )
 
@@ -21,7 +27,16 @@ func SoundcloudAuth(c buffalo.Context) error {
 	clientSecret := envy.Get("SOUNDCLOUD_CLIENT_SECRET", "")
 	redirectURI := envy.Get("SOUNDCLOUD_REDIRECT_URI", "http://jbhicks.dev/auth/callback")
 
-	if clientID == "" || clientSecret == "" {
+	if demoMode {
+		// Seeded users go straight back to their linked account
+		if user, ok := c.Value("current_user").(*models.User); ok && user != nil {
+			if accounts, err := services.NewAccountService(models.DB).Accounts(user.ID.String()); err == nil && len(accounts) > 0 {
+				c.Session().Set("soundcloud_access_token", accounts[0].AccessToken)
+				c.Session().Set("soundcloud_user_id", accounts[0].SoundcloudID)
+				return c.Redirect(http.StatusFound, "/feed")
+			}
+		}
+	} else if clientID == "" || clientSecret == "" {
 		return c.Error(http.StatusInternalServerError, errors.New("Soundcloud OAuth not configured"))
 	}
 
@@ -45,13 +60,13 @@ func SoundcloudCallback(c buffalo.Context) error {
 	clientSecret := envy.Get("SOUNDCLOUD_CLIENT_SECRET", "")
 	redirectURI := envy.Get("SOUNDCLOUD_REDIRECT_URI", "http://jbhicks.dev/auth/callback")
 
-	if clientID == "" || clientSecret == "" {
+	if (clientID == "" || clientSecret == "") && !demoMode {
 		return c.Error(http.StatusInternalServerError, errors.New("Soundcloud OAuth not configured"))
 	}
 
 	// Create service and handle callback
 	soundcloudService := services.NewSoundcloudService(clientID, clientSecret, redirectURI)
-	result, err := soundcloudService.HandleCallback(code)
+	result, err := soundcloudService.HandleCallback(requestContext(c), code)
 	if err != nil {
 		logging.Error("Soundcloud callback failed", err)
 		return c.Error(http.StatusInternalServerError, errors.New("authentication failed"))
@@ -66,9 +81,18 @@ func SoundcloudCallback(c buffalo.Context) error {
 	c.Session().Set("soundcloud_access_token", accessToken)
 	c.Session().Set("soundcloud_user_id", userInfo["id"])
 
-	userID := fmt.Sprintf("%v", userInfo["id"])
+	userID := services.FormatID(userInfo["id"])
 	logging.Info("Soundcloud authentication successful", logging.Fields{"user_id": userID})
 
+	// Store the account so background syncs can use its token. Connecting
+	// an account that is already linked refreshes its token.
+	if user, ok := c.Value("current_user").(*models.User); ok && user != nil {
+		username, _ := userInfo["username"].(string)
+		if _, err := services.NewAccountService(models.DB).SaveAccount(user.ID.String(), userID, username, accessToken); err != nil {
+			logging.Error("Error saving Soundcloud account", err, logging.Fields{"user_id": user.ID.String()})
+		}
+	}
+
 	// Redirect to feed page
 	return c.Redirect(http.StatusFound, "/feed")
 }
@@ -95,50 +119,73 @@ func FeedIndex(c buffalo.Context) error {
 	}
 	user := currentUser.(*models.User)
 
-	// Create services
-	clientID := envy.Get("SOUNDCLOUD_CLIENT_ID", "")
-	clientSecret := envy.Get("SOUNDCLOUD_CLIENT_SECRET", "")
-	redirectURI := envy.Get("SOUNDCLOUD_REDIRECT_URI", "http://jbhicks.dev/auth/callback")
-
-	soundcloudService := services.NewSoundcloudService(clientID, clientSecret, redirectURI)
+	// Create services. Their calls are abandoned if the client goes away.
+	ctx := requestContext(c)
 	feedService := services.NewFeedService(tx)
 
-	// Try to get cached feed first
-	cachedTracks, err := feedService.GetCachedFeed(user.ID.String())
+	// Make sure the feed has been stored before reading the first page
+	stored, err := feedService.CountTracks(ctx, user.ID.String())
 	if err != nil {
-		logging.Error("Error getting cached feed", err, logging.Fields{"user_id": user.ID.String()})
+		logging.Error("Error counting stored tracks", err, logging.Fields{"user_id": user.ID.String()})
+		return c.Error(http.StatusInternalServerError, errors.New("failed to get feed"))
 	}
-
-	var tracks []interface{}
-	if len(cachedTracks) > 0 {
-		tracks = cachedTracks
-		logging.Info("Using cached feed", logging.Fields{"user_id": user.ID.String(), "track_count": len(tracks)})
-	} else {
-		// Fetch fresh feed from Soundcloud
-		freshTracks, err := soundcloudService.FetchUserFeed(accessToken)
-		if err != nil {
+	if stored == 0 {
+		// While Soundcloud is down the page renders empty under the outage
+		// banner rather than failing
+		err := loadFeed(c, feedService, user, accessToken)
+		if err != nil && !errors.Is(err, services.ErrSoundcloudUnavailable) {
 			logging.Error("Error fetching feed from Soundcloud", err, logging.Fields{"user_id": user.ID.String()})
 			return c.Error(http.StatusInternalServerError, errors.New("failed to fetch feed"))
 		}
+	}
 
-		tracks = freshTracks
+	// Apply any filters and sort from the URL so views can be bookmarked
+	criteria := services.CriteriaFromValues(c.Request().URL.Query())
+	sort := services.NormalizeSort(c.Param("sort"))
 
-		// Cache the feed
-		if err := feedService.CacheFeed(user.ID.String(), tracks); err != nil {
-			logging.Error("Error caching feed", err, logging.Fields{"user_id": user.ID.String()})
+	page, err := feedService.Page(ctx, user.ID.String(), criteria, sort, "", services.FeedPageSize)
+	if err != nil {
+		logging.Error("Error loading feed page", err, logging.Fields{"user_id": user.ID.String()})
+		return c.Error(http.StatusInternalServerError, errors.New("failed to get feed"))
+	}
+
+	// Tracks pinned by rules sit above the unfiltered feed
+	pinned := []interface{}{}
+	if len(criteria) == 0 {
+		if pinned, err = feedService.Pinned(ctx, user.ID.String()); err != nil {
+			logging.Error("Error loading pinned tracks", err, logging.Fields{"user_id": user.ID.String()})
+			return c.Error(http.StatusInternalServerError, errors.New("failed to get feed"))
 		}
+	}
 
-		logging.Info("Fetched fresh feed", logging.Fields{"user_id": user.ID.String(), "track_count": len(tracks)})
+	labels, err := services.NewLabelService(tx).Labels(user.ID.String())
+	if err != nil {
+		logging.Error("Error loading labels", err, logging.Fields{"user_id": user.ID.String()})
+		return c.Error(http.StatusInternalServerError, errors.New("failed to get feed"))
+	}
+
+	accounts, err := services.NewAccountService(tx).Accounts(user.ID.String())
+	if err != nil {
+		logging.Error("Error loading Soundcloud accounts", err, logging.Fields{"user_id": user.ID.String()})
+		return c.Error(http.StatusInternalServerError, errors.New("failed to get feed"))
 	}
 
 	// Set data for template
-	c.Set("tracks", tracks)
+	setFeedPage(c, page, criteria, sort, "")
+	c.Set("pinned", pinned)
+	c.Set("labels", labels)
+	c.Set("accounts", accounts)
+	c.Set("soundcloudStatus", services.SoundcloudStatus())
 	c.Set("user", user)
+	c.Set("filters", feedValues(criteria, sort))
 
 	return c.Render(http.StatusOK, r.HTML("feed/index.html"))
 }
 
-// FeedFilter filters the feed based on criteria
+// FeedFilter filters the feed based on criteria. Criteria come from query
+// parameters or a form body, or from a JSON body for API clients. HTMX
+// requests get the track list partial and the filtered URL is pushed to
+// the browser history; clients that accept JSON get the tracks as JSON.
 func FeedFilter(c buffalo.Context) error {
 	// Get access token from session
 	accessToken, ok := c.Session().Get("soundcloud_access_token").(string)
@@ -159,36 +206,304 @@ func FeedFilter(c buffalo.Context) error {
 	}
 	user := currentUser.(*models.User)
 
-	// Parse filter criteria from request body
-	var criteria map[string]interface{}
-	if err := json.NewDecoder(c.Request().Body).Decode(&criteria); err != nil {
+	// Parse filter criteria from the request
+	criteria, err := filterCriteria(c.Request())
+	if err != nil {
 		return c.Error(http.StatusBadRequest, errors.New("invalid filter criteria"))
 	}
+	sort := services.NormalizeSort(c.Param("sort"))
+	feedURL := "/feed"
+	if query := feedValues(criteria, sort).Encode(); query != "" {
+		feedURL += "?" + query
+	}
 
 	// Create feed service
+	ctx := requestContext(c)
 	feedService := services.NewFeedService(tx)
 
-	// Get cached feed
-	tracks, err := feedService.GetCachedFeed(user.ID.String())
+	if wantsJSON(c.Request()) {
+		// Get cached feed
+		tracks, err := feedService.GetCachedFeed(ctx, user.ID.String())
+		if err != nil {
+			logging.Error("Error getting cached feed for filtering", err, logging.Fields{"user_id": user.ID.String()})
+			return c.Error(http.StatusInternalServerError, errors.New("failed to get feed"))
+		}
+
+		if len(tracks) == 0 {
+			// If no cached feed, redirect to feed page to fetch fresh data
+			return c.Redirect(http.StatusFound, feedURL)
+		}
+
+		// Labels and notes are kept on stored tracks, not in the cache
+		tracks, err = services.NewLabelService(tx).Annotate(user.ID.String(), tracks)
+		if err != nil {
+			logging.Error("Error adding labels to cached feed", err, logging.Fields{"user_id": user.ID.String()})
+			return c.Error(http.StatusInternalServerError, errors.New("failed to get feed"))
+		}
+
+		// Filter tracks based on criteria
+		filteredTracks := feedService.FilterTracks(tracks, criteria)
+
+		logging.Info("Feed filtered", logging.Fields{
+			"user_id":        user.ID.String(),
+			"original_count": len(tracks),
+			"filtered_count": len(filteredTracks),
+		})
+
+		return c.Render(http.StatusOK, r.JSON(filteredTracks))
+	}
+
+	if !IsHTMX(c.Request()) {
+		return c.Redirect(http.StatusSeeOther, feedURL)
+	}
+
+	page, err := feedService.Page(ctx, user.ID.String(), criteria, sort, "", services.FeedPageSize)
 	if err != nil {
-		logging.Error("Error getting cached feed for filtering", err, logging.Fields{"user_id": user.ID.String()})
+		logging.Error("Error loading feed page", err, logging.Fields{"user_id": user.ID.String()})
 		return c.Error(http.StatusInternalServerError, errors.New("failed to get feed"))
 	}
 
-	if len(tracks) == 0 {
-		// If no cached feed, redirect to feed page to fetch fresh data
-		return c.Redirect(http.StatusFound, "/feed")
+	if len(page.Tracks) == 0 {
+		// If nothing is stored yet, send the browser to the feed page to fetch fresh data
+		if stored, err := feedService.CountTracks(ctx, user.ID.String()); err == nil && stored == 0 {
+			c.Response().Header().Set("HX-Redirect", feedURL)
+			return c.Render(http.StatusOK, nil)
+		}
 	}
 
-	// Filter tracks based on criteria
-	filteredTracks := feedService.FilterTracks(tracks, criteria)
+	c.Response().Header().Set("HX-Push-Url", feedURL)
+	setFeedPage(c, page, criteria, sort, "")
+	return c.Render(http.StatusOK, rHTMX.HTML("feed/_tracks.html"))
+}
+
+// FeedNextPage renders the page of the feed that follows a cursor, keeping
+// the filters and sort of the page that requested it. The page ends with a
+// placeholder that loads the next one when it scrolls into view.
+func FeedNextPage(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	user := c.Value("current_user").(*models.User)
 
-	logging.Info("Feed filtered", logging.Fields{
-		"user_id":        user.ID.String(),
-		"original_count": len(tracks),
-		"filtered_count": len(filteredTracks),
+	criteria := services.CriteriaFromValues(c.Request().URL.Query())
+	sort := services.NormalizeSort(c.Param("sort"))
+	cursor := c.Param("cursor")
+
+	page, err := services.NewFeedService(tx).Page(requestContext(c), user.ID.String(), criteria, sort, cursor, services.FeedPageSize)
+	if errors.Is(err, services.ErrInvalidCursor) {
+		return c.Error(http.StatusBadRequest, err)
+	}
+	if err != nil {
+		logging.Error("Error loading feed page", err, logging.Fields{"user_id": user.ID.String()})
+		return c.Error(http.StatusInternalServerError, errors.New("failed to get feed"))
+	}
+
+	setFeedPage(c, page, criteria, sort, cursor)
+	return c.Render(http.StatusOK, rHTMX.HTML("feed/_page.html"))
+}
+
+// setFeedPage sets the template data shared by the feed page partials
+func setFeedPage(c buffalo.Context, page *services.FeedPage, criteria map[string]interface{}, sort, cursor string) {
+	nextPage := ""
+	if page.NextCursor != "" {
+		values := feedValues(criteria, sort)
+		values.Set("cursor", page.NextCursor)
+		nextPage = "/feed/page?" + values.Encode()
+	}
+
+	c.Set("tracks", page.Tracks)
+	c.Set("nextPage", nextPage)
+	c.Set("paged", cursor != "")
+	c.Set("filtered", len(criteria) > 0)
+	// Live tracks are only prepended to the default, unfiltered view
+	c.Set("live", len(criteria) == 0 && sort == services.SortNewest)
+}
+
+// feedValues encodes filter criteria and a sort order as query values
+func feedValues(criteria map[string]interface{}, sort string) url.Values {
+	values := services.CriteriaValues(criteria)
+	if sort != services.SortNewest {
+		values.Set("sort", sort)
+	}
+	return values
+}
+
+// loadFeed stores the user's feed for the first time, from the cached feed
+// when there is one and otherwise straight from Soundcloud
+func loadFeed(c buffalo.Context, feedService *services.FeedService, user *models.User, accessToken string) error {
+	ctx := requestContext(c)
+	cachedTracks, err := feedService.GetCachedFeed(ctx, user.ID.String())
+	if err != nil {
+		logging.Error("Error getting cached feed", err, logging.Fields{"user_id": user.ID.String()})
+	}
+	if len(cachedTracks) > 0 {
+		if _, err := feedService.StoreTracks(ctx, user.ID.String(), cachedTracks); err != nil {
+			return err
+		}
+		updateRequestSimilarities(ctx, feedService.DB, user.ID.String())
+		return nil
+	}
+
+	if _, err := ensureSoundcloudLink(c, user, accessToken); err != nil {
+		return err
+	}
+
+	syncService := services.NewSyncService(feedService.DB, newSoundcloudService(), feedEvents)
+	// A rejected token is flagged even though the request rolls back
+	syncService.FailureDB = models.DB
+	result, err := syncService.Sync(ctx, user.ID.String())
+	if err != nil {
+		return err
+	}
+	queueAlertMatches(ctx, user.ID.String(), result)
+	updateRequestSimilarities(ctx, feedService.DB, user.ID.String())
+
+	logging.Info("Fetched fresh feed", logging.Fields{"user_id": user.ID.String(), "track_count": len(result.Tracks)})
+	return nil
+}
+
+// filterCriteria reads filter criteria from a JSON body, or otherwise from
+// the query string and form values
+func filterCriteria(req *http.Request) (map[string]interface{}, error) {
+	if strings.HasPrefix(req.Header.Get("Content-Type"), "application/json") {
+		criteria := map[string]interface{}{}
+		if err := json.NewDecoder(req.Body).Decode(&criteria); err != nil {
+			return nil, err
+		}
+		return criteria, nil
+	}
+	if err := req.ParseForm(); err != nil {
+		return nil, err
+	}
+	return services.CriteriaFromValues(req.Form), nil
+}
+
+// wantsJSON reports whether the client asked for a JSON response
+func wantsJSON(req *http.Request) bool {
+	return strings.Contains(req.Header.Get("Accept"), "application/json")
+}
+
+// FeedSync starts a background sync of the user's feed. Progress and new
+// tracks are pushed to the live feed connection.
+func FeedSync(c buffalo.Context) error {
+	// Get access token from session
+	accessToken, ok := c.Session().Get("soundcloud_access_token").(string)
+	if !ok || accessToken == "" {
+		return c.Error(http.StatusUnauthorized, errors.New("not authenticated"))
+	}
+
+	user := c.Value("current_user").(*models.User)
+
+	if services.SoundcloudStatus().Open() {
+		if IsHTMX(c.Request()) {
+			c.Set("status", services.SyncStatusFailed)
+			c.Set("message", "Soundcloud is not responding, try again shortly")
+			return c.Render(http.StatusServiceUnavailable, rHTMX.HTML("feed/_sync_status.html"))
+		}
+		return c.Error(http.StatusServiceUnavailable, services.ErrSoundcloudUnavailable)
+	}
+
+	if _, err := ensureSoundcloudLink(c, user, accessToken); err != nil {
+		logging.Error("Error saving Soundcloud link", err, logging.Fields{"user_id": user.ID.String()})
+		return c.Error(http.StatusInternalServerError, errors.New("failed to start sync"))
+	}
+
+	err := app.Worker.Perform(worker.Job{
+		Handler: jobSyncFeed,
+		Args:    worker.Args{"user_id": user.ID.String(), "request_id": c.Value("request_id")},
 	})
+	if err != nil {
+		logging.Error("Error enqueueing feed sync", err, logging.Fields{"user_id": user.ID.String()})
+		return c.Error(http.StatusServiceUnavailable, errors.New("sync is unavailable right now"))
+	}
+
+	if IsHTMX(c.Request()) {
+		c.Set("status", services.SyncStatusRunning)
+		c.Set("message", "Sync started...")
+		return c.Render(http.StatusAccepted, rHTMX.HTML("feed/_sync_status.html"))
+	}
+	return c.Redirect(http.StatusSeeOther, "/feed")
+}
+
+// requestContext returns the context service calls made for a request run
+// under. It is cancelled when the client disconnects and carries the
+// request id, which is sent along with Soundcloud calls so their logs can be
+// matched with ours.
+func requestContext(c buffalo.Context) context.Context {
+	requestID, _ := c.Value("request_id").(string)
+	return services.WithRequestID(c, requestID)
+}
+
+// demoModeRequested is whether DEMO_MODE asks for demo mode
+var demoModeRequested, _ = strconv.ParseBool(envy.Get("DEMO_MODE", "false"))
+
+// demoMode answers Soundcloud calls with generated data, so the app runs
+// with no network or API credentials. Seed matching data with db:seed. It
+// is refused in production, where it would log everyone in as a generated
+// user.
+var demoMode = demoModeRequested && ENV != "production"
+
+// demoSeed is the generator seed demo mode uses. It has to match the seed
+// the database was seeded with.
+var demoSeed = envy.Get("DEMO_SEED", strconv.Itoa(services.DefaultDemoSeed))
+
+// useSoundcloudDemo swaps Soundcloud for generated data in demo mode
+func useSoundcloudDemo() {
+	seed, err := strconv.ParseInt(demoSeed, 10, 64)
+	if err != nil {
+		logging.Error("Invalid DEMO_SEED, using the default", err, logging.Fields{"value": demoSeed})
+		seed = services.DefaultDemoSeed
+	}
+	services.UseSoundcloudDemo(services.NewDemoGenerator(seed, time.Now()))
+	logging.Info("Demo mode: Soundcloud calls are answered with generated data", logging.Fields{"seed": seed})
+}
+
+// soundcloudCassette switches Soundcloud calls to recording ("record") or
+// to being answered offline from recordings ("replay"), for development and
+// test fixtures. It is ignored in production.
+var soundcloudCassette = envy.Get("SOUNDCLOUD_CASSETTE", "")
 
-	// Return filtered tracks as JSON
-	return c.Render(http.StatusOK, r.JSON(filteredTracks))
+// soundcloudCassetteDir is where Soundcloud recordings are kept
+var soundcloudCassetteDir = envy.Get("SOUNDCLOUD_CASSETTE_DIR", "fixtures/soundcloud")
+
+// useSoundcloudCassette routes Soundcloud calls through recordings when
+// SOUNDCLOUD_CASSETTE is set
+func useSoundcloudCassette() {
+	if soundcloudCassette == "" {
+		return
+	}
+	if ENV == "production" {
+		logging.Warn("SOUNDCLOUD_CASSETTE is ignored in production", logging.Fields{"mode": soundcloudCassette})
+		return
+	}
+	if err := services.UseSoundcloudCassette(soundcloudCassette, soundcloudCassetteDir); err != nil {
+		logging.Error("Invalid SOUNDCLOUD_CASSETTE, calling Soundcloud directly", err, logging.Fields{"mode": soundcloudCassette})
+		return
+	}
+	logging.Info("Soundcloud calls use recordings", logging.Fields{"mode": soundcloudCassette, "dir": soundcloudCassetteDir})
+}
+
+// newSoundcloudService creates a Soundcloud client from the environment
+func newSoundcloudService() *services.SoundcloudService {
+	clientID := envy.Get("SOUNDCLOUD_CLIENT_ID", "")
+	clientSecret := envy.Get("SOUNDCLOUD_CLIENT_SECRET", "")
+	redirectURI := envy.Get("SOUNDCLOUD_REDIRECT_URI", "http://jbhicks.dev/auth/callback")
+	return services.NewSoundcloudService(clientID, clientSecret, redirectURI)
+}
+
+// ensureSoundcloudLink returns the linked account the session's token
+// belongs to, saving it from the session when it is missing or stale. It
+// writes outside the request transaction so background jobs can read the
+// account right away.
+func ensureSoundcloudLink(c buffalo.Context, user *models.User, accessToken string) (*srcmodels.SoundcloudAccount, error) {
+	accountService := services.NewAccountService(models.DB)
+	accounts, err := accountService.Accounts(user.ID.String())
+	if err != nil {
+		return nil, err
+	}
+	for i := range accounts {
+		if accounts[i].AccessToken == accessToken {
+			return &accounts[i], nil
+		}
+	}
+	soundcloudID := services.FormatID(c.Session().Get("soundcloud_user_id"))
+	return accountService.SaveLink(user.ID.String(), soundcloudID, accessToken)
 }
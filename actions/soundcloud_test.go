@@ -0,0 +1,169 @@
+package actions
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"regexp"
+	"time"
+
+	"github.com/jbhicks/sound-cistern/src/services"
+)
+
+func (as *ActionSuite) Test_FeedEvents_RequiresAuth() {
+	res := as.HTML("/feed/events").Get()
+	as.Equal(http.StatusFound, res.Code)
+	as.Equal("/auth/new", res.Location())
+}
+
+func (as *ActionSuite) Test_FeedSync_RequiresSoundcloudToken() {
+	as.createAndLoginUser("sync@example.com", "user")
+
+	res := as.HTML("/feed/sync").Post(url.Values{})
+	as.Equal(http.StatusUnauthorized, res.Code)
+}
+
+func (as *ActionSuite) seedCachedFeed(userID string, tracks []interface{}) {
+	_, err := services.NewAccountService(as.DB).SaveLink(userID, "12345", "token")
+	as.NoError(err)
+	feedService := services.NewFeedService(as.DB)
+	_, err = feedService.StoreTracks(context.Background(), userID, tracks)
+	as.NoError(err)
+	as.NoError(feedService.CacheFeed(context.Background(), userID, tracks))
+	as.Session.Set("soundcloud_access_token", "token")
+}
+
+func (as *ActionSuite) Test_FeedFilter_HTMXRendersPartial() {
+	user := as.createAndLoginUser("filter@example.com", "user")
+	as.seedCachedFeed(user.ID.String(), []interface{}{
+		map[string]interface{}{"id": float64(1), "title": "Short edit", "duration": float64(180000)},
+		map[string]interface{}{"id": float64(2), "title": "Long mix", "duration": float64(3600000)},
+	})
+
+	req := as.HTML("/filter?min_length=600")
+	req.Headers["HX-Request"] = "true"
+	res := req.Get()
+
+	as.Equal(http.StatusOK, res.Code)
+	as.Equal("/feed?min_length=600", res.Header().Get("HX-Push-Url"))
+	as.Contains(res.Body.String(), "Long mix")
+	as.NotContains(res.Body.String(), "Short edit")
+	as.NotContains(res.Body.String(), "<html")
+}
+
+func (as *ActionSuite) Test_FeedFilter_JSON() {
+	user := as.createAndLoginUser("filterjson@example.com", "user")
+	as.seedCachedFeed(user.ID.String(), []interface{}{
+		map[string]interface{}{"id": float64(1), "title": "Short edit", "duration": float64(180000)},
+		map[string]interface{}{"id": float64(2), "title": "Long mix", "duration": float64(3600000)},
+	})
+
+	res := as.JSON("/filter").Post(map[string]interface{}{"query": "edit"})
+
+	as.Equal(http.StatusOK, res.Code)
+	tracks := []map[string]interface{}{}
+	res.Bind(&tracks)
+	as.Len(tracks, 1)
+	as.Equal("Short edit", tracks[0]["title"])
+}
+
+func (as *ActionSuite) Test_FeedFilter_FormRedirectsToFeed() {
+	user := as.createAndLoginUser("filterform@example.com", "user")
+	as.seedCachedFeed(user.ID.String(), []interface{}{
+		map[string]interface{}{"id": float64(1), "title": "Short edit", "duration": float64(180000)},
+	})
+
+	res := as.HTML("/filter").Post(url.Values{"genres": {"House"}})
+	as.Equal(http.StatusSeeOther, res.Code)
+	as.Equal("/feed?genres=House", res.Location())
+}
+
+func (as *ActionSuite) Test_FeedNextPage_LoadsOnReveal() {
+	user := as.createAndLoginUser("pages@example.com", "user")
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	var tracks []interface{}
+	for i := 0; i <= services.FeedPageSize; i++ {
+		tracks = append(tracks, map[string]interface{}{
+			"id":         float64(i + 1),
+			"title":      fmt.Sprintf("Track %02d", i),
+			"created_at": start.Add(time.Duration(i) * time.Hour).Format(time.RFC3339),
+		})
+	}
+	as.seedCachedFeed(user.ID.String(), tracks)
+
+	res := as.HTML("/feed?sort=oldest").Get()
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), "Track 00")
+	as.NotContains(res.Body.String(), fmt.Sprintf("Track %02d", services.FeedPageSize))
+
+	next := regexp.MustCompile(`hx-get="(/feed/page\?[^"]+)"`).FindStringSubmatch(res.Body.String())
+	as.Len(next, 2)
+	as.Contains(next[1], "sort=oldest")
+
+	res = as.HTML(html.UnescapeString(next[1])).Get()
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), fmt.Sprintf("Track %02d", services.FeedPageSize))
+	as.NotContains(res.Body.String(), "Track 00")
+	as.Contains(res.Body.String(), "reached the end")
+}
+
+func (as *ActionSuite) Test_FeedNextPage_RejectsBadCursor() {
+	as.createAndLoginUser("badcursor@example.com", "user")
+
+	res := as.HTML("/feed/page?cursor=not-a-cursor").Get()
+	as.Equal(http.StatusBadRequest, res.Code)
+}
+
+func (as *ActionSuite) Test_FeedIndex_CollapsesDuplicates() {
+	user := as.createAndLoginUser("duplicates@example.com", "user")
+	as.seedCachedFeed(user.ID.String(), []interface{}{
+		map[string]interface{}{
+			"id": float64(1), "title": "Archangel", "duration": float64(240000),
+			"created_at": "2025/03/01 10:00:00 +0000", "user": map[string]interface{}{"username": "burial"},
+		},
+		map[string]interface{}{
+			"id": float64(2), "title": "Burial - Archangel (Free Download)", "duration": float64(241000),
+			"created_at": "2025/03/02 10:00:00 +0000", "user": map[string]interface{}{"username": "reuploader"},
+		},
+	})
+
+	res := as.HTML("/feed").Get()
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), "Burial - Archangel (Free Download)")
+
+	res = as.HTML("/feed?collapse=true").Get()
+	as.Equal(http.StatusOK, res.Code)
+	as.NotContains(res.Body.String(), "Burial - Archangel (Free Download)")
+	as.Contains(res.Body.String(), "Also posted by")
+	as.Contains(res.Body.String(), "reuploader")
+}
+
+func (as *ActionSuite) Test_FeedIndex_FiltersBySource() {
+	user := as.createAndLoginUser("sources@example.com", "user")
+	as.seedCachedFeed(user.ID.String(), []interface{}{
+		map[string]interface{}{"id": float64(1), "title": "Stream upload", "duration": float64(180000)},
+	})
+	_, err := services.NewFeedService(as.DB).StoreLibrary(context.Background(), user.ID.String(),
+		[]interface{}{map[string]interface{}{"id": float64(2), "title": "Liked mix", "duration": float64(3600000)}},
+		[]interface{}{map[string]interface{}{"id": float64(9), "title": "Sunday", "tracks": []interface{}{
+			map[string]interface{}{"id": float64(3), "title": "Playlist track", "duration": float64(240000)},
+		}}})
+	as.NoError(err)
+
+	res := as.HTML("/feed").Get()
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), "Stream upload")
+	as.Contains(res.Body.String(), "Liked mix")
+	as.Contains(res.Body.String(), "In Sunday")
+
+	res = as.HTML("/feed?sources=likes").Get()
+	as.Contains(res.Body.String(), "Liked mix")
+	as.NotContains(res.Body.String(), "Stream upload")
+	as.NotContains(res.Body.String(), "Playlist track")
+
+	res = as.HTML("/feed?exclude_sources=stream").Get()
+	as.NotContains(res.Body.String(), "Stream upload")
+	as.Contains(res.Body.String(), "Playlist track")
+}
@@ -0,0 +1,98 @@
+package actions
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/gobuffalo/buffalo/worker"
+	"github.com/gobuffalo/pop/v6"
+	"github.com/jbhicks/sound-cistern/models"
+	"github.com/jbhicks/sound-cistern/pkg/logging"
+	srcmodels "github.com/jbhicks/sound-cistern/src/models"
+	"github.com/jbhicks/sound-cistern/src/services"
+)
+
+// TrackShow displays a stored track with the tracklist parsed from its
+// description and the user's labels and note. Tracklist timestamps seek
+// the player.
+func TrackShow(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	user := c.Value("current_user").(*models.User)
+
+	track, err := services.NewFeedService(tx).Track(requestContext(c), user.ID.String(), c.Param("track_id"))
+	if err != nil {
+		return c.Error(http.StatusNotFound, errors.New("track not found"))
+	}
+
+	entries, err := services.NewTracklistService(tx).Entries(track.ID)
+	if err != nil {
+		logging.Error("Error loading tracklist", err, logging.Fields{"track_id": track.ID.String()})
+		return c.Error(http.StatusInternalServerError, errors.New("failed to load tracklist"))
+	}
+
+	trackMap, err := services.NewFeedService(tx).TrackMap(requestContext(c), track)
+	if err != nil {
+		logging.Error("Error loading track", err, logging.Fields{"track_id": track.ID.String()})
+		return c.Error(http.StatusInternalServerError, errors.New("failed to load track"))
+	}
+	labels, err := services.NewLabelService(tx).Labels(user.ID.String())
+	if err != nil {
+		logging.Error("Error loading labels", err, logging.Fields{"user_id": user.ID.String()})
+		return c.Error(http.StatusInternalServerError, errors.New("failed to load track"))
+	}
+
+	if err := setRecommendations(c, tx, track); err != nil {
+		return err
+	}
+
+	c.Set("track", trackMap)
+	c.Set("artist", track.Artist.String)
+	c.Set("entries", entries)
+	c.Set("labels", labels)
+	return c.Render(http.StatusOK, r.HTML("tracks/show.html"))
+}
+
+// TrackKindUpdate overrides the kind the classifier gave a track. The
+// override teaches the classifier, so the user's other tracks are
+// reclassified in the background.
+func TrackKindUpdate(c buffalo.Context) error {
+	user := c.Value("current_user").(*models.User)
+
+	// Commit before the reclassify job can run so it sees what was learned
+	var track *srcmodels.Track
+	err := models.DB.Transaction(func(tx *pop.Connection) error {
+		var err error
+		track, err = services.NewClassifierService(tx).Override(user.ID.String(), c.Param("track_id"), c.Param("kind"))
+		return err
+	})
+	if errors.Is(err, services.ErrUnknownKind) {
+		return c.Error(http.StatusBadRequest, err)
+	}
+	if errors.Is(err, services.ErrTrackNotFound) {
+		return c.Error(http.StatusNotFound, err)
+	}
+	if err != nil {
+		logging.Error("Error overriding track kind", err, logging.Fields{"user_id": user.ID.String()})
+		return c.Error(http.StatusInternalServerError, errors.New("failed to update track"))
+	}
+
+	logging.UserAction(c, user.Email, "override_track_kind", "Changed a track's kind", logging.Fields{
+		"track_id": track.SoundcloudID,
+		"kind":     track.Kind,
+	})
+
+	err = app.Worker.Perform(worker.Job{
+		Handler: jobReclassify,
+		Args:    worker.Args{"user_id": user.ID.String()},
+	})
+	if err != nil {
+		logging.Error("Error enqueueing reclassify job", err, logging.Fields{"user_id": user.ID.String()})
+	}
+
+	if IsHTMX(c.Request()) {
+		c.Set("track", track.Map())
+		return c.Render(http.StatusOK, rHTMX.HTML("feed/_kind.html"))
+	}
+	return c.Redirect(http.StatusSeeOther, "/tracks/"+track.SoundcloudID)
+}
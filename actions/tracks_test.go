@@ -0,0 +1,78 @@
+package actions
+
+import (
+	"net/http"
+	"net/url"
+)
+
+var tracklistMix = map[string]interface{}{
+	"id":          float64(7),
+	"title":       "Warehouse session",
+	"duration":    float64(10800000),
+	"description": "Tracklist:\n00:00 Burial - Archangel\n1:02:03 Aphex Twin - Xtal",
+}
+
+func (as *ActionSuite) Test_TrackShow_ListsTracklist() {
+	user := as.createAndLoginUser("tracklist@example.com", "user")
+	as.seedCachedFeed(user.ID.String(), []interface{}{tracklistMix})
+
+	res := as.HTML("/tracks/7").Get()
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), "Aphex Twin")
+	as.Contains(res.Body.String(), "/player/tracks/7/play?start=3723")
+	as.Contains(res.Body.String(), "1:02:03")
+}
+
+func (as *ActionSuite) Test_TrackShow_NotFound() {
+	as.createAndLoginUser("notracks@example.com", "user")
+
+	res := as.HTML("/tracks/404").Get()
+	as.Equal(http.StatusNotFound, res.Code)
+}
+
+func (as *ActionSuite) Test_FeedSearch_MatchesTracklistArtists() {
+	user := as.createAndLoginUser("tracksearch@example.com", "user")
+	as.seedCachedFeed(user.ID.String(), []interface{}{
+		tracklistMix,
+		map[string]interface{}{"id": float64(8), "title": "Ambient hour", "duration": float64(3600000)},
+	})
+
+	res := as.HTML("/feed?query=aphex").Get()
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), "Warehouse session")
+	as.NotContains(res.Body.String(), "Ambient hour")
+}
+
+func (as *ActionSuite) Test_PlayerPlay_SeeksToStart() {
+	user := as.createAndLoginUser("seek@example.com", "user")
+	as.seedCachedFeed(user.ID.String(), []interface{}{tracklistMix})
+
+	res := as.HTML("/player/tracks/7/play?start=3723").Post(url.Values{})
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), `data-seek="3723"`)
+	as.Contains(res.Body.String(), `data-resume="3723"`)
+}
+
+func (as *ActionSuite) Test_TrackKindUpdate_OverridesKind() {
+	user := as.createAndLoginUser("kind@example.com", "user")
+	as.seedCachedFeed(user.ID.String(), []interface{}{tracklistMix})
+
+	req := as.HTML("/tracks/7/kind")
+	req.Headers["HX-Request"] = "true"
+	res := req.Post(url.Values{"kind": {"podcast"}})
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), `<option value="podcast" selected>`)
+	as.Contains(res.Body.String(), "Set by you")
+
+	res = as.HTML("/feed?kind=podcast").Get()
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), "Warehouse session")
+}
+
+func (as *ActionSuite) Test_TrackKindUpdate_RejectsUnknownKind() {
+	user := as.createAndLoginUser("badkind@example.com", "user")
+	as.seedCachedFeed(user.ID.String(), []interface{}{tracklistMix})
+
+	res := as.HTML("/tracks/7/kind").Post(url.Values{"kind": {"album"}})
+	as.Equal(http.StatusBadRequest, res.Code)
+}
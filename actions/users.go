@@ -233,7 +233,11 @@ func SetCurrentUser(next buffalo.Handler) buffalo.Handler {
 			}
 
 			u := &models.User{}
-			tx := c.Value("tx").(*pop.Connection)
+			tx, ok := c.Value("tx").(*pop.Connection)
+			if !ok {
+				// Streaming routes run without the request transaction
+				tx = models.DB
+			}
 			err := tx.Find(u, uid)
 			if err != nil {
 				// If user not found, clear the session and continue
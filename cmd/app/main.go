@@ -13,6 +13,7 @@ import (
 // application that is. :)
 func main() {
 	app := actions.App()
+	actions.ScheduleJobs()
 	if err := app.Serve(); err != nil {
 		logging.Fatal("Failed to start Buffalo application", logging.Fields{
 			"error": err.Error(),
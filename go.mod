@@ -1,6 +1,6 @@
 module github.com/jbhicks/sound-cistern
 
-go 1.18
+go 1.23.0
 
 require (
 	github.com/gobuffalo/buffalo v1.1.2
@@ -9,6 +9,8 @@ require (
 	github.com/gobuffalo/grift v1.5.2
 	github.com/gobuffalo/helpers v0.6.10
 	github.com/gobuffalo/middleware v1.0.0
+	github.com/gobuffalo/nulls v0.4.2
+	github.com/gobuffalo/plush/v5 v5.0.4
 	github.com/gobuffalo/pop/v6 v6.1.1
 	github.com/gobuffalo/suite/v4 v4.0.4
 	github.com/gobuffalo/validate/v3 v3.3.3
@@ -38,9 +40,7 @@ require (
 	github.com/gobuffalo/httptest v1.5.2 // indirect
 	github.com/gobuffalo/logger v1.0.7 // indirect
 	github.com/gobuffalo/meta v0.3.3 // indirect
-	github.com/gobuffalo/nulls v0.4.2 // indirect
 	github.com/gobuffalo/plush/v4 v4.1.18 // indirect
-	github.com/gobuffalo/plush/v5 v5.0.4 // indirect
 	github.com/gobuffalo/refresh v1.13.3 // indirect
 	github.com/gobuffalo/tags/v3 v3.1.4 // indirect
 	github.com/gorilla/css v1.0.0 // indirect
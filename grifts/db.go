@@ -1,8 +1,14 @@
 package grifts
 
 import (
+	"context"
 	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
 	"github.com/jbhicks/sound-cistern/models"
+	"github.com/jbhicks/sound-cistern/src/services"
 
 	"github.com/gobuffalo/grift/grift"
 	"github.com/gobuffalo/pop/v6"
@@ -10,9 +16,39 @@ import (
 
 var _ = grift.Namespace("db", func() {
 
-	grift.Desc("seed", "Seeds a database")
+	grift.Desc("seed", "Seeds demo users, linked Soundcloud accounts, tracks, presets and bookmarks from fixtures/demo/seed.json, another seed file given as an argument, or a number of generated users")
 	grift.Add("seed", func(c *grift.Context) error {
-		// Add DB seeding stuff here
+		spec, err := seedSpec(c.Args)
+		if err != nil {
+			return err
+		}
+
+		db, err := pop.Connect("development")
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		generator := services.NewDemoGenerator(spec.Seed, time.Now())
+		for _, seedUser := range spec.Users {
+			err := db.Transaction(func(tx *pop.Connection) error {
+				user, err := seedAccountUser(tx, seedUser, spec.Password)
+				if err != nil {
+					return err
+				}
+				result, err := services.NewSeedService(tx, generator).SeedUser(context.Background(), user.ID.String(), seedUser)
+				if err != nil {
+					return err
+				}
+				fmt.Printf("Seeded %s: %d accounts, %d tracks, %d presets, %d bookmarks\n",
+					user.Email, result.Accounts, result.Tracks, result.Presets, result.Bookmarks)
+				return nil
+			})
+			if err != nil {
+				return fmt.Errorf("seeding %s: %w", seedUser.Email, err)
+			}
+		}
+		fmt.Printf("Log in with password %q. Run the app with DEMO_MODE=true to use it without Soundcloud.\n", spec.Password)
 		return nil
 	})
 
@@ -42,3 +78,44 @@ var _ = grift.Namespace("db", func() {
 	})
 
 })
+
+// defaultSeedFile is the seed file used when db:seed is given no argument
+const defaultSeedFile = "fixtures/demo/seed.json"
+
+// seedSpec reads the seed file named by the task's argument, or generates
+// the given number of users when the argument is a number
+func seedSpec(args []string) (*services.SeedSpec, error) {
+	path := defaultSeedFile
+	if len(args) > 0 {
+		if users, err := strconv.Atoi(args[0]); err == nil {
+			return services.GenerateSeedSpec(services.DefaultDemoSeed, users), nil
+		}
+		path = args[0]
+	}
+	return services.LoadSeedSpec(path)
+}
+
+// seedAccountUser returns the seeded user with the given email, creating it
+// with the seed password when it does not exist yet
+func seedAccountUser(tx *pop.Connection, seedUser services.SeedUser, password string) (*models.User, error) {
+	user := &models.User{}
+	if err := tx.Where("email = ?", strings.ToLower(seedUser.Email)).First(user); err == nil {
+		return user, nil
+	}
+	user = &models.User{
+		Email:                seedUser.Email,
+		FirstName:            seedUser.FirstName,
+		LastName:             seedUser.LastName,
+		Role:                 seedUser.Role,
+		Password:             password,
+		PasswordConfirmation: password,
+	}
+	verrs, err := user.Create(tx)
+	if err != nil {
+		return nil, err
+	}
+	if verrs.HasAny() {
+		return nil, fmt.Errorf("invalid user %s: %s", seedUser.Email, verrs.Error())
+	}
+	return user, nil
+}
@@ -0,0 +1,41 @@
+package grifts
+
+import (
+	"fmt"
+
+	"github.com/gobuffalo/grift/grift"
+	"github.com/gobuffalo/pop/v6"
+	"github.com/gofrs/uuid"
+	"github.com/jbhicks/sound-cistern/src/services"
+)
+
+var _ = grift.Namespace("duplicates", func() {
+
+	grift.Desc("detect", "Links duplicate uploads of the same recording in every user's stored tracks")
+	grift.Add("detect", func(c *grift.Context) error {
+		db, err := pop.Connect("development")
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		var userIDs []uuid.UUID
+		if err := db.RawQuery("SELECT DISTINCT user_id FROM soundcloud_tracks").All(&userIDs); err != nil {
+			return err
+		}
+
+		duplicates := services.NewDuplicateService(db)
+		found := 0
+		for _, userID := range userIDs {
+			n, err := duplicates.Detect(userID.String())
+			if err != nil {
+				return err
+			}
+			found += n
+		}
+
+		fmt.Printf("Found %d duplicate tracks across %d users\n", found, len(userIDs))
+		return nil
+	})
+
+})
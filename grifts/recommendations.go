@@ -0,0 +1,45 @@
+package grifts
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gobuffalo/envy"
+	"github.com/gobuffalo/grift/grift"
+	"github.com/gobuffalo/pop/v6"
+	"github.com/gofrs/uuid"
+	"github.com/jbhicks/sound-cistern/src/services"
+)
+
+var _ = grift.Namespace("recommendations", func() {
+
+	grift.Desc("rebuild", "Works out the similar tracks of every user's stored tracks from scratch. Syncs only score the tracks they bring in.")
+	grift.Add("rebuild", func(c *grift.Context) error {
+		db, err := pop.Connect(envy.Get("GO_ENV", "development"))
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		var userIDs []uuid.UUID
+		if err := db.RawQuery("SELECT DISTINCT user_id FROM soundcloud_tracks").All(&userIDs); err != nil {
+			return err
+		}
+
+		scored := 0
+		for _, userID := range userIDs {
+			err := db.Transaction(func(tx *pop.Connection) error {
+				n, err := services.NewRecommendationService(tx).RebuildSimilarities(context.Background(), userID.String())
+				scored += n
+				return err
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		fmt.Printf("Scored %d tracks across %d users\n", scored, len(userIDs))
+		return nil
+	})
+
+})
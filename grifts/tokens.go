@@ -0,0 +1,51 @@
+package grifts
+
+import (
+	"fmt"
+
+	"github.com/gobuffalo/envy"
+	"github.com/gobuffalo/grift/grift"
+	"github.com/gobuffalo/pop/v6"
+	"github.com/jbhicks/sound-cistern/src/services"
+)
+
+var _ = grift.Namespace("tokens", func() {
+
+	grift.Desc("generate_key", "Prints a new key for TOKEN_ENCRYPTION_KEYS, given an optional key id")
+	grift.Add("generate_key", func(c *grift.Context) error {
+		id := "k1"
+		if len(c.Args) > 0 {
+			id = c.Args[0]
+		}
+		key, err := services.NewTokenKey()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s:%s\n", id, key)
+		fmt.Println("Put it first in TOKEN_ENCRYPTION_KEYS, keep the older keys after it, then run tokens:rotate")
+		return nil
+	})
+
+	grift.Desc("rotate", "Encrypts stored Soundcloud tokens under the first key in TOKEN_ENCRYPTION_KEYS, including tokens stored unencrypted or under older keys")
+	grift.Add("rotate", func(c *grift.Context) error {
+		// Rotate the database the app runs against, production included
+		env := envy.Get("GO_ENV", "development")
+		db, err := pop.Connect(env)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		var rotated int
+		err = db.Transaction(func(tx *pop.Connection) error {
+			rotated, err = services.NewAccountService(tx).RotateTokens()
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Re-encrypted %d tokens in the %s database. Older keys can now be removed from TOKEN_ENCRYPTION_KEYS.\n", rotated, env)
+		return nil
+	})
+
+})
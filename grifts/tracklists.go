@@ -0,0 +1,43 @@
+package grifts
+
+import (
+	"fmt"
+
+	"github.com/gobuffalo/grift/grift"
+	"github.com/gobuffalo/pop/v6"
+	"github.com/jbhicks/sound-cistern/src/models"
+	"github.com/jbhicks/sound-cistern/src/services"
+)
+
+var _ = grift.Namespace("tracklists", func() {
+
+	grift.Desc("extract", "Parses tracklists from the descriptions of all stored tracks")
+	grift.Add("extract", func(c *grift.Context) error {
+		db, err := pop.Connect("development")
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		tracks := models.Tracks{}
+		if err := db.Where("description IS NOT NULL").All(&tracks); err != nil {
+			return err
+		}
+
+		tracklists := services.NewTracklistService(db)
+		found := 0
+		for i := range tracks {
+			n, err := tracklists.Extract(&tracks[i])
+			if err != nil {
+				return err
+			}
+			if n > 0 {
+				found++
+			}
+		}
+
+		fmt.Printf("Extracted tracklists for %d of %d tracks\n", found, len(tracks))
+		return nil
+	})
+
+})
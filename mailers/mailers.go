@@ -0,0 +1,39 @@
+package mailers
+
+import (
+	"github.com/gobuffalo/buffalo/mail"
+	"github.com/gobuffalo/buffalo/render"
+	"github.com/gobuffalo/envy"
+	"github.com/jbhicks/sound-cistern/pkg/logging"
+	"github.com/jbhicks/sound-cistern/templates"
+)
+
+var smtp mail.Sender
+var r *render.Engine
+
+// From is the sender address used for outgoing mail
+var From = envy.Get("SMTP_FROM", "Sound Cistern <noreply@soundcistern.local>")
+
+func init() {
+	// Defaults point at a local capture server such as Mailpit
+	port := envy.Get("SMTP_PORT", "1025")
+	host := envy.Get("SMTP_HOST", "localhost")
+	user := envy.Get("SMTP_USER", "")
+	password := envy.Get("SMTP_PASSWORD", "")
+
+	var err error
+	smtp, err = mail.NewSMTPSender(host, port, user, password)
+	if err != nil {
+		logging.Fatal("Failed to configure SMTP sender", logging.Fields{
+			"host":  host,
+			"port":  port,
+			"error": err.Error(),
+		})
+	}
+
+	r = render.New(render.Options{
+		HTMLLayout:  "mail/layout.plush.html",
+		TemplatesFS: templates.FS(),
+		Helpers:     render.Helpers{},
+	})
+}
@@ -0,0 +1,36 @@
+package mailers
+
+import (
+	"fmt"
+
+	"github.com/gobuffalo/buffalo/mail"
+	"github.com/gobuffalo/buffalo/render"
+)
+
+// DigestEntry groups the tracks one alert matched since the last digest
+type DigestEntry struct {
+	AlertName string
+	Tracks    []interface{}
+}
+
+// SendMatchDigest emails a digest of newly matched tracks to a single recipient
+func SendMatchDigest(to string, entries []DigestEntry) error {
+	total := 0
+	for _, e := range entries {
+		total += len(e.Tracks)
+	}
+
+	m := mail.NewMessage()
+	m.Subject = fmt.Sprintf("Sound Cistern: %d new matching tracks", total)
+	m.From = From
+	m.To = []string{to}
+
+	data := render.Data{
+		"entries": entries,
+		"total":   total,
+	}
+	if err := m.AddBodies(data, r.HTML("mail/match_digest.plush.html"), r.Plain("mail/match_digest.plush.txt")); err != nil {
+		return err
+	}
+	return smtp.Send(m)
+}
@@ -0,0 +1,124 @@
+package logging
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Events returns the user actions, security events and audit events in the
+// main and audit log files that were logged for any of ids, matched
+// against their actor, email and user_id fields, in the order they were
+// written. Lines are read in either the JSON or the text format. Nothing is
+// returned when file output is disabled.
+func (s *Service) Events(ids ...string) ([]Fields, error) {
+	if !s.config.EnableFileOutput {
+		return nil, nil
+	}
+	wanted := map[string]bool{}
+	for _, id := range ids {
+		if id != "" {
+			wanted[strings.ToLower(id)] = true
+		}
+	}
+
+	var events []Fields
+	for _, path := range []string{s.config.LogFilePath, s.config.AuditLogPath} {
+		file, err := os.Open(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			fields := parseLogLine(scanner.Text())
+			if fields == nil || !isEvent(fields) {
+				continue
+			}
+			for _, key := range []string{"actor", "email", "user_id"} {
+				if v, ok := fields[key].(string); ok && wanted[strings.ToLower(v)] {
+					events = append(events, fields)
+					break
+				}
+			}
+		}
+		err = scanner.Err()
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return events, nil
+}
+
+// isEvent reports whether a log line is a user action, security event or
+// audit event rather than an ordinary log message
+func isEvent(fields Fields) bool {
+	switch fields["log_type"] {
+	case "user_action", "security_event":
+		return true
+	}
+	audit, _ := fields["audit"].(string)
+	return fields["audit"] == true || audit == "true"
+}
+
+// parseLogLine reads the fields of a line in logrus's JSON or text format,
+// or returns nil for a line in neither
+func parseLogLine(line string) Fields {
+	line = strings.TrimSpace(line)
+	if strings.HasPrefix(line, "{") {
+		fields := Fields{}
+		if err := json.Unmarshal([]byte(line), &fields); err != nil {
+			return nil
+		}
+		return fields
+	}
+
+	// key=value pairs, with values quoted when they hold spaces
+	fields := Fields{}
+	for line != "" {
+		eq := strings.IndexByte(line, '=')
+		if eq <= 0 {
+			return nil
+		}
+		key := line[:eq]
+		line = line[eq+1:]
+		var value string
+		if strings.HasPrefix(line, `"`) {
+			end, escaped := 1, false
+			for ; end < len(line); end++ {
+				if escaped {
+					escaped = false
+				} else if line[end] == '\\' {
+					escaped = true
+				} else if line[end] == '"' {
+					break
+				}
+			}
+			if end == len(line) {
+				return nil
+			}
+			unquoted, err := strconv.Unquote(line[:end+1])
+			if err != nil {
+				return nil
+			}
+			value, line = unquoted, line[end+1:]
+		} else if space := strings.IndexByte(line, ' '); space >= 0 {
+			value, line = line[:space], line[space:]
+		} else {
+			value, line = line, ""
+		}
+		fields[key] = value
+		line = strings.TrimLeft(line, " ")
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+	return fields
+}
@@ -0,0 +1,41 @@
+package logging
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestEventsReadsBothFormats(t *testing.T) {
+	tempDir := t.TempDir()
+	for _, env := range []string{"development", "production"} {
+		config := &Config{
+			LogLevel:         "info",
+			LogFilePath:      filepath.Join(tempDir, env+".log"),
+			ErrorLogPath:     filepath.Join(tempDir, env+"-error.log"),
+			AuditLogPath:     filepath.Join(tempDir, env+"-audit.log"),
+			EnableFileOutput: true,
+			Environment:      env,
+		}
+		service, err := NewService(config)
+		if err != nil {
+			t.Fatalf("Failed to create logging service: %v", err)
+		}
+
+		service.UserAction(nil, "dj@example.com", "label_delete", "Deleted a track label", Fields{"label_id": "1"})
+		service.SecurityEvent(nil, "login_failed", "failure", "invalid credentials", Fields{"email": "dj@example.com"})
+		service.Audit("soundcloud_account_disconnect", Fields{"user_id": "user-1", "tracks": 3})
+		service.UserAction(nil, "other@example.com", "label_delete", "Deleted a track label")
+		service.Info("Feed synced", Fields{"user_id": "user-1"})
+
+		events, err := service.Events("DJ@example.com", "user-1")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(events) != 3 {
+			t.Fatalf("%s: expected the user's 3 events, got %d: %v", env, len(events), events)
+		}
+		if events[1]["reason"] != "invalid credentials" {
+			t.Errorf("%s: expected quoted values to be read whole, got %v", env, events[1]["reason"])
+		}
+	}
+}
@@ -74,6 +74,12 @@ func Audit(action string, fields Fields) {
 	GetDefault().Audit(action, fields)
 }
 
+// Events returns the logged user actions, security and audit events for
+// any of ids using the default logger's files
+func Events(ids ...string) ([]Fields, error) {
+	return GetDefault().Events(ids...)
+}
+
 // UserAction logs user actions using the default logger
 func UserAction(c buffalo.Context, actor string, action string, details string, fields ...Fields) {
 	GetDefault().UserAction(c, actor, action, details, fields...)
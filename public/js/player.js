@@ -0,0 +1,120 @@
+/**
+ * Persistent audio player
+ *
+ * The player sits outside the page content and is preserved across boosted
+ * navigation, so playback carries on between pages. The server owns the
+ * queue; this script only points the audio element at the track at the
+ * front of the queue and asks the server to move on when a track ends.
+ * Playback position is reported as the track plays so it can be resumed.
+ */
+(function () {
+  // reportInterval is how often, in milliseconds, position is saved while playing
+  var reportInterval = 15000;
+  var lastReport = 0;
+  // positionURL is where the position of the loaded track is reported
+  var positionURL = '';
+
+  // load plays the queue head, starting playback when the user asked for it
+  function load(autoplay) {
+    var audio = document.getElementById('player-audio');
+    if (!audio) {
+      return;
+    }
+
+    var now = document.getElementById('player-now');
+    var src = now ? now.getAttribute('data-stream') : '';
+    if (audio.getAttribute('src') && audio.getAttribute('src') !== src && !audio.ended) {
+      // Save where the outgoing track got to before switching
+      report(false);
+    }
+    if (!src) {
+      positionURL = '';
+      if (audio.getAttribute('src')) {
+        audio.pause();
+        audio.removeAttribute('src');
+        audio.load();
+      }
+      return;
+    }
+
+    if (audio.getAttribute('src') !== src) {
+      positionURL = now.getAttribute('data-position-url');
+      audio.setAttribute('src', src);
+      var resume = parseInt(now.getAttribute('data-resume'), 10);
+      if (resume > 0) {
+        audio.addEventListener('loadedmetadata', function () {
+          audio.currentTime = resume;
+        }, { once: true });
+      }
+      if (autoplay) {
+        audio.play().catch(function () {});
+      }
+      return;
+    }
+
+    // The same track was asked to play from a point, such as a tracklist entry
+    var seek = parseInt(now.getAttribute('data-seek'), 10);
+    if (seek >= 0) {
+      audio.currentTime = seek;
+      audio.play().catch(function () {});
+    }
+  }
+
+  // report saves the playback position of the loaded track
+  function report(ended) {
+    var audio = document.getElementById('player-audio');
+    if (!audio || !positionURL || !audio.duration) {
+      return;
+    }
+    lastReport = Date.now();
+
+    var token = document.querySelector('meta[name="csrf-token"]');
+    fetch(positionURL, {
+      method: 'POST',
+      keepalive: true,
+      headers: token ? { 'X-CSRF-Token': token.getAttribute('content') } : {},
+      body: new URLSearchParams({
+        position: Math.floor(audio.currentTime),
+        duration: Math.floor(audio.duration),
+        ended: ended ? 'true' : 'false'
+      })
+    }).catch(function () {});
+  }
+
+  document.addEventListener('htmx:afterSwap', function (evt) {
+    if (evt.detail.target && evt.detail.target.id === 'player-body') {
+      // Only changes the user made start playback; the initial load does not
+      load(evt.detail.requestConfig && evt.detail.requestConfig.verb !== 'get');
+    }
+  });
+
+  // Media events do not bubble, so listen in the capture phase
+  document.addEventListener('timeupdate', function (evt) {
+    if (evt.target.id === 'player-audio' && Date.now() - lastReport > reportInterval) {
+      report(false);
+    }
+  }, true);
+
+  document.addEventListener('pause', function (evt) {
+    if (evt.target.id === 'player-audio' && !evt.target.ended) {
+      report(false);
+    }
+  }, true);
+
+  window.addEventListener('pagehide', function () {
+    var audio = document.getElementById('player-audio');
+    if (audio && !audio.paused) {
+      report(false);
+    }
+  });
+
+  document.addEventListener('ended', function (evt) {
+    if (evt.target.id === 'player-audio') {
+      report(true);
+      var next = document.getElementById('player-next');
+      if (next) {
+        next.click();
+      }
+    }
+  }, true);
+})();
@@ -0,0 +1,116 @@
+/**
+ * Server-Sent Events extension for htmx 2
+ *
+ * A small implementation of the htmx "sse" extension API:
+ *   hx-ext="sse"           enables the extension on an element and its children
+ *   sse-connect="<url>"    opens an EventSource for the element
+ *   sse-swap="<event>"     swaps the data of named events into the element,
+ *                          honouring hx-swap and hx-target
+ *
+ * The browser reconnects automatically and sends Last-Event-ID, so the
+ * server can replay anything that was missed while disconnected.
+ */
+(function () {
+  var api;
+
+  htmx.defineExtension('sse', {
+    init: function (apiRef) {
+      api = apiRef;
+    },
+
+    getSelectors: function () {
+      return ['[sse-connect]', '[data-sse-connect]', '[sse-swap]', '[data-sse-swap]'];
+    },
+
+    onEvent: function (name, evt) {
+      var elt = evt.target || evt.detail.elt;
+
+      switch (name) {
+        case 'htmx:beforeCleanupElement':
+          var data = api.getInternalData(elt);
+          if (data.sseEventSource) {
+            data.sseEventSource.close();
+          }
+          return;
+
+        case 'htmx:afterProcessNode':
+          connect(elt);
+          registerSwap(elt);
+      }
+    }
+  });
+
+  // connect opens an EventSource for elements carrying sse-connect
+  function connect(elt) {
+    var url = api.getAttributeValue(elt, 'sse-connect');
+    if (!url) {
+      return;
+    }
+
+    var data = api.getInternalData(elt);
+    if (data.sseEventSource) {
+      return;
+    }
+
+    var source = new EventSource(url, { withCredentials: true });
+    data.sseEventSource = source;
+
+    source.onopen = function () {
+      api.triggerEvent(elt, 'htmx:sseOpen', { source: source });
+    };
+    source.onerror = function (err) {
+      api.triggerErrorEvent(elt, 'htmx:sseError', { error: err, source: source });
+    };
+
+    elt.querySelectorAll('[sse-swap], [data-sse-swap]').forEach(registerSwap);
+  }
+
+  // registerSwap listens for the named events of the closest EventSource
+  function registerSwap(elt) {
+    var eventNames = api.getAttributeValue(elt, 'sse-swap');
+    if (!eventNames) {
+      return;
+    }
+
+    var sourceElt = api.getClosestMatch(elt, function (e) {
+      return api.getInternalData(e).sseEventSource != null;
+    });
+    if (!sourceElt) {
+      return;
+    }
+
+    var data = api.getInternalData(elt);
+    if (data.sseListening) {
+      return;
+    }
+    data.sseListening = true;
+
+    var source = api.getInternalData(sourceElt).sseEventSource;
+    eventNames.split(',').forEach(function (name) {
+      name = name.trim();
+      var listener = function (event) {
+        if (!api.bodyContains(elt)) {
+          source.removeEventListener(name, listener);
+          return;
+        }
+        if (!api.triggerEvent(elt, 'htmx:sseBeforeMessage', event)) {
+          return;
+        }
+        swap(elt, event.data);
+        api.triggerEvent(elt, 'htmx:sseMessage', event);
+      };
+      source.addEventListener(name, listener);
+    });
+  }
+
+  // swap inserts event content using the element's swap specification
+  function swap(elt, content) {
+    api.withExtensions(elt, function (extension) {
+      content = extension.transformResponse(content, null, elt);
+    });
+
+    var swapSpec = api.getSwapSpecification(elt);
+    var target = api.getTarget(elt);
+    api.swap(target, content, swapSpec);
+  }
+})();
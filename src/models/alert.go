@@ -0,0 +1,31 @@
+package models
+
+import (
+	"github.com/gobuffalo/nulls"
+	"github.com/gofrs/uuid"
+	"time"
+)
+
+// Alert channels
+const (
+	AlertChannelEmail   = "email"
+	AlertChannelWebhook = "webhook"
+)
+
+// Alert is a saved set of filter criteria that notifies its owner when
+// newly fetched tracks match
+type Alert struct {
+	ID        uuid.UUID    `json:"id" db:"id"`
+	UserID    uuid.UUID    `json:"user_id" db:"user_id"`
+	Name      string       `json:"name" db:"name"`
+	Criteria  string       `json:"criteria" db:"criteria"` // JSON object understood by FeedService.FilterTracks
+	Channel   string       `json:"channel" db:"channel"`
+	Target    string       `json:"target" db:"target"` // Email address or webhook URL
+	Secret    nulls.String `json:"-" db:"secret"`      // HMAC key for webhook signatures
+	Active    bool         `json:"active" db:"active"`
+	CreatedAt time.Time    `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time    `json:"updated_at" db:"updated_at"`
+}
+
+// Alerts is a slice of Alert
+type Alerts []Alert
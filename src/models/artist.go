@@ -0,0 +1,38 @@
+package models
+
+import (
+	"github.com/gobuffalo/nulls"
+	"github.com/gofrs/uuid"
+	"time"
+)
+
+// Artist is a Soundcloud account that users of the app follow. Artists are
+// shared between users; who follows them is kept in Following.
+type Artist struct {
+	ID             uuid.UUID    `json:"id" db:"id"`
+	SoundcloudID   string       `json:"soundcloud_id" db:"soundcloud_id"`
+	Username       string       `json:"username" db:"username"`
+	PermalinkURL   nulls.String `json:"permalink_url" db:"permalink_url"`
+	AvatarURL      nulls.String `json:"avatar_url" db:"avatar_url"`
+	FollowersCount int          `json:"followers_count" db:"followers_count"`
+	TrackCount     int          `json:"track_count" db:"track_count"`
+	LastPostAt     nulls.Time   `json:"last_post_at" db:"last_post_at"` // Newest stored track by the artist
+	CreatedAt      time.Time    `json:"created_at" db:"created_at"`
+	UpdatedAt      time.Time    `json:"updated_at" db:"updated_at"`
+}
+
+// Artists is a slice of Artist
+type Artists []Artist
+
+// Following links a user to an artist they follow on Soundcloud
+type Following struct {
+	ID        uuid.UUID `json:"id" db:"id"`
+	UserID    uuid.UUID `json:"user_id" db:"user_id"`
+	ArtistID  uuid.UUID `json:"artist_id" db:"artist_id"`
+	Filter    string    `json:"filter" db:"filter"` // "include", "exclude" or "" for neither
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// Followings is a slice of Following
+type Followings []Following
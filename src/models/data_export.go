@@ -0,0 +1,36 @@
+package models
+
+import (
+	"github.com/gobuffalo/nulls"
+	"github.com/gofrs/uuid"
+	"time"
+)
+
+// Data export statuses
+const (
+	DataExportPending = "pending"
+	DataExportReady   = "ready"
+	DataExportFailed  = "failed"
+)
+
+// DataExport is an archive of everything stored about a user, built in
+// the background and downloaded through a signed link until it expires
+type DataExport struct {
+	ID          uuid.UUID    `json:"id" db:"id"`
+	UserID      uuid.UUID    `json:"user_id" db:"user_id"`
+	Status      string       `json:"status" db:"status"`
+	Size        int64        `json:"size" db:"size"` // Bytes, once ready
+	Error       nulls.String `json:"error" db:"error"`
+	CompletedAt nulls.Time   `json:"completed_at" db:"completed_at"`
+	ExpiresAt   nulls.Time   `json:"expires_at" db:"expires_at"`
+	CreatedAt   time.Time    `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time    `json:"updated_at" db:"updated_at"`
+}
+
+// DataExports is a slice of DataExport
+type DataExports []DataExport
+
+// Downloadable reports whether the archive is built and not yet expired
+func (e DataExport) Downloadable() bool {
+	return e.Status == DataExportReady && e.ExpiresAt.Valid && time.Now().Before(e.ExpiresAt.Time)
+}
@@ -13,5 +13,10 @@ type Feed struct {
 	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
 }
 
+// TableName overrides the table name used by Pop
+func (f Feed) TableName() string {
+	return "soundcloud_feeds"
+}
+
 // Feeds is a slice of Feed
 type Feeds []Feed
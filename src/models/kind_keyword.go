@@ -0,0 +1,21 @@
+package models
+
+import (
+	"github.com/gofrs/uuid"
+	"time"
+)
+
+// KindKeyword is a keyword weight a user has taught the track classifier
+// by overriding a track's kind
+type KindKeyword struct {
+	ID        uuid.UUID `json:"id" db:"id"`
+	UserID    uuid.UUID `json:"user_id" db:"user_id"`
+	Keyword   string    `json:"keyword" db:"keyword"`
+	Kind      string    `json:"kind" db:"kind"`
+	Weight    float64   `json:"weight" db:"weight"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// KindKeywords is a slice of KindKeyword
+type KindKeywords []KindKeyword
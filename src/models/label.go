@@ -0,0 +1,43 @@
+package models
+
+import (
+	"github.com/gofrs/uuid"
+	"time"
+)
+
+// Label is a user's own category for stored tracks, used in place of
+// Soundcloud's free-form genres
+type Label struct {
+	ID        uuid.UUID `json:"id" db:"id"`
+	UserID    uuid.UUID `json:"user_id" db:"user_id"`
+	Name      string    `json:"name" db:"name"`
+	Color     string    `json:"color" db:"color"` // #rrggbb
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// Labels is a slice of Label
+type Labels []Label
+
+// TrackLabel puts a label on a stored track
+type TrackLabel struct {
+	ID        uuid.UUID `json:"id" db:"id"`
+	TrackID   uuid.UUID `json:"track_id" db:"track_id"`
+	LabelID   uuid.UUID `json:"label_id" db:"label_id"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// TrackLabels is a slice of TrackLabel
+type TrackLabels []TrackLabel
+
+// TrackNote is the user's free-text note on a stored track
+type TrackNote struct {
+	ID        uuid.UUID `json:"id" db:"id"`
+	TrackID   uuid.UUID `json:"track_id" db:"track_id"`
+	Body      string    `json:"body" db:"body"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// TrackNotes is a slice of TrackNote
+type TrackNotes []TrackNote
@@ -0,0 +1,27 @@
+package models
+
+import (
+	"github.com/gobuffalo/nulls"
+	"github.com/gofrs/uuid"
+	"time"
+)
+
+// Listen records how far a user got through a track
+type Listen struct {
+	ID        uuid.UUID  `json:"id" db:"id"`
+	UserID    uuid.UUID  `json:"user_id" db:"user_id"`
+	TrackID   uuid.UUID  `json:"track_id" db:"track_id"`
+	Position  int        `json:"position" db:"position"` // Seconds into the track to resume from
+	Heard     bool       `json:"heard" db:"heard"`
+	HeardAt   nulls.Time `json:"heard_at" db:"heard_at"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at" db:"updated_at"`
+}
+
+// Partial reports whether the track was started but not finished
+func (l Listen) Partial() bool {
+	return l.Position > 0 && !l.Heard
+}
+
+// Listens is a slice of Listen
+type Listens []Listen
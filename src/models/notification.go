@@ -0,0 +1,46 @@
+package models
+
+import (
+	"github.com/gobuffalo/nulls"
+	"github.com/gofrs/uuid"
+	"time"
+)
+
+// Notification statuses
+const (
+	NotificationPending   = "pending"
+	NotificationDelivered = "delivered"
+	NotificationFailed    = "failed"
+)
+
+// Notification is a queued message about tracks that matched an alert
+type Notification struct {
+	ID            uuid.UUID  `json:"id" db:"id"`
+	AlertID       uuid.UUID  `json:"alert_id" db:"alert_id"`
+	UserID        uuid.UUID  `json:"user_id" db:"user_id"`
+	Tracks        string     `json:"tracks" db:"tracks"` // JSON array of matching tracks
+	Status        string     `json:"status" db:"status"`
+	Attempts      int        `json:"attempts" db:"attempts"`
+	NextAttemptAt nulls.Time `json:"next_attempt_at" db:"next_attempt_at"`
+	DeliveredAt   nulls.Time `json:"delivered_at" db:"delivered_at"`
+	CreatedAt     time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at" db:"updated_at"`
+}
+
+// Notifications is a slice of Notification
+type Notifications []Notification
+
+// NotificationDelivery records a single attempt to deliver a notification
+type NotificationDelivery struct {
+	ID             uuid.UUID    `json:"id" db:"id"`
+	NotificationID uuid.UUID    `json:"notification_id" db:"notification_id"`
+	Attempt        int          `json:"attempt" db:"attempt"`
+	Channel        string       `json:"channel" db:"channel"`
+	Status         string       `json:"status" db:"status"`
+	ResponseCode   nulls.Int    `json:"response_code" db:"response_code"`
+	Error          nulls.String `json:"error" db:"error"`
+	CreatedAt      time.Time    `json:"created_at" db:"created_at"`
+}
+
+// NotificationDeliveries is a slice of NotificationDelivery
+type NotificationDeliveries []NotificationDelivery
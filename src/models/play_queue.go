@@ -0,0 +1,20 @@
+package models
+
+import (
+	"github.com/gofrs/uuid"
+	"time"
+)
+
+// PlayQueueItem is a stored track waiting in a user's player queue. The
+// item with the lowest position is the one playing.
+type PlayQueueItem struct {
+	ID        uuid.UUID `json:"id" db:"id"`
+	UserID    uuid.UUID `json:"user_id" db:"user_id"`
+	TrackID   uuid.UUID `json:"track_id" db:"track_id"`
+	Position  int       `json:"position" db:"position"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// PlayQueueItems is a slice of PlayQueueItem
+type PlayQueueItems []PlayQueueItem
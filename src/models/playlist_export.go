@@ -0,0 +1,27 @@
+package models
+
+import (
+	"github.com/gobuffalo/nulls"
+	"github.com/gofrs/uuid"
+	"time"
+)
+
+// PlaylistExport is a saved filter preset that is pushed to a Soundcloud
+// playlist and re-synced on a schedule
+type PlaylistExport struct {
+	ID                   uuid.UUID    `json:"id" db:"id"`
+	UserID               uuid.UUID    `json:"user_id" db:"user_id"`
+	Title                string       `json:"title" db:"title"`
+	Criteria             string       `json:"criteria" db:"criteria"` // JSON object understood by FeedService.Page
+	Sort                 string       `json:"sort" db:"sort"`
+	SoundcloudPlaylistID nulls.String `json:"soundcloud_playlist_id" db:"soundcloud_playlist_id"` // Set once the playlist has been created
+	PermalinkURL         nulls.String `json:"permalink_url" db:"permalink_url"`
+	TrackCount           int          `json:"track_count" db:"track_count"`
+	SyncedAt             nulls.Time   `json:"synced_at" db:"synced_at"`
+	LastError            nulls.String `json:"last_error" db:"last_error"`
+	CreatedAt            time.Time    `json:"created_at" db:"created_at"`
+	UpdatedAt            time.Time    `json:"updated_at" db:"updated_at"`
+}
+
+// PlaylistExports is a slice of PlaylistExport
+type PlaylistExports []PlaylistExport
@@ -0,0 +1,32 @@
+package models
+
+import (
+	"github.com/gobuffalo/nulls"
+	"github.com/gofrs/uuid"
+	"time"
+)
+
+// PodcastSubscription is an RSS, Atom or podcast feed a user follows
+// alongside Soundcloud. Its episodes are stored as tracks.
+type PodcastSubscription struct {
+	ID            uuid.UUID    `json:"id" db:"id"`
+	UserID        uuid.UUID    `json:"user_id" db:"user_id"`
+	URL           string       `json:"url" db:"url"`
+	Title         string       `json:"title" db:"title"`
+	ImageURL      nulls.String `json:"image_url" db:"image_url"`
+	LastFetchedAt nulls.Time   `json:"last_fetched_at" db:"last_fetched_at"`
+	LastError     nulls.String `json:"last_error" db:"last_error"`
+	CreatedAt     time.Time    `json:"created_at" db:"created_at"`
+	UpdatedAt     time.Time    `json:"updated_at" db:"updated_at"`
+}
+
+// PodcastSubscriptions is a slice of PodcastSubscription
+type PodcastSubscriptions []PodcastSubscription
+
+// DisplayName is the feed's title, or its URL before the title is known
+func (s PodcastSubscription) DisplayName() string {
+	if s.Title != "" {
+		return s.Title
+	}
+	return s.URL
+}
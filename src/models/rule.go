@@ -0,0 +1,57 @@
+package models
+
+import (
+	"github.com/gofrs/uuid"
+	"time"
+)
+
+// Rule actions
+const (
+	RuleActionTag    = "tag"
+	RuleActionHide   = "hide"
+	RuleActionPin    = "pin"
+	RuleActionNotify = "notify"
+)
+
+// Rule acts on newly synced tracks that match its criteria. A user's rules
+// run in priority order, lowest first.
+type Rule struct {
+	ID             uuid.UUID `json:"id" db:"id"`
+	UserID         uuid.UUID `json:"user_id" db:"user_id"`
+	Name           string    `json:"name" db:"name"`
+	Priority       int       `json:"priority" db:"priority"`
+	Criteria       string    `json:"criteria" db:"criteria"` // JSON object understood by FeedService.FilterTracks
+	Action         string    `json:"action" db:"action"`
+	ActionValue    string    `json:"action_value" db:"action_value"` // Tag name, or alert id to notify through
+	StopProcessing bool      `json:"stop_processing" db:"stop_processing"`
+	Active         bool      `json:"active" db:"active"`
+	CreatedAt      time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// Rules is a slice of Rule
+type Rules []Rule
+
+// RuleExecution records a rule acting on a track
+type RuleExecution struct {
+	ID           uuid.UUID `json:"id" db:"id"`
+	RuleID       uuid.UUID `json:"rule_id" db:"rule_id"`
+	SoundcloudID string    `json:"soundcloud_id" db:"soundcloud_id"`
+	Title        string    `json:"title" db:"title"`
+	Action       string    `json:"action" db:"action"`
+	CreatedAt    time.Time `json:"created_at" db:"created_at"`
+}
+
+// RuleExecutions is a slice of RuleExecution
+type RuleExecutions []RuleExecution
+
+// TrackTag is a tag a rule put on a stored track
+type TrackTag struct {
+	ID        uuid.UUID `json:"id" db:"id"`
+	TrackID   uuid.UUID `json:"track_id" db:"track_id"`
+	Tag       string    `json:"tag" db:"tag"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// TrackTags is a slice of TrackTag
+type TrackTags []TrackTag
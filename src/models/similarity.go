@@ -0,0 +1,34 @@
+package models
+
+import (
+	"github.com/gofrs/uuid"
+	"time"
+)
+
+// TrackSimilarity links a stored track to one of the user's tracks most
+// like it. Only each track's closest matches are kept.
+type TrackSimilarity struct {
+	ID             uuid.UUID `json:"id" db:"id"`
+	UserID         uuid.UUID `json:"user_id" db:"user_id"`
+	TrackID        uuid.UUID `json:"track_id" db:"track_id"`
+	SimilarTrackID uuid.UUID `json:"similar_track_id" db:"similar_track_id"`
+	Score          float64   `json:"score" db:"score"` // 0-1, higher is more alike
+	CreatedAt      time.Time `json:"created_at" db:"created_at"`
+}
+
+// TrackSimilarities is a slice of TrackSimilarity
+type TrackSimilarities []TrackSimilarity
+
+// RecommendationExclusion keeps an artist's tracks out of a user's
+// similar track recommendations
+type RecommendationExclusion struct {
+	ID         uuid.UUID `json:"id" db:"id"`
+	UserID     uuid.UUID `json:"user_id" db:"user_id"`
+	ArtistID   string    `json:"artist_soundcloud_id" db:"artist_soundcloud_id"`
+	ArtistName string    `json:"artist_name" db:"artist_name"`
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// RecommendationExclusions is a slice of RecommendationExclusion
+type RecommendationExclusions []RecommendationExclusion
@@ -0,0 +1,35 @@
+package models
+
+import (
+	"github.com/gobuffalo/nulls"
+	"github.com/gofrs/uuid"
+	"time"
+)
+
+// SoundcloudAccount is one of the Soundcloud identities a user has linked,
+// such as a personal account and a label account. Each is synced with its
+// own token.
+type SoundcloudAccount struct {
+	ID           uuid.UUID    `json:"id" db:"id"`
+	UserID       uuid.UUID    `json:"user_id" db:"user_id"`
+	SoundcloudID string       `json:"soundcloud_id" db:"soundcloud_id"`
+	Username     string       `json:"username" db:"username"`
+	AccessToken  string       `json:"-" db:"access_token"`
+	NeedsReauth  bool         `json:"needs_reauth" db:"needs_reauth"` // Set when Soundcloud rejects the token
+	LastSyncedAt nulls.Time   `json:"last_synced_at" db:"last_synced_at"`
+	LastError    nulls.String `json:"last_error" db:"last_error"`
+	CreatedAt    time.Time    `json:"created_at" db:"created_at"`
+	UpdatedAt    time.Time    `json:"updated_at" db:"updated_at"`
+}
+
+// SoundcloudAccounts is a slice of SoundcloudAccount
+type SoundcloudAccounts []SoundcloudAccount
+
+// DisplayName is the account's Soundcloud username, or its id before the
+// username is known
+func (a SoundcloudAccount) DisplayName() string {
+	if a.Username != "" {
+		return a.Username
+	}
+	return a.SoundcloudID
+}
@@ -1,21 +1,72 @@
 package models
 
 import (
+	"encoding/json"
+	"github.com/gobuffalo/nulls"
 	"github.com/gofrs/uuid"
 	"time"
 )
 
 // Track represents a Soundcloud track
 type Track struct {
-	ID           uuid.UUID `json:"id" db:"id"`
-	UserID       uuid.UUID `json:"user_id" db:"user_id"`
-	SoundcloudID string    `json:"soundcloud_id" db:"soundcloud_id"`
-	Title        string    `json:"title" db:"title"`
-	Length       int       `json:"length" db:"length"`
-	Genre        string    `json:"genre" db:"genre"`
-	PostTime     time.Time `json:"post_time" db:"post_time"`
-	CreatedAt    time.Time `json:"created_at" db:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at" db:"updated_at"`
+	ID             uuid.UUID    `json:"id" db:"id"`
+	UserID         uuid.UUID    `json:"user_id" db:"user_id"`
+	SoundcloudID   string       `json:"soundcloud_id" db:"soundcloud_id"`
+	Title          string       `json:"title" db:"title"`
+	Length         int          `json:"length" db:"length"`
+	Genre          string       `json:"genre" db:"genre"`
+	Artist         nulls.String `json:"artist" db:"artist"`
+	ArtistID       string       `json:"artist_soundcloud_id" db:"artist_soundcloud_id"` // Soundcloud id of the posting account
+	Description    nulls.String `json:"description" db:"description"`
+	TagList        nulls.String `json:"tag_list" db:"tag_list"`
+	PermalinkURL   nulls.String `json:"permalink_url" db:"permalink_url"`
+	ArtworkURL     nulls.String `json:"artwork_url" db:"artwork_url"`
+	Data           string       `json:"-" db:"data"`    // Raw Soundcloud JSON for rendering
+	Kind           string       `json:"kind" db:"kind"` // Mix, live set, podcast or single, see services.Kinds
+	KindConfidence float64      `json:"kind_confidence" db:"kind_confidence"`
+	KindOverridden bool         `json:"kind_overridden" db:"kind_overridden"` // Set by the user rather than the classifier
+	DuplicateOf    nulls.UUID   `json:"duplicate_of" db:"duplicate_of"`       // Canonical upload of the same recording
+	Hidden         bool         `json:"hidden" db:"hidden"`                   // Hidden from the feed by a rule
+	Pinned         bool         `json:"pinned" db:"pinned"`                   // Pinned to the top of the feed by a rule
+	PostTime       time.Time    `json:"post_time" db:"post_time"`
+	CreatedAt      time.Time    `json:"created_at" db:"created_at"`
+	UpdatedAt      time.Time    `json:"updated_at" db:"updated_at"`
+}
+
+// TableName overrides the table name used by Pop
+func (t Track) TableName() string {
+	return "soundcloud_tracks"
+}
+
+// Map returns the track in the same shape as a Soundcloud API track, which
+// is what templates and FeedService.FilterTracks work with
+func (t Track) Map() map[string]interface{} {
+	track := map[string]interface{}{}
+	if t.Data != "" {
+		json.Unmarshal([]byte(t.Data), &track)
+	}
+	if _, ok := track["id"]; !ok {
+		track["id"] = t.SoundcloudID
+	}
+	if _, ok := track["title"]; !ok {
+		track["title"] = t.Title
+	}
+	if _, ok := track["genre"]; !ok {
+		track["genre"] = t.Genre
+	}
+	track["length"] = float64(t.Length)
+	if t.Hidden {
+		track["hidden"] = true
+	}
+	if t.Pinned {
+		track["pinned"] = true
+	}
+	if t.Kind != "" {
+		track["kind"] = t.Kind
+		track["kind_confidence"] = t.KindConfidence
+		track["kind_overridden"] = t.KindOverridden
+	}
+	return track
 }
 
 // Tracks is a slice of Track
@@ -0,0 +1,24 @@
+package models
+
+import (
+	"github.com/gobuffalo/nulls"
+	"github.com/gofrs/uuid"
+	"time"
+)
+
+// TrackSource records where a stored track came from: the stream, the
+// user's likes or one of their playlists, and through which of the user's
+// Soundcloud accounts. A track can have several.
+type TrackSource struct {
+	ID            uuid.UUID  `json:"id" db:"id"`
+	TrackID       uuid.UUID  `json:"track_id" db:"track_id"`
+	Source        string     `json:"source" db:"source"`
+	PlaylistID    string     `json:"playlist_id" db:"playlist_id"` // Set for playlist sources only
+	PlaylistTitle string     `json:"playlist_title" db:"playlist_title"`
+	AccountID     nulls.UUID `json:"account_id" db:"account_id"`
+	CreatedAt     time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at" db:"updated_at"`
+}
+
+// TrackSources is a slice of TrackSource
+type TrackSources []TrackSource
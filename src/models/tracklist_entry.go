@@ -0,0 +1,21 @@
+package models
+
+import (
+	"github.com/gofrs/uuid"
+	"time"
+)
+
+// TracklistEntry is one track in a mix, parsed from the mix description
+type TracklistEntry struct {
+	ID        uuid.UUID `json:"id" db:"id"`
+	TrackID   uuid.UUID `json:"track_id" db:"track_id"`
+	Position  int       `json:"position" db:"position"`
+	Start     int       `json:"start" db:"start_seconds"` // Seconds into the mix
+	Artist    string    `json:"artist" db:"artist"`
+	Title     string    `json:"title" db:"title"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// TracklistEntries is a slice of TracklistEntry
+type TracklistEntries []TracklistEntry
@@ -14,5 +14,10 @@ type User struct {
 	UpdatedAt    time.Time `json:"updated_at" db:"updated_at"`
 }
 
+// TableName overrides the table name used by Pop
+func (u User) TableName() string {
+	return "soundcloud_users"
+}
+
 // Users is a slice of User
 type Users []User
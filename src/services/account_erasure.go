@@ -0,0 +1,154 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/jbhicks/sound-cistern/src/models"
+)
+
+// Erasure is what disconnecting a Soundcloud account removes. Tracks that
+// also came through another linked account, or from a podcast, are kept.
+// Disconnecting the user's last account removes everything that came from
+// Soundcloud: every stored track and followed artist. Podcast episodes are
+// stored with the Soundcloud tracks and go with them, but the user's own
+// settings, such as podcast subscriptions and the artists kept out of
+// recommendations, are kept.
+type Erasure struct {
+	Account *models.SoundcloudAccount
+	// Last is set when the account is the user's only linked account
+	Last       bool
+	Tracks     int
+	Presets    int
+	Listens    int
+	QueueItems int
+	Followings int
+
+	userUUID  uuid.UUID
+	presetIDs []interface{}
+	// tracks selects the ids of the erased tracks
+	tracks    string
+	trackArgs []interface{}
+}
+
+// PlanDisconnect works out what disconnecting an account would erase,
+// without changing anything
+func (as *AccountService) PlanDisconnect(userID, accountID string) (*Erasure, error) {
+	account, err := as.Account(userID, accountID)
+	if err != nil {
+		return nil, err
+	}
+	accounts, err := as.Accounts(userID)
+	if err != nil {
+		return nil, err
+	}
+	erasure := &Erasure{Account: account, Last: len(accounts) == 1, userUUID: account.UserID}
+
+	erasure.tracks = "SELECT id FROM soundcloud_tracks WHERE user_id = ?"
+	erasure.trackArgs = []interface{}{account.UserID}
+	if !erasure.Last {
+		erasure.tracks += `
+			AND EXISTS (SELECT 1 FROM track_sources WHERE track_id = soundcloud_tracks.id AND account_id = ?)
+			AND NOT EXISTS (SELECT 1 FROM track_sources WHERE track_id = soundcloud_tracks.id AND (account_id IS NULL OR account_id <> ?))`
+		erasure.trackArgs = append(erasure.trackArgs, account.ID, account.ID)
+	}
+	if erasure.Tracks, err = as.DB.Where("id IN ("+erasure.tracks+")", erasure.trackArgs...).Count(&models.Track{}); err != nil {
+		return nil, err
+	}
+	if erasure.Listens, err = as.DB.Where("track_id IN ("+erasure.tracks+")", erasure.trackArgs...).Count(&models.Listen{}); err != nil {
+		return nil, err
+	}
+	if erasure.QueueItems, err = as.DB.Where("track_id IN ("+erasure.tracks+")", erasure.trackArgs...).Count(&models.PlayQueueItem{}); err != nil {
+		return nil, err
+	}
+
+	exports, err := NewPlaylistExportService(as.DB, nil).Exports(userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, export := range exports {
+		if exportUsesAccount(export, account.ID.String()) {
+			erasure.presetIDs = append(erasure.presetIDs, export.ID)
+		}
+	}
+	erasure.Presets = len(erasure.presetIDs)
+
+	if erasure.Last {
+		if erasure.Followings, err = as.DB.Where("user_id = ?", account.UserID).Count(&models.Following{}); err != nil {
+			return nil, err
+		}
+	}
+	return erasure, nil
+}
+
+// Disconnect unlinks an account and erases its tracks, the presets
+// filtered to it and playback of the erased tracks, as planned by
+// PlanDisconnect. Revoking the token with Soundcloud is left to the
+// caller. Run it in a transaction so a failure leaves everything in place.
+func (as *AccountService) Disconnect(ctx context.Context, userID, accountID string) (*Erasure, error) {
+	erasure, err := as.PlanDisconnect(userID, accountID)
+	if err != nil {
+		return nil, err
+	}
+	db := as.DB.WithContext(ctx)
+
+	if len(erasure.presetIDs) > 0 {
+		in := strings.TrimSuffix(strings.Repeat("?,", len(erasure.presetIDs)), ",")
+		if err := db.RawQuery("DELETE FROM playlist_exports WHERE id IN ("+in+")", erasure.presetIDs...).Exec(); err != nil {
+			return nil, err
+		}
+	}
+
+	if erasure.Last {
+		// Tracks, sources, the cached feed, listens and the play queue
+		// cascade from the Soundcloud link
+		for _, table := range []string{"followings", "play_queue_items", "listens"} {
+			if err := db.RawQuery("DELETE FROM "+table+" WHERE user_id = ?", erasure.userUUID).Exec(); err != nil {
+				return nil, err
+			}
+		}
+		if err := db.RawQuery("DELETE FROM soundcloud_users WHERE id = ?", erasure.userUUID).Exec(); err != nil {
+			return nil, err
+		}
+		return erasure, nil
+	}
+
+	// Tracks go before the account, whose sources pick them out. Listens,
+	// queue entries, labels and notes cascade from the tracks.
+	if err := db.RawQuery("DELETE FROM soundcloud_tracks WHERE id IN ("+erasure.tracks+")", erasure.trackArgs...).Exec(); err != nil {
+		return nil, err
+	}
+	if err := db.RawQuery("DELETE FROM soundcloud_accounts WHERE id = ?", erasure.Account.ID).Exec(); err != nil {
+		return nil, err
+	}
+	if _, err := NewFeedService(as.DB).CacheAccountFeed(ctx, userID, erasure.Account.ID, nil); err != nil {
+		return nil, err
+	}
+	// The link keeps the first account's id and token; hand it to an
+	// account that is still linked
+	err = db.RawQuery(`UPDATE soundcloud_users SET soundcloud_id = a.soundcloud_id, access_token = a.access_token, updated_at = ?
+		FROM (SELECT soundcloud_id, access_token FROM soundcloud_accounts WHERE user_id = ? ORDER BY created_at asc LIMIT 1) a
+		WHERE soundcloud_users.id = ? AND soundcloud_users.soundcloud_id = ?`,
+		time.Now(), erasure.userUUID, erasure.userUUID, erasure.Account.SoundcloudID).Exec()
+	if err != nil {
+		return nil, err
+	}
+	return erasure, nil
+}
+
+// exportUsesAccount reports whether a preset is filtered to an account
+func exportUsesAccount(export models.PlaylistExport, accountID string) bool {
+	var criteria map[string]interface{}
+	if err := json.Unmarshal([]byte(export.Criteria), &criteria); err != nil {
+		return false
+	}
+	for _, id := range stringValues(criteria["accounts"]) {
+		if id == accountID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,222 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gobuffalo/pop/v6"
+	"github.com/gofrs/uuid"
+	"github.com/jbhicks/sound-cistern/src/models"
+)
+
+var (
+	// ErrAccountNotFound is returned when a Soundcloud account is not
+	// linked to the user
+	ErrAccountNotFound = errors.New("soundcloud account not found")
+	// ErrNoUsableAccount is returned when every linked account needs to be
+	// connected again
+	ErrNoUsableAccount = errors.New("no connected soundcloud account")
+)
+
+// AccountService stores the link between a local user and their Soundcloud
+// accounts. The link shares its ID with the local user so that cached feeds
+// and tracks can reference it directly. Each linked account keeps its own
+// token, encrypted when token keys are configured.
+type AccountService struct {
+	DB *pop.Connection
+}
+
+// NewAccountService creates a new service
+func NewAccountService(db *pop.Connection) *AccountService {
+	return &AccountService{DB: db}
+}
+
+// SaveLink creates or updates the Soundcloud account with the given id for
+// a user, creating the user's link on their first account
+func (as *AccountService) SaveLink(userID, soundcloudID, accessToken string) (*models.SoundcloudAccount, error) {
+	return as.SaveAccount(userID, soundcloudID, "", accessToken)
+}
+
+// SaveAccount links a Soundcloud account to a user or refreshes its token
+// after the user connected it again. The username is kept when empty.
+func (as *AccountService) SaveAccount(userID, soundcloudID, username, accessToken string) (*models.SoundcloudAccount, error) {
+	userUUID, err := uuid.FromString(userID)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := sealToken(accessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	link := &models.User{}
+	if err := as.DB.Find(link, userUUID); err != nil {
+		link = &models.User{
+			ID:           userUUID,
+			SoundcloudID: soundcloudID,
+			AccessToken:  sealed,
+		}
+		if err := as.DB.Create(link); err != nil {
+			return nil, err
+		}
+	} else if link.SoundcloudID == soundcloudID {
+		link.AccessToken = sealed
+		if err := as.DB.Update(link); err != nil {
+			return nil, err
+		}
+	}
+
+	account := &models.SoundcloudAccount{}
+	if err := as.DB.Where("user_id = ? AND soundcloud_id = ?", userUUID, soundcloudID).First(account); err != nil {
+		account = &models.SoundcloudAccount{
+			ID:           uuid.Must(uuid.NewV4()),
+			UserID:       userUUID,
+			SoundcloudID: soundcloudID,
+			Username:     username,
+			AccessToken:  sealed,
+		}
+		if err := as.DB.Create(account); err != nil {
+			return nil, err
+		}
+		account.AccessToken = accessToken
+		return account, nil
+	}
+
+	if username != "" {
+		account.Username = username
+	}
+	account.AccessToken = sealed
+	account.NeedsReauth = false
+	if err := as.DB.Update(account); err != nil {
+		return nil, err
+	}
+	account.AccessToken = accessToken
+	return account, nil
+}
+
+// GetLink returns the Soundcloud link for a user
+func (as *AccountService) GetLink(userID string) (*models.User, error) {
+	userUUID, err := uuid.FromString(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	link := &models.User{}
+	if err := as.DB.Find(link, userUUID); err != nil {
+		return nil, err
+	}
+	if link.AccessToken, err = openToken(link.AccessToken); err != nil {
+		return nil, err
+	}
+	return link, nil
+}
+
+// Accounts returns the user's linked Soundcloud accounts in the order
+// they were connected
+func (as *AccountService) Accounts(userID string) (models.SoundcloudAccounts, error) {
+	accounts := models.SoundcloudAccounts{}
+	if err := as.DB.Where("user_id = ?", userID).Order("created_at asc").All(&accounts); err != nil {
+		return nil, err
+	}
+	for i := range accounts {
+		if err := openAccountToken(&accounts[i]); err != nil {
+			return nil, err
+		}
+	}
+	return accounts, nil
+}
+
+// Account returns one of the user's linked Soundcloud accounts
+func (as *AccountService) Account(userID, accountID string) (*models.SoundcloudAccount, error) {
+	accountUUID, err := uuid.FromString(accountID)
+	if err != nil {
+		return nil, ErrAccountNotFound
+	}
+	account := &models.SoundcloudAccount{}
+	if err := as.DB.Where("id = ? AND user_id = ?", accountUUID, userID).First(account); err != nil {
+		return nil, ErrAccountNotFound
+	}
+	if err := openAccountToken(account); err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+// Token returns a working token for calls that are not tied to one
+// account, such as streaming or pushing playlists: the first connected
+// account's, skipping accounts that need to be connected again
+func (as *AccountService) Token(userID string) (string, error) {
+	accounts, err := as.Accounts(userID)
+	if err != nil {
+		return "", err
+	}
+	for _, account := range accounts {
+		if !account.NeedsReauth && account.AccessToken != "" {
+			return account.AccessToken, nil
+		}
+	}
+	return "", ErrNoUsableAccount
+}
+
+// RecordSync notes a successful sync of an account
+func (as *AccountService) RecordSync(account *models.SoundcloudAccount) error {
+	return as.DB.RawQuery("UPDATE soundcloud_accounts SET last_synced_at = ?, last_error = NULL, updated_at = ? WHERE id = ?",
+		time.Now(), time.Now(), account.ID).Exec()
+}
+
+// RecordFailure notes why an account's sync failed. A rejected token flags
+// the account so the user is asked to connect it again.
+func (as *AccountService) RecordFailure(account *models.SoundcloudAccount, syncErr error) error {
+	account.NeedsReauth = account.NeedsReauth || errors.Is(syncErr, ErrUnauthorized)
+	return as.DB.RawQuery("UPDATE soundcloud_accounts SET needs_reauth = ?, last_error = ?, updated_at = ? WHERE id = ?",
+		account.NeedsReauth, syncErr.Error(), time.Now(), account.ID).Exec()
+}
+
+// RotateTokens seals every stored token under the primary key, encrypting
+// tokens stored in plain text and re-encrypting those sealed under older
+// keys, and returns how many were rewritten. Every key the tokens are
+// sealed under has to still be configured.
+func (as *AccountService) RotateTokens() (int, error) {
+	if tokenCipher == nil {
+		return 0, ErrTokenCipherMissing
+	}
+	rotated := 0
+	for _, table := range []string{"soundcloud_users", "soundcloud_accounts"} {
+		var rows []struct {
+			ID          uuid.UUID `db:"id"`
+			AccessToken string    `db:"access_token"`
+		}
+		if err := as.DB.RawQuery("SELECT id, access_token FROM " + table).All(&rows); err != nil {
+			return rotated, err
+		}
+		for _, row := range rows {
+			if !tokenCipher.NeedsRotation(row.AccessToken) {
+				continue
+			}
+			token, err := tokenCipher.Decrypt(row.AccessToken)
+			if err != nil {
+				return rotated, fmt.Errorf("%s %s: %w", table, row.ID, err)
+			}
+			sealed, err := tokenCipher.Encrypt(token)
+			if err != nil {
+				return rotated, err
+			}
+			if err := as.DB.RawQuery("UPDATE "+table+" SET access_token = ? WHERE id = ?", sealed, row.ID).Exec(); err != nil {
+				return rotated, err
+			}
+			rotated++
+		}
+	}
+	return rotated, nil
+}
+
+// openAccountToken decrypts an account's token after it is loaded
+func openAccountToken(account *models.SoundcloudAccount) error {
+	token, err := openToken(account.AccessToken)
+	if err != nil {
+		return fmt.Errorf("soundcloud account %s: %w", account.ID, err)
+	}
+	account.AccessToken = token
+	return nil
+}
@@ -0,0 +1,90 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func useCassette(t *testing.T, mode, dir string) {
+	original := soundcloudClient.Transport
+	if err := UseSoundcloudCassette(mode, dir); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	t.Cleanup(func() { soundcloudClient.Transport = original })
+}
+
+func TestCassetteRecordsAndReplays(t *testing.T) {
+	dir := t.TempDir()
+	withSoundcloudAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/oauth2/token":
+			w.Write([]byte(`{"access_token": "live-token-123", "refresh_token": "live-refresh-456"}`))
+		case "/me":
+			w.Write([]byte(`{"id": 42, "username": "burial"}`))
+		case "/me/likes/tracks":
+			w.Write([]byte(`{"collection": [{"id": 1, "title": "Archangel"}]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+
+	sc := NewSoundcloudService("client-id", "client-secret-789", "http://localhost:3000/auth/callback")
+	useCassette(t, CassetteRecord, dir)
+	if _, err := sc.HandleCallback(context.Background(), "auth-code"); err != nil {
+		t.Fatalf("Unexpected error recording the login: %v", err)
+	}
+	if _, err := sc.FetchLikes(context.Background(), "live-token-123"); err != nil {
+		t.Fatalf("Unexpected error recording likes: %v", err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	if len(files) != 3 {
+		t.Fatalf("Expected a recording per request, got %d", len(files))
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, secret := range []string{"live-token-123", "live-refresh-456", "client-secret-789", "auth-code"} {
+			if strings.Contains(string(data), secret) {
+				t.Errorf("Expected %s to be redacted from %s", secret, filepath.Base(file))
+			}
+		}
+	}
+
+	// Replay offline, with whatever token the app holds now
+	soundcloudAPIURL = "http://127.0.0.1:1"
+	useCassette(t, CassetteReplay, dir)
+	result, err := sc.HandleCallback(context.Background(), "another-code")
+	if err != nil {
+		t.Fatalf("Unexpected error replaying the login: %v", err)
+	}
+	userInfo := result.(map[string]interface{})["user_info"].(map[string]interface{})
+	if userInfo["username"] != "burial" {
+		t.Errorf("Expected the recorded user, got %v", userInfo["username"])
+	}
+	likes, err := sc.FetchLikes(context.Background(), "other-token")
+	if err != nil {
+		t.Fatalf("Unexpected error replaying likes: %v", err)
+	}
+	if len(likes) != 1 {
+		t.Errorf("Expected the recorded like, got %d", len(likes))
+	}
+
+	if _, err := sc.FetchFollowings(context.Background(), "other-token"); !errors.Is(err, ErrNotRecorded) {
+		t.Errorf("Expected ErrNotRecorded for a call that was never recorded, got %v", err)
+	}
+}
+
+func TestCassetteModeMustBeKnown(t *testing.T) {
+	if _, err := NewCassette("rewind", t.TempDir()); err == nil {
+		t.Error("Expected an unknown mode to be rejected")
+	}
+}
@@ -0,0 +1,246 @@
+package services
+
+import (
+	"fmt"
+	"html"
+	"html/template"
+	"math"
+	"strings"
+)
+
+// ChartPoint is a labelled value on a bar chart
+type ChartPoint struct {
+	Label string
+	Value float64
+}
+
+// ChartSeries is one line on a line chart, with a value per label
+type ChartSeries struct {
+	Name   string
+	Values []float64
+}
+
+// Chart dimensions, in SVG user units. Charts scale to their container.
+const (
+	chartWidth  = 640
+	chartHeight = 220
+	chartLeft   = 44
+	chartRight  = 8
+	chartTop    = 10
+	chartBottom = 28
+	// chartMaxLabels is how many x-axis labels fit before some are skipped
+	chartMaxLabels = 9
+)
+
+// chartPalette colours line chart series, in order
+var chartPalette = []string{"#1095c1", "#d97706", "#16a34a", "#db2777", "#7c3aed", "#64748b"}
+
+// BarChart draws points as a vertical bar chart in SVG. Each bar's value
+// and unit show on hover.
+func BarChart(points []ChartPoint, unit string) template.HTML {
+	var b strings.Builder
+	top := niceCeil(maxPoint(points))
+	openChart(&b, chartHeight, fmt.Sprintf("Bar chart of %d values", len(points)))
+	drawAxis(&b, top, len(points) == 0 || top == 0)
+
+	plotWidth := float64(chartWidth - chartLeft - chartRight)
+	plotHeight := float64(chartHeight - chartTop - chartBottom)
+	slot := plotWidth / math.Max(float64(len(points)), 1)
+	every := labelStep(len(points))
+	for i, point := range points {
+		x := float64(chartLeft) + float64(i)*slot
+		height := 0.0
+		if top > 0 {
+			height = point.Value / top * plotHeight
+		}
+		fmt.Fprintf(&b, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="%s"><title>%s: %s %s</title></rect>`,
+			x+slot*0.1, float64(chartTop)+plotHeight-height, slot*0.8, height, chartPalette[0],
+			html.EscapeString(point.Label), formatChartValue(point.Value), html.EscapeString(unit))
+		if i%every == 0 {
+			fmt.Fprintf(&b, `<text x="%.1f" y="%d" text-anchor="middle" font-size="11" fill="currentColor">%s</text>`,
+				x+slot/2, chartHeight-chartBottom+16, html.EscapeString(point.Label))
+		}
+	}
+	b.WriteString(`</svg>`)
+	return template.HTML(b.String())
+}
+
+// HorizontalBarChart draws points as labelled horizontal bars in SVG, for
+// rankings whose labels are too long to fit under vertical bars
+func HorizontalBarChart(points []ChartPoint, unit string) template.HTML {
+	const rowHeight, labelWidth, valueWidth = 24, 180, 48
+	height := chartTop*2 + rowHeight*len(points)
+	if len(points) == 0 {
+		height = 60
+	}
+
+	var b strings.Builder
+	openChart(&b, height, fmt.Sprintf("Ranking of %d values", len(points)))
+	if len(points) == 0 {
+		noData(&b, height)
+	}
+	top := maxPoint(points)
+	barSpace := float64(chartWidth - labelWidth - valueWidth)
+	for i, point := range points {
+		y := chartTop + i*rowHeight
+		width := 0.0
+		if top > 0 {
+			width = point.Value / top * barSpace
+		}
+		fmt.Fprintf(&b, `<text x="%d" y="%d" text-anchor="end" font-size="12" fill="currentColor">%s</text>`,
+			labelWidth-8, y+16, html.EscapeString(truncateLabel(point.Label, 26)))
+		fmt.Fprintf(&b, `<rect x="%d" y="%d" width="%.1f" height="%d" fill="%s"><title>%s: %s %s</title></rect>`,
+			labelWidth, y+4, width, rowHeight-8, chartPalette[0],
+			html.EscapeString(point.Label), formatChartValue(point.Value), html.EscapeString(unit))
+		fmt.Fprintf(&b, `<text x="%.1f" y="%d" font-size="12" fill="currentColor">%s</text>`,
+			float64(labelWidth)+width+6, y+16, formatChartValue(point.Value))
+	}
+	b.WriteString(`</svg>`)
+	return template.HTML(b.String())
+}
+
+// LineChart draws each series as a line over the labels in SVG, with a
+// legend naming the series by colour
+func LineChart(labels []string, series []ChartSeries) template.HTML {
+	const legendRow = 20
+	top := 0.0
+	for _, s := range series {
+		top = math.Max(top, maxValue(s.Values))
+	}
+	top = niceCeil(top)
+	height := chartHeight + legendRow*((len(series)+2)/3)
+
+	var b strings.Builder
+	openChart(&b, height, fmt.Sprintf("Line chart of %d series", len(series)))
+	drawAxis(&b, top, len(series) == 0 || top == 0)
+
+	plotWidth := float64(chartWidth - chartLeft - chartRight)
+	plotHeight := float64(chartHeight - chartTop - chartBottom)
+	step := plotWidth / math.Max(float64(len(labels)-1), 1)
+	x := func(i int) float64 {
+		if len(labels) == 1 {
+			return float64(chartLeft) + plotWidth/2
+		}
+		return float64(chartLeft) + float64(i)*step
+	}
+	every := labelStep(len(labels))
+	for i, label := range labels {
+		if i%every == 0 {
+			fmt.Fprintf(&b, `<text x="%.1f" y="%d" text-anchor="middle" font-size="11" fill="currentColor">%s</text>`,
+				x(i), chartHeight-chartBottom+16, html.EscapeString(label))
+		}
+	}
+
+	for n, s := range series {
+		color := chartPalette[n%len(chartPalette)]
+		var coords []string
+		for i, v := range s.Values {
+			if i >= len(labels) {
+				break
+			}
+			y := float64(chartTop) + plotHeight
+			if top > 0 {
+				y -= v / top * plotHeight
+			}
+			coords = append(coords, fmt.Sprintf("%.1f,%.1f", x(i), y))
+			fmt.Fprintf(&b, `<circle cx="%.1f" cy="%.1f" r="3" fill="%s"><title>%s, %s: %s</title></circle>`,
+				x(i), y, color, html.EscapeString(s.Name), html.EscapeString(labels[i]), formatChartValue(v))
+		}
+		fmt.Fprintf(&b, `<polyline points="%s" fill="none" stroke="%s" stroke-width="2"/>`, strings.Join(coords, " "), color)
+
+		legendX := chartLeft + (n%3)*((chartWidth-chartLeft)/3)
+		legendY := chartHeight + (n/3)*legendRow + 4
+		fmt.Fprintf(&b, `<rect x="%d" y="%d" width="12" height="12" fill="%s"/>`, legendX, legendY, color)
+		fmt.Fprintf(&b, `<text x="%d" y="%d" font-size="12" fill="currentColor">%s</text>`,
+			legendX+18, legendY+11, html.EscapeString(truncateLabel(s.Name, 24)))
+	}
+	b.WriteString(`</svg>`)
+	return template.HTML(b.String())
+}
+
+// openChart starts an SVG that scales to the width of its container
+func openChart(b *strings.Builder, height int, label string) {
+	fmt.Fprintf(b, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" width="100%%" role="img" aria-label="%s" class="chart">`,
+		chartWidth, height, html.EscapeString(label))
+}
+
+// drawAxis draws the baseline and value gridlines up to top
+func drawAxis(b *strings.Builder, top float64, empty bool) {
+	plotHeight := float64(chartHeight - chartTop - chartBottom)
+	for _, fraction := range []float64{0, 0.5, 1} {
+		y := float64(chartTop) + plotHeight*(1-fraction)
+		opacity := 0.15
+		if fraction == 0 {
+			opacity = 0.5
+		}
+		fmt.Fprintf(b, `<line x1="%d" y1="%.1f" x2="%d" y2="%.1f" stroke="currentColor" stroke-opacity="%.2f"/>`,
+			chartLeft, y, chartWidth-chartRight, y, opacity)
+		fmt.Fprintf(b, `<text x="%d" y="%.1f" text-anchor="end" font-size="11" fill="currentColor">%s</text>`,
+			chartLeft-6, y+4, formatChartValue(top*fraction))
+	}
+	if empty {
+		noData(b, chartHeight)
+	}
+}
+
+// noData marks a chart with nothing to show
+func noData(b *strings.Builder, height int) {
+	fmt.Fprintf(b, `<text x="%d" y="%d" text-anchor="middle" font-size="13" fill="currentColor" fill-opacity="0.6">No data yet</text>`,
+		chartWidth/2, height/2)
+}
+
+// niceCeil rounds a chart's top value up to 1, 2 or 5 times a power of ten
+func niceCeil(v float64) float64 {
+	if v <= 0 {
+		return 0
+	}
+	magnitude := math.Pow(10, math.Floor(math.Log10(v)))
+	for _, step := range []float64{1, 2, 5, 10} {
+		if v <= step*magnitude {
+			return step * magnitude
+		}
+	}
+	return 10 * magnitude
+}
+
+// labelStep is how often to label the x axis so labels do not overlap
+func labelStep(n int) int {
+	if n <= chartMaxLabels {
+		return 1
+	}
+	return int(math.Ceil(float64(n) / chartMaxLabels))
+}
+
+// formatChartValue formats whole values without decimals and others to one
+// decimal place
+func formatChartValue(v float64) string {
+	if v == math.Trunc(v) {
+		return fmt.Sprintf("%.0f", v)
+	}
+	return fmt.Sprintf("%.1f", v)
+}
+
+// truncateLabel shortens a label to n characters
+func truncateLabel(label string, n int) string {
+	runes := []rune(label)
+	if len(runes) <= n {
+		return label
+	}
+	return string(runes[:n-1]) + "…"
+}
+
+func maxPoint(points []ChartPoint) float64 {
+	top := 0.0
+	for _, p := range points {
+		top = math.Max(top, p.Value)
+	}
+	return top
+}
+
+func maxValue(values []float64) float64 {
+	top := 0.0
+	for _, v := range values {
+		top = math.Max(top, v)
+	}
+	return top
+}
@@ -0,0 +1,100 @@
+package services
+
+import (
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// wellFormed reports whether a chart parses as XML
+func wellFormed(t *testing.T, svg string) {
+	t.Helper()
+	decoder := xml.NewDecoder(strings.NewReader(svg))
+	for {
+		_, err := decoder.Token()
+		if err != nil {
+			if err != io.EOF {
+				t.Fatalf("Expected well-formed SVG, got %v in %s", err, svg)
+			}
+			return
+		}
+	}
+}
+
+func TestBarChart(t *testing.T) {
+	svg := string(BarChart([]ChartPoint{{Label: "Jan 5", Value: 3}, {Label: "Jan 12", Value: 7}, {Label: "<b>", Value: 0}}, "tracks"))
+	wellFormed(t, svg)
+	if strings.Count(svg, "<rect") != 3 {
+		t.Errorf("Expected a bar per point, got %s", svg)
+	}
+	if !strings.Contains(svg, "Jan 12: 7 tracks") {
+		t.Error("Expected bars to show their value on hover")
+	}
+	if strings.Contains(svg, "<b>") {
+		t.Error("Expected labels to be escaped")
+	}
+	// The tallest bar fills the plot up to the rounded-up top of 10
+	if !strings.Contains(svg, `>10</text>`) {
+		t.Errorf("Expected the axis to top out at 10, got %s", svg)
+	}
+
+	empty := string(BarChart(nil, "tracks"))
+	wellFormed(t, empty)
+	if !strings.Contains(empty, "No data yet") {
+		t.Error("Expected an empty chart to say so")
+	}
+}
+
+func TestLineAndHorizontalBarCharts(t *testing.T) {
+	svg := string(LineChart([]string{"Jan 26", "Feb 26"}, []ChartSeries{
+		{Name: "house", Values: []float64{4, 9}},
+		{Name: "drum & bass", Values: []float64{2, 1}},
+	}))
+	wellFormed(t, svg)
+	if strings.Count(svg, "<polyline") != 2 || !strings.Contains(svg, "drum &amp; bass") {
+		t.Errorf("Expected a labelled line per series, got %s", svg)
+	}
+
+	svg = string(HorizontalBarChart([]ChartPoint{{Label: "A very long artist name that will not fit", Value: 12}}, "tracks"))
+	wellFormed(t, svg)
+	if !strings.Contains(svg, "…") || !strings.Contains(svg, "A very long artist name that will not fit: 12 tracks") {
+		t.Errorf("Expected long labels to be shortened with the full label on hover, got %s", svg)
+	}
+}
+
+func TestNiceCeil(t *testing.T) {
+	for v, want := range map[float64]float64{0: 0, 0.3: 0.5, 1: 1, 7: 10, 12: 20, 180: 200, 4100: 5000} {
+		if got := niceCeil(v); got != want {
+			t.Errorf("niceCeil(%v) = %v, want %v", v, got, want)
+		}
+	}
+}
+
+func TestStatsPeriodAndTrends(t *testing.T) {
+	// A Wednesday
+	now := time.Date(2026, 3, 4, 15, 0, 0, 0, time.UTC)
+	stats := newStats(now, 12)
+	if len(stats.weekStarts) != 12 || stats.weekStarts[11] != time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC) {
+		t.Fatalf("Expected 12 Monday-starting weeks ending this week, got %v", stats.weekStarts)
+	}
+	if stats.Since.Weekday() != time.Monday {
+		t.Errorf("Expected the period to start on a Monday, got %v", stats.Since)
+	}
+	if stats.Months[0] != "Dec 25" || stats.Months[len(stats.Months)-1] != "Mar 26" {
+		t.Errorf("Expected months from December to March, got %v", stats.Months)
+	}
+
+	trends := trendSeries([]termCount{
+		{Term: "techno", Month: stats.monthStarts[0], Tracks: 1},
+		{Term: "house", Month: stats.monthStarts[1], Tracks: 4},
+		{Term: "techno", Month: stats.monthStarts[3], Tracks: 2},
+	}, stats.monthStarts)
+	if len(trends) != 2 || trends[0].Name != "house" {
+		t.Fatalf("Expected the most common term first, got %v", trends)
+	}
+	if got := trends[1].Values; len(got) != 4 || got[0] != 1 || got[1] != 0 || got[3] != 2 {
+		t.Errorf("Expected a value for every month, got %v", got)
+	}
+}
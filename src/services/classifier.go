@@ -0,0 +1,254 @@
+package services
+
+import (
+	"errors"
+	"math"
+	"regexp"
+	"strings"
+
+	"github.com/gobuffalo/pop/v6"
+	"github.com/gofrs/uuid"
+	"github.com/jbhicks/sound-cistern/src/models"
+)
+
+// Track kinds assigned by the classifier
+const (
+	KindMix     = "mix"
+	KindLiveSet = "live_set"
+	KindPodcast = "podcast"
+	KindSingle  = "single"
+)
+
+// ErrUnknownKind is returned when a track is given a kind that does not exist
+var ErrUnknownKind = errors.New("unknown track kind")
+
+// Kinds lists every track kind in display order
+var Kinds = []string{KindMix, KindLiveSet, KindPodcast, KindSingle}
+
+// KindLabels are the display names of track kinds
+var KindLabels = map[string]string{
+	KindMix:     "Mix",
+	KindLiveSet: "Live set",
+	KindPodcast: "Podcast episode",
+	KindSingle:  "Single track",
+}
+
+const (
+	// overrideLearningRate is how much one override moves a keyword's weight
+	overrideLearningRate = 0.5
+	// maxLearnedWeight caps how far overrides can push a single keyword
+	maxLearnedWeight = 3.0
+)
+
+// kindSignal adds weight to kinds when a pattern appears in a track's
+// title or tags
+type kindSignal struct {
+	pattern *regexp.Regexp
+	weights map[string]float64
+}
+
+var kindSignals = []kindSignal{
+	{regexp.MustCompile(`\b(original|extended|radio|club) mix\b|\bremix\b|\bedit\b|\bbootleg\b|\bpremiere\b`), map[string]float64{KindSingle: 2.5, KindMix: -2}},
+	{regexp.MustCompile(`\bmix(tape|ed by)?\b|\bdj ?set\b|\bselections?\b|\bpodcast mix\b`), map[string]float64{KindMix: 2}},
+	{regexp.MustCompile(`\bb2b\b`), map[string]float64{KindMix: 0.5, KindLiveSet: 2}},
+	{regexp.MustCompile(`\blive (at|from|@|in)\b|\brecorded live\b|\bset at\b|\bboiler room\b|\bfestival\b`), map[string]float64{KindLiveSet: 2.5}},
+	{regexp.MustCompile(`\bepisode\b|\bep\.? ?\d+|\bpodcast\b|\bradio show\b|\bshow\b|#\d+`), map[string]float64{KindPodcast: 2}},
+}
+
+// wordPattern splits text into keywords for learned weights
+var wordPattern = regexp.MustCompile(`[a-z0-9]+`)
+
+// KeywordWeights are per-user keyword weights learned from overrides,
+// keyed by keyword and then kind
+type KeywordWeights map[string]map[string]float64
+
+// Classification is the classifier's verdict on a track
+type Classification struct {
+	Kind       string
+	Confidence float64
+}
+
+// ClassifyTrack scores a track as each kind from its length, title and tag
+// keywords and whether its description holds a tracklist, then adds any
+// weights the user has taught it. Confidence is the winning kind's share
+// of the softmax over all scores.
+func ClassifyTrack(track models.Track, learned KeywordWeights) Classification {
+	scores := map[string]float64{}
+
+	switch length := track.Length; {
+	case length <= 0:
+	case length < 10*60:
+		scores[KindSingle] += 2
+	case length < 20*60:
+		scores[KindSingle] += 0.5
+		scores[KindMix] += 0.5
+	default:
+		scores[KindMix] += 1.5
+		scores[KindLiveSet] += 1
+		scores[KindPodcast] += 1
+		if length >= 45*60 {
+			scores[KindMix] += 0.5
+		}
+	}
+
+	text := classifierText(track)
+	for _, signal := range kindSignals {
+		if signal.pattern.MatchString(text) {
+			for kind, weight := range signal.weights {
+				scores[kind] += weight
+			}
+		}
+	}
+
+	if len(ParseTracklist(track.Description.String)) > 0 {
+		scores[KindMix] += 2
+		scores[KindLiveSet] += 0.5
+		scores[KindPodcast] += 0.5
+	}
+
+	for _, word := range classifierWords(text) {
+		for kind, weight := range learned[word] {
+			scores[kind] += weight
+		}
+	}
+
+	best := KindSingle
+	var total float64
+	for _, kind := range Kinds {
+		total += math.Exp(scores[kind])
+		if scores[kind] > scores[best] {
+			best = kind
+		}
+	}
+	confidence := math.Exp(scores[best]) / total
+	return Classification{Kind: best, Confidence: math.Round(confidence*100) / 100}
+}
+
+// classifierText is the lowercased text the classifier looks for keywords in
+func classifierText(track models.Track) string {
+	return strings.ToLower(track.Title + " " + track.TagList.String)
+}
+
+// classifierWords returns the distinct keywords in text that overrides
+// can teach weights for
+func classifierWords(text string) []string {
+	seen := map[string]bool{}
+	var words []string
+	for _, word := range wordPattern.FindAllString(text, -1) {
+		if len(word) < 3 || seen[word] {
+			continue
+		}
+		seen[word] = true
+		words = append(words, word)
+	}
+	return words
+}
+
+// ClassifierService stores track kinds and learns from user overrides
+type ClassifierService struct {
+	DB *pop.Connection
+}
+
+// NewClassifierService creates a new service
+func NewClassifierService(db *pop.Connection) *ClassifierService {
+	return &ClassifierService{DB: db}
+}
+
+// Weights loads the keyword weights a user has taught the classifier
+func (cs *ClassifierService) Weights(userID uuid.UUID) (KeywordWeights, error) {
+	keywords := models.KindKeywords{}
+	if err := cs.DB.Where("user_id = ?", userID).All(&keywords); err != nil {
+		return nil, err
+	}
+	weights := KeywordWeights{}
+	for _, k := range keywords {
+		if weights[k.Keyword] == nil {
+			weights[k.Keyword] = map[string]float64{}
+		}
+		weights[k.Keyword][k.Kind] = k.Weight
+	}
+	return weights, nil
+}
+
+// Override sets a track's kind by hand. The track's keywords are nudged
+// towards the chosen kind, and away from the kind it was given, so
+// similar tracks are classified the same way.
+func (cs *ClassifierService) Override(userID, soundcloudID, kind string) (*models.Track, error) {
+	if _, ok := KindLabels[kind]; !ok {
+		return nil, ErrUnknownKind
+	}
+	track, err := NewFeedService(cs.DB).Track(cs.DB.Context(), userID, soundcloudID)
+	if err != nil {
+		return nil, err
+	}
+
+	previous := track.Kind
+	for _, word := range classifierWords(classifierText(*track)) {
+		if err := cs.learn(track.UserID, word, kind, overrideLearningRate); err != nil {
+			return nil, err
+		}
+		if previous != "" && previous != kind {
+			if err := cs.learn(track.UserID, word, previous, -overrideLearningRate); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	track.Kind = kind
+	track.KindConfidence = 1
+	track.KindOverridden = true
+	return track, cs.DB.Update(track)
+}
+
+// Reclassify classifies every track of a user that has not been
+// overridden, using what the user has taught the classifier so far
+func (cs *ClassifierService) Reclassify(userID string) (int, error) {
+	userUUID, err := uuid.FromString(userID)
+	if err != nil {
+		return 0, err
+	}
+	weights, err := cs.Weights(userUUID)
+	if err != nil {
+		return 0, err
+	}
+
+	tracks := models.Tracks{}
+	if err := cs.DB.Where("user_id = ? AND kind_overridden = ?", userUUID, false).All(&tracks); err != nil {
+		return 0, err
+	}
+
+	changed := 0
+	for i := range tracks {
+		result := ClassifyTrack(tracks[i], weights)
+		if result.Kind == tracks[i].Kind && result.Confidence == tracks[i].KindConfidence {
+			continue
+		}
+		tracks[i].Kind = result.Kind
+		tracks[i].KindConfidence = result.Confidence
+		if err := cs.DB.Update(&tracks[i]); err != nil {
+			return changed, err
+		}
+		changed++
+	}
+	return changed, nil
+}
+
+// learn adjusts one learned keyword weight, keeping it within
+// ±maxLearnedWeight
+func (cs *ClassifierService) learn(userID uuid.UUID, keyword, kind string, delta float64) error {
+	k := &models.KindKeyword{}
+	err := cs.DB.Where("user_id = ? AND keyword = ? AND kind = ?", userID, keyword, kind).First(k)
+	if err != nil {
+		k = &models.KindKeyword{
+			ID:      uuid.Must(uuid.NewV4()),
+			UserID:  userID,
+			Keyword: keyword,
+			Kind:    kind,
+		}
+	}
+	k.Weight = math.Max(-maxLearnedWeight, math.Min(maxLearnedWeight, k.Weight+delta))
+	if err != nil {
+		return cs.DB.Create(k)
+	}
+	return cs.DB.Update(k)
+}
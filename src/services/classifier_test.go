@@ -0,0 +1,59 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/gobuffalo/nulls"
+	"github.com/jbhicks/sound-cistern/src/models"
+)
+
+func TestClassifyTrack(t *testing.T) {
+	tests := []struct {
+		name  string
+		track models.Track
+		want  string
+	}{
+		{"single", models.Track{Title: "Artist - Tune (Original Mix)", Length: 400}, KindSingle},
+		{"long remix", models.Track{Title: "Tune (Extended Remix)", Length: 720}, KindSingle},
+		{"mix", models.Track{Title: "Summer Mix 2025", Length: 3600}, KindMix},
+		{"live set", models.Track{Title: "Live at Dekmantel Festival", Length: 5400}, KindLiveSet},
+		{"b2b", models.Track{Title: "Ben UFO b2b Pearson Sound", Length: 7200, TagList: nulls.NewString("techno")}, KindLiveSet},
+		{"podcast", models.Track{Title: "Weekly Transmissions Episode 112", Length: 3600}, KindPodcast},
+		{"tracklist", models.Track{Title: "Sunday", Length: 4000, Description: nulls.NewString("00:00 A - B\n05:00 C - D")}, KindMix},
+	}
+
+	for _, tt := range tests {
+		got := ClassifyTrack(tt.track, nil)
+		if got.Kind != tt.want {
+			t.Errorf("%s: expected %s, got %s (%.2f)", tt.name, tt.want, got.Kind, got.Confidence)
+		}
+		if got.Confidence <= 0 || got.Confidence > 1 {
+			t.Errorf("%s: confidence %.2f out of range", tt.name, got.Confidence)
+		}
+	}
+}
+
+func TestClassifyTrackUsesLearnedWeights(t *testing.T) {
+	track := models.Track{Title: "Night Drive Sessions 14", Length: 3600}
+	if got := ClassifyTrack(track, nil); got.Kind == KindPodcast {
+		t.Fatalf("Expected the untrained classifier not to pick podcast")
+	}
+
+	learned := KeywordWeights{"sessions": {KindPodcast: 3}}
+	if got := ClassifyTrack(track, learned); got.Kind != KindPodcast {
+		t.Errorf("Expected learned weights to pick podcast, got %s", got.Kind)
+	}
+}
+
+func TestFilterTracksByKind(t *testing.T) {
+	fs := NewFeedService(nil)
+	tracks := []interface{}{
+		map[string]interface{}{"id": float64(1), "title": "Deep House Mix", "duration": float64(3600000)},
+		map[string]interface{}{"id": float64(2), "title": "Tune (Original Mix)", "duration": float64(360000)},
+	}
+
+	filtered := fs.FilterTracks(tracks, map[string]interface{}{"kind": KindMix})
+	if len(filtered) != 1 || filtered[0].(map[string]interface{})["id"] != float64(1) {
+		t.Errorf("Expected only the mix, got %v", filtered)
+	}
+}
@@ -0,0 +1,460 @@
+package services
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gobuffalo/nulls"
+	"github.com/gobuffalo/pop/v6"
+	"github.com/gofrs/uuid"
+	"github.com/jbhicks/sound-cistern/src/models"
+)
+
+// DataExportTTL is how long a built data export can be downloaded
+const DataExportTTL = 24 * time.Hour
+
+var (
+	// ErrDataExportNotFound is returned for an export that does not exist
+	// or belongs to someone else
+	ErrDataExportNotFound = errors.New("data export not found")
+	// ErrDownloadLinkInvalid is returned for a download link that was not
+	// signed by the app, or whose export is no longer available
+	ErrDownloadLinkInvalid = errors.New("invalid download link")
+	// ErrDownloadLinkExpired is returned for a download link past its
+	// expiry
+	ErrDownloadLinkExpired = errors.New("download link expired")
+)
+
+// ExportFile is a file the caller adds to a data export, such as the
+// profile and posts, which live outside this package
+type ExportFile struct {
+	Name string
+	Data []byte
+}
+
+// DataExportService builds archives of everything stored about a user:
+// their Soundcloud link with tokens redacted, cached feed, stored tracks
+// with labels and notes, presets and listening history, along with files
+// the caller adds
+type DataExportService struct {
+	DB *pop.Connection
+	// Dir is where built archives are kept until they expire
+	Dir string
+}
+
+// NewDataExportService creates a new service
+func NewDataExportService(db *pop.Connection, dir string) *DataExportService {
+	return &DataExportService{DB: db, Dir: dir}
+}
+
+// Request starts a data export for a user, or returns the one that is
+// still being built
+func (ds *DataExportService) Request(userID string) (*models.DataExport, error) {
+	userUUID, err := uuid.FromString(userID)
+	if err != nil {
+		return nil, err
+	}
+	export := &models.DataExport{}
+	if err := ds.DB.Where("user_id = ? AND status = ?", userUUID, models.DataExportPending).First(export); err == nil {
+		return export, nil
+	}
+	export = &models.DataExport{
+		ID:     uuid.Must(uuid.NewV4()),
+		UserID: userUUID,
+		Status: models.DataExportPending,
+	}
+	return export, ds.DB.Create(export)
+}
+
+// Latest returns the user's most recent export, or nil when they have none
+func (ds *DataExportService) Latest(userID string) (*models.DataExport, error) {
+	export := &models.DataExport{}
+	err := ds.DB.Where("user_id = ?", userID).Order("created_at desc").First(export)
+	if err != nil {
+		return nil, nil
+	}
+	return export, nil
+}
+
+// Export returns one of the user's exports
+func (ds *DataExportService) Export(userID, exportID string) (*models.DataExport, error) {
+	exportUUID, err := uuid.FromString(exportID)
+	if err != nil {
+		return nil, ErrDataExportNotFound
+	}
+	export := &models.DataExport{}
+	if err := ds.DB.Where("id = ? AND user_id = ?", exportUUID, userID).First(export); err != nil {
+		return nil, ErrDataExportNotFound
+	}
+	return export, nil
+}
+
+// Download returns the export a signed download link points at and the
+// path of its archive, checking the signature and expiry
+func (ds *DataExportService) Download(secret []byte, exportID, expires, signature string) (*models.DataExport, string, error) {
+	if err := VerifyDownloadLink(secret, exportID, expires, signature); err != nil {
+		return nil, "", err
+	}
+	export := &models.DataExport{}
+	if err := ds.DB.Find(export, exportID); err != nil || !export.Downloadable() {
+		return nil, "", ErrDownloadLinkInvalid
+	}
+	return export, ds.path(export.ID), nil
+}
+
+// Build writes the export's archive from the user's stored data and the
+// given files, marks it ready to download and removes the user's older
+// exports
+func (ds *DataExportService) Build(ctx context.Context, exportID string, files []ExportFile) (*models.DataExport, error) {
+	db := ds.DB.WithContext(ctx)
+	export := &models.DataExport{}
+	if err := db.Find(export, exportID); err != nil {
+		return nil, ErrDataExportNotFound
+	}
+	userID := export.UserID.String()
+
+	link, err := ds.soundcloudFile(db, export.UserID)
+	if err != nil {
+		return nil, err
+	}
+	feed, err := NewFeedService(ds.DB).GetCachedFeed(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	tracks, err := ds.tracksFile(db, export.UserID)
+	if err != nil {
+		return nil, err
+	}
+	labels, err := ds.labelsFile(db, export.UserID)
+	if err != nil {
+		return nil, err
+	}
+	notes, err := ds.notesFile(db, export.UserID)
+	if err != nil {
+		return nil, err
+	}
+	listens, err := ds.listensFile(db, export.UserID)
+	if err != nil {
+		return nil, err
+	}
+	presets := models.PlaylistExports{}
+	if err := db.Where("user_id = ?", export.UserID).Order("created_at asc").All(&presets); err != nil {
+		return nil, err
+	}
+
+	feedJSON, err := exportJSON(feed)
+	if err != nil {
+		return nil, err
+	}
+	presetsJSON, err := exportJSON(presets)
+	if err != nil {
+		return nil, err
+	}
+	files = append(files,
+		ExportFile{Name: "soundcloud.json", Data: link},
+		ExportFile{Name: "feed.json", Data: feedJSON},
+		ExportFile{Name: "tracks.csv", Data: tracks},
+		ExportFile{Name: "labels.json", Data: labels},
+		ExportFile{Name: "notes.json", Data: notes},
+		ExportFile{Name: "presets.json", Data: presetsJSON},
+		ExportFile{Name: "listens.csv", Data: listens},
+	)
+
+	size, err := ds.writeArchive(export.ID, files)
+	if err != nil {
+		return nil, err
+	}
+	if err := ds.removeOlder(db, export); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	export.Status = models.DataExportReady
+	export.Size = size
+	export.Error = nulls.String{}
+	export.CompletedAt = nulls.NewTime(now)
+	export.ExpiresAt = nulls.NewTime(now.Add(DataExportTTL))
+	return export, db.Update(export)
+}
+
+// RecordFailure notes why building an export failed
+func (ds *DataExportService) RecordFailure(exportID string, buildErr error) error {
+	return ds.DB.RawQuery("UPDATE data_exports SET status = ?, error = ?, updated_at = ? WHERE id = ?",
+		models.DataExportFailed, buildErr.Error(), time.Now(), exportID).Exec()
+}
+
+// path is where an export's archive is kept
+func (ds *DataExportService) path(exportID uuid.UUID) string {
+	return filepath.Join(ds.Dir, exportID.String()+".zip")
+}
+
+// writeArchive zips the files next to their final path and moves the
+// archive into place, so a half-written archive is never served
+func (ds *DataExportService) writeArchive(exportID uuid.UUID, files []ExportFile) (int64, error) {
+	if err := os.MkdirAll(ds.Dir, 0o700); err != nil {
+		return 0, err
+	}
+	tmp, err := os.CreateTemp(ds.Dir, exportID.String()+"-*.zip")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	archive := zip.NewWriter(tmp)
+	for _, file := range files {
+		w, err := archive.CreateHeader(&zip.FileHeader{Name: file.Name, Method: zip.Deflate, Modified: time.Now()})
+		if err != nil {
+			tmp.Close()
+			return 0, err
+		}
+		if _, err := w.Write(file.Data); err != nil {
+			tmp.Close()
+			return 0, err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	info, err := tmp.Stat()
+	if err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, err
+	}
+	return info.Size(), os.Rename(tmp.Name(), ds.path(exportID))
+}
+
+// removeOlder deletes the user's earlier exports and their archives
+func (ds *DataExportService) removeOlder(db *pop.Connection, export *models.DataExport) error {
+	older := models.DataExports{}
+	if err := db.Where("user_id = ? AND id <> ? AND status <> ?", export.UserID, export.ID, models.DataExportPending).All(&older); err != nil {
+		return err
+	}
+	for _, e := range older {
+		if err := os.Remove(ds.path(e.ID)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		if err := db.Destroy(&e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// soundcloudFile describes the user's Soundcloud link and linked accounts.
+// Tokens are stored encrypted or not depending on configuration, and are
+// left out either way.
+func (ds *DataExportService) soundcloudFile(db *pop.Connection, userID uuid.UUID) ([]byte, error) {
+	data := map[string]interface{}{"link": nil, "accounts": []interface{}{}}
+	link := &models.User{}
+	if err := db.Find(link, userID); err == nil {
+		data["link"] = map[string]interface{}{
+			"soundcloud_id": link.SoundcloudID,
+			"access_token":  redacted,
+			"created_at":    link.CreatedAt,
+			"updated_at":    link.UpdatedAt,
+		}
+	}
+	accounts := models.SoundcloudAccounts{}
+	if err := db.Where("user_id = ?", userID).Order("created_at asc").All(&accounts); err != nil {
+		return nil, err
+	}
+	var linked []interface{}
+	for _, account := range accounts {
+		linked = append(linked, map[string]interface{}{
+			"id":             account.ID,
+			"soundcloud_id":  account.SoundcloudID,
+			"username":       account.Username,
+			"access_token":   redacted,
+			"needs_reauth":   account.NeedsReauth,
+			"last_synced_at": account.LastSyncedAt,
+			"last_error":     account.LastError,
+			"created_at":     account.CreatedAt,
+		})
+	}
+	if linked != nil {
+		data["accounts"] = linked
+	}
+	return exportJSON(data)
+}
+
+// tracksFile lists the user's stored tracks with their labels and note
+func (ds *DataExportService) tracksFile(db *pop.Connection, userID uuid.UUID) ([]byte, error) {
+	var rows []struct {
+		SoundcloudID string       `db:"soundcloud_id"`
+		Title        string       `db:"title"`
+		Artist       nulls.String `db:"artist"`
+		Genre        nulls.String `db:"genre"`
+		Kind         string       `db:"kind"`
+		Length       int          `db:"length"`
+		PermalinkURL nulls.String `db:"permalink_url"`
+		PostTime     time.Time    `db:"post_time"`
+		Hidden       bool         `db:"hidden"`
+		Pinned       bool         `db:"pinned"`
+		Labels       nulls.String `db:"labels"`
+		Note         nulls.String `db:"note"`
+	}
+	err := db.RawQuery(`SELECT t.soundcloud_id, t.title, t.artist, t.genre, t.kind, t.length, t.permalink_url, t.post_time, t.hidden, t.pinned,
+			(SELECT string_agg(labels.name, ';' ORDER BY lower(labels.name)) FROM track_labels JOIN labels ON labels.id = track_labels.label_id WHERE track_labels.track_id = t.id) AS labels,
+			(SELECT body FROM track_notes WHERE track_notes.track_id = t.id) AS note
+		FROM soundcloud_tracks t WHERE t.user_id = ? ORDER BY t.post_time desc`, userID).All(&rows)
+	if err != nil {
+		return nil, err
+	}
+
+	records := [][]string{{"soundcloud_id", "title", "artist", "genre", "kind", "length_seconds", "permalink_url", "posted_at", "hidden", "pinned", "labels", "note"}}
+	for _, row := range rows {
+		records = append(records, []string{
+			row.SoundcloudID, row.Title, row.Artist.String, row.Genre.String, row.Kind, strconv.Itoa(row.Length),
+			row.PermalinkURL.String, row.PostTime.UTC().Format(time.RFC3339), strconv.FormatBool(row.Hidden),
+			strconv.FormatBool(row.Pinned), row.Labels.String, row.Note.String,
+		})
+	}
+	return exportCSV(records)
+}
+
+// labelsFile lists the user's labels with the tracks each is on
+func (ds *DataExportService) labelsFile(db *pop.Connection, userID uuid.UUID) ([]byte, error) {
+	labels := models.Labels{}
+	if err := db.Where("user_id = ?", userID).Order("lower(name) asc").All(&labels); err != nil {
+		return nil, err
+	}
+	var rows []struct {
+		LabelID      uuid.UUID `db:"label_id"`
+		SoundcloudID string    `db:"soundcloud_id"`
+	}
+	err := db.RawQuery(`SELECT track_labels.label_id, soundcloud_tracks.soundcloud_id FROM track_labels
+		JOIN labels ON labels.id = track_labels.label_id
+		JOIN soundcloud_tracks ON soundcloud_tracks.id = track_labels.track_id
+		WHERE labels.user_id = ? ORDER BY track_labels.created_at asc`, userID).All(&rows)
+	if err != nil {
+		return nil, err
+	}
+	tracks := map[uuid.UUID][]string{}
+	for _, row := range rows {
+		tracks[row.LabelID] = append(tracks[row.LabelID], row.SoundcloudID)
+	}
+	data := []interface{}{}
+	for _, label := range labels {
+		data = append(data, map[string]interface{}{
+			"name":       label.Name,
+			"color":      label.Color,
+			"created_at": label.CreatedAt,
+			"tracks":     append([]string{}, tracks[label.ID]...),
+		})
+	}
+	return exportJSON(data)
+}
+
+// notesFile lists the user's notes on tracks
+func (ds *DataExportService) notesFile(db *pop.Connection, userID uuid.UUID) ([]byte, error) {
+	var rows []struct {
+		SoundcloudID string    `db:"soundcloud_id" json:"soundcloud_id"`
+		Title        string    `db:"title" json:"title"`
+		Body         string    `db:"body" json:"body"`
+		UpdatedAt    time.Time `db:"updated_at" json:"updated_at"`
+	}
+	err := db.RawQuery(`SELECT soundcloud_tracks.soundcloud_id, soundcloud_tracks.title, track_notes.body, track_notes.updated_at FROM track_notes
+		JOIN soundcloud_tracks ON soundcloud_tracks.id = track_notes.track_id
+		WHERE soundcloud_tracks.user_id = ? ORDER BY track_notes.updated_at desc`, userID).All(&rows)
+	if err != nil {
+		return nil, err
+	}
+	if rows == nil {
+		return exportJSON([]interface{}{})
+	}
+	return exportJSON(rows)
+}
+
+// listensFile lists where the user got to in each track they played
+func (ds *DataExportService) listensFile(db *pop.Connection, userID uuid.UUID) ([]byte, error) {
+	var rows []struct {
+		SoundcloudID string     `db:"soundcloud_id"`
+		Title        string     `db:"title"`
+		Position     int        `db:"position"`
+		Heard        bool       `db:"heard"`
+		HeardAt      nulls.Time `db:"heard_at"`
+		UpdatedAt    time.Time  `db:"updated_at"`
+	}
+	err := db.RawQuery(`SELECT soundcloud_tracks.soundcloud_id, soundcloud_tracks.title, listens.position, listens.heard, listens.heard_at, listens.updated_at FROM listens
+		JOIN soundcloud_tracks ON soundcloud_tracks.id = listens.track_id
+		WHERE listens.user_id = ? ORDER BY listens.updated_at desc`, userID).All(&rows)
+	if err != nil {
+		return nil, err
+	}
+	records := [][]string{{"soundcloud_id", "title", "position_seconds", "heard", "heard_at", "last_played_at"}}
+	for _, row := range rows {
+		heardAt := ""
+		if row.HeardAt.Valid {
+			heardAt = row.HeardAt.Time.UTC().Format(time.RFC3339)
+		}
+		records = append(records, []string{
+			row.SoundcloudID, row.Title, strconv.Itoa(row.Position), strconv.FormatBool(row.Heard),
+			heardAt, row.UpdatedAt.UTC().Format(time.RFC3339),
+		})
+	}
+	return exportCSV(records)
+}
+
+// SignDownloadLink signs an export's download link until it expires. The
+// signature covers the export id and expiry.
+func SignDownloadLink(secret []byte, exportID string, expires time.Time) (string, string) {
+	expiry := strconv.FormatInt(expires.Unix(), 10)
+	return expiry, downloadSignature(secret, exportID, expiry)
+}
+
+// VerifyDownloadLink checks a download link's signature and expiry
+func VerifyDownloadLink(secret []byte, exportID, expires, signature string) error {
+	if !hmac.Equal([]byte(signature), []byte(downloadSignature(secret, exportID, expires))) {
+		return ErrDownloadLinkInvalid
+	}
+	expiry, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return ErrDownloadLinkInvalid
+	}
+	if time.Now().Unix() > expiry {
+		return ErrDownloadLinkExpired
+	}
+	return nil
+}
+
+// downloadSignature is the HMAC of an export id and expiry
+func downloadSignature(secret []byte, exportID, expires string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("data-export:" + exportID + ":" + expires))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// exportJSON encodes a file of an export
+func exportJSON(v interface{}) ([]byte, error) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// exportCSV encodes a CSV file of an export
+func exportCSV(records [][]string) ([]byte, error) {
+	var b strings.Builder
+	w := csv.NewWriter(&b)
+	if err := w.WriteAll(records); err != nil {
+		return nil, fmt.Errorf("writing csv: %w", err)
+	}
+	return []byte(b.String()), nil
+}
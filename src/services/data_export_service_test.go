@@ -0,0 +1,68 @@
+package services
+
+import (
+	"archive/zip"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+func TestDownloadLinkSignatures(t *testing.T) {
+	secret := []byte("export-secret")
+	id := uuid.Must(uuid.NewV4()).String()
+
+	expires, signature := SignDownloadLink(secret, id, time.Now().Add(time.Hour))
+	if err := VerifyDownloadLink(secret, id, expires, signature); err != nil {
+		t.Fatalf("Expected a fresh link to verify, got %v", err)
+	}
+	if err := VerifyDownloadLink([]byte("other-secret"), id, expires, signature); !errors.Is(err, ErrDownloadLinkInvalid) {
+		t.Errorf("Expected a link signed with another key to be rejected, got %v", err)
+	}
+	if err := VerifyDownloadLink(secret, uuid.Must(uuid.NewV4()).String(), expires, signature); !errors.Is(err, ErrDownloadLinkInvalid) {
+		t.Errorf("Expected a signature for another export to be rejected, got %v", err)
+	}
+	if err := VerifyDownloadLink(secret, id, expires+"0", signature); !errors.Is(err, ErrDownloadLinkInvalid) {
+		t.Errorf("Expected an extended expiry to be rejected, got %v", err)
+	}
+
+	expires, signature = SignDownloadLink(secret, id, time.Now().Add(-time.Minute))
+	if err := VerifyDownloadLink(secret, id, expires, signature); !errors.Is(err, ErrDownloadLinkExpired) {
+		t.Errorf("Expected an expired link to be rejected, got %v", err)
+	}
+}
+
+func TestWriteArchive(t *testing.T) {
+	ds := NewDataExportService(nil, t.TempDir())
+	id := uuid.Must(uuid.NewV4())
+	size, err := ds.writeArchive(id, []ExportFile{
+		{Name: "profile.json", Data: []byte(`{"email":"listener@example.com"}`)},
+		{Name: "tracks.csv", Data: []byte("soundcloud_id,title\n1,Mix\n")},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	archive, err := zip.OpenReader(ds.path(id))
+	if err != nil {
+		t.Fatalf("Expected a zip archive, got %v", err)
+	}
+	defer archive.Close()
+	if len(archive.File) != 2 || archive.File[1].Name != "tracks.csv" {
+		t.Fatalf("Expected both files in order, got %d files", len(archive.File))
+	}
+	f, err := archive.File[1].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(f)
+	f.Close()
+	if string(data) != "soundcloud_id,title\n1,Mix\n" {
+		t.Errorf("Expected the file contents to round-trip, got %q", data)
+	}
+	if size == 0 {
+		t.Error("Expected the archive size to be recorded")
+	}
+}
@@ -0,0 +1,299 @@
+package services
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sizes of the generated demo catalog
+const (
+	demoArtistCount    = 240
+	demoArtistIDBase   = 7000000
+	demoTrackIDBase    = 800000000
+	demoPlaylistIDBase = 900000000
+	// demoFeedDays is how far back an account's feed reaches
+	demoFeedDays = 365
+	// demoCatalogDays is how far back artists have been posting
+	demoCatalogDays = 730
+)
+
+// demoGenres are the genres artists post in, each with tags their tracks
+// pick from
+var demoGenres = map[string][]string{
+	"House":       {"deep house", "garage", "vocal", "club"},
+	"Techno":      {"warehouse", "hypnotic", "berlin", "industrial"},
+	"Drum & Bass": {"jungle", "liquid", "rollers", "amen"},
+	"Dubstep":     {"140", "dub", "halfstep", "bass"},
+	"Ambient":     {"drone", "field recordings", "sleep", "modular"},
+	"Hip-hop":     {"boom bap", "instrumental", "lofi", "beats"},
+	"Jazz":        {"fusion", "spiritual", "live", "nu jazz"},
+	"Electronica": {"idm", "braindance", "leftfield", "downtempo"},
+	"Disco":       {"edits", "italo", "boogie", "cosmic"},
+	"Footwork":    {"juke", "160", "chicago", "teklife"},
+	"Dub":         {"roots", "steppers", "sound system", "reggae"},
+	"Indie":       {"guitar", "bedroom pop", "shoegaze", "dream pop"},
+}
+
+// demoGenreNames are the genres in a fixed order, so generation does not
+// depend on map iteration
+var demoGenreNames = func() []string {
+	names := make([]string, 0, len(demoGenres))
+	for name := range demoGenres {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}()
+
+var (
+	demoNameParts    = []string{"aurora", "basalt", "cinder", "delta", "ember", "fathom", "glass", "halcyon", "iris", "juniper", "kestrel", "lumen", "mirage", "nocturne", "opal", "pylon", "quartz", "rook", "sable", "tundra", "umber", "vesper", "willow", "xylem", "yarrow", "zephyr"}
+	demoNameSuffixes = []string{"", "_music", "_sound", "_dj", "_live", "_records", "_collective", "_beats", "_audio", "_project"}
+	demoTitleWords   = []string{"Midnight", "Tide", "Static", "Harbour", "Signal", "Velvet", "Drift", "Mercury", "Lanterns", "Concrete", "Orbit", "Paper", "Ritual", "Satellite", "Hollow", "Northern", "Lights", "Pressure", "Rain", "Echo", "Bloom", "Circuit", "Ghost", "Summer", "Fever", "Silver", "Canal", "Dust", "Reverie", "Undertow"}
+	demoVenues       = []string{"Corsica Studios", "Fabric", "Tresor", "De School", "Nowadays", "Panorama Bar", "The Warehouse Project", "Dekmantel", "Boiler Room", "NTS Radio", "Rinse FM", "Red Light Radio"}
+	demoShows        = []string{"Late Night Sessions", "Deep Cuts", "Sunday Service", "Night Moves", "Low End Theory", "Pressure Drop"}
+	demoPlaylists    = []string{"Morning coffee", "Gym rotation", "Late night drive", "Deep focus", "Warm-up set", "Peak time", "Rainy Sunday", "Records to buy"}
+)
+
+// demoArtist is a generated artist and everything they have posted, oldest
+// first
+type demoArtist struct {
+	ID       string
+	Username string
+	Genre    string
+	Tracks   []map[string]interface{}
+}
+
+// DemoGenerator makes up a Soundcloud world for demos and local
+// development: a catalog of artists and their tracks, and for each
+// Soundcloud account the artists it follows, its feed, likes and playlists.
+// Everything is derived from Seed and the account id, so the same account
+// gets the same data whether it is seeded into the database or fetched
+// through the demo Soundcloud API.
+type DemoGenerator struct {
+	Seed int64
+	// Now anchors post dates. Tracks are spread over the years before it.
+	Now time.Time
+
+	once    sync.Once
+	artists []demoArtist
+}
+
+// NewDemoGenerator creates a generator whose catalog ends at now
+func NewDemoGenerator(seed int64, now time.Time) *DemoGenerator {
+	return &DemoGenerator{Seed: seed, Now: now.UTC().Truncate(time.Hour)}
+}
+
+// rand returns a random source for one kind of data about one key
+func (g *DemoGenerator) rand(key, kind string) *rand.Rand {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%d/%s/%s", g.Seed, key, kind)
+	return rand.New(rand.NewSource(int64(h.Sum64())))
+}
+
+// catalog builds the artists and their tracks once
+func (g *DemoGenerator) catalog() []demoArtist {
+	g.once.Do(func() {
+		g.artists = make([]demoArtist, demoArtistCount)
+		for i := range g.artists {
+			g.artists[i] = g.artist(i)
+		}
+	})
+	return g.artists
+}
+
+// artist generates one artist of the catalog
+func (g *DemoGenerator) artist(index int) demoArtist {
+	r := g.rand(strconv.Itoa(index), "artist")
+	artist := demoArtist{
+		ID:       strconv.Itoa(demoArtistIDBase + index),
+		Username: demoNameParts[index%len(demoNameParts)] + demoNameSuffixes[(index/len(demoNameParts))%len(demoNameSuffixes)],
+		Genre:    demoGenreNames[r.Intn(len(demoGenreNames))],
+	}
+	count := 20 + r.Intn(70)
+	for k := 0; k < count; k++ {
+		posted := g.Now.Add(-time.Duration(r.Int63n(int64(demoCatalogDays * 24 * time.Hour))))
+		artist.Tracks = append(artist.Tracks, g.track(r, &artist, demoTrackIDBase+index*1000+k, posted))
+	}
+	sort.Slice(artist.Tracks, func(a, b int) bool {
+		return trackPostTime(artist.Tracks[a]).Before(trackPostTime(artist.Tracks[b]))
+	})
+	user := g.user(&artist)
+	for _, track := range artist.Tracks {
+		track["user"] = user
+	}
+	return artist
+}
+
+// track generates a track in the shape of a Soundcloud API track. Most are
+// singles, with some long mixes carrying a tracklist and some podcast
+// episodes.
+func (g *DemoGenerator) track(r *rand.Rand, artist *demoArtist, id int, posted time.Time) map[string]interface{} {
+	genre := artist.Genre
+	if r.Intn(5) == 0 {
+		genre = demoGenreNames[r.Intn(len(demoGenreNames))]
+	}
+	tags := demoGenres[genre]
+	tagList := fmt.Sprintf("%q %s", tags[r.Intn(len(tags))], strings.ReplaceAll(strings.ToLower(genre), " ", ""))
+
+	title := demoTitleWords[r.Intn(len(demoTitleWords))] + " " + demoTitleWords[r.Intn(len(demoTitleWords))]
+	seconds := 150 + r.Intn(330)
+	description := ""
+	switch roll := r.Intn(100); {
+	case roll < 12:
+		seconds = 2400 + r.Intn(4800)
+		if r.Intn(2) == 0 {
+			title = fmt.Sprintf("Live at %s", demoVenues[r.Intn(len(demoVenues))])
+		} else {
+			title = fmt.Sprintf("%s Mix %03d", demoShows[r.Intn(len(demoShows))], 1+r.Intn(200))
+		}
+		var lines []string
+		for n := 1; n <= 8+r.Intn(8); n++ {
+			other := demoNameParts[r.Intn(len(demoNameParts))]
+			lines = append(lines, fmt.Sprintf("%d. %s - %s %s", n, other, demoTitleWords[r.Intn(len(demoTitleWords))], demoTitleWords[r.Intn(len(demoTitleWords))]))
+		}
+		description = "Tracklist:\n" + strings.Join(lines, "\n")
+	case roll < 17:
+		seconds = 1800 + r.Intn(1800)
+		title = fmt.Sprintf("Episode %d: %s", 1+r.Intn(120), title)
+		description = "A conversation about " + strings.ToLower(genre) + " and the records that shaped it."
+	}
+
+	slug := strings.Map(func(c rune) rune {
+		if c >= 'a' && c <= 'z' || c >= '0' && c <= '9' {
+			return c
+		}
+		return '-'
+	}, strings.ToLower(title))
+	return map[string]interface{}{
+		"id":             float64(id),
+		"kind":           "track",
+		"title":          title,
+		"genre":          genre,
+		"tag_list":       tagList,
+		"description":    description,
+		"duration":       float64(seconds * 1000),
+		"created_at":     posted.Format("2006/01/02 15:04:05 -0700"),
+		"permalink_url":  "https://soundcloud.com/" + artist.Username + "/" + slug + "-" + strconv.Itoa(id),
+		"playback_count": float64(r.Intn(250000)),
+		"likes_count":    float64(r.Intn(8000)),
+	}
+}
+
+// user returns an artist in the shape of a Soundcloud API user
+func (g *DemoGenerator) user(artist *demoArtist) map[string]interface{} {
+	id, _ := strconv.Atoi(artist.ID)
+	return map[string]interface{}{
+		"id":              float64(id),
+		"username":        artist.Username,
+		"permalink_url":   "https://soundcloud.com/" + artist.Username,
+		"followers_count": float64((id*7919)%50000 + 120),
+		"track_count":     float64(len(artist.Tracks)),
+	}
+}
+
+// followed returns the catalog indexes of the artists an account follows
+func (g *DemoGenerator) followed(soundcloudID string) []int {
+	r := g.rand(soundcloudID, "followings")
+	return r.Perm(demoArtistCount)[:45+r.Intn(30)]
+}
+
+// Me returns an account in the shape of the Soundcloud /me response
+func (g *DemoGenerator) Me(soundcloudID string) map[string]interface{} {
+	id, _ := strconv.ParseFloat(soundcloudID, 64)
+	username := "listener_" + soundcloudID
+	return map[string]interface{}{
+		"id":            id,
+		"username":      username,
+		"permalink_url": "https://soundcloud.com/" + username,
+	}
+}
+
+// Followings returns the artists an account follows
+func (g *DemoGenerator) Followings(soundcloudID string) []interface{} {
+	artists := g.catalog()
+	var followings []interface{}
+	for _, i := range g.followed(soundcloudID) {
+		followings = append(followings, g.user(&artists[i]))
+	}
+	return followings
+}
+
+// Feed returns what the artists an account follows posted over the last
+// year, newest first
+func (g *DemoGenerator) Feed(soundcloudID string) []interface{} {
+	artists := g.catalog()
+	since := g.Now.AddDate(0, 0, -demoFeedDays)
+	var tracks []map[string]interface{}
+	for _, i := range g.followed(soundcloudID) {
+		for _, track := range artists[i].Tracks {
+			if trackPostTime(track).After(since) {
+				tracks = append(tracks, track)
+			}
+		}
+	}
+	sort.SliceStable(tracks, func(a, b int) bool {
+		return trackPostTime(tracks[a]).After(trackPostTime(tracks[b]))
+	})
+	feed := make([]interface{}, len(tracks))
+	for i, track := range tracks {
+		feed[i] = copyTrack(track)
+	}
+	return feed
+}
+
+// Likes returns tracks an account liked from anywhere in the catalog
+func (g *DemoGenerator) Likes(soundcloudID string) []interface{} {
+	r := g.rand(soundcloudID, "likes")
+	likes := make([]interface{}, 60+r.Intn(90))
+	for i := range likes {
+		likes[i] = g.anyTrack(r)
+	}
+	return likes
+}
+
+// Playlists returns an account's playlists along with their tracks
+func (g *DemoGenerator) Playlists(soundcloudID string) []interface{} {
+	r := g.rand(soundcloudID, "playlists")
+	accountID, _ := strconv.Atoi(soundcloudID)
+	titles := r.Perm(len(demoPlaylists))[:3+r.Intn(3)]
+	playlists := make([]interface{}, len(titles))
+	for i, t := range titles {
+		tracks := make([]interface{}, 8+r.Intn(22))
+		for k := range tracks {
+			tracks[k] = g.anyTrack(r)
+		}
+		id := demoPlaylistIDBase + (accountID%100000)*10 + i
+		playlists[i] = map[string]interface{}{
+			"id":            float64(id),
+			"kind":          "playlist",
+			"title":         demoPlaylists[t],
+			"permalink_url": "https://soundcloud.com/listener_" + soundcloudID + "/sets/" + strconv.Itoa(id),
+			"track_count":   float64(len(tracks)),
+			"tracks":        tracks,
+		}
+	}
+	return playlists
+}
+
+// anyTrack picks a track from the whole catalog
+func (g *DemoGenerator) anyTrack(r *rand.Rand) map[string]interface{} {
+	artists := g.catalog()
+	artist := artists[r.Intn(len(artists))]
+	return copyTrack(artist.Tracks[r.Intn(len(artist.Tracks))])
+}
+
+// copyTrack copies a catalog track so callers tagging it with sources or
+// accounts do not change the catalog
+func copyTrack(track map[string]interface{}) map[string]interface{} {
+	copied := make(map[string]interface{}, len(track))
+	for key, value := range track {
+		copied[key] = value
+	}
+	return copied
+}
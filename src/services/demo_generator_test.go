@@ -0,0 +1,99 @@
+package services
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func useDemo(t *testing.T, generator *DemoGenerator) {
+	original := soundcloudClient.Transport
+	UseSoundcloudDemo(generator)
+	t.Cleanup(func() { soundcloudClient.Transport = original })
+}
+
+func TestDemoGeneratorIsDeterministic(t *testing.T) {
+	now := time.Date(2026, 6, 1, 12, 30, 0, 0, time.UTC)
+	a := NewDemoGenerator(7, now).Feed("6000010")
+	b := NewDemoGenerator(7, now).Feed("6000010")
+	if !reflect.DeepEqual(a, b) {
+		t.Error("Expected the same seed to generate the same feed")
+	}
+	if reflect.DeepEqual(a, NewDemoGenerator(8, now).Feed("6000010")) {
+		t.Error("Expected a different seed to generate a different feed")
+	}
+}
+
+func TestDemoFeedCoversAYear(t *testing.T) {
+	now := time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
+	feed := NewDemoGenerator(DefaultDemoSeed, now).Feed("6000010")
+	if len(feed) < 1000 {
+		t.Fatalf("Expected thousands of tracks, got %d", len(feed))
+	}
+	since := now.AddDate(0, 0, -demoFeedDays)
+	previous := now
+	kinds := map[string]int{}
+	for _, item := range feed {
+		track, err := NormalizeTrack(item.(map[string]interface{}))
+		if err != nil {
+			t.Fatalf("Expected a Soundcloud-shaped track, got %v", err)
+		}
+		if track.PostTime.Before(since) || track.PostTime.After(previous) {
+			t.Fatalf("Expected tracks from the last year, newest first, got %v after %v", track.PostTime, previous)
+		}
+		previous = track.PostTime
+		kinds[ClassifyTrack(*track, nil).Kind]++
+	}
+	if len(kinds) < 2 {
+		t.Errorf("Expected a mix of track kinds, got %v", kinds)
+	}
+}
+
+func TestDemoSoundcloudServesGeneratedAccounts(t *testing.T) {
+	generator := NewDemoGenerator(DefaultDemoSeed, time.Now())
+	useDemo(t, generator)
+	sc := NewSoundcloudService("", "", "http://localhost:3000/auth/callback")
+
+	result, err := sc.HandleCallback(context.Background(), "offline")
+	if err != nil {
+		t.Fatalf("Unexpected error logging in: %v", err)
+	}
+	token := result.(map[string]interface{})["access_token"].(string)
+	userInfo := result.(map[string]interface{})["user_info"].(map[string]interface{})
+	if token != DemoToken(FormatID(userInfo["id"])) {
+		t.Errorf("Expected a demo token for the new account, got %q", token)
+	}
+
+	likes, err := sc.FetchLikes(context.Background(), DemoToken("6000010"))
+	if err != nil {
+		t.Fatalf("Unexpected error fetching likes: %v", err)
+	}
+	if !reflect.DeepEqual(likes, generator.Likes("6000010")) {
+		t.Error("Expected a seeded account's likes to match the generator")
+	}
+
+	if _, err := sc.FetchLikes(context.Background(), "live-token"); err == nil {
+		t.Error("Expected a token the demo did not hand out to be rejected")
+	}
+}
+
+func TestLoadSeedSpec(t *testing.T) {
+	spec, err := LoadSeedSpec("../../fixtures/demo/seed.json")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if spec.Seed != DefaultDemoSeed || len(spec.Users) == 0 {
+		t.Errorf("Expected the demo seed with users, got seed %d and %d users", spec.Seed, len(spec.Users))
+	}
+	generated := GenerateSeedSpec(DefaultDemoSeed, 5)
+	seen := map[string]bool{}
+	for _, user := range generated.Users {
+		for _, account := range user.Accounts {
+			if seen[account.SoundcloudID] {
+				t.Errorf("Expected generated users to have distinct accounts, %s repeats", account.SoundcloudID)
+			}
+			seen[account.SoundcloudID] = true
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// demoTokenPrefix starts every token the demo Soundcloud API hands out.
+// The rest of the token is the account's Soundcloud id.
+const demoTokenPrefix = "demo-"
+
+// DemoToken returns the token the demo Soundcloud API accepts for an
+// account
+func DemoToken(soundcloudID string) string {
+	return demoTokenPrefix + soundcloudID
+}
+
+// DemoSoundcloud stands in for the Soundcloud API in demo mode. It answers
+// the calls SoundcloudService makes with data from a DemoGenerator, so the
+// whole app runs without network access or API credentials. Logging in
+// mints a new account; seeded accounts are reached through their
+// DemoToken.
+type DemoSoundcloud struct {
+	Generator *DemoGenerator
+
+	mu  sync.Mutex
+	ids *rand.Rand
+}
+
+// NewDemoSoundcloud creates a demo API serving the generator's data
+func NewDemoSoundcloud(generator *DemoGenerator) *DemoSoundcloud {
+	return &DemoSoundcloud{
+		Generator: generator,
+		ids:       rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// UseSoundcloudDemo answers every Soundcloud API call from generated data
+func UseSoundcloudDemo(generator *DemoGenerator) {
+	soundcloudClient.Transport = NewDemoSoundcloud(generator)
+}
+
+// RoundTrip answers a call as the Soundcloud API would
+func (d *DemoSoundcloud) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		io.Copy(io.Discard, req.Body)
+		req.Body.Close()
+	}
+	path := req.URL.Path
+
+	if req.Method == http.MethodPost && path == "/oauth2/token" {
+		return demoResponse(req, http.StatusOK, map[string]interface{}{
+			"access_token": DemoToken(d.newAccountID()),
+			"token_type":   "bearer",
+		})
+	}
+
+	soundcloudID, ok := d.account(req)
+	if !ok {
+		return demoResponse(req, http.StatusUnauthorized, map[string]interface{}{"error": "invalid token"})
+	}
+	gen := d.Generator
+	switch {
+	case req.Method == http.MethodGet && path == "/me":
+		return demoResponse(req, http.StatusOK, gen.Me(soundcloudID))
+	case req.Method == http.MethodGet && path == "/me/tracks":
+		return demoResponse(req, http.StatusOK, gen.Feed(soundcloudID))
+	case req.Method == http.MethodGet && path == "/me/followings":
+		return demoResponse(req, http.StatusOK, map[string]interface{}{"collection": gen.Followings(soundcloudID)})
+	case req.Method == http.MethodGet && path == "/me/likes/tracks":
+		return demoResponse(req, http.StatusOK, map[string]interface{}{"collection": gen.Likes(soundcloudID)})
+	case req.Method == http.MethodGet && path == "/me/playlists":
+		return demoResponse(req, http.StatusOK, map[string]interface{}{"collection": gen.Playlists(soundcloudID)})
+	case req.Method == http.MethodPost && path == "/playlists":
+		return d.playlist(req, strconv.Itoa(demoPlaylistIDBase+d.randomID()))
+	case req.Method == http.MethodPut && strings.HasPrefix(path, "/playlists/"):
+		return d.playlist(req, strings.TrimPrefix(path, "/playlists/"))
+	}
+	// There is no audio offline, so streams are unavailable like any other
+	// missing resource
+	return demoResponse(req, http.StatusNotFound, map[string]interface{}{"error": "not found"})
+}
+
+// account returns the Soundcloud id a request's demo token belongs to
+func (d *DemoSoundcloud) account(req *http.Request) (string, bool) {
+	token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if !strings.HasPrefix(token, demoTokenPrefix) {
+		return "", false
+	}
+	soundcloudID := strings.TrimPrefix(token, demoTokenPrefix)
+	if _, err := strconv.Atoi(soundcloudID); err != nil {
+		return "", false
+	}
+	return soundcloudID, true
+}
+
+// newAccountID returns the Soundcloud id of a newly connected account
+func (d *DemoSoundcloud) newAccountID() string {
+	return strconv.Itoa(1000000 + d.randomID())
+}
+
+// randomID returns an id that is unique enough for one demo run
+func (d *DemoSoundcloud) randomID() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.ids.Intn(5000000)
+}
+
+// playlist answers saving a playlist with its id and address
+func (d *DemoSoundcloud) playlist(req *http.Request, id string) (*http.Response, error) {
+	return demoResponse(req, http.StatusOK, map[string]interface{}{
+		"id":            json.Number(id),
+		"permalink_url": "https://soundcloud.com/demo/sets/" + id,
+	})
+}
+
+// demoResponse builds a JSON response to a request
+func demoResponse(req *http.Request, status int, body interface{}) (*http.Response, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": []string{"application/json"}},
+		Body:          io.NopCloser(bytes.NewReader(data)),
+		ContentLength: int64(len(data)),
+		Request:       req,
+	}, nil
+}
@@ -0,0 +1,248 @@
+package services
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/gobuffalo/nulls"
+	"github.com/gobuffalo/pop/v6"
+	"github.com/gofrs/uuid"
+	"github.com/jbhicks/sound-cistern/src/models"
+)
+
+// minDuplicateTolerance is how many seconds apart two uploads can be and
+// still count as the same recording. Re-uploads are often re-encoded or
+// trimmed by a second or two.
+const minDuplicateTolerance = 3
+
+var (
+	// titleNoisePattern matches bracketed notes uploaders add to titles
+	titleNoisePattern = regexp.MustCompile(`(?i)[\[(][^\])]*(free (download|dl)|premiere|out now|exclusive|re-?upload|\bhq\b)[^\])]*[\])]`)
+	// titlePrefixPattern matches notes uploaders put in front of titles
+	titlePrefixPattern = regexp.MustCompile(`(?i)^\s*(premiere|free (download|dl))\s*[:|-]\s*`)
+	// nonWordPattern matches runs of punctuation and spaces
+	nonWordPattern = regexp.MustCompile(`[^\p{L}\p{N}]+`)
+)
+
+// duplicateTitleKey reduces a title to the words that identify the
+// recording, dropping uploader notes, any "Artist - " prefix, case and
+// punctuation
+func duplicateTitleKey(title string) string {
+	title = titleNoisePattern.ReplaceAllString(title, " ")
+	title = titlePrefixPattern.ReplaceAllString(title, "")
+	if parts := artistSeparator.Split(title, 2); len(parts) == 2 {
+		title = parts[1]
+	}
+	return strings.TrimSpace(nonWordPattern.ReplaceAllString(strings.ToLower(title), " "))
+}
+
+// duplicateTolerance is how far apart in seconds two uploads of a
+// recording of the given length can be
+func duplicateTolerance(length int) int {
+	if tolerance := length / 200; tolerance > minDuplicateTolerance {
+		return tolerance
+	}
+	return minDuplicateTolerance
+}
+
+// sameRecording reports whether two uploads are the same recording: the
+// same Soundcloud track, or the same title within a few seconds of length
+func sameRecording(a, b models.Track) bool {
+	if a.SoundcloudID != "" && a.SoundcloudID == b.SoundcloudID {
+		return true
+	}
+	if a.Length <= 0 || b.Length <= 0 {
+		return false
+	}
+	diff := a.Length - b.Length
+	if diff < 0 {
+		diff = -diff
+	}
+	if diff > duplicateTolerance(a.Length) {
+		return false
+	}
+	key := duplicateTitleKey(a.Title)
+	return key != "" && key == duplicateTitleKey(b.Title)
+}
+
+// CollapseDuplicates groups uploads of the same recording in a list of
+// Soundcloud tracks. The earliest upload stands for the group, in the
+// place of the group's first track, with the other posters listed under
+// "also_posted_by".
+func CollapseDuplicates(tracks []interface{}) []interface{} {
+	type group struct {
+		canonical map[string]interface{}
+		track     models.Track
+		members   []map[string]interface{}
+	}
+
+	var groups []*group
+	for _, t := range tracks {
+		trackMap, ok := t.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		normalized, err := NormalizeTrack(trackMap)
+		if err != nil {
+			continue
+		}
+
+		var match *group
+		for _, g := range groups {
+			if sameRecording(g.track, *normalized) {
+				match = g
+				break
+			}
+		}
+		if match == nil {
+			groups = append(groups, &group{canonical: trackMap, track: *normalized})
+			continue
+		}
+		if normalized.PostTime.Before(match.track.PostTime) {
+			match.members = append(match.members, match.canonical)
+			match.canonical, match.track = trackMap, *normalized
+			continue
+		}
+		match.members = append(match.members, trackMap)
+	}
+
+	collapsed := make([]interface{}, 0, len(groups))
+	for _, g := range groups {
+		if len(g.members) == 0 {
+			collapsed = append(collapsed, g.canonical)
+			continue
+		}
+		canonical := map[string]interface{}{}
+		for k, v := range g.canonical {
+			canonical[k] = v
+		}
+		canonicalPoster := stringField(trackPoster(g.canonical), "username")
+		var posters []map[string]interface{}
+		for _, member := range g.members {
+			if poster := trackPoster(member); stringField(poster, "username") != canonicalPoster {
+				posters = append(posters, poster)
+			}
+		}
+		if len(posters) > 0 {
+			canonical["also_posted_by"] = posters
+		}
+		collapsed = append(collapsed, canonical)
+	}
+	return collapsed
+}
+
+// trackPoster describes the account that posted a Soundcloud track, for
+// the "also posted by" list
+func trackPoster(track map[string]interface{}) map[string]interface{} {
+	poster := map[string]interface{}{
+		"id":            track["id"],
+		"permalink_url": stringField(track, "permalink_url"),
+	}
+	if user, ok := track["user"].(map[string]interface{}); ok {
+		poster["username"] = stringField(user, "username")
+	}
+	return poster
+}
+
+// DuplicateService links stored uploads of the same recording to one
+// canonical track, the earliest one posted
+type DuplicateService struct {
+	DB *pop.Connection
+}
+
+// NewDuplicateService creates a new service
+func NewDuplicateService(db *pop.Connection) *DuplicateService {
+	return &DuplicateService{DB: db}
+}
+
+// Link looks for a stored canonical track that is the same recording as
+// track. The later of the two becomes a duplicate of the earlier, and when
+// track is the earlier one the whole group moves over to it.
+func (ds *DuplicateService) Link(track *models.Track) error {
+	if track.Length <= 0 || duplicateTitleKey(track.Title) == "" {
+		return nil
+	}
+
+	tolerance := duplicateTolerance(track.Length)
+	candidates := models.Tracks{}
+	err := ds.DB.Where("user_id = ? AND id <> ? AND duplicate_of IS NULL AND length BETWEEN ? AND ?",
+		track.UserID, track.ID, track.Length-tolerance, track.Length+tolerance).
+		Order("post_time asc").All(&candidates)
+	if err != nil {
+		return err
+	}
+
+	for _, canonical := range candidates {
+		if !sameRecording(*track, canonical) {
+			continue
+		}
+		if track.PostTime.Before(canonical.PostTime) {
+			err := ds.DB.RawQuery("UPDATE soundcloud_tracks SET duplicate_of = ? WHERE id = ? OR duplicate_of = ?",
+				track.ID, canonical.ID, canonical.ID).Exec()
+			if err != nil {
+				return err
+			}
+			track.DuplicateOf = nulls.UUID{}
+		} else {
+			track.DuplicateOf = nulls.NewUUID(canonical.ID)
+		}
+		return ds.DB.Update(track)
+	}
+	return nil
+}
+
+// Detect relinks all of a user's stored tracks from scratch and returns
+// how many were found to be duplicates
+func (ds *DuplicateService) Detect(userID string) (int, error) {
+	userUUID, err := uuid.FromString(userID)
+	if err != nil {
+		return 0, err
+	}
+	if err := ds.DB.RawQuery("UPDATE soundcloud_tracks SET duplicate_of = NULL WHERE user_id = ?", userUUID).Exec(); err != nil {
+		return 0, err
+	}
+
+	tracks := models.Tracks{}
+	if err := ds.DB.Where("user_id = ?", userUUID).Order("post_time asc").All(&tracks); err != nil {
+		return 0, err
+	}
+
+	found := 0
+	for i := range tracks {
+		if err := ds.Link(&tracks[i]); err != nil {
+			return found, err
+		}
+		if tracks[i].DuplicateOf.Valid {
+			found++
+		}
+	}
+	return found, nil
+}
+
+// Posters returns who else posted each canonical track, keyed by the
+// canonical track's id, earliest first
+func (ds *DuplicateService) Posters(canonicalIDs []uuid.UUID) (map[uuid.UUID][]map[string]interface{}, error) {
+	posters := map[uuid.UUID][]map[string]interface{}{}
+	if len(canonicalIDs) == 0 {
+		return posters, nil
+	}
+
+	ids := make([]interface{}, len(canonicalIDs))
+	for i, id := range canonicalIDs {
+		ids[i] = id
+	}
+	duplicates := models.Tracks{}
+	if err := ds.DB.Where("duplicate_of IN (?)", ids...).Order("post_time asc").All(&duplicates); err != nil {
+		return nil, err
+	}
+
+	for _, duplicate := range duplicates {
+		canonicalID := duplicate.DuplicateOf.UUID
+		posters[canonicalID] = append(posters[canonicalID], map[string]interface{}{
+			"id":            duplicate.SoundcloudID,
+			"username":      duplicate.Artist.String,
+			"permalink_url": duplicate.PermalinkURL.String,
+		})
+	}
+	return posters, nil
+}
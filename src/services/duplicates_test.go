@@ -0,0 +1,73 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/jbhicks/sound-cistern/src/models"
+)
+
+func TestDuplicateTitleKey(t *testing.T) {
+	tests := map[string]string{
+		"Archangel":                          "archangel",
+		"Burial - Archangel (Free Download)": "archangel",
+		"PREMIERE: Burial – Archangel":       "archangel",
+		"Archangel [HQ]":                     "archangel",
+		"Archangel (VIP Remix)":              "archangel vip remix",
+	}
+	for title, want := range tests {
+		if got := duplicateTitleKey(title); got != want {
+			t.Errorf("duplicateTitleKey(%q) = %q, want %q", title, got, want)
+		}
+	}
+}
+
+func TestSameRecording(t *testing.T) {
+	original := models.Track{SoundcloudID: "1", Title: "Archangel", Length: 240}
+
+	if !sameRecording(original, models.Track{SoundcloudID: "1", Title: "Something else"}) {
+		t.Error("Expected the same Soundcloud id to be the same recording")
+	}
+	if !sameRecording(original, models.Track{SoundcloudID: "2", Title: "Burial - Archangel", Length: 242}) {
+		t.Error("Expected a re-upload within a few seconds to be the same recording")
+	}
+	if sameRecording(original, models.Track{SoundcloudID: "3", Title: "Archangel", Length: 300}) {
+		t.Error("Expected a different length to be a different recording")
+	}
+	if sameRecording(original, models.Track{SoundcloudID: "4", Title: "Archangel (VIP Remix)", Length: 240}) {
+		t.Error("Expected a remix to be a different recording")
+	}
+}
+
+func TestCollapseDuplicates(t *testing.T) {
+	tracks := []interface{}{
+		map[string]interface{}{
+			"id": float64(2), "title": "Burial - Archangel (Free Download)", "duration": float64(241000),
+			"created_at": "2025/03/02 10:00:00 +0000", "user": map[string]interface{}{"username": "reuploader"},
+		},
+		map[string]interface{}{"id": float64(3), "title": "Ambient hour", "duration": float64(3600000)},
+		map[string]interface{}{
+			"id": float64(1), "title": "Archangel", "duration": float64(240000),
+			"created_at": "2025/03/01 10:00:00 +0000", "user": map[string]interface{}{"username": "burial"},
+		},
+		map[string]interface{}{
+			"id": float64(1), "title": "Archangel", "duration": float64(240000),
+			"created_at": "2025/03/01 10:00:00 +0000", "user": map[string]interface{}{"username": "burial"},
+		},
+	}
+
+	collapsed := CollapseDuplicates(tracks)
+	if len(collapsed) != 2 {
+		t.Fatalf("Expected 2 tracks, got %d", len(collapsed))
+	}
+	canonical := collapsed[0].(map[string]interface{})
+	if canonical["id"] != float64(1) {
+		t.Errorf("Expected the earliest upload to stand for the group, got %v", canonical["id"])
+	}
+	posters, _ := canonical["also_posted_by"].([]map[string]interface{})
+	if len(posters) != 1 || posters[0]["username"] != "reuploader" {
+		t.Errorf("Expected the re-uploader to be listed once, got %v", posters)
+	}
+	if _, ok := tracks[2].(map[string]interface{})["also_posted_by"]; ok {
+		t.Error("Expected the input tracks to be left unchanged")
+	}
+}
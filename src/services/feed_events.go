@@ -0,0 +1,142 @@
+package services
+
+import (
+	"errors"
+	"sync"
+)
+
+// Feed event types sent to live feed subscribers
+const (
+	FeedEventTrack      = "track"
+	FeedEventSyncStatus = "sync-status"
+)
+
+// Sync statuses published with FeedEventSyncStatus
+const (
+	SyncStatusRunning = "running"
+	SyncStatusDone    = "done"
+	SyncStatusFailed  = "failed"
+)
+
+// ErrTooManyConnections is returned when a user already has the maximum
+// number of live feed connections open
+var ErrTooManyConnections = errors.New("too many live feed connections")
+
+// FeedEvent is a single update pushed to a user's live feed
+type FeedEvent struct {
+	ID      uint64
+	Type    string
+	Track   map[string]interface{}
+	Status  string
+	Message string
+}
+
+// FeedSubscription receives events for one live feed connection
+type FeedSubscription struct {
+	C      chan FeedEvent
+	userID string
+}
+
+// FeedBroker fans feed events out to each user's open live feed connections
+// and keeps a short backlog so reconnecting clients can catch up
+type FeedBroker struct {
+	mu          sync.Mutex
+	lastID      uint64
+	maxConns    int
+	backlogSize int
+	subscribers map[string]map[*FeedSubscription]struct{}
+	backlog     map[string][]FeedEvent
+}
+
+// NewFeedBroker creates a broker that allows maxConns connections per user
+// and remembers the last backlogSize events per user
+func NewFeedBroker(maxConns, backlogSize int) *FeedBroker {
+	return &FeedBroker{
+		maxConns:    maxConns,
+		backlogSize: backlogSize,
+		subscribers: map[string]map[*FeedSubscription]struct{}{},
+		backlog:     map[string][]FeedEvent{},
+	}
+}
+
+// Subscribe opens a live feed connection for a user. Events newer than
+// lastEventID that are still in the backlog are returned for replay.
+func (b *FeedBroker) Subscribe(userID string, lastEventID uint64) (*FeedSubscription, []FeedEvent, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.maxConns > 0 && len(b.subscribers[userID]) >= b.maxConns {
+		return nil, nil, ErrTooManyConnections
+	}
+
+	sub := &FeedSubscription{C: make(chan FeedEvent, 16), userID: userID}
+	if b.subscribers[userID] == nil {
+		b.subscribers[userID] = map[*FeedSubscription]struct{}{}
+	}
+	b.subscribers[userID][sub] = struct{}{}
+
+	var replay []FeedEvent
+	if lastEventID > 0 {
+		for _, e := range b.backlog[userID] {
+			if e.ID > lastEventID {
+				replay = append(replay, e)
+			}
+		}
+	}
+	return sub, replay, nil
+}
+
+// Unsubscribe closes a live feed connection
+func (b *FeedBroker) Unsubscribe(sub *FeedSubscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.remove(sub)
+}
+
+// remove closes sub's channel and forgets it. The caller holds b.mu.
+func (b *FeedBroker) remove(sub *FeedSubscription) {
+	if subs, ok := b.subscribers[sub.userID]; ok {
+		if _, ok := subs[sub]; ok {
+			delete(subs, sub)
+			close(sub.C)
+		}
+		if len(subs) == 0 {
+			delete(b.subscribers, sub.userID)
+		}
+	}
+}
+
+// Publish assigns the event an ID, records it in the user's backlog and
+// sends it to every open connection. A connection whose buffer is full is
+// closed instead, so the client reconnects with its Last-Event-ID and
+// replays what it missed from the backlog.
+func (b *FeedBroker) Publish(userID string, event FeedEvent) FeedEvent {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lastID++
+	event.ID = b.lastID
+
+	backlog := append(b.backlog[userID], event)
+	if len(backlog) > b.backlogSize {
+		backlog = backlog[len(backlog)-b.backlogSize:]
+	}
+	b.backlog[userID] = backlog
+
+	for sub := range b.subscribers[userID] {
+		select {
+		case sub.C <- event:
+		default:
+			b.remove(sub)
+		}
+	}
+	return event
+}
+
+// Connections returns how many live feed connections a user has open
+func (b *FeedBroker) Connections(userID string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subscribers[userID])
+}
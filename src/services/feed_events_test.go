@@ -0,0 +1,102 @@
+package services
+
+import "testing"
+
+func TestFeedBrokerConnectionCap(t *testing.T) {
+	b := NewFeedBroker(2, 10)
+
+	first, _, err := b.Subscribe("user-1", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := b.Subscribe("user-1", 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := b.Subscribe("user-1", 0); err != ErrTooManyConnections {
+		t.Errorf("Expected ErrTooManyConnections, got %v", err)
+	}
+	if _, _, err := b.Subscribe("user-2", 0); err != nil {
+		t.Errorf("Expected other users to be unaffected, got %v", err)
+	}
+
+	b.Unsubscribe(first)
+	if b.Connections("user-1") != 1 {
+		t.Errorf("Expected 1 connection after unsubscribe, got %d", b.Connections("user-1"))
+	}
+	if _, _, err := b.Subscribe("user-1", 0); err != nil {
+		t.Errorf("Expected a free slot after unsubscribe, got %v", err)
+	}
+}
+
+func TestFeedBrokerPublishAndReplay(t *testing.T) {
+	b := NewFeedBroker(0, 3)
+
+	sub, _, _ := b.Subscribe("user-1", 0)
+	first := b.Publish("user-1", FeedEvent{Type: FeedEventSyncStatus, Status: SyncStatusRunning})
+	b.Publish("user-2", FeedEvent{Type: FeedEventSyncStatus, Status: SyncStatusRunning})
+
+	got := <-sub.C
+	if got.ID != first.ID || got.Status != SyncStatusRunning {
+		t.Errorf("Unexpected event %+v", got)
+	}
+	select {
+	case e := <-sub.C:
+		t.Errorf("Received another user's event %+v", e)
+	default:
+	}
+
+	for i := 0; i < 4; i++ {
+		b.Publish("user-1", FeedEvent{Type: FeedEventTrack})
+	}
+
+	_, replay, _ := b.Subscribe("user-1", first.ID)
+	if len(replay) != 3 {
+		t.Fatalf("Expected backlog to be trimmed to 3 events, got %d", len(replay))
+	}
+	for _, e := range replay {
+		if e.ID <= first.ID {
+			t.Errorf("Replayed event %d is not newer than Last-Event-ID %d", e.ID, first.ID)
+		}
+	}
+
+	_, replay, _ = b.Subscribe("user-1", 0)
+	if len(replay) != 0 {
+		t.Errorf("Expected no replay without Last-Event-ID, got %d", len(replay))
+	}
+}
+
+func TestFeedBrokerClosesLaggingSubscription(t *testing.T) {
+	b := NewFeedBroker(0, 64)
+
+	slow, _, _ := b.Subscribe("user-1", 0)
+	fast, _, _ := b.Subscribe("user-1", 0)
+	buffer := cap(slow.C)
+
+	for i := 0; i < buffer; i++ {
+		b.Publish("user-1", FeedEvent{Type: FeedEventTrack})
+	}
+	for len(fast.C) > 0 {
+		<-fast.C
+	}
+	last := b.Publish("user-1", FeedEvent{Type: FeedEventTrack})
+
+	var received []FeedEvent
+	for e := range slow.C {
+		received = append(received, e)
+	}
+	if len(received) != buffer {
+		t.Fatalf("Expected %d buffered events before the close, got %d", buffer, len(received))
+	}
+	if b.Connections("user-1") != 1 {
+		t.Errorf("Expected only the lagging connection to be closed, got %d open", b.Connections("user-1"))
+	}
+	if e := <-fast.C; e.ID != last.ID {
+		t.Errorf("Expected the drained connection to get event %d, got %d", last.ID, e.ID)
+	}
+
+	b.Unsubscribe(slow)
+	_, replay, _ := b.Subscribe("user-1", received[len(received)-1].ID)
+	if len(replay) != 1 || replay[0].ID != last.ID {
+		t.Errorf("Expected the dropped event %d to be replayed, got %+v", last.ID, replay)
+	}
+}
@@ -0,0 +1,388 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gobuffalo/pop/v6"
+	"github.com/gofrs/uuid"
+	"github.com/jbhicks/sound-cistern/src/models"
+)
+
+// Feed sort orders
+const (
+	SortNewest   = "newest"
+	SortOldest   = "oldest"
+	SortLongest  = "longest"
+	SortShortest = "shortest"
+)
+
+// FeedPageSize is how many tracks are loaded per feed page
+const FeedPageSize = 24
+
+// ErrInvalidCursor is returned when a page cursor cannot be decoded
+var ErrInvalidCursor = errors.New("invalid page cursor")
+
+// FeedPage is one page of a user's stored tracks
+type FeedPage struct {
+	Tracks     []interface{}
+	NextCursor string
+}
+
+// feedSort describes how a sort order maps onto the tracks table
+type feedSort struct {
+	column string
+	desc   bool
+}
+
+var feedSorts = map[string]feedSort{
+	SortNewest:   {column: "post_time", desc: true},
+	SortOldest:   {column: "post_time", desc: false},
+	SortLongest:  {column: "length", desc: true},
+	SortShortest: {column: "length", desc: false},
+}
+
+// NormalizeSort returns sort if it is a known sort order, or SortNewest
+func NormalizeSort(sort string) string {
+	if _, ok := feedSorts[sort]; ok {
+		return sort
+	}
+	return SortNewest
+}
+
+// Page returns up to limit stored tracks for a user that match criteria,
+// in the given sort order, starting after cursor. The cursor holds the
+// sort key and id of the last track on the previous page, so pages stay
+// stable while new tracks are synced.
+func (fs *FeedService) Page(ctx context.Context, userID string, criteria map[string]interface{}, sort, cursor string, limit int) (*FeedPage, error) {
+	fs = fs.with(ctx)
+	userUUID, err := uuid.FromString(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	order := feedSorts[NormalizeSort(sort)]
+	q := filterQuery(fs.DB.Where("user_id = ?", userUUID), userUUID, criteria)
+
+	if cursor != "" {
+		key, id, err := decodeCursor(cursor, order.column)
+		if err != nil {
+			return nil, err
+		}
+		op := ">"
+		if order.desc {
+			op = "<"
+		}
+		q = q.Where("("+order.column+", id) "+op+" (?, ?)", key, id)
+	}
+
+	direction := "asc"
+	if order.desc {
+		direction = "desc"
+	}
+	q = q.Order(order.column + " " + direction + ", id " + direction)
+
+	tracks := models.Tracks{}
+	if err := q.Limit(limit + 1).All(&tracks); err != nil {
+		return nil, err
+	}
+
+	page := &FeedPage{Tracks: []interface{}{}}
+	if len(tracks) > limit {
+		tracks = tracks[:limit]
+		page.NextCursor = encodeCursor(tracks[limit-1], order.column)
+	}
+	page.Tracks, err = fs.trackMaps(ctx, userUUID, tracks, criteria)
+	if err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// Pinned returns the tracks rules have pinned to the top of the user's
+// feed, newest first
+func (fs *FeedService) Pinned(ctx context.Context, userID string) ([]interface{}, error) {
+	fs = fs.with(ctx)
+	userUUID, err := uuid.FromString(userID)
+	if err != nil {
+		return nil, err
+	}
+	tracks := models.Tracks{}
+	if err := fs.DB.Where("user_id = ? AND pinned = ? AND hidden = ?", userUUID, true, false).Order("post_time desc").All(&tracks); err != nil {
+		return nil, err
+	}
+	return fs.trackMaps(ctx, userUUID, tracks, map[string]interface{}{})
+}
+
+// TrackMap turns one stored track into a feed map the way Page does
+func (fs *FeedService) TrackMap(ctx context.Context, track *models.Track) (map[string]interface{}, error) {
+	fs = fs.with(ctx)
+	maps, err := fs.trackMaps(ctx, track.UserID, models.Tracks{*track}, map[string]interface{}{})
+	if err != nil {
+		return nil, err
+	}
+	return maps[0].(map[string]interface{}), nil
+}
+
+// trackMaps turns stored tracks into feed maps along with what the user
+// has heard, where each came from and through which of several linked
+// accounts, its rule tags, labels and note and, when collapsing, who else
+// posted it
+func (fs *FeedService) trackMaps(ctx context.Context, userUUID uuid.UUID, tracks models.Tracks, criteria map[string]interface{}) ([]interface{}, error) {
+	trackIDs := make([]uuid.UUID, len(tracks))
+	for i, track := range tracks {
+		trackIDs[i] = track.ID
+	}
+	listens, err := NewListenService(fs.DB).Listens(userUUID, trackIDs)
+	if err != nil {
+		return nil, err
+	}
+	sources, err := fs.TrackSources(ctx, trackIDs)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := NewRuleService(fs.DB).Tags(trackIDs)
+	if err != nil {
+		return nil, err
+	}
+	labelService := NewLabelService(fs.DB)
+	labels, err := labelService.TrackLabels(trackIDs)
+	if err != nil {
+		return nil, err
+	}
+	notes, err := labelService.Notes(trackIDs)
+	if err != nil {
+		return nil, err
+	}
+	accounts, err := NewAccountService(fs.DB).Accounts(userUUID.String())
+	if err != nil {
+		return nil, err
+	}
+	accountNames := map[string]string{}
+	if len(accounts) > 1 {
+		for _, account := range accounts {
+			accountNames[account.ID.String()] = account.DisplayName()
+		}
+	}
+	posters := map[uuid.UUID][]map[string]interface{}{}
+	if collapse, ok := criteria["collapse"].(bool); ok && collapse {
+		if posters, err = NewDuplicateService(fs.DB).Posters(trackIDs); err != nil {
+			return nil, err
+		}
+	}
+
+	maps := make([]interface{}, 0, len(tracks))
+	for _, track := range tracks {
+		trackMap := track.Map()
+		if listen, ok := listens[track.ID]; ok {
+			if listen.Partial() {
+				trackMap["resume_position"] = listen.Position
+			}
+			trackMap["heard"] = listen.Heard
+		}
+		setTrackSources(trackMap, sources[track.ID])
+		if len(accountNames) > 0 {
+			setTrackAccounts(trackMap, accountNames)
+		}
+		if len(tags[track.ID]) > 0 {
+			trackMap["tags"] = tags[track.ID]
+		}
+		if len(labels[track.ID]) > 0 {
+			trackMap["labels"] = labels[track.ID]
+		}
+		if note, ok := notes[track.ID]; ok {
+			trackMap["note"] = note
+		}
+		if len(posters[track.ID]) > 0 {
+			trackMap["also_posted_by"] = posters[track.ID]
+		}
+		maps = append(maps, trackMap)
+	}
+	return maps, nil
+}
+
+// Track returns a stored track by its Soundcloud id
+func (fs *FeedService) Track(ctx context.Context, userID, soundcloudID string) (*models.Track, error) {
+	fs = fs.with(ctx)
+	userUUID, err := uuid.FromString(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	track := &models.Track{}
+	if err := fs.DB.Where("user_id = ? AND soundcloud_id = ?", userUUID, soundcloudID).First(track); err != nil {
+		return nil, ErrTrackNotFound
+	}
+	return track, nil
+}
+
+// CountTracks returns how many tracks are stored for a user
+func (fs *FeedService) CountTracks(ctx context.Context, userID string) (int, error) {
+	fs = fs.with(ctx)
+	userUUID, err := uuid.FromString(userID)
+	if err != nil {
+		return 0, err
+	}
+	return fs.DB.Where("user_id = ?", userUUID).Count(&models.Track{})
+}
+
+// filterQuery applies filter criteria to a user's tracks query, matching
+// the rules FilterTracks uses for cached feeds
+func filterQuery(q *pop.Query, userID uuid.UUID, criteria map[string]interface{}) *pop.Query {
+	if minLength, ok := criteria["min_length"].(float64); ok {
+		q = q.Where("length >= ?", minLength)
+	}
+	if maxLength, ok := criteria["max_length"].(float64); ok {
+		q = q.Where("length <= ?", maxLength)
+	}
+	if genres, ok := criteria["genres"].([]interface{}); ok && len(genres) > 0 {
+		q = q.Where("genre IN (?)", genres...)
+	}
+	if query, ok := criteria["query"].(string); ok && query != "" {
+		// Mixes also match on the artists and titles in their tracklists
+		q = q.Where(`(strpos(title, ?) > 0 OR id IN (
+			SELECT track_id FROM tracklist_entries
+			WHERE strpos(lower(artist), lower(?)) > 0 OR strpos(lower(title), lower(?)) > 0))`, query, query, query)
+	}
+	if name, ok := criteria["artist_name"].(string); ok && name != "" {
+		q = q.Where("strpos(lower(artist), lower(?)) > 0", name)
+	}
+	if keywords, ok := criteria["keywords"].([]interface{}); ok && len(keywords) > 0 {
+		var clauses []string
+		var args []interface{}
+		for _, k := range keywords {
+			clauses = append(clauses, "strpos(lower(title || ' ' || coalesce(description, '') || ' ' || coalesce(tag_list, '')), lower(?)) > 0")
+			args = append(args, k)
+		}
+		q = q.Where("("+strings.Join(clauses, " OR ")+")", args...)
+	}
+	if tag, ok := criteria["tag"].(string); ok && tag != "" {
+		q = q.Where("id IN (SELECT track_id FROM track_tags WHERE tag = ?)", tag)
+	}
+	if show, _ := criteria["show_hidden"].(bool); !show {
+		q = q.Where("hidden = ?", false)
+	}
+	if kind, ok := criteria["kind"].(string); ok && kind != "" {
+		q = q.Where("kind = ?", kind)
+	}
+	if partial, ok := criteria["partial"].(bool); ok && partial {
+		q = q.Where("id IN (SELECT track_id FROM listens WHERE user_id = ? AND position > 0 AND heard = false)", userID)
+	}
+	if sources, ok := criteria["sources"].([]interface{}); ok && len(sources) > 0 {
+		q = q.Where("id IN (SELECT track_id FROM track_sources WHERE source IN (?))", sources...)
+	}
+	if excluded, ok := criteria["exclude_sources"].([]interface{}); ok && len(excluded) > 0 {
+		q = q.Where("id NOT IN (SELECT track_id FROM track_sources WHERE source IN (?))", excluded...)
+	}
+	if labels, ok := criteria["labels"].([]interface{}); ok && len(labels) > 0 {
+		q = q.Where("id IN (SELECT track_id FROM track_labels WHERE label_id IN (?))", labels...)
+	}
+	if accounts, ok := criteria["accounts"].([]interface{}); ok && len(accounts) > 0 {
+		q = q.Where("id IN (SELECT track_id FROM track_sources WHERE account_id IN (?))", accounts...)
+	}
+	if artist, ok := criteria["artist"].(string); ok && artist != "" {
+		q = q.Where("artist_soundcloud_id = ?", artist)
+	} else {
+		// Followed artists the user excluded are hidden, and once any
+		// artist is included the feed shows only included artists. Feed
+		// episodes have no Soundcloud artist and are left alone.
+		q = q.Where(`artist_soundcloud_id NOT IN (
+			SELECT a.soundcloud_id FROM followings f JOIN artists a ON a.id = f.artist_id
+			WHERE f.user_id = ? AND f.filter = ?)`, userID, ArtistFilterExclude)
+		q = q.Where(`(soundcloud_id LIKE ? OR NOT EXISTS (SELECT 1 FROM followings WHERE user_id = ? AND filter = ?) OR artist_soundcloud_id IN (
+			SELECT a.soundcloud_id FROM followings f JOIN artists a ON a.id = f.artist_id
+			WHERE f.user_id = ? AND f.filter = ?))`, rssIDPrefix+"%", userID, ArtistFilterInclude, userID, ArtistFilterInclude)
+	}
+	if collapse, ok := criteria["collapse"].(bool); ok && collapse {
+		// Duplicates are listed under their canonical track instead
+		q = q.Where("duplicate_of IS NULL")
+	}
+	return q
+}
+
+// encodeCursor builds the cursor that follows track in the given sort
+func encodeCursor(track models.Track, column string) string {
+	key := strconv.Itoa(track.Length)
+	if column == "post_time" {
+		key = strconv.FormatInt(track.PostTime.UnixNano(), 10)
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(key + "|" + track.ID.String()))
+}
+
+// decodeCursor returns the sort key and track id held by a cursor
+func decodeCursor(cursor, column string) (interface{}, uuid.UUID, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, uuid.Nil, ErrInvalidCursor
+	}
+	parts := strings.SplitN(string(raw), "|", 2)
+	if len(parts) != 2 {
+		return nil, uuid.Nil, ErrInvalidCursor
+	}
+	id, err := uuid.FromString(parts[1])
+	if err != nil {
+		return nil, uuid.Nil, ErrInvalidCursor
+	}
+	n, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, uuid.Nil, ErrInvalidCursor
+	}
+	if column == "post_time" {
+		return time.Unix(0, n).UTC(), id, nil
+	}
+	return n, id, nil
+}
+
+// setTrackAccounts names the linked accounts a track came through, for
+// users with more than one account
+func setTrackAccounts(trackMap map[string]interface{}, names map[string]string) {
+	var via []string
+	for _, id := range stringValues(trackMap["accounts"]) {
+		if name, ok := names[id]; ok {
+			via = append(via, name)
+		}
+	}
+	if len(via) > 0 {
+		trackMap["via"] = via
+	}
+}
+
+// setTrackSources adds a stored track's distinct sources, the linked
+// accounts it came through, the titles of the playlists it is in and the
+// podcast it is an episode of to its map
+func setTrackSources(trackMap map[string]interface{}, rows models.TrackSources) {
+	if len(rows) == 0 {
+		return
+	}
+	seen := map[string]bool{}
+	names := []string{}
+	playlists := []string{}
+	accounts := []string{}
+	for _, row := range rows {
+		if row.AccountID.Valid && !seen[row.AccountID.UUID.String()] {
+			seen[row.AccountID.UUID.String()] = true
+			accounts = append(accounts, row.AccountID.UUID.String())
+		}
+		if !seen[row.Source] {
+			seen[row.Source] = true
+			names = append(names, row.Source)
+		}
+		if row.Source == SourcePlaylists && row.PlaylistTitle != "" {
+			playlists = append(playlists, row.PlaylistTitle)
+		}
+		if row.Source == SourcePodcasts && row.PlaylistTitle != "" {
+			trackMap["podcast"] = row.PlaylistTitle
+		}
+	}
+	trackMap["sources"] = names
+	if len(accounts) > 0 {
+		trackMap["accounts"] = accounts
+	}
+	if len(playlists) > 0 {
+		trackMap["playlists"] = playlists
+	}
+}
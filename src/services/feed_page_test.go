@@ -0,0 +1,50 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/jbhicks/sound-cistern/src/models"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	track := models.Track{
+		ID:       uuid.Must(uuid.NewV4()),
+		Length:   3600,
+		PostTime: time.Date(2025, 3, 1, 12, 30, 0, 0, time.UTC),
+	}
+
+	key, id, err := decodeCursor(encodeCursor(track, "post_time"), "post_time")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !key.(time.Time).Equal(track.PostTime) || id != track.ID {
+		t.Errorf("Expected %v/%v, got %v/%v", track.PostTime, track.ID, key, id)
+	}
+
+	key, _, err = decodeCursor(encodeCursor(track, "length"), "length")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if key != int64(3600) {
+		t.Errorf("Expected length key 3600, got %v", key)
+	}
+}
+
+func TestDecodeCursorRejectsGarbage(t *testing.T) {
+	for _, cursor := range []string{"not-a-cursor", "bm9waXBl", "MTIzfG5vdC1hLXV1aWQ"} {
+		if _, _, err := decodeCursor(cursor, "post_time"); err != ErrInvalidCursor {
+			t.Errorf("Expected ErrInvalidCursor for %q, got %v", cursor, err)
+		}
+	}
+}
+
+func TestNormalizeSort(t *testing.T) {
+	if got := NormalizeSort("longest"); got != SortLongest {
+		t.Errorf("Expected longest, got %q", got)
+	}
+	if got := NormalizeSort("random"); got != SortNewest {
+		t.Errorf("Expected unknown sorts to fall back to newest, got %q", got)
+	}
+}
@@ -1,9 +1,16 @@
 package services
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/gobuffalo/nulls"
 	"github.com/gobuffalo/pop/v6"
 	"github.com/gofrs/uuid"
 	"github.com/jbhicks/sound-cistern/src/models"
@@ -19,8 +26,15 @@ func NewFeedService(db *pop.Connection) *FeedService {
 	return &FeedService{DB: db}
 }
 
+// with returns a copy of the service whose queries run under ctx, so they
+// are abandoned along with the request or job that made them
+func (fs *FeedService) with(ctx context.Context) *FeedService {
+	return &FeedService{DB: fs.DB.WithContext(ctx)}
+}
+
 // CacheFeed caches the feed for a user
-func (fs *FeedService) CacheFeed(userID string, tracks []interface{}) error {
+func (fs *FeedService) CacheFeed(ctx context.Context, userID string, tracks []interface{}) error {
+	fs = fs.with(ctx)
 	userUUID, err := uuid.FromString(userID)
 	if err != nil {
 		return err
@@ -52,8 +66,79 @@ func (fs *FeedService) CacheFeed(userID string, tracks []interface{}) error {
 	}
 }
 
+// CacheAccountFeed merges one linked account's feed into the user's cached
+// feed. Cached tracks remember which accounts they came through, so tracks
+// followed from several accounts appear once and tracks that dropped out of
+// this account's feed stay while another account still has them.
+func (fs *FeedService) CacheAccountFeed(ctx context.Context, userID string, accountID uuid.UUID, tracks []interface{}) ([]interface{}, error) {
+	fs = fs.with(ctx)
+	cached, err := fs.GetCachedFeed(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	merged := mergeAccountFeed(cached, accountID.String(), tracks)
+	return merged, fs.CacheFeed(ctx, userID, merged)
+}
+
+// mergeAccountFeed replaces one account's tracks in a cached feed with its
+// latest ones, de-duplicated by track id and newest first. Cached tracks
+// from before accounts were tracked are treated as this account's.
+func mergeAccountFeed(cached []interface{}, accountID string, tracks []interface{}) []interface{} {
+	byID := map[string]map[string]interface{}{}
+	var order []string
+	add := func(track map[string]interface{}, accounts []string) {
+		id := FormatID(track["id"])
+		if _, ok := byID[id]; !ok {
+			order = append(order, id)
+		}
+		copied := make(map[string]interface{}, len(track)+1)
+		for k, v := range track {
+			copied[k] = v
+		}
+		copied["accounts"] = accounts
+		byID[id] = copied
+	}
+
+	for _, t := range cached {
+		track, ok := t.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		var others []string
+		for _, id := range stringValues(track["accounts"]) {
+			if id != accountID {
+				others = append(others, id)
+			}
+		}
+		if len(others) > 0 {
+			add(track, others)
+		}
+	}
+	for _, t := range tracks {
+		track, ok := t.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		accounts := []string{accountID}
+		if existing, ok := byID[FormatID(track["id"])]; ok {
+			accounts = append(stringValues(existing["accounts"]), accountID)
+		}
+		add(track, accounts)
+	}
+
+	merged := make([]interface{}, 0, len(order))
+	for _, id := range order {
+		merged = append(merged, byID[id])
+	}
+	sort.SliceStable(merged, func(i, j int) bool {
+		return trackPostTime(merged[i].(map[string]interface{})).After(trackPostTime(merged[j].(map[string]interface{})))
+	})
+	return merged
+}
+
 // GetCachedFeed gets cached feed for user
-func (fs *FeedService) GetCachedFeed(userID string) ([]interface{}, error) {
+func (fs *FeedService) GetCachedFeed(ctx context.Context, userID string) ([]interface{}, error) {
+	fs = fs.with(ctx)
 	userUUID, err := uuid.FromString(userID)
 	if err != nil {
 		return nil, err
@@ -74,6 +159,150 @@ func (fs *FeedService) GetCachedFeed(userID string) ([]interface{}, error) {
 	return tracks, nil
 }
 
+// StoreTracks upserts tracks fetched from the stream into normalized
+// storage and returns the tracks that were not stored for the user before
+func (fs *FeedService) StoreTracks(ctx context.Context, userID string, tracks []interface{}) ([]interface{}, error) {
+	fs = fs.with(ctx)
+	return fs.storeTracks(userID, tracks, TrackOrigin{Source: SourceStream})
+}
+
+// StoreAccountTracks stores the stream tracks fetched through one linked
+// account and returns the tracks the user had not stored before
+func (fs *FeedService) StoreAccountTracks(ctx context.Context, account *models.SoundcloudAccount, tracks []interface{}) ([]interface{}, error) {
+	fs = fs.with(ctx)
+	return fs.storeTracks(account.UserID.String(), tracks, TrackOrigin{Source: SourceStream, AccountID: nulls.NewUUID(account.ID)})
+}
+
+// storeTracks upserts fetched Soundcloud tracks, tags them with where they
+// came from and returns the tracks that were not stored before
+func (fs *FeedService) storeTracks(userID string, tracks []interface{}, origin TrackOrigin) ([]interface{}, error) {
+	userUUID, err := uuid.FromString(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	tracklists := NewTracklistService(fs.DB)
+	duplicates := NewDuplicateService(fs.DB)
+	weights, err := NewClassifierService(fs.DB).Weights(userUUID)
+	if err != nil {
+		return nil, err
+	}
+
+	var newTracks []interface{}
+	for _, t := range tracks {
+		trackMap, ok := t.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		track, err := NormalizeTrack(trackMap)
+		if err != nil {
+			return newTracks, err
+		}
+		track.UserID = userUUID
+		classification := ClassifyTrack(*track, weights)
+		track.Kind = classification.Kind
+		track.KindConfidence = classification.Confidence
+
+		existing := &models.Track{}
+		err = fs.DB.Where("user_id = ? AND soundcloud_id = ?", userUUID, track.SoundcloudID).First(existing)
+		if err != nil {
+			track.ID = uuid.Must(uuid.NewV4())
+			if err := fs.DB.Create(track); err != nil {
+				return newTracks, err
+			}
+			if _, err := tracklists.Extract(track); err != nil {
+				return newTracks, err
+			}
+			if err := duplicates.Link(track); err != nil {
+				return newTracks, err
+			}
+			if err := fs.tagSource(track.ID, origin); err != nil {
+				return newTracks, err
+			}
+			newTracks = append(newTracks, trackMap)
+			continue
+		}
+
+		track.ID = existing.ID
+		track.CreatedAt = existing.CreatedAt
+		track.DuplicateOf = existing.DuplicateOf
+		track.Hidden = existing.Hidden
+		track.Pinned = existing.Pinned
+		if existing.KindOverridden {
+			track.Kind = existing.Kind
+			track.KindConfidence = existing.KindConfidence
+			track.KindOverridden = true
+		}
+		if err := fs.DB.Update(track); err != nil {
+			return newTracks, err
+		}
+		if track.Description != existing.Description {
+			if _, err := tracklists.Extract(track); err != nil {
+				return newTracks, err
+			}
+		}
+		if err := fs.tagSource(track.ID, origin); err != nil {
+			return newTracks, err
+		}
+	}
+	return newTracks, nil
+}
+
+// NormalizeTrack converts a Soundcloud API track into a Track row
+func NormalizeTrack(track map[string]interface{}) (*models.Track, error) {
+	data, err := json.Marshal(track)
+	if err != nil {
+		return nil, err
+	}
+
+	normalized := &models.Track{
+		SoundcloudID: FormatID(track["id"]),
+		Title:        stringField(track, "title"),
+		Length:       int(trackLength(track)),
+		Genre:        stringField(track, "genre"),
+		Data:         string(data),
+		PostTime:     trackPostTime(track),
+	}
+	if normalized.SoundcloudID == "" {
+		return nil, errors.New("track has no Soundcloud id")
+	}
+	if user, ok := track["user"].(map[string]interface{}); ok {
+		if artist := stringField(user, "username"); artist != "" {
+			normalized.Artist = nulls.NewString(artist)
+		}
+		normalized.ArtistID = FormatID(user["id"])
+	}
+	for field, target := range map[string]*nulls.String{
+		"description":   &normalized.Description,
+		"tag_list":      &normalized.TagList,
+		"permalink_url": &normalized.PermalinkURL,
+		"artwork_url":   &normalized.ArtworkURL,
+	} {
+		if v := stringField(track, field); v != "" {
+			*target = nulls.NewString(v)
+		}
+	}
+	return normalized, nil
+}
+
+// trackPostTime parses the time a track was posted, accepting both the
+// Soundcloud API format and RFC 3339
+func trackPostTime(track map[string]interface{}) time.Time {
+	raw := stringField(track, "created_at")
+	for _, layout := range []string{"2006/01/02 15:04:05 -0700", time.RFC3339} {
+		if t, err := time.Parse(layout, raw); err == nil {
+			return t
+		}
+	}
+	return time.Now()
+}
+
+// stringField returns a string field from a track map, or "" when missing
+func stringField(track map[string]interface{}, key string) string {
+	v, _ := track[key].(string)
+	return v
+}
+
 // FilterTracks filters tracks based on criteria
 func (fs *FeedService) FilterTracks(tracks []interface{}, criteria map[string]interface{}) []interface{} {
 	var filtered []interface{}
@@ -83,23 +312,26 @@ func (fs *FeedService) FilterTracks(tracks []interface{}, criteria map[string]in
 			filtered = append(filtered, track)
 		}
 	}
+	if collapse, ok := criteria["collapse"].(bool); ok && collapse {
+		return CollapseDuplicates(filtered)
+	}
 	return filtered
 }
 
 // matchesCriteria checks if track matches filter criteria
 func (fs *FeedService) matchesCriteria(track map[string]interface{}, criteria map[string]interface{}) bool {
 	if minLength, ok := criteria["min_length"].(float64); ok {
-		if track["length"].(float64) < minLength {
+		if trackLength(track) < minLength {
 			return false
 		}
 	}
 	if maxLength, ok := criteria["max_length"].(float64); ok {
-		if track["length"].(float64) > maxLength {
+		if trackLength(track) > maxLength {
 			return false
 		}
 	}
 	if genres, ok := criteria["genres"].([]interface{}); ok {
-		trackGenre := track["genre"].(string)
+		trackGenre, _ := track["genre"].(string)
 		found := false
 		for _, g := range genres {
 			if g.(string) == trackGenre {
@@ -112,14 +344,329 @@ func (fs *FeedService) matchesCriteria(track map[string]interface{}, criteria ma
 		}
 	}
 	if query, ok := criteria["query"].(string); ok {
-		title := track["title"].(string)
-		if !contains(title, query) {
+		title, _ := track["title"].(string)
+		if !contains(title, query) && !tracklistMatches(stringField(track, "description"), query) {
+			return false
+		}
+	}
+	if kind, ok := criteria["kind"].(string); ok && kind != "" {
+		if trackKind(track) != kind {
+			return false
+		}
+	}
+	if sources, ok := criteria["sources"].([]interface{}); ok && len(sources) > 0 {
+		if !hasAnySource(track, sources) {
+			return false
+		}
+	}
+	if excluded, ok := criteria["exclude_sources"].([]interface{}); ok && len(excluded) > 0 {
+		if hasAnySource(track, excluded) {
+			return false
+		}
+	}
+	if labels, ok := criteria["labels"].([]interface{}); ok && len(labels) > 0 {
+		if !hasAnyLabel(track, labels) {
+			return false
+		}
+	}
+	if accounts, ok := criteria["accounts"].([]interface{}); ok && len(accounts) > 0 {
+		if !hasAnyAccount(track, accounts) {
+			return false
+		}
+	}
+	if name, ok := criteria["artist_name"].(string); ok && name != "" {
+		user, _ := track["user"].(map[string]interface{})
+		if !strings.Contains(strings.ToLower(stringField(user, "username")), strings.ToLower(name)) {
+			return false
+		}
+	}
+	if keywords, ok := criteria["keywords"].([]interface{}); ok && len(keywords) > 0 {
+		if !hasAnyKeyword(track, keywords) {
+			return false
+		}
+	}
+	if tag, ok := criteria["tag"].(string); ok && tag != "" {
+		if !hasTag(track, tag) {
+			return false
+		}
+	}
+	if hidden, _ := track["hidden"].(bool); hidden {
+		if show, _ := criteria["show_hidden"].(bool); !show {
+			return false
+		}
+	}
+	if artist, ok := criteria["artist"].(string); ok && artist != "" {
+		user, _ := track["user"].(map[string]interface{})
+		if FormatID(user["id"]) != artist {
+			return false
+		}
+	}
+	if partial, ok := criteria["partial"].(bool); ok && partial {
+		position, _ := track["resume_position"].(int)
+		if position == 0 {
 			return false
 		}
 	}
 	return true
 }
 
+// hasAnyKeyword reports whether any keyword appears in a track's title,
+// description or tags, ignoring case
+func hasAnyKeyword(track map[string]interface{}, keywords []interface{}) bool {
+	text := strings.ToLower(stringField(track, "title") + " " + stringField(track, "description") + " " + stringField(track, "tag_list"))
+	for _, k := range keywords {
+		if keyword, ok := k.(string); ok && strings.Contains(text, strings.ToLower(keyword)) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasTag reports whether a rule has tagged a track
+func hasTag(track map[string]interface{}, tag string) bool {
+	switch tags := track["tags"].(type) {
+	case []string:
+		for _, t := range tags {
+			if t == tag {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, t := range tags {
+			if t == tag {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// hasAnyLabel reports whether a track carries any of the given label ids
+func hasAnyLabel(track map[string]interface{}, labelIDs []interface{}) bool {
+	labels, _ := track["labels"].([]map[string]interface{})
+	for _, label := range labels {
+		for _, id := range labelIDs {
+			if label["id"] == id {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// hasAnyAccount reports whether a track came through any of the given
+// linked account ids
+func hasAnyAccount(track map[string]interface{}, accountIDs []interface{}) bool {
+	for _, account := range stringValues(track["accounts"]) {
+		for _, id := range accountIDs {
+			if id == account {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// stringValues reads a list of strings from a track map, whether it was
+// built in memory or decoded from the cached JSON
+func stringValues(v interface{}) []string {
+	switch values := v.(type) {
+	case []string:
+		return values
+	case []interface{}:
+		strs := make([]string, 0, len(values))
+		for _, value := range values {
+			if s, ok := value.(string); ok {
+				strs = append(strs, s)
+			}
+		}
+		return strs
+	}
+	return nil
+}
+
+// hasAnySource reports whether a track came from any of the given sources
+func hasAnySource(track map[string]interface{}, sources []interface{}) bool {
+	for _, name := range trackSourceNames(track) {
+		for _, s := range sources {
+			if s == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// trackKind returns a track's stored kind, classifying tracks that have
+// not been stored yet
+func trackKind(track map[string]interface{}) string {
+	if kind, ok := track["kind"].(string); ok && kind != "" {
+		return kind
+	}
+	normalized, err := NormalizeTrack(track)
+	if err != nil {
+		return ""
+	}
+	return ClassifyTrack(*normalized, nil).Kind
+}
+
+// trackLength returns a track's length in seconds, falling back to the
+// millisecond "duration" field Soundcloud returns
+func trackLength(track map[string]interface{}) float64 {
+	if length, ok := track["length"].(float64); ok {
+		return length
+	}
+	if duration, ok := track["duration"].(float64); ok {
+		return duration / 1000
+	}
+	return 0
+}
+
+// CriteriaFromValues builds filter criteria from form or query values,
+// using the same shapes FilterTracks expects from a JSON body
+func CriteriaFromValues(values url.Values) map[string]interface{} {
+	criteria := map[string]interface{}{}
+	for _, key := range []string{"min_length", "max_length"} {
+		if v := strings.TrimSpace(values.Get(key)); v != "" {
+			if n, err := strconv.ParseFloat(v, 64); err == nil {
+				criteria[key] = n
+			}
+		}
+	}
+	var genres []interface{}
+	for _, v := range values["genres"] {
+		for _, g := range strings.Split(v, ",") {
+			if g = strings.TrimSpace(g); g != "" {
+				genres = append(genres, g)
+			}
+		}
+	}
+	if len(genres) > 0 {
+		criteria["genres"] = genres
+	}
+	if query := strings.TrimSpace(values.Get("query")); query != "" {
+		criteria["query"] = query
+	}
+	if name := strings.TrimSpace(values.Get("artist_name")); name != "" {
+		criteria["artist_name"] = name
+	}
+	var keywords []interface{}
+	for _, v := range values["keywords"] {
+		for _, k := range strings.Split(v, ",") {
+			if k = strings.TrimSpace(k); k != "" {
+				keywords = append(keywords, k)
+			}
+		}
+	}
+	if len(keywords) > 0 {
+		criteria["keywords"] = keywords
+	}
+	if tag := strings.TrimSpace(values.Get("tag")); tag != "" {
+		criteria["tag"] = tag
+	}
+	if kind := values.Get("kind"); KindLabels[kind] != "" {
+		criteria["kind"] = kind
+	}
+	for _, key := range []string{"sources", "exclude_sources"} {
+		var sources []interface{}
+		for _, source := range values[key] {
+			if SourceLabels[source] != "" {
+				sources = append(sources, source)
+			}
+		}
+		if len(sources) > 0 {
+			criteria[key] = sources
+		}
+	}
+	for _, key := range []string{"labels", "accounts"} {
+		var ids []interface{}
+		for _, id := range values[key] {
+			if _, err := uuid.FromString(id); err == nil {
+				ids = append(ids, id)
+			}
+		}
+		if len(ids) > 0 {
+			criteria[key] = ids
+		}
+	}
+	if artist := strings.TrimSpace(values.Get("artist")); artist != "" {
+		criteria["artist"] = artist
+	}
+	if partial, err := strconv.ParseBool(values.Get("partial")); err == nil && partial {
+		criteria["partial"] = true
+	}
+	if collapse, err := strconv.ParseBool(values.Get("collapse")); err == nil && collapse {
+		criteria["collapse"] = true
+	}
+	if show, err := strconv.ParseBool(values.Get("show_hidden")); err == nil && show {
+		criteria["show_hidden"] = true
+	}
+	return criteria
+}
+
+// CriteriaValues is the inverse of CriteriaFromValues. It encodes criteria
+// as query values so a filtered view can be linked to.
+func CriteriaValues(criteria map[string]interface{}) url.Values {
+	values := url.Values{}
+	for _, key := range []string{"min_length", "max_length"} {
+		if n, ok := criteria[key].(float64); ok {
+			values.Set(key, strconv.FormatFloat(n, 'f', -1, 64))
+		}
+	}
+	if genres, ok := criteria["genres"].([]interface{}); ok && len(genres) > 0 {
+		names := make([]string, 0, len(genres))
+		for _, g := range genres {
+			if name, ok := g.(string); ok {
+				names = append(names, name)
+			}
+		}
+		values.Set("genres", strings.Join(names, ", "))
+	}
+	if query, ok := criteria["query"].(string); ok && query != "" {
+		values.Set("query", query)
+	}
+	if name, ok := criteria["artist_name"].(string); ok && name != "" {
+		values.Set("artist_name", name)
+	}
+	if keywords, ok := criteria["keywords"].([]interface{}); ok && len(keywords) > 0 {
+		words := make([]string, 0, len(keywords))
+		for _, k := range keywords {
+			if word, ok := k.(string); ok {
+				words = append(words, word)
+			}
+		}
+		values.Set("keywords", strings.Join(words, ", "))
+	}
+	if tag, ok := criteria["tag"].(string); ok && tag != "" {
+		values.Set("tag", tag)
+	}
+	if kind, ok := criteria["kind"].(string); ok && kind != "" {
+		values.Set("kind", kind)
+	}
+	for _, key := range []string{"sources", "exclude_sources", "labels", "accounts"} {
+		if sources, ok := criteria[key].([]interface{}); ok {
+			for _, source := range sources {
+				if name, ok := source.(string); ok {
+					values.Add(key, name)
+				}
+			}
+		}
+	}
+	if artist, ok := criteria["artist"].(string); ok && artist != "" {
+		values.Set("artist", artist)
+	}
+	if partial, ok := criteria["partial"].(bool); ok && partial {
+		values.Set("partial", "true")
+	}
+	if collapse, ok := criteria["collapse"].(bool); ok && collapse {
+		values.Set("collapse", "true")
+	}
+	if show, ok := criteria["show_hidden"].(bool); ok && show {
+		values.Set("show_hidden", "true")
+	}
+	return values
+}
+
 // contains checks if s contains substr
 func contains(s, substr string) bool {
 	return len(s) >= len(substr) && (s == substr || len(substr) == 0 || contains(s[1:], substr))
@@ -0,0 +1,246 @@
+package services
+
+import (
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCriteriaFromValues(t *testing.T) {
+	values := url.Values{
+		"min_length": {"60"},
+		"max_length": {""},
+		"genres":     {"Techno, House", "Ambient"},
+		"query":      {"  live  "},
+	}
+
+	criteria := CriteriaFromValues(values)
+
+	if criteria["min_length"] != float64(60) {
+		t.Errorf("Expected min_length 60, got %v", criteria["min_length"])
+	}
+	if _, ok := criteria["max_length"]; ok {
+		t.Error("Expected empty max_length to be omitted")
+	}
+	wantGenres := []interface{}{"Techno", "House", "Ambient"}
+	if !reflect.DeepEqual(criteria["genres"], wantGenres) {
+		t.Errorf("Expected genres %v, got %v", wantGenres, criteria["genres"])
+	}
+	if criteria["query"] != "live" {
+		t.Errorf("Expected trimmed query, got %q", criteria["query"])
+	}
+}
+
+func TestFilterTracksUsesDuration(t *testing.T) {
+	fs := NewFeedService(nil)
+	tracks := []interface{}{
+		map[string]interface{}{"title": "Short edit", "duration": float64(180000), "genre": "House"},
+		map[string]interface{}{"title": "Long mix", "duration": float64(3600000), "genre": "Techno"},
+		map[string]interface{}{"title": "Untagged mix", "duration": float64(5400000), "genre": nil},
+	}
+
+	filtered := fs.FilterTracks(tracks, map[string]interface{}{"min_length": float64(600)})
+	if len(filtered) != 2 {
+		t.Fatalf("Expected 2 long tracks, got %d", len(filtered))
+	}
+
+	filtered = fs.FilterTracks(tracks, map[string]interface{}{"genres": []interface{}{"Techno"}})
+	if len(filtered) != 1 || filtered[0].(map[string]interface{})["title"] != "Long mix" {
+		t.Errorf("Expected only the techno track, got %v", filtered)
+	}
+}
+
+func TestNormalizeTrack(t *testing.T) {
+	track, err := NormalizeTrack(map[string]interface{}{
+		"id":            float64(123456789),
+		"title":         "Boiler Room: Live Set",
+		"duration":      float64(5400000),
+		"genre":         "Techno",
+		"created_at":    "2025/09/28 20:15:00 +0000",
+		"user":          map[string]interface{}{"username": "someartist"},
+		"permalink_url": "https://soundcloud.com/someartist/live-set",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if track.SoundcloudID != "123456789" {
+		t.Errorf("Expected plain numeric id, got %s", track.SoundcloudID)
+	}
+	if track.Length != 5400 {
+		t.Errorf("Expected length in seconds, got %d", track.Length)
+	}
+	if track.Artist.String != "someartist" {
+		t.Errorf("Expected artist from user, got %q", track.Artist.String)
+	}
+	if track.PostTime.Year() != 2025 || track.PostTime.Month() != 9 {
+		t.Errorf("Unexpected post time %s", track.PostTime)
+	}
+	if track.Description.Valid {
+		t.Error("Expected missing description to stay null")
+	}
+
+	if _, err := NormalizeTrack(map[string]interface{}{"title": "No id"}); err == nil {
+		t.Error("Expected an error for a track without an id")
+	}
+}
+
+func TestCriteriaValuesRoundTrip(t *testing.T) {
+	values := url.Values{
+		"min_length":  {"60"},
+		"genres":      {"Techno, House"},
+		"query":       {"live"},
+		"partial":     {"true"},
+		"collapse":    {"true"},
+		"artist":      {"123"},
+		"sources":     {"likes", "playlists"},
+		"artist_name": {"dj"},
+		"keywords":    {"edit, bootleg"},
+		"tag":         {"boots"},
+		"show_hidden": {"true"},
+		"labels":      {"6ba7b810-9dad-11d1-80b4-00c04fd430c8"},
+	}
+
+	got := CriteriaValues(CriteriaFromValues(values))
+	if !reflect.DeepEqual(got, values) {
+		t.Errorf("Expected %v, got %v", values, got)
+	}
+	if encoded := CriteriaValues(map[string]interface{}{}).Encode(); encoded != "" {
+		t.Errorf("Expected empty criteria to encode to nothing, got %q", encoded)
+	}
+}
+
+func TestFilterTracksPartiallyListened(t *testing.T) {
+	fs := NewFeedService(nil)
+	tracks := []interface{}{
+		map[string]interface{}{"title": "Half way", "resume_position": 1800},
+		map[string]interface{}{"title": "Not started"},
+	}
+
+	filtered := fs.FilterTracks(tracks, map[string]interface{}{"partial": true})
+	if len(filtered) != 1 || filtered[0].(map[string]interface{})["title"] != "Half way" {
+		t.Errorf("Expected only the partially listened track, got %v", filtered)
+	}
+}
+
+func TestFilterTracksByArtist(t *testing.T) {
+	fs := NewFeedService(nil)
+	tracks := []interface{}{
+		map[string]interface{}{"title": "Archangel", "user": map[string]interface{}{"id": float64(55)}},
+		map[string]interface{}{"title": "Other", "user": map[string]interface{}{"id": float64(56)}},
+		map[string]interface{}{"title": "No poster"},
+	}
+
+	filtered := fs.FilterTracks(tracks, map[string]interface{}{"artist": "55"})
+	if len(filtered) != 1 || filtered[0].(map[string]interface{})["title"] != "Archangel" {
+		t.Errorf("Expected only the artist's track, got %v", filtered)
+	}
+}
+
+func TestFilterTracksBySource(t *testing.T) {
+	fs := NewFeedService(nil)
+	tracks := []interface{}{
+		map[string]interface{}{"title": "Liked", "sources": []string{SourceLikes}},
+		map[string]interface{}{"title": "Liked and streamed", "sources": []string{SourceStream, SourceLikes}},
+		map[string]interface{}{"title": "Cached stream track"},
+	}
+
+	filtered := fs.FilterTracks(tracks, map[string]interface{}{"sources": []interface{}{SourceLikes}})
+	if len(filtered) != 2 {
+		t.Errorf("Expected both liked tracks, got %v", filtered)
+	}
+
+	filtered = fs.FilterTracks(tracks, map[string]interface{}{"exclude_sources": []interface{}{SourceStream}})
+	if len(filtered) != 1 || filtered[0].(map[string]interface{})["title"] != "Liked" {
+		t.Errorf("Expected only the track not in the stream, got %v", filtered)
+	}
+}
+
+func TestCriteriaFromValuesIgnoresUnknownSources(t *testing.T) {
+	criteria := CriteriaFromValues(url.Values{"sources": {"likes", "radio"}})
+	if !reflect.DeepEqual(criteria["sources"], []interface{}{"likes"}) {
+		t.Errorf("Expected only known sources, got %v", criteria["sources"])
+	}
+}
+
+func TestFilterTracksByArtistNameAndKeywords(t *testing.T) {
+	fs := NewFeedService(nil)
+	tracks := []interface{}{
+		map[string]interface{}{"title": "Track (Club Edit)", "user": map[string]interface{}{"username": "DJ Sprinkles"}},
+		map[string]interface{}{"title": "Track", "tag_list": "bootleg house", "user": map[string]interface{}{"username": "Other"}},
+		map[string]interface{}{"title": "Original", "description": "nothing here", "user": map[string]interface{}{"username": "Another DJ"}},
+	}
+
+	filtered := fs.FilterTracks(tracks, map[string]interface{}{"keywords": []interface{}{"edit", "BOOTLEG"}})
+	if len(filtered) != 2 {
+		t.Errorf("Expected the edit and the bootleg, got %v", filtered)
+	}
+
+	filtered = fs.FilterTracks(tracks, map[string]interface{}{"artist_name": "dj"})
+	if len(filtered) != 2 {
+		t.Errorf("Expected both DJs, got %v", filtered)
+	}
+}
+
+func TestFilterTracksSkipsHiddenUnlessAsked(t *testing.T) {
+	fs := NewFeedService(nil)
+	tracks := []interface{}{
+		map[string]interface{}{"title": "Hidden", "hidden": true, "tags": []string{"boots"}},
+		map[string]interface{}{"title": "Visible"},
+	}
+
+	if filtered := fs.FilterTracks(tracks, map[string]interface{}{}); len(filtered) != 1 {
+		t.Errorf("Expected hidden tracks to be skipped, got %v", filtered)
+	}
+	filtered := fs.FilterTracks(tracks, map[string]interface{}{"show_hidden": true, "tag": "boots"})
+	if len(filtered) != 1 || filtered[0].(map[string]interface{})["title"] != "Hidden" {
+		t.Errorf("Expected the hidden tagged track, got %v", filtered)
+	}
+}
+
+func TestFilterTracksByLabel(t *testing.T) {
+	fs := NewFeedService(nil)
+	warm := "6ba7b810-9dad-11d1-80b4-00c04fd430c8"
+	tracks := []interface{}{
+		map[string]interface{}{"title": "Opener", "labels": []map[string]interface{}{{"id": warm, "name": "Warm-up"}}},
+		map[string]interface{}{"title": "Unlabeled"},
+	}
+
+	filtered := fs.FilterTracks(tracks, CriteriaFromValues(url.Values{"labels": {warm, "not-a-label"}}))
+	if len(filtered) != 1 || filtered[0].(map[string]interface{})["title"] != "Opener" {
+		t.Errorf("Expected only the labeled track, got %v", filtered)
+	}
+}
+
+func TestMergeAccountFeed(t *testing.T) {
+	personal := "6ba7b810-9dad-11d1-80b4-00c04fd430c8"
+	label := "6ba7b811-9dad-11d1-80b4-00c04fd430c8"
+	cached := []interface{}{
+		map[string]interface{}{"id": float64(1), "title": "Both", "created_at": "2025/01/02 00:00:00 +0000", "accounts": []interface{}{personal, label}},
+		map[string]interface{}{"id": float64(2), "title": "Dropped", "created_at": "2025/01/01 00:00:00 +0000", "accounts": []interface{}{label}},
+		map[string]interface{}{"id": float64(3), "title": "Personal only", "created_at": "2025/01/03 00:00:00 +0000", "accounts": []interface{}{personal}},
+	}
+	fetched := []interface{}{
+		map[string]interface{}{"id": float64(1), "title": "Both", "created_at": "2025/01/02 00:00:00 +0000"},
+		map[string]interface{}{"id": float64(4), "title": "New", "created_at": "2025/01/04 00:00:00 +0000"},
+	}
+
+	merged := mergeAccountFeed(cached, label, fetched)
+	var titles []string
+	for _, t := range merged {
+		titles = append(titles, t.(map[string]interface{})["title"].(string))
+	}
+	if strings.Join(titles, ",") != "New,Personal only,Both" {
+		t.Fatalf("Expected the label's latest tracks merged newest first, got %v", titles)
+	}
+	both := merged[2].(map[string]interface{})
+	if accounts := stringValues(both["accounts"]); len(accounts) != 2 {
+		t.Errorf("Expected the shared track to keep both accounts, got %v", accounts)
+	}
+
+	filtered := NewFeedService(nil).FilterTracks(merged, CriteriaFromValues(url.Values{"accounts": {personal}}))
+	if len(filtered) != 2 {
+		t.Errorf("Expected the personal account's two tracks, got %v", filtered)
+	}
+}
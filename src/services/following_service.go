@@ -0,0 +1,208 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/gobuffalo/nulls"
+	"github.com/gobuffalo/pop/v6"
+	"github.com/gofrs/uuid"
+	"github.com/jbhicks/sound-cistern/src/models"
+)
+
+// Per-artist feed filters
+const (
+	ArtistFilterInclude = "include"
+	ArtistFilterExclude = "exclude"
+)
+
+var (
+	// ErrArtistNotFound is returned when the user does not follow an artist
+	ErrArtistNotFound = errors.New("artist not found")
+	// ErrUnknownArtistFilter is returned for a filter other than include or exclude
+	ErrUnknownArtistFilter = errors.New("unknown artist filter")
+)
+
+// FollowedArtist is an artist along with the user's feed filter for them
+type FollowedArtist struct {
+	Artist models.Artist
+	Filter string
+}
+
+// FollowingService keeps the artists each user follows on Soundcloud
+type FollowingService struct {
+	DB *pop.Connection
+}
+
+// NewFollowingService creates a new service
+func NewFollowingService(db *pop.Connection) *FollowingService {
+	return &FollowingService{DB: db}
+}
+
+// Store replaces the user's followings with the given Soundcloud accounts
+// and returns how many were stored. Artists are upserted by Soundcloud id;
+// accounts the user no longer follows are dropped along with their
+// filters. Each artist's last post date is taken from the stored tracks.
+func (fs *FollowingService) Store(userID string, accounts []interface{}) (int, error) {
+	userUUID, err := uuid.FromString(userID)
+	if err != nil {
+		return 0, err
+	}
+
+	var kept []interface{}
+	for _, a := range accounts {
+		account, ok := a.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		artist, err := fs.storeArtist(account)
+		if err != nil {
+			return len(kept), err
+		}
+		if artist == nil {
+			continue
+		}
+
+		following := &models.Following{}
+		if err := fs.DB.Where("user_id = ? AND artist_id = ?", userUUID, artist.ID).First(following); err != nil {
+			following = &models.Following{
+				ID:       uuid.Must(uuid.NewV4()),
+				UserID:   userUUID,
+				ArtistID: artist.ID,
+			}
+			if err := fs.DB.Create(following); err != nil {
+				return len(kept), err
+			}
+		}
+		kept = append(kept, artist.ID)
+	}
+
+	unfollowed := "DELETE FROM followings WHERE user_id = ?"
+	if len(kept) > 0 {
+		unfollowed += " AND artist_id NOT IN (" + strings.TrimSuffix(strings.Repeat("?,", len(kept)), ",") + ")"
+	}
+	if err := fs.DB.RawQuery(unfollowed, append([]interface{}{userUUID}, kept...)...).Exec(); err != nil {
+		return len(kept), err
+	}
+
+	err = fs.DB.RawQuery(`UPDATE artists SET last_post_at = (
+		SELECT max(post_time) FROM soundcloud_tracks WHERE artist_soundcloud_id = artists.soundcloud_id)
+		WHERE id IN (SELECT artist_id FROM followings WHERE user_id = ?)`, userUUID).Exec()
+	return len(kept), err
+}
+
+// storeArtist upserts an artist from a Soundcloud user. Accounts without
+// an id are skipped and return nil.
+func (fs *FollowingService) storeArtist(account map[string]interface{}) (*models.Artist, error) {
+	soundcloudID := FormatID(account["id"])
+	if soundcloudID == "" {
+		return nil, nil
+	}
+
+	artist := &models.Artist{}
+	exists := fs.DB.Where("soundcloud_id = ?", soundcloudID).First(artist) == nil
+	if !exists {
+		artist = &models.Artist{ID: uuid.Must(uuid.NewV4()), SoundcloudID: soundcloudID}
+	}
+	artist.Username = stringField(account, "username")
+	artist.PermalinkURL = nulls.String{}
+	if v := stringField(account, "permalink_url"); v != "" {
+		artist.PermalinkURL = nulls.NewString(v)
+	}
+	artist.AvatarURL = nulls.String{}
+	if v := stringField(account, "avatar_url"); v != "" {
+		artist.AvatarURL = nulls.NewString(v)
+	}
+	followers, _ := account["followers_count"].(float64)
+	artist.FollowersCount = int(followers)
+	tracks, _ := account["track_count"].(float64)
+	artist.TrackCount = int(tracks)
+
+	if exists {
+		return artist, fs.DB.Update(artist)
+	}
+	return artist, fs.DB.Create(artist)
+}
+
+// Followings returns the artists the user follows by name
+func (fs *FollowingService) Followings(userID string) ([]FollowedArtist, error) {
+	return fs.followed(userID, "lower(username) asc", "")
+}
+
+// Artist returns an artist the user follows by Soundcloud id
+func (fs *FollowingService) Artist(userID, soundcloudID string) (*FollowedArtist, error) {
+	artists, err := fs.followed(userID, "username asc", "soundcloud_id = ?", soundcloudID)
+	if err != nil {
+		return nil, err
+	}
+	if len(artists) == 0 {
+		return nil, ErrArtistNotFound
+	}
+	return &artists[0], nil
+}
+
+// Inactive returns the followed artists with no stored track posted since
+// the given time, longest quiet first
+func (fs *FollowingService) Inactive(userID string, since time.Time) ([]FollowedArtist, error) {
+	return fs.followed(userID, "last_post_at asc nulls first", "(last_post_at IS NULL OR last_post_at < ?)", since)
+}
+
+// SetFilter includes an artist in or excludes them from the user's feed,
+// or clears the filter when filter is empty
+func (fs *FollowingService) SetFilter(userID, soundcloudID, filter string) (*FollowedArtist, error) {
+	if filter != "" && filter != ArtistFilterInclude && filter != ArtistFilterExclude {
+		return nil, ErrUnknownArtistFilter
+	}
+	followed, err := fs.Artist(userID, soundcloudID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = fs.DB.RawQuery("UPDATE followings SET filter = ?, updated_at = ? WHERE user_id = ? AND artist_id = ?",
+		filter, time.Now(), uuid.FromStringOrNil(userID), followed.Artist.ID).Exec()
+	if err != nil {
+		return nil, err
+	}
+	followed.Filter = filter
+	return followed, nil
+}
+
+// followed loads the user's followed artists that match an optional
+// condition on the artists table, in the given order
+func (fs *FollowingService) followed(userID, order, where string, args ...interface{}) ([]FollowedArtist, error) {
+	userUUID, err := uuid.FromString(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	followings := models.Followings{}
+	if err := fs.DB.Where("user_id = ?", userUUID).All(&followings); err != nil {
+		return nil, err
+	}
+	if len(followings) == 0 {
+		return []FollowedArtist{}, nil
+	}
+
+	filters := map[uuid.UUID]string{}
+	ids := make([]interface{}, len(followings))
+	for i, following := range followings {
+		filters[following.ArtistID] = following.Filter
+		ids[i] = following.ArtistID
+	}
+
+	q := fs.DB.Where("id IN (?)", ids...)
+	if where != "" {
+		q = q.Where(where, args...)
+	}
+	artists := models.Artists{}
+	if err := q.Order(order).All(&artists); err != nil {
+		return nil, err
+	}
+
+	followed := make([]FollowedArtist, len(artists))
+	for i, artist := range artists {
+		followed[i] = FollowedArtist{Artist: artist, Filter: filters[artist.ID]}
+	}
+	return followed, nil
+}
@@ -0,0 +1,293 @@
+package services
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gobuffalo/pop/v6"
+	"github.com/gofrs/uuid"
+	"github.com/jbhicks/sound-cistern/src/models"
+)
+
+// DefaultLabelColor is used when a label is created without a color
+const DefaultLabelColor = "#6c757d"
+
+// maxLabelName is the longest label name the labels table holds
+const maxLabelName = 50
+
+var (
+	// ErrLabelNotFound is returned when a label does not exist for the user
+	ErrLabelNotFound = errors.New("label not found")
+	// ErrInvalidLabel is returned for a label without a name or with a
+	// color that is not #rrggbb
+	ErrInvalidLabel = errors.New("labels need a name of up to 50 characters and a #rrggbb color")
+	// ErrDuplicateLabel is returned when the user already has a label with
+	// the same name
+	ErrDuplicateLabel = errors.New("you already have a label with that name")
+)
+
+// labelColorPattern matches the colors an HTML color input produces
+var labelColorPattern = regexp.MustCompile(`^#[0-9a-f]{6}$`)
+
+// LabelService keeps each user's labels and notes on stored tracks
+type LabelService struct {
+	DB *pop.Connection
+}
+
+// NewLabelService creates a new service
+func NewLabelService(db *pop.Connection) *LabelService {
+	return &LabelService{DB: db}
+}
+
+// Labels returns the user's labels by name
+func (ls *LabelService) Labels(userID string) (models.Labels, error) {
+	labels := models.Labels{}
+	err := ls.DB.Where("user_id = ?", userID).Order("lower(name) asc").All(&labels)
+	return labels, err
+}
+
+// Label returns one of the user's labels
+func (ls *LabelService) Label(userID, labelID string) (*models.Label, error) {
+	labelUUID, err := uuid.FromString(labelID)
+	if err != nil {
+		return nil, ErrLabelNotFound
+	}
+	label := &models.Label{}
+	if err := ls.DB.Where("id = ? AND user_id = ?", labelUUID, userID).First(label); err != nil {
+		return nil, ErrLabelNotFound
+	}
+	return label, nil
+}
+
+// Create adds a label for the user. Colors are stored lowercase.
+func (ls *LabelService) Create(userID, name, color string) (*models.Label, error) {
+	userUUID, err := uuid.FromString(userID)
+	if err != nil {
+		return nil, err
+	}
+	name = strings.TrimSpace(name)
+	color = strings.ToLower(strings.TrimSpace(color))
+	if color == "" {
+		color = DefaultLabelColor
+	}
+	if name == "" || len(name) > maxLabelName || !labelColorPattern.MatchString(color) {
+		return nil, ErrInvalidLabel
+	}
+
+	count, err := ls.DB.Where("user_id = ? AND lower(name) = lower(?)", userUUID, name).Count(&models.Label{})
+	if err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, ErrDuplicateLabel
+	}
+
+	label := &models.Label{ID: uuid.Must(uuid.NewV4()), UserID: userUUID, Name: name, Color: color}
+	return label, ls.DB.Create(label)
+}
+
+// Counts returns how many stored tracks carry each of the user's labels
+func (ls *LabelService) Counts(userID string) (map[uuid.UUID]int, error) {
+	var rows []struct {
+		LabelID uuid.UUID `db:"label_id"`
+		Count   int       `db:"count"`
+	}
+	err := ls.DB.RawQuery(`SELECT track_labels.label_id, count(*) AS count FROM track_labels
+		JOIN labels ON labels.id = track_labels.label_id
+		WHERE labels.user_id = ? GROUP BY track_labels.label_id`, userID).All(&rows)
+	if err != nil {
+		return nil, err
+	}
+	counts := map[uuid.UUID]int{}
+	for _, row := range rows {
+		counts[row.LabelID] = row.Count
+	}
+	return counts, nil
+}
+
+// SetTrackLabels replaces the labels on one of the user's stored tracks.
+// Ids of labels the user does not own are ignored.
+func (ls *LabelService) SetTrackLabels(userID string, track *models.Track, labelIDs []string) error {
+	if err := ls.DB.RawQuery("DELETE FROM track_labels WHERE track_id = ?", track.ID).Exec(); err != nil {
+		return err
+	}
+	for _, id := range labelIDs {
+		label, err := ls.Label(userID, id)
+		if errors.Is(err, ErrLabelNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := ls.attach(track.ID, label.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LabelSelection puts a label on every stored track matching the feed
+// filter criteria and returns how many tracks were newly labeled
+func (ls *LabelService) LabelSelection(userID string, label *models.Label, criteria map[string]interface{}) (int, error) {
+	userUUID, err := uuid.FromString(userID)
+	if err != nil {
+		return 0, err
+	}
+
+	tracks := models.Tracks{}
+	q := filterQuery(ls.DB.Where("user_id = ?", userUUID), userUUID, criteria)
+	if err := q.Where("id NOT IN (SELECT track_id FROM track_labels WHERE label_id = ?)", label.ID).Select("id").All(&tracks); err != nil {
+		return 0, err
+	}
+	for _, track := range tracks {
+		if err := ls.attach(track.ID, label.ID); err != nil {
+			return 0, err
+		}
+	}
+	return len(tracks), nil
+}
+
+// TrackLabels returns the labels on each track, keyed by track id, for
+// display on feed cards
+func (ls *LabelService) TrackLabels(trackIDs []uuid.UUID) (map[uuid.UUID][]map[string]interface{}, error) {
+	labels := map[uuid.UUID][]map[string]interface{}{}
+	if len(trackIDs) == 0 {
+		return labels, nil
+	}
+	ids := make([]interface{}, len(trackIDs))
+	for i, id := range trackIDs {
+		ids[i] = id
+	}
+
+	var rows []struct {
+		TrackID uuid.UUID `db:"track_id"`
+		ID      uuid.UUID `db:"id"`
+		Name    string    `db:"name"`
+		Color   string    `db:"color"`
+	}
+	query := `SELECT track_labels.track_id, labels.id, labels.name, labels.color FROM track_labels
+		JOIN labels ON labels.id = track_labels.label_id
+		WHERE track_labels.track_id IN (` + strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",") + `)
+		ORDER BY lower(labels.name) asc`
+	if err := ls.DB.RawQuery(query, ids...).All(&rows); err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		labels[row.TrackID] = append(labels[row.TrackID], map[string]interface{}{
+			"id":    row.ID.String(),
+			"name":  row.Name,
+			"color": row.Color,
+		})
+	}
+	return labels, nil
+}
+
+// Notes returns the note on each track that has one, keyed by track id
+func (ls *LabelService) Notes(trackIDs []uuid.UUID) (map[uuid.UUID]string, error) {
+	notes := map[uuid.UUID]string{}
+	if len(trackIDs) == 0 {
+		return notes, nil
+	}
+	ids := make([]interface{}, len(trackIDs))
+	for i, id := range trackIDs {
+		ids[i] = id
+	}
+	rows := models.TrackNotes{}
+	if err := ls.DB.Where("track_id IN (?)", ids...).All(&rows); err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		notes[row.TrackID] = row.Body
+	}
+	return notes, nil
+}
+
+// SetNote saves the note on a stored track. An empty note removes it.
+func (ls *LabelService) SetNote(track *models.Track, body string) error {
+	body = strings.TrimSpace(body)
+	if body == "" {
+		return ls.DB.RawQuery("DELETE FROM track_notes WHERE track_id = ?", track.ID).Exec()
+	}
+
+	note := &models.TrackNote{}
+	if err := ls.DB.Where("track_id = ?", track.ID).First(note); err != nil {
+		return ls.DB.Create(&models.TrackNote{ID: uuid.Must(uuid.NewV4()), TrackID: track.ID, Body: body})
+	}
+	return ls.DB.RawQuery("UPDATE track_notes SET body = ?, updated_at = ? WHERE id = ?", body, time.Now(), note.ID).Exec()
+}
+
+// Annotate adds the labels and note of each stored track to Soundcloud
+// track maps such as the cached feed, so labels can be filtered on and
+// exported with them. The maps are copied rather than changed.
+func (ls *LabelService) Annotate(userID string, tracks []interface{}) ([]interface{}, error) {
+	userUUID, err := uuid.FromString(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var soundcloudIDs []interface{}
+	for _, t := range tracks {
+		if track, ok := t.(map[string]interface{}); ok {
+			soundcloudIDs = append(soundcloudIDs, FormatID(track["id"]))
+		}
+	}
+	if len(soundcloudIDs) == 0 {
+		return tracks, nil
+	}
+	stored := models.Tracks{}
+	if err := ls.DB.Where("user_id = ?", userUUID).Where("soundcloud_id IN (?)", soundcloudIDs...).Select("id", "soundcloud_id").All(&stored); err != nil {
+		return nil, err
+	}
+	storedIDs := map[string]uuid.UUID{}
+	trackIDs := make([]uuid.UUID, len(stored))
+	for i, track := range stored {
+		storedIDs[track.SoundcloudID] = track.ID
+		trackIDs[i] = track.ID
+	}
+	labels, err := ls.TrackLabels(trackIDs)
+	if err != nil {
+		return nil, err
+	}
+	notes, err := ls.Notes(trackIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	annotated := make([]interface{}, 0, len(tracks))
+	for _, t := range tracks {
+		track, ok := t.(map[string]interface{})
+		if !ok {
+			annotated = append(annotated, t)
+			continue
+		}
+		id, stored := storedIDs[FormatID(track["id"])]
+		_, hasNote := notes[id]
+		if !stored || (len(labels[id]) == 0 && !hasNote) {
+			annotated = append(annotated, track)
+			continue
+		}
+		copied := make(map[string]interface{}, len(track)+2)
+		for k, v := range track {
+			copied[k] = v
+		}
+		if len(labels[id]) > 0 {
+			copied["labels"] = labels[id]
+		}
+		if hasNote {
+			copied["note"] = notes[id]
+		}
+		annotated = append(annotated, copied)
+	}
+	return annotated, nil
+}
+
+// attach puts a label on a track once
+func (ls *LabelService) attach(trackID, labelID uuid.UUID) error {
+	count, err := ls.DB.Where("track_id = ? AND label_id = ?", trackID, labelID).Count(&models.TrackLabel{})
+	if err != nil || count > 0 {
+		return err
+	}
+	return ls.DB.Create(&models.TrackLabel{ID: uuid.Must(uuid.NewV4()), TrackID: trackID, LabelID: labelID})
+}
@@ -0,0 +1,88 @@
+package services
+
+import (
+	"time"
+
+	"github.com/gobuffalo/nulls"
+	"github.com/gobuffalo/pop/v6"
+	"github.com/gofrs/uuid"
+	"github.com/jbhicks/sound-cistern/src/models"
+)
+
+// listenCompleteMargin is how close to the end, in seconds, a listen has
+// to get to count as heard. Mixes often end in a long fade or applause.
+const listenCompleteMargin = 30
+
+// ListenService records playback positions so long tracks can be resumed
+type ListenService struct {
+	DB *pop.Connection
+}
+
+// NewListenService creates a new service
+func NewListenService(db *pop.Connection) *ListenService {
+	return &ListenService{DB: db}
+}
+
+// RecordPosition saves how far the user has got through a stored track.
+// A listen that ends, or reaches the last listenCompleteMargin seconds, marks
+// the track as heard and clears the resume position.
+func (ls *ListenService) RecordPosition(userID, soundcloudID string, position, duration int, ended bool) (*models.Listen, error) {
+	track, err := NewFeedService(ls.DB).Track(ls.DB.Context(), userID, soundcloudID)
+	if err != nil {
+		return nil, err
+	}
+
+	listen := &models.Listen{}
+	exists := ls.DB.Where("user_id = ? AND track_id = ?", track.UserID, track.ID).First(listen) == nil
+	if !exists {
+		listen = &models.Listen{
+			ID:      uuid.Must(uuid.NewV4()),
+			UserID:  track.UserID,
+			TrackID: track.ID,
+		}
+	}
+
+	if duration <= 0 {
+		duration = track.Length
+	}
+	if position < 0 {
+		position = 0
+	}
+
+	if ended || (duration > listenCompleteMargin && position >= duration-listenCompleteMargin) {
+		listen.Position = 0
+		if !listen.Heard {
+			listen.Heard = true
+			listen.HeardAt = nulls.NewTime(time.Now())
+		}
+	} else {
+		listen.Position = position
+	}
+
+	if exists {
+		return listen, ls.DB.Update(listen)
+	}
+	return listen, ls.DB.Create(listen)
+}
+
+// Listens returns the user's listens for the given stored tracks, keyed by
+// track id
+func (ls *ListenService) Listens(userID uuid.UUID, trackIDs []uuid.UUID) (map[uuid.UUID]models.Listen, error) {
+	byTrack := map[uuid.UUID]models.Listen{}
+	if len(trackIDs) == 0 {
+		return byTrack, nil
+	}
+
+	ids := make([]interface{}, len(trackIDs))
+	for i, id := range trackIDs {
+		ids[i] = id
+	}
+	listens := models.Listens{}
+	if err := ls.DB.Where("user_id = ?", userID).Where("track_id IN (?)", ids...).All(&listens); err != nil {
+		return nil, err
+	}
+	for _, listen := range listens {
+		byTrack[listen.TrackID] = listen
+	}
+	return byTrack, nil
+}
@@ -0,0 +1,345 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gobuffalo/nulls"
+	"github.com/gobuffalo/pop/v6"
+	"github.com/gofrs/uuid"
+	"github.com/jbhicks/sound-cistern/mailers"
+	"github.com/jbhicks/sound-cistern/src/models"
+)
+
+const (
+	// MaxDeliveryAttempts is how often a notification is tried before it is marked failed
+	MaxDeliveryAttempts = 5
+	// webhookBaseBackoff is the delay before the first webhook retry
+	webhookBaseBackoff = 30 * time.Second
+	// webhookMaxBackoff caps the delay between webhook retries
+	webhookMaxBackoff = time.Hour
+)
+
+// NotificationService matches new tracks against user alerts and delivers
+// the resulting notifications
+type NotificationService struct {
+	DB     *pop.Connection
+	Client *http.Client
+}
+
+// NewNotificationService creates a new service
+func NewNotificationService(db *pop.Connection) *NotificationService {
+	return &NotificationService{
+		DB:     db,
+		Client: newPublicClient(10 * time.Second),
+	}
+}
+
+// GenerateSecret returns a random hex key for signing webhook payloads
+func GenerateSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// SignPayload returns the signature sent in the X-Cistern-Signature header
+func SignPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// WebhookBackoff returns how long to wait before retrying after the given attempt
+func WebhookBackoff(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	backoff := webhookBaseBackoff
+	for i := 1; i < attempt; i++ {
+		backoff *= 2
+		if backoff >= webhookMaxBackoff {
+			return webhookMaxBackoff
+		}
+	}
+	return backoff
+}
+
+// QueueMatches checks tracks against the user's active alerts and queues a
+// notification for each alert that matched at least one track
+func (ns *NotificationService) QueueMatches(userID string, tracks []interface{}) (models.Notifications, error) {
+	userUUID, err := uuid.FromString(userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(tracks) == 0 {
+		return models.Notifications{}, nil
+	}
+
+	alerts := models.Alerts{}
+	if err := ns.DB.Where("user_id = ? AND active = ?", userUUID, true).All(&alerts); err != nil {
+		return nil, err
+	}
+
+	feedService := NewFeedService(ns.DB)
+	queued := models.Notifications{}
+	for _, alert := range alerts {
+		var criteria map[string]interface{}
+		if err := json.Unmarshal([]byte(alert.Criteria), &criteria); err != nil {
+			return queued, fmt.Errorf("alert %s has invalid criteria: %w", alert.ID, err)
+		}
+
+		matches := feedService.FilterTracks(tracks, criteria)
+		if len(matches) == 0 {
+			continue
+		}
+
+		matchesJSON, err := json.Marshal(matches)
+		if err != nil {
+			return queued, err
+		}
+
+		notification := models.Notification{
+			ID:      uuid.Must(uuid.NewV4()),
+			AlertID: alert.ID,
+			UserID:  userUUID,
+			Tracks:  string(matchesJSON),
+			Status:  models.NotificationPending,
+		}
+		if err := ns.DB.Create(&notification); err != nil {
+			return queued, err
+		}
+		queued = append(queued, notification)
+	}
+	return queued, nil
+}
+
+// QueueRuleMatches queues a notification through each alert that notify
+// rules matched tracks for. Alerts that are missing, inactive or not the
+// user's are skipped.
+func (ns *NotificationService) QueueRuleMatches(userID string, matches map[uuid.UUID][]interface{}) (models.Notifications, error) {
+	userUUID, err := uuid.FromString(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	queued := models.Notifications{}
+	for alertID, tracks := range matches {
+		if len(tracks) == 0 {
+			continue
+		}
+		alert := &models.Alert{}
+		if err := ns.DB.Where("id = ? AND user_id = ? AND active = ?", alertID, userUUID, true).First(alert); err != nil {
+			continue
+		}
+
+		tracksJSON, err := json.Marshal(tracks)
+		if err != nil {
+			return queued, err
+		}
+		notification := models.Notification{
+			ID:      uuid.Must(uuid.NewV4()),
+			AlertID: alert.ID,
+			UserID:  userUUID,
+			Tracks:  string(tracksJSON),
+			Status:  models.NotificationPending,
+		}
+		if err := ns.DB.Create(&notification); err != nil {
+			return queued, err
+		}
+		queued = append(queued, notification)
+	}
+	return queued, nil
+}
+
+// DeliverWebhook POSTs a pending webhook notification to its alert's target.
+// It returns the delay before the next retry, or zero when no retry is needed.
+// Cancelling ctx abandons the delivery, which counts as a failed attempt.
+// The notification is loaded and the attempt recorded in short transactions
+// of their own, with the POST outside either, so a slow receiver holds no
+// connection or locks; ns.DB must not already be a transaction.
+func (ns *NotificationService) DeliverWebhook(ctx context.Context, notificationID string) (time.Duration, error) {
+	notification := &models.Notification{}
+	alert := &models.Alert{}
+	var body []byte
+	err := ns.DB.Transaction(func(tx *pop.Connection) error {
+		if err := tx.Find(notification, notificationID); err != nil {
+			return err
+		}
+		if notification.Status != models.NotificationPending {
+			return nil
+		}
+		if err := tx.Find(alert, notification.AlertID); err != nil {
+			return err
+		}
+		var tracks []interface{}
+		if err := json.Unmarshal([]byte(notification.Tracks), &tracks); err != nil {
+			return err
+		}
+		var err error
+		body, err = json.Marshal(map[string]interface{}{
+			"event":      "tracks.matched",
+			"alert_id":   alert.ID,
+			"alert_name": alert.Name,
+			"tracks":     tracks,
+			"created_at": notification.CreatedAt,
+		})
+		return err
+	})
+	if err != nil || body == nil {
+		return 0, err
+	}
+
+	statusCode, deliveryErr := ns.postWebhook(ctx, notification, alert, body)
+
+	err = ns.DB.Transaction(func(tx *pop.Connection) error {
+		return NewNotificationService(tx).finishAttempt(notification, alert.Channel, statusCode, deliveryErr)
+	})
+	if err != nil {
+		return 0, err
+	}
+	if notification.Status == models.NotificationPending {
+		return WebhookBackoff(notification.Attempts), nil
+	}
+	return 0, nil
+}
+
+// postWebhook sends a signed webhook payload and returns the response
+// status, with an error for anything but a 2xx
+func (ns *NotificationService) postWebhook(ctx context.Context, notification *models.Notification, alert *models.Alert, body []byte) (int, error) {
+	req, err := newRequest(ctx, "POST", alert.Target, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Cistern-Event", "tracks.matched")
+	req.Header.Set("X-Cistern-Delivery", notification.ID.String())
+	req.Header.Set("X-Cistern-Signature", SignPayload(alert.Secret.String, body))
+
+	res, err := ns.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return res.StatusCode, fmt.Errorf("webhook returned status %d", res.StatusCode)
+	}
+	return res.StatusCode, nil
+}
+
+// SendDigests emails every user's pending email notifications as a single
+// digest per recipient address
+func (ns *NotificationService) SendDigests() error {
+	pending := models.Notifications{}
+	err := ns.DB.Q().
+		Join("alerts", "alerts.id = notifications.alert_id").
+		Where("notifications.status = ? AND alerts.channel = ?", models.NotificationPending, models.AlertChannelEmail).
+		Order("notifications.created_at asc").
+		All(&pending)
+	if err != nil {
+		return err
+	}
+
+	alerts := map[uuid.UUID]*models.Alert{}
+	byTarget := map[string]models.Notifications{}
+	var targets []string
+	for _, n := range pending {
+		alert, ok := alerts[n.AlertID]
+		if !ok {
+			alert = &models.Alert{}
+			if err := ns.DB.Find(alert, n.AlertID); err != nil {
+				return err
+			}
+			alerts[n.AlertID] = alert
+		}
+		if _, ok := byTarget[alert.Target]; !ok {
+			targets = append(targets, alert.Target)
+		}
+		byTarget[alert.Target] = append(byTarget[alert.Target], n)
+	}
+
+	for _, target := range targets {
+		notifications := byTarget[target]
+
+		var entries []mailers.DigestEntry
+		entryIndex := map[uuid.UUID]int{}
+		for _, n := range notifications {
+			var tracks []interface{}
+			if err := json.Unmarshal([]byte(n.Tracks), &tracks); err != nil {
+				return err
+			}
+			i, ok := entryIndex[n.AlertID]
+			if !ok {
+				i = len(entries)
+				entryIndex[n.AlertID] = i
+				entries = append(entries, mailers.DigestEntry{AlertName: alerts[n.AlertID].Name})
+			}
+			entries[i].Tracks = append(entries[i].Tracks, tracks...)
+		}
+
+		sendErr := mailers.SendMatchDigest(target, entries)
+		for i := range notifications {
+			if err := ns.finishAttempt(&notifications[i], models.AlertChannelEmail, 0, sendErr); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// finishAttempt records a delivery attempt and moves the notification to its
+// next state
+func (ns *NotificationService) finishAttempt(n *models.Notification, channel string, statusCode int, deliveryErr error) error {
+	n.Attempts++
+	delivery := &models.NotificationDelivery{
+		ID:             uuid.Must(uuid.NewV4()),
+		NotificationID: n.ID,
+		Attempt:        n.Attempts,
+		Channel:        channel,
+		Status:         models.NotificationDelivered,
+	}
+	if statusCode != 0 {
+		delivery.ResponseCode = nulls.NewInt(statusCode)
+	}
+
+	if deliveryErr != nil {
+		delivery.Status = models.NotificationFailed
+		delivery.Error = nulls.NewString(deliveryErr.Error())
+		if n.Attempts >= MaxDeliveryAttempts {
+			n.Status = models.NotificationFailed
+			n.NextAttemptAt = nulls.Time{}
+		} else if channel == models.AlertChannelWebhook {
+			n.NextAttemptAt = nulls.NewTime(time.Now().Add(WebhookBackoff(n.Attempts)))
+		}
+	} else {
+		n.Status = models.NotificationDelivered
+		n.DeliveredAt = nulls.NewTime(time.Now())
+		n.NextAttemptAt = nulls.Time{}
+	}
+
+	if err := ns.DB.Create(delivery); err != nil {
+		return err
+	}
+	return ns.DB.Update(n)
+}
+
+// DeliveriesForAlert returns the most recent delivery attempts for an alert
+func (ns *NotificationService) DeliveriesForAlert(alertID uuid.UUID, limit int) (models.NotificationDeliveries, error) {
+	deliveries := models.NotificationDeliveries{}
+	err := ns.DB.Q().
+		Join("notifications", "notifications.id = notification_deliveries.notification_id").
+		Where("notifications.alert_id = ?", alertID).
+		Order("notification_deliveries.created_at desc").
+		Limit(limit).
+		All(&deliveries)
+	return deliveries, err
+}
@@ -0,0 +1,48 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSignPayload(t *testing.T) {
+	// Reference value from: printf '{"event":"tracks.matched"}' | openssl dgst -sha256 -hmac secret
+	got := SignPayload("secret", []byte(`{"event":"tracks.matched"}`))
+	want := "sha256=3eb3e03d396dd768a4867a3e301fb8c4e119d23c8e363a33ef5d7b8465da77d3"
+	if got != want {
+		t.Errorf("SignPayload() = %s, want %s", got, want)
+	}
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	cases := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{0, 30 * time.Second},
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{5, 8 * time.Minute},
+		{20, time.Hour},
+	}
+	for _, c := range cases {
+		if got := WebhookBackoff(c.attempt); got != c.want {
+			t.Errorf("WebhookBackoff(%d) = %s, want %s", c.attempt, got, c.want)
+		}
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	a, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := GenerateSecret()
+	if len(a) != 64 {
+		t.Errorf("Expected 64 hex characters, got %d", len(a))
+	}
+	if a == b {
+		t.Error("Expected secrets to differ")
+	}
+}
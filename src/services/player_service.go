@@ -0,0 +1,153 @@
+package services
+
+import (
+	"errors"
+
+	"github.com/gobuffalo/pop/v6"
+	"github.com/gofrs/uuid"
+	"github.com/jbhicks/sound-cistern/src/models"
+)
+
+// ErrTrackNotFound is returned when a track is not stored for the user
+var ErrTrackNotFound = errors.New("track not found")
+
+// PlayerService keeps each user's play queue. The queue is stored so it
+// follows the user between pages and devices.
+type PlayerService struct {
+	DB *pop.Connection
+}
+
+// NewPlayerService creates a new service
+func NewPlayerService(db *pop.Connection) *PlayerService {
+	return &PlayerService{DB: db}
+}
+
+// QueueEntry is a queued track, in play order
+type QueueEntry struct {
+	ID     uuid.UUID
+	Track  models.Track
+	Resume int // Seconds to resume playback from
+}
+
+// Queue returns the user's queue in play order. The first entry is the
+// track that is playing.
+func (ps *PlayerService) Queue(userID string) ([]QueueEntry, error) {
+	userUUID, err := uuid.FromString(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	items := models.PlayQueueItems{}
+	if err := ps.DB.Where("user_id = ?", userUUID).Order("position asc").All(&items); err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return []QueueEntry{}, nil
+	}
+
+	ids := make([]interface{}, len(items))
+	trackIDs := make([]uuid.UUID, len(items))
+	for i, item := range items {
+		ids[i] = item.TrackID
+		trackIDs[i] = item.TrackID
+	}
+	tracks := models.Tracks{}
+	if err := ps.DB.Where("id IN (?)", ids...).All(&tracks); err != nil {
+		return nil, err
+	}
+	byID := map[uuid.UUID]models.Track{}
+	for _, track := range tracks {
+		byID[track.ID] = track
+	}
+
+	listens, err := NewListenService(ps.DB).Listens(userUUID, trackIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]QueueEntry, 0, len(items))
+	for _, item := range items {
+		if track, ok := byID[item.TrackID]; ok {
+			entries = append(entries, QueueEntry{ID: item.ID, Track: track, Resume: listens[track.ID].Position})
+		}
+	}
+	return entries, nil
+}
+
+// Enqueue adds a stored track to the end of the user's queue. Tracks that
+// are already queued keep their place.
+func (ps *PlayerService) Enqueue(userID, soundcloudID string) error {
+	track, err := NewFeedService(ps.DB).Track(ps.DB.Context(), userID, soundcloudID)
+	if err != nil {
+		return err
+	}
+
+	existing := &models.PlayQueueItem{}
+	if err := ps.DB.Where("user_id = ? AND track_id = ?", track.UserID, track.ID).First(existing); err == nil {
+		return nil
+	}
+
+	position := 0
+	last := &models.PlayQueueItem{}
+	if err := ps.DB.Where("user_id = ?", track.UserID).Order("position desc").First(last); err == nil {
+		position = last.Position + 1
+	}
+	return ps.create(track, position)
+}
+
+// PlayNow moves a stored track to the front of the user's queue so it
+// plays next
+func (ps *PlayerService) PlayNow(userID, soundcloudID string) error {
+	track, err := NewFeedService(ps.DB).Track(ps.DB.Context(), userID, soundcloudID)
+	if err != nil {
+		return err
+	}
+
+	if err := ps.DB.RawQuery("DELETE FROM play_queue_items WHERE user_id = ? AND track_id = ?", track.UserID, track.ID).Exec(); err != nil {
+		return err
+	}
+
+	position := 0
+	first := &models.PlayQueueItem{}
+	if err := ps.DB.Where("user_id = ?", track.UserID).Order("position asc").First(first); err == nil {
+		position = first.Position - 1
+	}
+	return ps.create(track, position)
+}
+
+// Next removes the playing track from the front of the user's queue
+func (ps *PlayerService) Next(userID string) error {
+	userUUID, err := uuid.FromString(userID)
+	if err != nil {
+		return err
+	}
+
+	first := &models.PlayQueueItem{}
+	if err := ps.DB.Where("user_id = ?", userUUID).Order("position asc").First(first); err != nil {
+		return nil
+	}
+	return ps.DB.Destroy(first)
+}
+
+// Remove takes one entry out of the user's queue
+func (ps *PlayerService) Remove(userID, itemID string) error {
+	userUUID, err := uuid.FromString(userID)
+	if err != nil {
+		return err
+	}
+	itemUUID, err := uuid.FromString(itemID)
+	if err != nil {
+		return ErrTrackNotFound
+	}
+	return ps.DB.RawQuery("DELETE FROM play_queue_items WHERE id = ? AND user_id = ?", itemUUID, userUUID).Exec()
+}
+
+// create queues a track at the given position
+func (ps *PlayerService) create(track *models.Track, position int) error {
+	return ps.DB.Create(&models.PlayQueueItem{
+		ID:       uuid.Must(uuid.NewV4()),
+		UserID:   track.UserID,
+		TrackID:  track.ID,
+		Position: position,
+	})
+}
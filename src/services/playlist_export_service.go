@@ -0,0 +1,162 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/gobuffalo/nulls"
+	"github.com/gobuffalo/pop/v6"
+	"github.com/gofrs/uuid"
+	"github.com/jbhicks/sound-cistern/src/models"
+)
+
+// MaxPlaylistTracks is the most tracks Soundcloud allows in one playlist.
+// Filters matching more are cut off after this many in their sort order.
+const MaxPlaylistTracks = 500
+
+// ErrExportNotFound is returned when a playlist export does not exist for
+// the user
+var ErrExportNotFound = errors.New("playlist export not found")
+
+// PlaylistExportService pushes saved filter presets to Soundcloud
+// playlists and keeps them in sync
+type PlaylistExportService struct {
+	DB         *pop.Connection
+	Soundcloud *SoundcloudService
+}
+
+// NewPlaylistExportService creates a new service
+func NewPlaylistExportService(db *pop.Connection, soundcloud *SoundcloudService) *PlaylistExportService {
+	return &PlaylistExportService{DB: db, Soundcloud: soundcloud}
+}
+
+// Create saves a filter preset that will be pushed to a new playlist
+func (ps *PlaylistExportService) Create(userID, title string, criteria map[string]interface{}, sort string) (*models.PlaylistExport, error) {
+	userUUID, err := uuid.FromString(userID)
+	if err != nil {
+		return nil, err
+	}
+	criteriaJSON, err := json.Marshal(criteria)
+	if err != nil {
+		return nil, err
+	}
+
+	export := &models.PlaylistExport{
+		ID:       uuid.Must(uuid.NewV4()),
+		UserID:   userUUID,
+		Title:    title,
+		Criteria: string(criteriaJSON),
+		Sort:     NormalizeSort(sort),
+	}
+	return export, ps.DB.Create(export)
+}
+
+// Exports returns the user's playlist exports, newest first
+func (ps *PlaylistExportService) Exports(userID string) (models.PlaylistExports, error) {
+	exports := models.PlaylistExports{}
+	err := ps.DB.Where("user_id = ?", userID).Order("created_at desc").All(&exports)
+	return exports, err
+}
+
+// Export returns one of the user's playlist exports
+func (ps *PlaylistExportService) Export(userID, exportID string) (*models.PlaylistExport, error) {
+	exportUUID, err := uuid.FromString(exportID)
+	if err != nil {
+		return nil, ErrExportNotFound
+	}
+	export := &models.PlaylistExport{}
+	if err := ps.DB.Where("id = ? AND user_id = ?", exportUUID, userID).First(export); err != nil {
+		return nil, ErrExportNotFound
+	}
+	return export, nil
+}
+
+// ExportIDs returns the ids of every playlist export, for scheduled syncs
+func (ps *PlaylistExportService) ExportIDs() ([]string, error) {
+	exports := models.PlaylistExports{}
+	if err := ps.DB.Select("id").All(&exports); err != nil {
+		return nil, err
+	}
+	ids := make([]string, len(exports))
+	for i, export := range exports {
+		ids[i] = export.ID.String()
+	}
+	return ids, nil
+}
+
+// Push fills the export's Soundcloud playlist with the tracks its filter
+// matches now, creating the playlist on the first push or when it has been
+// deleted on Soundcloud. Playlists are kept on the user's first connected
+// account.
+func (ps *PlaylistExportService) Push(ctx context.Context, exportID string) (*models.PlaylistExport, error) {
+	export := &models.PlaylistExport{}
+	if err := ps.DB.Find(export, exportID); err != nil {
+		return nil, ErrExportNotFound
+	}
+	userID := export.UserID.String()
+
+	token, err := NewAccountService(ps.DB).Token(userID)
+	if err != nil {
+		return nil, err
+	}
+	criteria := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(export.Criteria), &criteria); err != nil {
+		return nil, err
+	}
+	page, err := NewFeedService(ps.DB).Page(ctx, userID, criteria, export.Sort, "", MaxPlaylistTracks)
+	if err != nil {
+		return nil, err
+	}
+	trackIDs := playlistTrackIDs(page.Tracks)
+
+	remote, err := ps.Soundcloud.SavePlaylist(ctx, token, export.SoundcloudPlaylistID.String, export.Title, trackIDs)
+	if errors.Is(err, ErrPlaylistNotFound) && export.SoundcloudPlaylistID.Valid {
+		remote, err = ps.Soundcloud.SavePlaylist(ctx, token, "", export.Title, trackIDs)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	export.SoundcloudPlaylistID = nulls.NewString(remote.ID)
+	if remote.PermalinkURL != "" {
+		export.PermalinkURL = nulls.NewString(remote.PermalinkURL)
+	}
+	export.TrackCount = len(trackIDs)
+	export.SyncedAt = nulls.NewTime(time.Now())
+	export.LastError = nulls.String{}
+	return export, ps.DB.Update(export)
+}
+
+// RecordFailure stores why the last push of an export failed so the user
+// can see it
+func (ps *PlaylistExportService) RecordFailure(exportID string, pushErr error) error {
+	return ps.DB.RawQuery("UPDATE playlist_exports SET last_error = ?, updated_at = ? WHERE id = ?",
+		pushErr.Error(), time.Now(), exportID).Exec()
+}
+
+// playlistTrackIDs returns the Soundcloud ids of tracks in order, without
+// repeats and capped at the playlist size limit. Only numeric ids can be
+// added to a playlist.
+func playlistTrackIDs(tracks []interface{}) []string {
+	seen := map[string]bool{}
+	var ids []string
+	for _, t := range tracks {
+		track, ok := t.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		id := FormatID(track["id"])
+		if _, err := strconv.ParseUint(id, 10, 64); err != nil || seen[id] {
+			continue
+		}
+		seen[id] = true
+		ids = append(ids, id)
+		if len(ids) == MaxPlaylistTracks {
+			break
+		}
+	}
+	return ids
+}
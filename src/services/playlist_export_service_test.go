@@ -0,0 +1,75 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestPlaylistTrackIDs(t *testing.T) {
+	tracks := []interface{}{
+		map[string]interface{}{"id": float64(1)},
+		map[string]interface{}{"id": float64(1)},
+		map[string]interface{}{"id": "not-numeric"},
+		map[string]interface{}{"id": float64(2)},
+	}
+	ids := playlistTrackIDs(tracks)
+	if fmt.Sprint(ids) != "[1 2]" {
+		t.Errorf("Expected [1 2], got %v", ids)
+	}
+
+	var many []interface{}
+	for i := 0; i < MaxPlaylistTracks+10; i++ {
+		many = append(many, map[string]interface{}{"id": float64(i + 1)})
+	}
+	if got := len(playlistTrackIDs(many)); got != MaxPlaylistTracks {
+		t.Errorf("Expected playlists to be capped at %d tracks, got %d", MaxPlaylistTracks, got)
+	}
+}
+
+func TestSavePlaylist(t *testing.T) {
+	withSoundcloudAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Playlist struct {
+				Title  string `json:"title"`
+				Tracks []struct {
+					ID int64 `json:"id"`
+				} `json:"tracks"`
+			} `json:"playlist"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Fatalf("Invalid playlist body: %v", err)
+		}
+		if body.Playlist.Title != "Sunday" || len(body.Playlist.Tracks) != 2 || body.Playlist.Tracks[1].ID != 456 {
+			t.Errorf("Unexpected playlist body %+v", body)
+		}
+
+		switch {
+		case r.Method == "POST" && r.URL.Path == "/playlists":
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"id": 99, "permalink_url": "https://soundcloud.com/me/sets/sunday"}`))
+		case r.Method == "PUT" && r.URL.Path == "/playlists/99":
+			w.Write([]byte(`{"id": 99}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+
+	sc := NewSoundcloudService("", "", "")
+	created, err := sc.SavePlaylist(context.Background(), "token", "", "Sunday", []string{"123", "456"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if created.ID != "99" || created.PermalinkURL == "" {
+		t.Errorf("Unexpected playlist %+v", created)
+	}
+
+	if _, err := sc.SavePlaylist(context.Background(), "token", "99", "Sunday", []string{"123", "456"}); err != nil {
+		t.Errorf("Unexpected error updating: %v", err)
+	}
+	if _, err := sc.SavePlaylist(context.Background(), "token", "404", "Sunday", []string{"123", "456"}); err != ErrPlaylistNotFound {
+		t.Errorf("Expected ErrPlaylistNotFound for a deleted playlist, got %v", err)
+	}
+}
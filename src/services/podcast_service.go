@@ -0,0 +1,187 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gobuffalo/nulls"
+	"github.com/gobuffalo/pop/v6"
+	"github.com/gofrs/uuid"
+	"github.com/jbhicks/sound-cistern/src/models"
+)
+
+var (
+	// ErrSubscriptionNotFound is returned when a podcast subscription does
+	// not exist for the user
+	ErrSubscriptionNotFound = errors.New("podcast subscription not found")
+	// ErrInvalidFeedURL is returned for feed URLs that are not http or https
+	ErrInvalidFeedURL = errors.New("enter the http or https address of an RSS, Atom or podcast feed")
+	// ErrDuplicateSubscription is returned when the user already follows
+	// the feed
+	ErrDuplicateSubscription = errors.New("you are already subscribed to that feed")
+	// ErrFeedUnavailable is returned when a feed cannot be fetched or read
+	ErrFeedUnavailable = errors.New("could not read that feed")
+)
+
+// PodcastService keeps each user's podcast subscriptions and stores their
+// episodes as tracks, so they go through the same filters and rules as the
+// Soundcloud feed
+type PodcastService struct {
+	DB *pop.Connection
+}
+
+// NewPodcastService creates a new service
+func NewPodcastService(db *pop.Connection) *PodcastService {
+	return &PodcastService{DB: db}
+}
+
+// Subscriptions returns the user's podcast subscriptions by title
+func (ps *PodcastService) Subscriptions(userID string) (models.PodcastSubscriptions, error) {
+	subscriptions := models.PodcastSubscriptions{}
+	err := ps.DB.Where("user_id = ?", userID).Order("lower(title) asc, url asc").All(&subscriptions)
+	return subscriptions, err
+}
+
+// Subscription returns one of the user's podcast subscriptions
+func (ps *PodcastService) Subscription(userID, subscriptionID string) (*models.PodcastSubscription, error) {
+	subscriptionUUID, err := uuid.FromString(subscriptionID)
+	if err != nil {
+		return nil, ErrSubscriptionNotFound
+	}
+	subscription := &models.PodcastSubscription{}
+	if err := ps.DB.Where("id = ? AND user_id = ?", subscriptionUUID, userID).First(subscription); err != nil {
+		return nil, ErrSubscriptionNotFound
+	}
+	return subscription, nil
+}
+
+// Subscribe follows a feed for the user and stores its episodes. The feed
+// is fetched first so a typo or a web page is caught before it is saved.
+// It returns the subscription and the episodes that were not stored before.
+func (ps *PodcastService) Subscribe(ctx context.Context, userID, feedURL string) (*models.PodcastSubscription, []interface{}, error) {
+	userUUID, err := uuid.FromString(userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	feedURL = strings.TrimSpace(feedURL)
+	parsed, err := url.Parse(feedURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return nil, nil, ErrInvalidFeedURL
+	}
+
+	// Episodes are stored with the user's Soundcloud tracks, which hang
+	// off their Soundcloud link
+	if _, err := NewAccountService(ps.DB).GetLink(userID); err != nil {
+		return nil, nil, ErrNoUsableAccount
+	}
+
+	count, err := ps.DB.Where("user_id = ? AND url = ?", userUUID, feedURL).Count(&models.PodcastSubscription{})
+	if err != nil {
+		return nil, nil, err
+	}
+	if count > 0 {
+		return nil, nil, ErrDuplicateSubscription
+	}
+
+	source := NewRSSSource(feedURL)
+	tracks, err := FetchTracks(ctx, source)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrFeedUnavailable, err)
+	}
+	subscription := &models.PodcastSubscription{
+		ID:            uuid.Must(uuid.NewV4()),
+		UserID:        userUUID,
+		URL:           feedURL,
+		LastFetchedAt: nulls.NewTime(time.Now()),
+	}
+	setFeedDetails(subscription, source)
+	if err := ps.DB.Create(subscription); err != nil {
+		return nil, nil, err
+	}
+	newTracks, err := ps.store(subscription, tracks)
+	if err != nil {
+		return nil, nil, err
+	}
+	return subscription, newTracks, nil
+}
+
+// Unsubscribe stops following a feed. Its episodes stay stored but no
+// longer count as coming from the feed.
+func (ps *PodcastService) Unsubscribe(subscription *models.PodcastSubscription) error {
+	if err := ps.DB.RawQuery("DELETE FROM track_sources WHERE source = ? AND playlist_id = ?", SourcePodcasts, subscription.ID.String()).Exec(); err != nil {
+		return err
+	}
+	return ps.DB.Destroy(subscription)
+}
+
+// RefreshAll fetches every feed the user follows and returns the episodes
+// that were not stored before along with how many feeds were read. A feed
+// that cannot be read is noted on its subscription and skipped.
+func (ps *PodcastService) RefreshAll(ctx context.Context, userID string) ([]interface{}, int, error) {
+	subscriptions, err := ps.Subscriptions(userID)
+	if err != nil {
+		return nil, 0, err
+	}
+	var newTracks []interface{}
+	refreshed := 0
+	for i := range subscriptions {
+		subscription := &subscriptions[i]
+		source := NewRSSSource(subscription.URL)
+		tracks, fetchErr := FetchTracks(ctx, source)
+		if fetchErr != nil {
+			subscription.LastError = nulls.NewString(feedErrorMessage(fetchErr))
+			if err := ps.DB.Update(subscription); err != nil {
+				return newTracks, refreshed, err
+			}
+			continue
+		}
+
+		setFeedDetails(subscription, source)
+		subscription.LastFetchedAt = nulls.NewTime(time.Now())
+		subscription.LastError = nulls.String{}
+		if err := ps.DB.Update(subscription); err != nil {
+			return newTracks, refreshed, err
+		}
+		stored, err := ps.store(subscription, tracks)
+		if err != nil {
+			return newTracks, refreshed, err
+		}
+		newTracks = append(newTracks, stored...)
+		refreshed++
+	}
+	return newTracks, refreshed, nil
+}
+
+// store stores a feed's episodes as the user's tracks, tagged with the
+// subscription they came from
+func (ps *PodcastService) store(subscription *models.PodcastSubscription, tracks []interface{}) ([]interface{}, error) {
+	origin := TrackOrigin{
+		Source:        SourcePodcasts,
+		PlaylistID:    subscription.ID.String(),
+		PlaylistTitle: subscription.DisplayName(),
+	}
+	return NewFeedService(ps.DB).storeTracks(subscription.UserID.String(), tracks, origin)
+}
+
+// feedErrorMessage describes why a feed could not be read without passing
+// on what the feed's server said
+func feedErrorMessage(err error) string {
+	if errors.Is(err, ErrPrivateAddress) {
+		return "The feed's address is not on the public internet"
+	}
+	return "Could not read the feed"
+}
+
+// setFeedDetails copies the title and artwork a fetched feed declares
+func setFeedDetails(subscription *models.PodcastSubscription, source *RSSSource) {
+	if source.Title != "" {
+		subscription.Title = source.Title
+	}
+	if source.Image != "" {
+		subscription.ImageURL = nulls.NewString(source.Image)
+	}
+}
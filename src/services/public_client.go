@@ -0,0 +1,67 @@
+package services
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrPrivateAddress is returned for feeds and webhooks on loopback,
+// link-local or private addresses, which are never requested on a user's
+// behalf
+var ErrPrivateAddress = errors.New("address is not public")
+
+// newPublicClient returns a client for URLs users supply. Its dialer checks
+// each address after DNS resolution, so neither the URL's host nor a
+// redirect can reach the server's own network. Proxies are not used, since
+// the dialer would only see the proxy's address.
+func newPublicClient(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext: (&net.Dialer{
+				Timeout: 10 * time.Second,
+				Control: publicAddressOnly,
+			}).DialContext,
+			TLSHandshakeTimeout: 10 * time.Second,
+			MaxIdleConns:        10,
+			IdleConnTimeout:     90 * time.Second,
+		},
+	}
+}
+
+// PublicHost reports whether host may be public. Names other than localhost
+// are allowed here and checked again once resolved; IP literals are checked
+// now so obviously private targets can be rejected when they are entered.
+func PublicHost(host string) bool {
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return false
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return publicIP(ip)
+	}
+	return true
+}
+
+// publicAddressOnly refuses connections to addresses outside the public
+// internet
+func publicAddressOnly(_, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+		return ErrPrivateAddress
+	}
+	return nil
+}
+
+// publicIP reports whether ip is routable on the public internet
+func publicIP(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsUnspecified() &&
+		!ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() &&
+		!ip.IsInterfaceLocalMulticast() && !ip.IsMulticast()
+}
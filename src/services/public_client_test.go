@@ -0,0 +1,66 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jbhicks/sound-cistern/src/models"
+)
+
+func TestPublicIP(t *testing.T) {
+	for raw, want := range map[string]bool{
+		"93.184.216.34":    true,
+		"2606:4700::1111":  true,
+		"127.0.0.1":        false,
+		"::1":              false,
+		"10.1.2.3":         false,
+		"172.16.0.1":       false,
+		"192.168.1.1":      false,
+		"169.254.169.254":  false,
+		"fe80::1":          false,
+		"fd00::1":          false,
+		"0.0.0.0":          false,
+		"::ffff:127.0.0.1": false,
+	} {
+		if got := publicIP(net.ParseIP(raw)); got != want {
+			t.Errorf("publicIP(%s) = %v, want %v", raw, got, want)
+		}
+	}
+}
+
+func TestPublicHost(t *testing.T) {
+	for host, want := range map[string]bool{
+		"hooks.example.com": true,
+		"93.184.216.34":     true,
+		"localhost":         false,
+		"api.localhost":     false,
+		"127.0.0.1":         false,
+		"169.254.169.254":   false,
+		"::1":               false,
+	} {
+		if got := PublicHost(host); got != want {
+			t.Errorf("PublicHost(%s) = %v, want %v", host, got, want)
+		}
+	}
+}
+
+func TestWebhookRefusesPrivateAddresses(t *testing.T) {
+	called := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer srv.Close()
+
+	ns := NewNotificationService(nil)
+	alert := &models.Alert{Target: srv.URL}
+	if _, err := ns.postWebhook(context.Background(), &models.Notification{}, alert, []byte("{}")); !errors.Is(err, ErrPrivateAddress) {
+		t.Errorf("Expected a loopback webhook to be refused, got %v", err)
+	}
+	if called {
+		t.Error("Expected the loopback server not to be called")
+	}
+}
@@ -0,0 +1,372 @@
+package services
+
+import (
+	"context"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gobuffalo/nulls"
+	"github.com/gobuffalo/pop/v6"
+	"github.com/gofrs/uuid"
+	"github.com/jbhicks/sound-cistern/src/models"
+)
+
+// How much each signal counts towards a similarity score. Shared tags and
+// genre matter most; length and uploader break ties between tracks that
+// share them.
+const (
+	similarityTermWeight     = 0.6
+	similarityLengthWeight   = 0.25
+	similarityUploaderWeight = 0.15
+)
+
+// minSimilarity is the lowest score kept as a recommendation
+const minSimilarity = 0.2
+
+// similarTracksKept is how many of each track's closest matches are stored
+const similarTracksKept = 20
+
+// similarityBatch is how many rows are written per statement, keeping
+// well under Postgres's limit on parameters
+const similarityBatch = 500
+
+// tagPattern matches the tags in a Soundcloud tag list, which separates
+// tags with spaces and quotes tags that contain them
+var tagPattern = regexp.MustCompile(`"([^"]+)"|([^\s"]+)`)
+
+// Recommendation is a stored track like the one being viewed, and why
+type Recommendation struct {
+	Track        models.Track
+	Score        float64
+	SharedTerms  []string
+	SameUploader bool
+}
+
+// RecommendationService recommends similar tracks from a user's stored
+// feed. Each track's closest matches are worked out once, after the sync
+// that stored it, so viewing a track only reads them back.
+type RecommendationService struct {
+	DB *pop.Connection
+}
+
+// NewRecommendationService creates a new service
+func NewRecommendationService(db *pop.Connection) *RecommendationService {
+	return &RecommendationService{DB: db}
+}
+
+// similarityTrack is the part of a stored track similarity is scored on
+type similarityTrack struct {
+	ID       uuid.UUID    `db:"id"`
+	ArtistID string       `db:"artist_soundcloud_id"`
+	Genre    string       `db:"genre"`
+	TagList  nulls.String `db:"tag_list"`
+	Length   int          `db:"length"`
+	// Pending is set until the track's matches have been worked out
+	Pending bool `db:"pending"`
+
+	terms map[string]bool
+}
+
+// UpdateSimilarities works out the closest matches of the user's tracks
+// stored since the last update, and adds them to the matches of the
+// tracks they are close to. Returns how many tracks were scored.
+func (rs *RecommendationService) UpdateSimilarities(ctx context.Context, userID string) (int, error) {
+	db := rs.DB.WithContext(ctx)
+	userUUID, err := uuid.FromString(userID)
+	if err != nil {
+		return 0, err
+	}
+
+	var tracks []similarityTrack
+	err = db.RawQuery(`SELECT id, artist_soundcloud_id, genre, tag_list, length, similarities_at IS NULL AS pending
+		FROM soundcloud_tracks WHERE user_id = ? AND duplicate_of IS NULL`, userUUID).All(&tracks)
+	if err != nil {
+		return 0, err
+	}
+	byTerm := map[string][]int{}
+	byArtist := map[string][]int{}
+	var pending []int
+	for i := range tracks {
+		tracks[i].terms = trackTerms(tracks[i].Genre, tracks[i].TagList.String)
+		for term := range tracks[i].terms {
+			byTerm[term] = append(byTerm[term], i)
+		}
+		if tracks[i].ArtistID != "" {
+			byArtist[tracks[i].ArtistID] = append(byArtist[tracks[i].ArtistID], i)
+		}
+		if tracks[i].Pending {
+			pending = append(pending, i)
+		}
+	}
+	if len(pending) == 0 {
+		return 0, nil
+	}
+
+	type match struct {
+		index int
+		score float64
+	}
+	// scores holds the pairs to store, by track and similar track
+	scores := map[[2]int]float64{}
+	for _, p := range pending {
+		seen := map[int]bool{p: true}
+		var matches []match
+		score := func(candidates []int) {
+			for _, c := range candidates {
+				if seen[c] {
+					continue
+				}
+				seen[c] = true
+				s := similarityScore(&tracks[p], &tracks[c])
+				if s < minSimilarity {
+					continue
+				}
+				matches = append(matches, match{c, s})
+				// The new track may now be one of an older track's closest
+				// matches; the older track's extra matches are trimmed below
+				if !tracks[c].Pending {
+					scores[[2]int{c, p}] = s
+				}
+			}
+		}
+		score(byArtist[tracks[p].ArtistID])
+		for term := range tracks[p].terms {
+			score(byTerm[term])
+		}
+
+		sort.Slice(matches, func(i, j int) bool { return matches[i].score > matches[j].score })
+		if len(matches) > similarTracksKept {
+			matches = matches[:similarTracksKept]
+		}
+		for _, m := range matches {
+			scores[[2]int{p, m.index}] = m.score
+		}
+	}
+
+	now := time.Now()
+	var values []string
+	var args []interface{}
+	flush := func() error {
+		if len(values) == 0 {
+			return nil
+		}
+		err := db.RawQuery(`INSERT INTO track_similarities (id, user_id, track_id, similar_track_id, score, created_at) VALUES `+
+			strings.Join(values, ", ")+` ON CONFLICT (track_id, similar_track_id) DO UPDATE SET score = EXCLUDED.score`, args...).Exec()
+		values, args = values[:0], args[:0]
+		return err
+	}
+	for pair, score := range scores {
+		values = append(values, "(?, ?, ?, ?, ?, ?)")
+		args = append(args, uuid.Must(uuid.NewV4()), userUUID, tracks[pair[0]].ID, tracks[pair[1]].ID, score, now)
+		if len(values) == similarityBatch {
+			if err := flush(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return 0, err
+	}
+
+	err = db.RawQuery(`DELETE FROM track_similarities WHERE id IN (
+			SELECT id FROM (
+				SELECT id, row_number() OVER (PARTITION BY track_id ORDER BY score DESC, similar_track_id) AS rank
+				FROM track_similarities WHERE user_id = ?
+			) ranked WHERE rank > ?)`, userUUID, similarTracksKept).Exec()
+	if err != nil {
+		return 0, err
+	}
+
+	for start := 0; start < len(pending); start += similarityBatch {
+		end := start + similarityBatch
+		if end > len(pending) {
+			end = len(pending)
+		}
+		ids := make([]interface{}, 0, end-start)
+		for _, p := range pending[start:end] {
+			ids = append(ids, tracks[p].ID)
+		}
+		in := strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")
+		if err := db.RawQuery("UPDATE soundcloud_tracks SET similarities_at = ? WHERE id IN ("+in+")", append([]interface{}{now}, ids...)...).Exec(); err != nil {
+			return 0, err
+		}
+	}
+	return len(pending), nil
+}
+
+// RebuildSimilarities forgets the user's stored matches and works them
+// all out again, for tracks stored before recommendations existed or after
+// the scoring changes
+func (rs *RecommendationService) RebuildSimilarities(ctx context.Context, userID string) (int, error) {
+	db := rs.DB.WithContext(ctx)
+	if err := db.RawQuery("DELETE FROM track_similarities WHERE user_id = ?", userID).Exec(); err != nil {
+		return 0, err
+	}
+	if err := db.RawQuery("UPDATE soundcloud_tracks SET similarities_at = NULL WHERE user_id = ?", userID).Exec(); err != nil {
+		return 0, err
+	}
+	return rs.UpdateSimilarities(ctx, userID)
+}
+
+// Similar returns the tracks most like a stored track, best first, leaving
+// out hidden tracks and artists the user has excluded
+func (rs *RecommendationService) Similar(ctx context.Context, track *models.Track, limit int) ([]Recommendation, error) {
+	db := rs.DB.WithContext(ctx)
+	var matches []struct {
+		ID uuid.UUID `db:"similar_track_id"`
+	}
+	err := db.RawQuery(`SELECT track_similarities.similar_track_id FROM track_similarities
+		JOIN soundcloud_tracks ON soundcloud_tracks.id = track_similarities.similar_track_id
+		WHERE track_similarities.track_id = ? AND soundcloud_tracks.hidden = false AND soundcloud_tracks.duplicate_of IS NULL
+			AND soundcloud_tracks.artist_soundcloud_id NOT IN (SELECT artist_soundcloud_id FROM recommendation_exclusions WHERE user_id = ?)
+		ORDER BY track_similarities.score DESC, soundcloud_tracks.post_time DESC LIMIT ?`, track.ID, track.UserID, limit).All(&matches)
+	if err != nil || len(matches) == 0 {
+		return nil, err
+	}
+	ids := make([]interface{}, len(matches))
+	for i, m := range matches {
+		ids[i] = m.ID
+	}
+	stored := models.Tracks{}
+	if err := db.Where("id IN (?)", ids...).All(&stored); err != nil {
+		return nil, err
+	}
+	byID := map[uuid.UUID]models.Track{}
+	for _, t := range stored {
+		byID[t.ID] = t
+	}
+
+	source := newSimilarityTrack(*track)
+	var recommendations []Recommendation
+	for _, m := range matches {
+		t, ok := byID[m.ID]
+		if !ok {
+			continue
+		}
+		similar := newSimilarityTrack(t)
+		recommendation := Recommendation{
+			Track:        t,
+			Score:        similarityScore(&source, &similar),
+			SameUploader: source.ArtistID != "" && source.ArtistID == similar.ArtistID,
+		}
+		for term := range source.terms {
+			if similar.terms[term] {
+				recommendation.SharedTerms = append(recommendation.SharedTerms, term)
+			}
+		}
+		sort.Strings(recommendation.SharedTerms)
+		recommendations = append(recommendations, recommendation)
+	}
+	return recommendations, nil
+}
+
+// Exclusions returns the artists the user has kept out of recommendations
+func (rs *RecommendationService) Exclusions(userID string) (models.RecommendationExclusions, error) {
+	exclusions := models.RecommendationExclusions{}
+	err := rs.DB.Where("user_id = ?", userID).Order("lower(artist_name) asc").All(&exclusions)
+	return exclusions, err
+}
+
+// ExcludeArtist stops recommending an artist's tracks to the user
+func (rs *RecommendationService) ExcludeArtist(userID, artistID string) (*models.RecommendationExclusion, error) {
+	userUUID, err := uuid.FromString(userID)
+	if err != nil {
+		return nil, err
+	}
+	exclusion := &models.RecommendationExclusion{}
+	if err := rs.DB.Where("user_id = ? AND artist_soundcloud_id = ?", userUUID, artistID).First(exclusion); err == nil {
+		return exclusion, nil
+	}
+
+	track := &models.Track{}
+	if artistID == "" || rs.DB.Where("user_id = ? AND artist_soundcloud_id = ?", userUUID, artistID).First(track) != nil {
+		return nil, ErrArtistNotFound
+	}
+	exclusion = &models.RecommendationExclusion{
+		ID:         uuid.Must(uuid.NewV4()),
+		UserID:     userUUID,
+		ArtistID:   artistID,
+		ArtistName: track.Artist.String,
+	}
+	return exclusion, rs.DB.Create(exclusion)
+}
+
+// IncludeArtist recommends an excluded artist's tracks again
+func (rs *RecommendationService) IncludeArtist(userID, artistID string) error {
+	return rs.DB.RawQuery("DELETE FROM recommendation_exclusions WHERE user_id = ? AND artist_soundcloud_id = ?", userID, artistID).Exec()
+}
+
+// newSimilarityTrack takes what similarity is scored on from a stored track
+func newSimilarityTrack(track models.Track) similarityTrack {
+	return similarityTrack{
+		ID:       track.ID,
+		ArtistID: track.ArtistID,
+		Genre:    track.Genre,
+		TagList:  track.TagList,
+		Length:   track.Length,
+		terms:    trackTerms(track.Genre, track.TagList.String),
+	}
+}
+
+// similarityScore rates how alike two tracks are from 0 to 1, from the
+// Jaccard overlap of their genre and tags, how close their lengths are and
+// whether the same account uploaded them. Tracks with nothing in common
+// but their length score 0.
+func similarityScore(a, b *similarityTrack) float64 {
+	terms := jaccard(a.terms, b.terms)
+	sameUploader := a.ArtistID != "" && a.ArtistID == b.ArtistID
+	if terms == 0 && !sameUploader {
+		return 0
+	}
+	score := similarityTermWeight*terms + similarityLengthWeight*lengthProximity(a.Length, b.Length)
+	if sameUploader {
+		score += similarityUploaderWeight
+	}
+	return score
+}
+
+// jaccard is the size of the intersection of two sets over their union
+func jaccard(a, b map[string]bool) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	shared := 0
+	for term := range a {
+		if b[term] {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(a)+len(b)-shared)
+}
+
+// lengthProximity is 1 for tracks of the same length, falling towards 0 as
+// one gets longer than the other, or 0 when either length is unknown
+func lengthProximity(a, b int) float64 {
+	if a <= 0 || b <= 0 {
+		return 0
+	}
+	if a > b {
+		a, b = b, a
+	}
+	return float64(a) / float64(b)
+}
+
+// trackTerms is the set of a track's genre and tags, lower-cased
+func trackTerms(genre, tagList string) map[string]bool {
+	terms := map[string]bool{}
+	if genre = strings.ToLower(strings.TrimSpace(genre)); genre != "" {
+		terms[genre] = true
+	}
+	for _, m := range tagPattern.FindAllStringSubmatch(tagList, -1) {
+		tag := m[1]
+		if tag == "" {
+			tag = m[2]
+		}
+		if tag = strings.ToLower(strings.TrimSpace(tag)); tag != "" {
+			terms[tag] = true
+		}
+	}
+	return terms
+}
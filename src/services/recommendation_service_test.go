@@ -0,0 +1,62 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTrackTerms(t *testing.T) {
+	got := trackTerms(" Deep House ", `house "Late Night" Berlin vinyl`)
+	want := map[string]bool{"deep house": true, "house": true, "late night": true, "berlin": true, "vinyl": true}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	if len(trackTerms("", "")) != 0 {
+		t.Error("Expected no terms for an untagged track")
+	}
+}
+
+func TestSimilarityScore(t *testing.T) {
+	track := func(artist string, length int, genre, tags string) *similarityTrack {
+		return &similarityTrack{ArtistID: artist, Length: length, terms: trackTerms(genre, tags)}
+	}
+	mix := track("1", 3600, "techno", "berlin warehouse")
+
+	same := similarityScore(mix, track("1", 3600, "techno", "berlin warehouse"))
+	if same < 0.999 || same > 1.001 {
+		t.Errorf("Expected identical tracks by one uploader to score 1, got %v", same)
+	}
+
+	closer := similarityScore(mix, track("2", 3500, "techno", "berlin"))
+	further := similarityScore(mix, track("2", 600, "techno", "berlin"))
+	if closer <= further {
+		t.Errorf("Expected a closer length to score higher, got %v and %v", closer, further)
+	}
+	if shared := similarityScore(mix, track("2", 3600, "techno", "berlin warehouse")); shared >= same {
+		t.Errorf("Expected a shared uploader to add to the score, got %v without and %v with", shared, same)
+	}
+
+	if s := similarityScore(mix, track("2", 3600, "ambient", "drone")); s != 0 {
+		t.Errorf("Expected tracks sharing only a length to score 0, got %v", s)
+	}
+	if s := similarityScore(mix, track("1", 3600, "ambient", "")); s == 0 {
+		t.Error("Expected tracks by the same uploader to be related")
+	}
+}
+
+func TestJaccardAndLengthProximity(t *testing.T) {
+	a := map[string]bool{"house": true, "disco": true}
+	b := map[string]bool{"house": true, "techno": true}
+	if got := jaccard(a, b); got < 0.333 || got > 0.334 {
+		t.Errorf("Expected one shared term of three to give 1/3, got %v", got)
+	}
+	if jaccard(a, nil) != 0 {
+		t.Error("Expected no overlap with an untagged track")
+	}
+	if lengthProximity(1800, 3600) != 0.5 || lengthProximity(3600, 1800) != 0.5 {
+		t.Error("Expected proximity to be the shorter length over the longer")
+	}
+	if lengthProximity(0, 3600) != 0 {
+		t.Error("Expected an unknown length to add nothing")
+	}
+}
@@ -0,0 +1,42 @@
+package services
+
+import (
+	"context"
+	"io"
+	"net/http"
+)
+
+// RequestIDHeader carries the id of the request or job behind an outgoing
+// API call, so upstream logs can be matched with ours
+const RequestIDHeader = "X-Request-ID"
+
+// requestIDKey is the context key the request id is stored under
+type requestIDKey struct{}
+
+// WithRequestID returns a context carrying the id of the request or job
+// doing the work
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	if requestID == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, requestIDKey{}, requestID)
+}
+
+// RequestID returns the request id carried by ctx, or an empty string
+func RequestID(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	return requestID
+}
+
+// newRequest creates an outgoing request bound to ctx, so it is abandoned
+// when the caller goes away, and tagged with the context's request id
+func newRequest(ctx context.Context, method, url string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if requestID := RequestID(ctx); requestID != "" {
+		req.Header.Set(RequestIDHeader, requestID)
+	}
+	return req, nil
+}
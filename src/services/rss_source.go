@@ -0,0 +1,451 @@
+package services
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// rssIDPrefix marks track and artist ids that came from a feed rather than
+// Soundcloud
+const rssIDPrefix = "rss-"
+
+// maxFeedSize caps how much of a feed page is read
+const maxFeedSize = 10 << 20
+
+// feedClient fetches feeds. Neither a feed's host nor a redirect can reach
+// the server's own network.
+var feedClient = newPublicClient(15 * time.Second)
+
+// UseFeedTransport sends feed requests through transport and returns the
+// transport it replaced, so tests can read feeds served locally
+func UseFeedTransport(transport http.RoundTripper) http.RoundTripper {
+	previous := feedClient.Transport
+	feedClient.Transport = transport
+	return previous
+}
+
+// RSSSource is an RSS, Atom or podcast feed. Episodes are normalized to
+// tracks with their enclosure as the stream, so they can be played,
+// stored and filtered like Soundcloud tracks. Feeds that page their
+// archive with a rel="next" link are followed.
+type RSSSource struct {
+	URL string
+	// Title and Image are the feed's, known after the first page is fetched
+	Title string
+	Image string
+}
+
+// NewRSSSource creates a source for the feed at url
+func NewRSSSource(url string) *RSSSource {
+	return &RSSSource{URL: url}
+}
+
+// feedItem is an RSS item or Atom entry with the fields tracks are made of
+type feedItem struct {
+	GUID          string
+	Title         string
+	Link          string
+	Description   string
+	Published     time.Time
+	Duration      float64 // Seconds
+	Categories    []string
+	Author        string
+	Image         string
+	EnclosureURL  string
+	EnclosureType string
+}
+
+// Fetch fetches one page of the feed. The cursor is the URL of the page.
+func (src *RSSSource) Fetch(ctx context.Context, cursor string) ([]interface{}, string, error) {
+	pageURL := cursor
+	if pageURL == "" {
+		pageURL = src.URL
+	}
+	req, err := newRequest(ctx, "GET", pageURL, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("Accept", "application/rss+xml, application/atom+xml, application/xml;q=0.9, */*;q=0.8")
+	res, err := feedClient.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		return nil, "", fmt.Errorf("feed error: %d", res.StatusCode)
+	}
+
+	doc, err := parseFeed(io.LimitReader(res.Body, maxFeedSize))
+	if err != nil {
+		return nil, "", err
+	}
+	if src.Title == "" {
+		src.Title = doc.title()
+	}
+	if src.Image == "" {
+		src.Image = webURL(doc.image())
+	}
+	entries := doc.items()
+	items := make([]interface{}, len(entries))
+	for i, entry := range entries {
+		items[i] = entry
+	}
+	return items, doc.next(), nil
+}
+
+// Normalize turns a feed item with an audio or video enclosure into a
+// track map. Items without one, such as show notes posts, are skipped.
+func (src *RSSSource) Normalize(item interface{}) (map[string]interface{}, bool) {
+	entry, ok := item.(feedItem)
+	if !ok {
+		return nil, false
+	}
+	// The feed's author controls these links, which end up in the page
+	entry.Link = webURL(entry.Link)
+	entry.EnclosureURL = webURL(entry.EnclosureURL)
+	entry.Image = webURL(entry.Image)
+	if entry.EnclosureURL == "" {
+		return nil, false
+	}
+	if entry.EnclosureType != "" && !strings.HasPrefix(entry.EnclosureType, "audio/") && !strings.HasPrefix(entry.EnclosureType, "video/") {
+		return nil, false
+	}
+
+	key := entry.GUID
+	if key == "" {
+		key = entry.EnclosureURL
+	}
+	author := entry.Author
+	if author == "" {
+		author = src.Title
+	}
+	track := map[string]interface{}{
+		"id":            feedID(src.URL + "\n" + key),
+		"title":         entry.Title,
+		"description":   entry.Description,
+		"duration":      entry.Duration * 1000,
+		"permalink_url": entry.Link,
+		"enclosure_url": entry.EnclosureURL,
+		"feed_url":      src.URL,
+		"user": map[string]interface{}{
+			"id":       feedID(src.URL),
+			"username": author,
+		},
+	}
+	if !entry.Published.IsZero() {
+		track["created_at"] = entry.Published.Format("2006/01/02 15:04:05 -0700")
+	}
+	if len(entry.Categories) > 0 {
+		track["genre"] = entry.Categories[0]
+		track["tag_list"] = tagList(entry.Categories)
+	}
+	if image := entry.Image; image != "" {
+		track["artwork_url"] = image
+	} else if src.Image != "" {
+		track["artwork_url"] = src.Image
+	}
+	return track, true
+}
+
+// webURL returns raw when it is an absolute http or https URL and an empty
+// string otherwise, so links such as javascript: ones are dropped
+func webURL(raw string) string {
+	raw = strings.TrimSpace(raw)
+	parsed, err := url.Parse(raw)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return ""
+	}
+	return raw
+}
+
+// EnclosureURL returns the media URL of a track that came from a feed, or
+// an empty string for Soundcloud tracks
+func EnclosureURL(track map[string]interface{}) string {
+	return stringField(track, "enclosure_url")
+}
+
+// feedID derives a stable id from a feed URL and item key. Ids are short
+// and URL safe since they end up in track links.
+func feedID(key string) string {
+	sum := sha1.Sum([]byte(key))
+	return rssIDPrefix + hex.EncodeToString(sum[:8])
+}
+
+// tagList joins categories the way Soundcloud formats tag lists, quoting
+// tags with spaces in them
+func tagList(categories []string) string {
+	tags := make([]string, 0, len(categories))
+	for _, category := range categories {
+		if strings.Contains(category, " ") {
+			category = `"` + category + `"`
+		}
+		tags = append(tags, category)
+	}
+	return strings.Join(tags, " ")
+}
+
+// feedDocument is an RSS or Atom document. RSS keeps its items in a
+// channel while Atom keeps entries at the top level.
+type feedDocument struct {
+	XMLName xml.Name
+	Channel struct {
+		Title  string      `xml:"title"`
+		Author string      `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd author"`
+		Images []feedImage `xml:"image"`
+		Links  []feedLink  `xml:"link"`
+		Items  []rssItem   `xml:"item"`
+	} `xml:"channel"`
+	Title   string      `xml:"title"`
+	Author  string      `xml:"author>name"`
+	Logo    string      `xml:"logo"`
+	Links   []feedLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+// feedLink is an Atom link, or an RSS link with the URL as its text
+type feedLink struct {
+	Rel    string `xml:"rel,attr"`
+	Href   string `xml:"href,attr"`
+	Type   string `xml:"type,attr"`
+	Length string `xml:"length,attr"`
+	Text   string `xml:",chardata"`
+}
+
+// feedImage is an RSS image with a url child or an itunes:image with an
+// href attribute
+type feedImage struct {
+	URL  string `xml:"url"`
+	Href string `xml:"href,attr"`
+}
+
+// rssItem is an RSS item with the iTunes podcast extensions
+type rssItem struct {
+	GUID        string      `xml:"guid"`
+	Title       string      `xml:"title"`
+	Links       []feedLink  `xml:"link"`
+	Description string      `xml:"description"`
+	Summary     string      `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd summary"`
+	PubDate     string      `xml:"pubDate"`
+	Duration    string      `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd duration"`
+	Categories  []string    `xml:"category"`
+	Keywords    string      `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd keywords"`
+	Author      string      `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd author"`
+	Images      []feedImage `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd image"`
+	Enclosure   struct {
+		URL  string `xml:"url,attr"`
+		Type string `xml:"type,attr"`
+	} `xml:"enclosure"`
+}
+
+// atomEntry is an Atom entry. Podcast media is a link with
+// rel="enclosure".
+type atomEntry struct {
+	ID         string     `xml:"id"`
+	Title      string     `xml:"title"`
+	Summary    string     `xml:"summary"`
+	Content    string     `xml:"content"`
+	Published  string     `xml:"published"`
+	Updated    string     `xml:"updated"`
+	Links      []feedLink `xml:"link"`
+	Author     string     `xml:"author>name"`
+	Duration   string     `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd duration"`
+	Categories []struct {
+		Term string `xml:"term,attr"`
+	} `xml:"category"`
+}
+
+// parseFeed decodes an RSS or Atom document. Parsing is lenient since
+// feeds in the wild often use HTML entities in their titles and show
+// notes. Auto-closing HTML tags is left off because RSS uses <link> for
+// text.
+func parseFeed(r io.Reader) (*feedDocument, error) {
+	decoder := xml.NewDecoder(r)
+	decoder.Strict = false
+	decoder.Entity = xml.HTMLEntity
+	// Feeds declaring another charset are read as they are, which keeps
+	// ASCII intact and is close enough for titles and notes
+	decoder.CharsetReader = func(_ string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+
+	doc := &feedDocument{}
+	if err := decoder.Decode(doc); err != nil {
+		return nil, fmt.Errorf("invalid feed: %w", err)
+	}
+	if doc.XMLName.Local != "rss" && doc.XMLName.Local != "feed" {
+		return nil, fmt.Errorf("invalid feed: unexpected <%s> document", doc.XMLName.Local)
+	}
+	return doc, nil
+}
+
+// title returns the feed's title
+func (doc *feedDocument) title() string {
+	if doc.XMLName.Local == "feed" {
+		return strings.TrimSpace(doc.Title)
+	}
+	return strings.TrimSpace(doc.Channel.Title)
+}
+
+// image returns the feed's artwork
+func (doc *feedDocument) image() string {
+	if doc.XMLName.Local == "feed" {
+		return strings.TrimSpace(doc.Logo)
+	}
+	return imageURL(doc.Channel.Images)
+}
+
+// next returns the URL of the feed's next page, if it is paged
+func (doc *feedDocument) next() string {
+	links := doc.Links
+	if doc.XMLName.Local != "feed" {
+		links = doc.Channel.Links
+	}
+	for _, link := range links {
+		if link.Rel == "next" && link.Href != "" {
+			return link.Href
+		}
+	}
+	return ""
+}
+
+// items returns the feed's RSS items or Atom entries
+func (doc *feedDocument) items() []feedItem {
+	var items []feedItem
+	if doc.XMLName.Local == "feed" {
+		for _, entry := range doc.Entries {
+			item := feedItem{
+				GUID:        strings.TrimSpace(entry.ID),
+				Title:       strings.TrimSpace(entry.Title),
+				Description: strings.TrimSpace(entry.Summary),
+				Published:   parseFeedTime(entry.Published),
+				Duration:    parseFeedDuration(entry.Duration),
+				Author:      strings.TrimSpace(entry.Author),
+			}
+			if item.Description == "" {
+				item.Description = strings.TrimSpace(entry.Content)
+			}
+			if item.Published.IsZero() {
+				item.Published = parseFeedTime(entry.Updated)
+			}
+			if item.Author == "" {
+				item.Author = strings.TrimSpace(doc.Author)
+			}
+			for _, category := range entry.Categories {
+				if term := strings.TrimSpace(category.Term); term != "" {
+					item.Categories = append(item.Categories, term)
+				}
+			}
+			for _, link := range entry.Links {
+				switch link.Rel {
+				case "enclosure":
+					item.EnclosureURL, item.EnclosureType = link.Href, link.Type
+				case "", "alternate":
+					item.Link = link.Href
+				}
+			}
+			items = append(items, item)
+		}
+		return items
+	}
+
+	for _, entry := range doc.Channel.Items {
+		item := feedItem{
+			GUID:          strings.TrimSpace(entry.GUID),
+			Title:         strings.TrimSpace(entry.Title),
+			Description:   strings.TrimSpace(entry.Description),
+			Published:     parseFeedTime(entry.PubDate),
+			Duration:      parseFeedDuration(entry.Duration),
+			Author:        strings.TrimSpace(entry.Author),
+			Image:         imageURL(entry.Images),
+			EnclosureURL:  strings.TrimSpace(entry.Enclosure.URL),
+			EnclosureType: strings.TrimSpace(entry.Enclosure.Type),
+		}
+		if item.Description == "" {
+			item.Description = strings.TrimSpace(entry.Summary)
+		}
+		if item.Author == "" {
+			item.Author = strings.TrimSpace(doc.Channel.Author)
+		}
+		for _, link := range entry.Links {
+			if text := strings.TrimSpace(link.Text); text != "" {
+				item.Link = text
+				break
+			}
+		}
+		for _, category := range entry.Categories {
+			if category = strings.TrimSpace(category); category != "" {
+				item.Categories = append(item.Categories, category)
+			}
+		}
+		for _, keyword := range strings.Split(entry.Keywords, ",") {
+			if keyword = strings.TrimSpace(keyword); keyword != "" {
+				item.Categories = append(item.Categories, keyword)
+			}
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+// imageURL returns the first image of a feed or item
+func imageURL(images []feedImage) string {
+	for _, image := range images {
+		if href := strings.TrimSpace(image.Href); href != "" {
+			return href
+		}
+		if url := strings.TrimSpace(image.URL); url != "" {
+			return url
+		}
+	}
+	return ""
+}
+
+// feedTimeLayouts are the date formats seen in RSS pubDate and Atom
+// timestamps
+var feedTimeLayouts = []string{
+	time.RFC1123Z,
+	time.RFC1123,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"2 Jan 2006 15:04:05 -0700",
+	time.RFC3339,
+}
+
+// parseFeedTime parses a feed date, returning the zero time when the date
+// is missing or in an unknown format
+func parseFeedTime(raw string) time.Time {
+	raw = strings.TrimSpace(raw)
+	for _, layout := range feedTimeLayouts {
+		if t, err := time.Parse(layout, raw); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// parseFeedDuration parses an itunes:duration, which is either a number
+// of seconds or colon separated hours, minutes and seconds
+func parseFeedDuration(raw string) float64 {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return 0
+	}
+	var seconds float64
+	for _, part := range strings.Split(raw, ":") {
+		n, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return 0
+		}
+		seconds = seconds*60 + n
+	}
+	return seconds
+}
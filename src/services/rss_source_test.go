@@ -0,0 +1,192 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const podcastFeed = `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0" xmlns:itunes="http://www.itunes.com/dtds/podcast-1.0.dtd">
+  <channel>
+    <title>Deep Mix Series</title>
+    <itunes:author>Night Shift Radio</itunes:author>
+    <itunes:image href="https://example.com/show.jpg"/>
+    <item>
+      <guid>episode-42</guid>
+      <title>Episode 42 &ndash; Warehouse set</title>
+      <link>https://example.com/42</link>
+      <description>Tracklist: 1. Artist - Song</description>
+      <pubDate>Tue, 07 Oct 2025 20:00:00 +0000</pubDate>
+      <itunes:duration>1:02:03</itunes:duration>
+      <category>Techno</category>
+      <category>Live Sets</category>
+      <enclosure url="https://cdn.example.com/42.mp3" type="audio/mpeg" length="1000"/>
+    </item>
+    <item>
+      <guid>announcement</guid>
+      <title>Tour dates</title>
+      <description>No audio here</description>
+    </item>
+  </channel>
+</rss>`
+
+func TestRSSSourceNormalizesPodcastEpisodes(t *testing.T) {
+	// Test feeds are served on loopback, which feeds are not fetched from
+	defer UseFeedTransport(UseFeedTransport(http.DefaultTransport))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(podcastFeed))
+	}))
+	defer srv.Close()
+
+	source := NewRSSSource(srv.URL)
+	tracks, err := FetchTracks(context.Background(), source)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(tracks) != 1 {
+		t.Fatalf("Expected the episode without an enclosure to be skipped, got %d tracks", len(tracks))
+	}
+	if source.Title != "Deep Mix Series" || source.Image != "https://example.com/show.jpg" {
+		t.Errorf("Unexpected feed details %q %q", source.Title, source.Image)
+	}
+
+	track := tracks[0].(map[string]interface{})
+	if track["title"] != "Episode 42 – Warehouse set" {
+		t.Errorf("Unexpected title %q", track["title"])
+	}
+	if track["duration"] != float64(3723000) {
+		t.Errorf("Expected the itunes:duration in milliseconds, got %v", track["duration"])
+	}
+	if track["genre"] != "Techno" || track["tag_list"] != `Techno "Live Sets"` {
+		t.Errorf("Unexpected categories %q %q", track["genre"], track["tag_list"])
+	}
+	if EnclosureURL(track) != "https://cdn.example.com/42.mp3" {
+		t.Errorf("Unexpected enclosure %q", EnclosureURL(track))
+	}
+	if track["artwork_url"] != "https://example.com/show.jpg" {
+		t.Errorf("Expected the show artwork, got %v", track["artwork_url"])
+	}
+	user := track["user"].(map[string]interface{})
+	if user["username"] != "Night Shift Radio" {
+		t.Errorf("Expected the podcast author, got %v", user["username"])
+	}
+
+	normalized, err := NormalizeTrack(track)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if normalized.Length != 3723 || normalized.PostTime.Year() != 2025 {
+		t.Errorf("Unexpected stored track %+v", normalized)
+	}
+	again, err := FetchTracks(context.Background(), NewRSSSource(srv.URL))
+	if err != nil || again[0].(map[string]interface{})["id"] != track["id"] {
+		t.Errorf("Expected a stable id across fetches, got %v", again)
+	}
+}
+
+func TestRSSSourceFollowsAtomPages(t *testing.T) {
+	defer UseFeedTransport(UseFeedTransport(http.DefaultTransport))
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next := ""
+		page := r.URL.Query().Get("page")
+		if page == "" {
+			next = fmt.Sprintf(`<link rel="next" href="%s/?page=2"/>`, srv.URL)
+			page = "1"
+		}
+		fmt.Fprintf(w, `<feed xmlns="http://www.w3.org/2005/Atom">
+  <title>Radio Show</title>
+  <author><name>Host</name></author>
+  %s
+  <entry>
+    <id>urn:show:%s</id>
+    <title>Show %s</title>
+    <published>2025-10-0%sT10:00:00Z</published>
+    <category term="House"/>
+    <link rel="alternate" href="https://example.com/show/%s"/>
+    <link rel="enclosure" href="https://cdn.example.com/%s.m4a" type="audio/mp4"/>
+  </entry>
+</feed>`, next, page, page, page, page, page)
+	}))
+	defer srv.Close()
+
+	tracks, err := FetchTracks(context.Background(), NewRSSSource(srv.URL))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(tracks) != 2 {
+		t.Fatalf("Expected an episode from each page, got %d", len(tracks))
+	}
+	second := tracks[1].(map[string]interface{})
+	if second["title"] != "Show 2" || second["permalink_url"] != "https://example.com/show/2" || second["genre"] != "House" {
+		t.Errorf("Unexpected entry %v", second)
+	}
+	if second["created_at"] != "2025/10/02 10:00:00 +0000" {
+		t.Errorf("Unexpected post time %v", second["created_at"])
+	}
+}
+
+func TestRSSSourceRejectsWebPages(t *testing.T) {
+	defer UseFeedTransport(UseFeedTransport(http.DefaultTransport))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<!DOCTYPE html><html><body><p>Not a feed<br></body></html>`))
+	}))
+	defer srv.Close()
+
+	if _, err := FetchTracks(context.Background(), NewRSSSource(srv.URL)); err == nil {
+		t.Error("Expected an HTML page to be rejected")
+	}
+}
+
+func TestRSSSourceDropsScriptLinks(t *testing.T) {
+	source := NewRSSSource("https://example.com/feed.rss")
+	track, ok := source.Normalize(feedItem{
+		GUID:         "episode-1",
+		Title:        "Episode",
+		Link:         "javascript:alert(document.cookie)",
+		Image:        "data:image/svg+xml,<svg onload=alert(1)>",
+		EnclosureURL: "https://cdn.example.com/1.mp3",
+	})
+	if !ok {
+		t.Fatal("Expected the episode to be kept")
+	}
+	if track["permalink_url"] != "" {
+		t.Errorf("Expected the javascript: link to be dropped, got %v", track["permalink_url"])
+	}
+	if _, ok := track["artwork_url"]; ok {
+		t.Errorf("Expected the data: artwork to be dropped, got %v", track["artwork_url"])
+	}
+
+	if _, ok := source.Normalize(feedItem{GUID: "episode-2", EnclosureURL: " JavaScript:alert(1)"}); ok {
+		t.Error("Expected an episode whose media is not a web URL to be skipped")
+	}
+}
+
+func TestRSSSourceRefusesPrivateAddresses(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(podcastFeed))
+	}))
+	defer srv.Close()
+
+	if _, err := FetchTracks(context.Background(), NewRSSSource(srv.URL)); !errors.Is(err, ErrPrivateAddress) {
+		t.Errorf("Expected a loopback feed to be refused, got %v", err)
+	}
+}
+
+func TestParseFeedDuration(t *testing.T) {
+	for raw, want := range map[string]float64{
+		"3723":     3723,
+		"62:03":    3723,
+		"01:02:03": 3723,
+		"":         0,
+		"an hour":  0,
+	} {
+		if got := parseFeedDuration(raw); got != want {
+			t.Errorf("parseFeedDuration(%q) = %v, want %v", raw, got, want)
+		}
+	}
+}
@@ -0,0 +1,296 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/gobuffalo/pop/v6"
+	"github.com/gofrs/uuid"
+	"github.com/jbhicks/sound-cistern/src/models"
+)
+
+// ErrRuleNotFound is returned when a rule does not exist for the user
+var ErrRuleNotFound = errors.New("rule not found")
+
+// RuleActions lists the actions a rule can take, in the order they are
+// offered
+var RuleActions = []string{models.RuleActionTag, models.RuleActionHide, models.RuleActionPin, models.RuleActionNotify}
+
+// RuleActionLabels names each rule action for display
+var RuleActionLabels = map[string]string{
+	models.RuleActionTag:    "Tag",
+	models.RuleActionHide:   "Hide",
+	models.RuleActionPin:    "Pin",
+	models.RuleActionNotify: "Notify",
+}
+
+// RuleResult is what the user's rules did to a batch of newly synced tracks
+type RuleResult struct {
+	// Visible are the tracks no rule hid, in their original order
+	Visible []interface{}
+	// Notify holds the tracks each notify rule matched, by the alert they
+	// are delivered through
+	Notify map[uuid.UUID][]interface{}
+	// Executions counts the actions taken
+	Executions int
+}
+
+// RulePreview is a dry run of a draft rule against the cached feed
+type RulePreview struct {
+	// Matches are the tracks the rule would act on
+	Matches []interface{}
+	// Blocked are tracks that match the rule's criteria but that an earlier
+	// rule stops processing for, by the blocking rule's name
+	Blocked map[string][]interface{}
+}
+
+// compiledRule is a rule with its criteria decoded
+type compiledRule struct {
+	rule     models.Rule
+	criteria map[string]interface{}
+}
+
+// RuleService runs each user's rules on newly synced tracks
+type RuleService struct {
+	DB *pop.Connection
+}
+
+// NewRuleService creates a new service
+func NewRuleService(db *pop.Connection) *RuleService {
+	return &RuleService{DB: db}
+}
+
+// Rules returns the user's rules in the order they run
+func (rs *RuleService) Rules(userID string) (models.Rules, error) {
+	rules := models.Rules{}
+	err := rs.DB.Where("user_id = ?", userID).Order("priority asc, created_at asc").All(&rules)
+	return rules, err
+}
+
+// Rule returns one of the user's rules
+func (rs *RuleService) Rule(userID, ruleID string) (*models.Rule, error) {
+	ruleUUID, err := uuid.FromString(ruleID)
+	if err != nil {
+		return nil, ErrRuleNotFound
+	}
+	rule := &models.Rule{}
+	if err := rs.DB.Where("id = ? AND user_id = ?", ruleUUID, userID).First(rule); err != nil {
+		return nil, ErrRuleNotFound
+	}
+	return rule, nil
+}
+
+// Apply runs the user's active rules on newly synced tracks. Each track
+// goes through the rules in priority order until one that matches stops
+// processing. Tags, hides and pins are stored on the track, and every
+// action is recorded in its rule's execution log.
+func (rs *RuleService) Apply(userID string, tracks []interface{}) (*RuleResult, error) {
+	result := &RuleResult{Visible: []interface{}{}, Notify: map[uuid.UUID][]interface{}{}}
+	if len(tracks) == 0 {
+		return result, nil
+	}
+	userUUID, err := uuid.FromString(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	rules, err := rs.activeRules(userUUID)
+	if err != nil {
+		return nil, err
+	}
+	if len(rules) == 0 {
+		result.Visible = tracks
+		return result, nil
+	}
+
+	feedService := NewFeedService(rs.DB)
+	for _, t := range tracks {
+		trackMap, ok := t.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		stored := &models.Track{}
+		if err := rs.DB.Where("user_id = ? AND soundcloud_id = ?", userUUID, FormatID(trackMap["id"])).First(stored); err != nil {
+			return result, err
+		}
+
+		hidden := false
+		for _, matched := range matchRules(feedService, rules, trackMap) {
+			rule := matched.rule
+			switch rule.Action {
+			case models.RuleActionTag:
+				err = rs.tag(stored.ID, rule.ActionValue)
+			case models.RuleActionHide:
+				hidden = true
+				err = rs.DB.RawQuery("UPDATE soundcloud_tracks SET hidden = ? WHERE id = ?", true, stored.ID).Exec()
+			case models.RuleActionPin:
+				err = rs.DB.RawQuery("UPDATE soundcloud_tracks SET pinned = ? WHERE id = ?", true, stored.ID).Exec()
+			case models.RuleActionNotify:
+				if alertID, perr := uuid.FromString(rule.ActionValue); perr == nil {
+					result.Notify[alertID] = append(result.Notify[alertID], trackMap)
+				}
+			}
+			if err != nil {
+				return result, err
+			}
+
+			execution := &models.RuleExecution{
+				ID:           uuid.Must(uuid.NewV4()),
+				RuleID:       rule.ID,
+				SoundcloudID: stored.SoundcloudID,
+				Title:        stored.Title,
+				Action:       rule.Action,
+			}
+			if err := rs.DB.Create(execution); err != nil {
+				return result, err
+			}
+			result.Executions++
+		}
+		if !hidden {
+			result.Visible = append(result.Visible, trackMap)
+		}
+	}
+	return result, nil
+}
+
+// Preview runs a draft rule against tracks, usually the cached feed, as
+// if it were saved alongside the user's active rules. Nothing is stored.
+func (rs *RuleService) Preview(userID string, draft models.Rule, tracks []interface{}) (*RulePreview, error) {
+	userUUID, err := uuid.FromString(userID)
+	if err != nil {
+		return nil, err
+	}
+	rules, err := rs.activeRules(userUUID)
+	if err != nil {
+		return nil, err
+	}
+	criteria := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(draft.Criteria), &criteria); err != nil {
+		return nil, err
+	}
+
+	// The draft runs after saved rules of the same priority, as it would
+	// once created
+	position := len(rules)
+	for i, rule := range rules {
+		if rule.rule.Priority > draft.Priority {
+			position = i
+			break
+		}
+	}
+
+	feedService := NewFeedService(rs.DB)
+	preview := &RulePreview{Matches: []interface{}{}, Blocked: map[string][]interface{}{}}
+	for _, t := range tracks {
+		trackMap, ok := t.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		before := matchRules(feedService, rules[:position], trackMap)
+		if !feedService.matchesCriteria(withRuleTags(trackMap, before), criteria) {
+			continue
+		}
+		if n := len(before); n > 0 && before[n-1].rule.StopProcessing {
+			blocker := before[n-1].rule.Name
+			preview.Blocked[blocker] = append(preview.Blocked[blocker], trackMap)
+			continue
+		}
+		preview.Matches = append(preview.Matches, trackMap)
+	}
+	return preview, nil
+}
+
+// Executions returns a rule's most recent actions, newest first
+func (rs *RuleService) Executions(ruleID uuid.UUID, limit int) (models.RuleExecutions, error) {
+	executions := models.RuleExecutions{}
+	err := rs.DB.Where("rule_id = ?", ruleID).Order("created_at desc").Limit(limit).All(&executions)
+	return executions, err
+}
+
+// Tags returns the tags rules have put on each track, keyed by track id
+func (rs *RuleService) Tags(trackIDs []uuid.UUID) (map[uuid.UUID][]string, error) {
+	tags := map[uuid.UUID][]string{}
+	if len(trackIDs) == 0 {
+		return tags, nil
+	}
+	ids := make([]interface{}, len(trackIDs))
+	for i, id := range trackIDs {
+		ids[i] = id
+	}
+	rows := models.TrackTags{}
+	if err := rs.DB.Where("track_id IN (?)", ids...).Order("tag asc").All(&rows); err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		tags[row.TrackID] = append(tags[row.TrackID], row.Tag)
+	}
+	return tags, nil
+}
+
+// tag puts a tag on a stored track once
+func (rs *RuleService) tag(trackID uuid.UUID, tag string) error {
+	count, err := rs.DB.Where("track_id = ? AND tag = ?", trackID, tag).Count(&models.TrackTag{})
+	if err != nil || count > 0 {
+		return err
+	}
+	return rs.DB.Create(&models.TrackTag{ID: uuid.Must(uuid.NewV4()), TrackID: trackID, Tag: tag})
+}
+
+// activeRules loads the user's active rules in the order they run with
+// their criteria decoded
+func (rs *RuleService) activeRules(userUUID uuid.UUID) ([]compiledRule, error) {
+	rules := models.Rules{}
+	if err := rs.DB.Where("user_id = ? AND active = ?", userUUID, true).Order("priority asc, created_at asc").All(&rules); err != nil {
+		return nil, err
+	}
+	compiled := make([]compiledRule, 0, len(rules))
+	for _, rule := range rules {
+		criteria := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(rule.Criteria), &criteria); err != nil {
+			return nil, fmt.Errorf("rule %s has invalid criteria: %w", rule.ID, err)
+		}
+		compiled = append(compiled, compiledRule{rule: rule, criteria: criteria})
+	}
+	return compiled, nil
+}
+
+// matchRules returns the rules that act on a track, in order, up to and
+// including the first matching rule that stops processing. Tags added by
+// earlier rules count towards the criteria of later ones.
+func matchRules(feedService *FeedService, rules []compiledRule, track map[string]interface{}) []compiledRule {
+	var matched []compiledRule
+	for _, rule := range rules {
+		if !feedService.matchesCriteria(withRuleTags(track, matched), rule.criteria) {
+			continue
+		}
+		matched = append(matched, rule)
+		if rule.rule.StopProcessing {
+			break
+		}
+	}
+	return matched
+}
+
+// withRuleTags returns the track with the tags the given rules add to it,
+// leaving the original untouched
+func withRuleTags(track map[string]interface{}, rules []compiledRule) map[string]interface{} {
+	var tags []string
+	for _, rule := range rules {
+		if rule.rule.Action == models.RuleActionTag {
+			tags = append(tags, rule.rule.ActionValue)
+		}
+	}
+	if len(tags) == 0 {
+		return track
+	}
+	if existing, ok := track["tags"].([]string); ok {
+		tags = append(append([]string{}, existing...), tags...)
+	}
+	tagged := make(map[string]interface{}, len(track)+1)
+	for k, v := range track {
+		tagged[k] = v
+	}
+	tagged["tags"] = tags
+	return tagged
+}
@@ -0,0 +1,58 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/gofrs/uuid"
+	"github.com/jbhicks/sound-cistern/src/models"
+)
+
+func testRule(name, action, value string, stop bool, criteria map[string]interface{}) compiledRule {
+	return compiledRule{
+		rule: models.Rule{
+			ID:             uuid.Must(uuid.NewV4()),
+			Name:           name,
+			Action:         action,
+			ActionValue:    value,
+			StopProcessing: stop,
+		},
+		criteria: criteria,
+	}
+}
+
+func TestMatchRulesStopsProcessing(t *testing.T) {
+	fs := NewFeedService(nil)
+	rules := []compiledRule{
+		testRule("Tag edits", models.RuleActionTag, "edit", false, map[string]interface{}{"keywords": []interface{}{"edit"}}),
+		testRule("Hide short", models.RuleActionHide, "", true, map[string]interface{}{"max_length": float64(120)}),
+		testRule("Pin everything", models.RuleActionPin, "", false, map[string]interface{}{}),
+	}
+
+	short := map[string]interface{}{"title": "Quick Edit", "duration": float64(90000)}
+	matched := matchRules(fs, rules, short)
+	if len(matched) != 2 || matched[1].rule.Name != "Hide short" {
+		t.Fatalf("Expected the tag and hide rules only, got %v", matched)
+	}
+
+	long := map[string]interface{}{"title": "Long mix", "duration": float64(3600000)}
+	matched = matchRules(fs, rules, long)
+	if len(matched) != 1 || matched[0].rule.Name != "Pin everything" {
+		t.Errorf("Expected only the pin rule, got %v", matched)
+	}
+}
+
+func TestMatchRulesSeesEarlierTags(t *testing.T) {
+	fs := NewFeedService(nil)
+	rules := []compiledRule{
+		testRule("Tag edits", models.RuleActionTag, "edit", false, map[string]interface{}{"keywords": []interface{}{"edit"}}),
+		testRule("Pin edits", models.RuleActionPin, "", false, map[string]interface{}{"tag": "edit"}),
+	}
+	track := map[string]interface{}{"title": "Club Edit"}
+
+	if matched := matchRules(fs, rules, track); len(matched) != 2 {
+		t.Errorf("Expected the pin rule to see the tag, got %v", matched)
+	}
+	if _, ok := track["tags"]; ok {
+		t.Error("Expected the track itself to be left untouched")
+	}
+}
@@ -0,0 +1,300 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gobuffalo/pop/v6"
+	"github.com/gofrs/uuid"
+	"github.com/jbhicks/sound-cistern/src/models"
+)
+
+// DefaultDemoSeed is the generator seed used when a seed file or DEMO_SEED
+// does not set one. Seeding and demo mode have to agree on it for seeded
+// accounts to sync the same tracks they were seeded with.
+const DefaultDemoSeed = 42
+
+// SeedSpec describes demo data: the users to create and, for each, the
+// Soundcloud accounts to link and the presets and bookmarks to save
+type SeedSpec struct {
+	Seed int64 `json:"seed"`
+	// Password is given to every seeded user
+	Password string     `json:"password"`
+	Users    []SeedUser `json:"users"`
+}
+
+// SeedUser is a user to seed
+type SeedUser struct {
+	Email     string         `json:"email"`
+	FirstName string         `json:"first_name"`
+	LastName  string         `json:"last_name"`
+	Role      string         `json:"role"`
+	Accounts  []SeedAccount  `json:"accounts"`
+	Presets   []SeedPreset   `json:"presets"`
+	Bookmarks []SeedBookmark `json:"bookmarks"`
+}
+
+// SeedAccount is a Soundcloud account to link. Its feed, likes, playlists
+// and followed artists are generated from its id.
+type SeedAccount struct {
+	SoundcloudID string `json:"soundcloud_id"`
+	Username     string `json:"username"`
+	// TracksFile is a JSON file of Soundcloud API tracks, such as a
+	// recorded /me/tracks response, stored as the feed instead of
+	// generated tracks. Relative paths are read from the seed file's
+	// directory.
+	TracksFile string `json:"tracks_file"`
+}
+
+// SeedPreset is a feed filter saved as a playlist preset
+type SeedPreset struct {
+	Title string `json:"title"`
+	// Filters is a feed query string, as in the address bar
+	Filters string `json:"filters"`
+}
+
+// SeedBookmark labels the newest tracks matching a filter, optionally
+// with a note on each
+type SeedBookmark struct {
+	Label   string `json:"label"`
+	Color   string `json:"color"`
+	Filters string `json:"filters"`
+	Limit   int    `json:"limit"`
+	Note    string `json:"note"`
+}
+
+// SeedResult counts what seeding a user stored
+type SeedResult struct {
+	Accounts  int
+	Tracks    int
+	Presets   int
+	Bookmarks int
+}
+
+// LoadSeedSpec reads a seed file
+func LoadSeedSpec(path string) (*SeedSpec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	spec := &SeedSpec{}
+	if err := json.Unmarshal(data, spec); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	dir := filepath.Dir(path)
+	for i := range spec.Users {
+		for k, account := range spec.Users[i].Accounts {
+			if account.TracksFile != "" && !filepath.IsAbs(account.TracksFile) {
+				spec.Users[i].Accounts[k].TracksFile = filepath.Join(dir, account.TracksFile)
+			}
+		}
+	}
+	if spec.Seed == 0 {
+		spec.Seed = DefaultDemoSeed
+	}
+	if spec.Password == "" {
+		spec.Password = "demo-password"
+	}
+	return spec, nil
+}
+
+// GenerateSeedSpec makes up a seed for the given number of users, each
+// with one or two linked accounts, a few presets and a bookmark label
+func GenerateSeedSpec(seed int64, users int) *SeedSpec {
+	spec := &SeedSpec{Seed: seed, Password: "demo-password"}
+	genres := demoGenreNames
+	for i := 1; i <= users; i++ {
+		soundcloudID := fmt.Sprintf("%d", 6000000+i*10)
+		user := SeedUser{
+			Email:     fmt.Sprintf("demo%d@soundcistern.local", i),
+			FirstName: "Demo",
+			LastName:  fmt.Sprintf("Listener %d", i),
+			Accounts:  []SeedAccount{{SoundcloudID: soundcloudID, Username: fmt.Sprintf("demo_listener_%d", i)}},
+			Presets: []SeedPreset{
+				{Title: "Long mixes", Filters: "kind=mix&sort=longest"},
+				{Title: genres[i%len(genres)] + " singles", Filters: url.Values{"genres": {genres[i%len(genres)]}, "kind": {"single"}}.Encode()},
+			},
+			Bookmarks: []SeedBookmark{
+				{Label: "Favourites", Color: "#e8590c", Filters: "kind=single", Limit: 25},
+			},
+		}
+		if i%2 == 1 {
+			user.Accounts = append(user.Accounts, SeedAccount{SoundcloudID: fmt.Sprintf("%d", 6000000+i*10+1), Username: fmt.Sprintf("demo_label_%d", i)})
+		}
+		spec.Users = append(spec.Users, user)
+	}
+	return spec
+}
+
+// SeedService stores demo data for seeded users
+type SeedService struct {
+	DB        *pop.Connection
+	Generator *DemoGenerator
+}
+
+// NewSeedService creates a new service
+func NewSeedService(db *pop.Connection, generator *DemoGenerator) *SeedService {
+	return &SeedService{DB: db, Generator: generator}
+}
+
+// SeedUser links the user's accounts and stores their tracks, library and
+// followed artists as a sync would, then saves their presets and
+// bookmarks. Seeding the same user again refreshes the data rather than
+// duplicating it.
+func (ss *SeedService) SeedUser(ctx context.Context, userID string, user SeedUser) (*SeedResult, error) {
+	result := &SeedResult{}
+	accountService := NewAccountService(ss.DB)
+	feedService := NewFeedService(ss.DB)
+	var followings []interface{}
+	for _, spec := range user.Accounts {
+		account, err := accountService.SaveAccount(userID, spec.SoundcloudID, spec.Username, DemoToken(spec.SoundcloudID))
+		if err != nil {
+			return nil, err
+		}
+		feed := ss.Generator.Feed(spec.SoundcloudID)
+		if spec.TracksFile != "" {
+			if feed, err = loadSeedTracks(spec.TracksFile); err != nil {
+				return nil, err
+			}
+		}
+		if _, err := feedService.StoreAccountTracks(ctx, account, feed); err != nil {
+			return nil, err
+		}
+		if _, err := feedService.CacheAccountFeed(ctx, userID, account.ID, feed); err != nil {
+			return nil, err
+		}
+		likes, playlists := ss.Generator.Likes(spec.SoundcloudID), ss.Generator.Playlists(spec.SoundcloudID)
+		if _, err := feedService.StoreAccountLibrary(ctx, account, likes, playlists); err != nil {
+			return nil, err
+		}
+		if err := accountService.RecordSync(account); err != nil {
+			return nil, err
+		}
+		followings = append(followings, ss.Generator.Followings(spec.SoundcloudID)...)
+		result.Accounts++
+		result.Tracks += len(feed)
+	}
+	if _, err := NewFollowingService(ss.DB).Store(userID, followings); err != nil {
+		return nil, err
+	}
+
+	for _, preset := range user.Presets {
+		created, err := ss.seedPreset(userID, preset)
+		if err != nil {
+			return nil, err
+		}
+		if created {
+			result.Presets++
+		}
+	}
+	for _, bookmark := range user.Bookmarks {
+		n, err := ss.seedBookmark(userID, bookmark)
+		if err != nil {
+			return nil, err
+		}
+		result.Bookmarks += n
+	}
+	return result, nil
+}
+
+// seedPreset saves a preset unless the user has one with the same title
+func (ss *SeedService) seedPreset(userID string, preset SeedPreset) (bool, error) {
+	exportService := NewPlaylistExportService(ss.DB, nil)
+	exports, err := exportService.Exports(userID)
+	if err != nil {
+		return false, err
+	}
+	for _, export := range exports {
+		if export.Title == preset.Title {
+			return false, nil
+		}
+	}
+	values, err := url.ParseQuery(preset.Filters)
+	if err != nil {
+		return false, fmt.Errorf("preset %q: %w", preset.Title, err)
+	}
+	_, err = exportService.Create(userID, preset.Title, CriteriaFromValues(values), values.Get("sort"))
+	return err == nil, err
+}
+
+// seedBookmark labels the newest tracks matching the bookmark's filter and
+// returns how many were labeled
+func (ss *SeedService) seedBookmark(userID string, bookmark SeedBookmark) (int, error) {
+	userUUID, err := uuid.FromString(userID)
+	if err != nil {
+		return 0, err
+	}
+	labelService := NewLabelService(ss.DB)
+	label, err := ss.findLabel(userID, bookmark.Label)
+	if err != nil {
+		return 0, err
+	}
+	if label == nil {
+		if label, err = labelService.Create(userID, bookmark.Label, bookmark.Color); err != nil {
+			return 0, fmt.Errorf("bookmark label %q: %w", bookmark.Label, err)
+		}
+	}
+
+	values, err := url.ParseQuery(bookmark.Filters)
+	if err != nil {
+		return 0, fmt.Errorf("bookmark %q: %w", bookmark.Label, err)
+	}
+	q := filterQuery(ss.DB.Where("user_id = ?", userUUID), userUUID, CriteriaFromValues(values)).Order("post_time desc")
+	if bookmark.Limit > 0 {
+		q = q.Limit(bookmark.Limit)
+	}
+	tracks := models.Tracks{}
+	if err := q.All(&tracks); err != nil {
+		return 0, err
+	}
+	for i := range tracks {
+		if err := labelService.attach(tracks[i].ID, label.ID); err != nil {
+			return 0, err
+		}
+		if bookmark.Note != "" {
+			if err := labelService.SetNote(&tracks[i], bookmark.Note); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return len(tracks), nil
+}
+
+// findLabel returns the user's label with the given name, or nil
+func (ss *SeedService) findLabel(userID, name string) (*models.Label, error) {
+	labels, err := NewLabelService(ss.DB).Labels(userID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range labels {
+		if strings.EqualFold(labels[i].Name, name) {
+			return &labels[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// loadSeedTracks reads Soundcloud API tracks from a file holding either an
+// array of tracks or a collection
+func loadSeedTracks(path string) ([]interface{}, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var tracks []interface{}
+	if err := json.Unmarshal(data, &tracks); err == nil {
+		return tracks, nil
+	}
+	var collection struct {
+		Collection []interface{} `json:"collection"`
+	}
+	if err := json.Unmarshal(data, &collection); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	return collection.Collection, nil
+}
@@ -1,13 +1,36 @@
 package services
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
-	"time"
 )
 
+// soundcloudAPIURL is the base URL of the Soundcloud API
+var soundcloudAPIURL = "https://api.soundcloud.com"
+
+// soundcloudSignOutURL revokes an access token
+var soundcloudSignOutURL = "https://secure.soundcloud.com/sign-out"
+
+// ErrStreamUnavailable is returned when Soundcloud has no playable stream
+// for a track
+var ErrStreamUnavailable = errors.New("stream unavailable")
+
+// ErrPlaylistNotFound is returned when a playlist no longer exists on
+// Soundcloud, usually because the user deleted it in the app
+var ErrPlaylistNotFound = errors.New("playlist not found")
+
+// ErrUnauthorized is returned when Soundcloud rejects an account's token,
+// usually because it expired or access was revoked. The account has to be
+// connected again.
+var ErrUnauthorized = errors.New("soundcloud token rejected")
+
 // SoundcloudService handles Soundcloud API interactions
 type SoundcloudService struct {
 	ClientID     string
@@ -24,27 +47,46 @@ func NewSoundcloudService(clientID, clientSecret, redirectURI string) *Soundclou
 	}
 }
 
-// GetAuthURL returns the Soundcloud OAuth URL
+// FormatID returns a Soundcloud id decoded from JSON as a string. Numeric
+// ids decode as float64, which would otherwise print in exponent form.
+func FormatID(id interface{}) string {
+	switch v := id.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case nil:
+		return ""
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+// GetAuthURL returns the Soundcloud OAuth URL. When calls are answered
+// offline it points straight at the callback instead, since the recorded
+// or demo token exchange accepts any code.
 func (s *SoundcloudService) GetAuthURL() string {
+	if soundcloudOffline() {
+		return s.RedirectURI + "?code=offline"
+	}
 	return fmt.Sprintf("https://soundcloud.com/connect?client_id=%s&redirect_uri=%s&response_type=code", s.ClientID, s.RedirectURI)
 }
 
 // HandleCallback exchanges code for access token and creates user
-func (s *SoundcloudService) HandleCallback(code string) (interface{}, error) {
-	client := &http.Client{Timeout: 10 * time.Second}
+func (s *SoundcloudService) HandleCallback(ctx context.Context, code string) (interface{}, error) {
 
 	// Exchange code for access token
-	tokenURL := "https://api.soundcloud.com/oauth2/token"
+	tokenURL := soundcloudAPIURL + "/oauth2/token"
 	data := fmt.Sprintf("client_id=%s&client_secret=%s&redirect_uri=%s&grant_type=authorization_code&code=%s",
 		s.ClientID, s.ClientSecret, s.RedirectURI, code)
 
-	req, err := http.NewRequest("POST", tokenURL, strings.NewReader(data))
+	req, err := newRequest(ctx, "POST", tokenURL, strings.NewReader(data))
 	if err != nil {
 		return nil, err
 	}
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	res, err := client.Do(req)
+	res, err := soundcloudClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -66,7 +108,7 @@ func (s *SoundcloudService) HandleCallback(code string) (interface{}, error) {
 	}
 
 	// Fetch user info
-	userInfo, err := s.fetchUserInfo(accessToken)
+	userInfo, err := s.fetchUserInfo(ctx, accessToken)
 	if err != nil {
 		return nil, err
 	}
@@ -77,15 +119,41 @@ func (s *SoundcloudService) HandleCallback(code string) (interface{}, error) {
 	}, nil
 }
 
+// RevokeToken signs a token out of Soundcloud so it stops working. Tokens
+// that were already rejected count as revoked. Offline there is nothing to
+// revoke.
+func (s *SoundcloudService) RevokeToken(ctx context.Context, accessToken string) error {
+	if soundcloudOffline() || accessToken == "" {
+		return nil
+	}
+	body, err := json.Marshal(map[string]string{"access_token": accessToken})
+	if err != nil {
+		return err
+	}
+	req, err := newRequest(ctx, "POST", soundcloudSignOutURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := soundcloudClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 && res.StatusCode != http.StatusUnauthorized {
+		return fmt.Errorf("token revocation failed: %d", res.StatusCode)
+	}
+	return nil
+}
+
 // fetchUserInfo fetches user information from Soundcloud
-func (s *SoundcloudService) fetchUserInfo(accessToken string) (map[string]interface{}, error) {
-	client := &http.Client{Timeout: 10 * time.Second}
-	req, err := http.NewRequest("GET", "https://api.soundcloud.com/me", nil)
+func (s *SoundcloudService) fetchUserInfo(ctx context.Context, accessToken string) (map[string]interface{}, error) {
+	req, err := newRequest(ctx, "GET", soundcloudAPIURL+"/me", nil)
 	if err != nil {
 		return nil, err
 	}
 	req.Header.Set("Authorization", "Bearer "+accessToken)
-	res, err := client.Do(req)
+	res, err := soundcloudClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -99,22 +167,178 @@ func (s *SoundcloudService) fetchUserInfo(accessToken string) (map[string]interf
 }
 
 // FetchUserFeed fetches user's feed from Soundcloud
-func (s *SoundcloudService) FetchUserFeed(accessToken string) ([]interface{}, error) {
-	client := &http.Client{Timeout: 10 * time.Second}
-	req, err := http.NewRequest("GET", "https://api.soundcloud.com/me/tracks", nil)
+func (s *SoundcloudService) FetchUserFeed(ctx context.Context, accessToken string) ([]interface{}, error) {
+	return FetchTracks(ctx, s.Source(accessToken, "/me/tracks"))
+}
+
+// ResolveStream returns a playable URL for a track. Soundcloud stream URLs
+// need the user's token, so the API is asked for a signed MP3 URL that the
+// browser can play directly.
+func (s *SoundcloudService) ResolveStream(ctx context.Context, accessToken, trackID string) (string, error) {
+	req, err := newRequest(ctx, "GET", soundcloudAPIURL+"/tracks/"+url.PathEscape(trackID)+"/streams", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	res, err := soundcloudClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound || res.StatusCode == http.StatusForbidden {
+		return "", ErrStreamUnavailable
+	}
+	if res.StatusCode != 200 {
+		return "", fmt.Errorf("streams API error: %d", res.StatusCode)
+	}
+
+	var streams map[string]interface{}
+	if err := json.NewDecoder(res.Body).Decode(&streams); err != nil {
+		return "", err
+	}
+	for _, key := range []string{"http_mp3_128_url", "preview_mp3_128_url"} {
+		if streamURL, ok := streams[key].(string); ok && streamURL != "" {
+			return streamURL, nil
+		}
+	}
+	return "", ErrStreamUnavailable
+}
+
+// FetchFollowings fetches the accounts the user follows
+func (s *SoundcloudService) FetchFollowings(ctx context.Context, accessToken string) ([]interface{}, error) {
+	return s.fetchCollection(ctx, accessToken, "/me/followings?limit=200&linked_partitioning=true")
+}
+
+// FetchLikes fetches the tracks the user has liked
+func (s *SoundcloudService) FetchLikes(ctx context.Context, accessToken string) ([]interface{}, error) {
+	return s.fetchCollection(ctx, accessToken, "/me/likes/tracks?limit=200&linked_partitioning=true")
+}
+
+// FetchPlaylists fetches the user's playlists along with their tracks
+func (s *SoundcloudService) FetchPlaylists(ctx context.Context, accessToken string) ([]interface{}, error) {
+	return s.fetchCollection(ctx, accessToken, "/me/playlists?limit=50&show_tracks=true&linked_partitioning=true")
+}
+
+// fetchCollection fetches every page of a paginated API collection
+func (s *SoundcloudService) fetchCollection(ctx context.Context, accessToken, path string) ([]interface{}, error) {
+	return FetchItems(ctx, s.Source(accessToken, path))
+}
+
+// SoundcloudSource is a Soundcloud API listing fetched with an account's
+// token. Listings come back either as a plain array or as a collection
+// whose next_href links to the next page.
+type SoundcloudSource struct {
+	AccessToken string
+	Path        string
+}
+
+// Source returns the listing at path as a track source
+func (s *SoundcloudService) Source(accessToken, path string) *SoundcloudSource {
+	return &SoundcloudSource{AccessToken: accessToken, Path: path}
+}
+
+// Fetch fetches one page of the listing. The cursor is the next_href of
+// the previous page.
+func (src *SoundcloudSource) Fetch(ctx context.Context, cursor string) ([]interface{}, string, error) {
+	next := cursor
+	if next == "" {
+		next = soundcloudAPIURL + src.Path
+	}
+	req, err := newRequest(ctx, "GET", next, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+src.AccessToken)
+	res, err := soundcloudClient.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusUnauthorized {
+		return nil, "", ErrUnauthorized
+	}
+	if res.StatusCode != 200 {
+		return nil, "", fmt.Errorf("API error: %d", res.StatusCode)
+	}
+
+	var raw json.RawMessage
+	if err := json.NewDecoder(res.Body).Decode(&raw); err != nil {
+		return nil, "", err
+	}
+	if trimmed := bytes.TrimSpace(raw); len(trimmed) > 0 && trimmed[0] == '[' {
+		var items []interface{}
+		return items, "", json.Unmarshal(trimmed, &items)
+	}
+	var body struct {
+		Collection []interface{} `json:"collection"`
+		NextHref   string        `json:"next_href"`
+	}
+	if err := json.Unmarshal(raw, &body); err != nil {
+		return nil, "", err
+	}
+	return body.Collection, body.NextHref, nil
+}
+
+// Normalize returns Soundcloud tracks as they are, since they already are
+// the shape every other source is normalized to
+func (src *SoundcloudSource) Normalize(item interface{}) (map[string]interface{}, bool) {
+	track, ok := item.(map[string]interface{})
+	return track, ok
+}
+
+// RemotePlaylist is a playlist stored on Soundcloud
+type RemotePlaylist struct {
+	ID           string
+	PermalinkURL string
+}
+
+// SavePlaylist creates a private playlist holding the given tracks, in
+// order. When playlistID is set that playlist's title and tracks are
+// replaced instead.
+func (s *SoundcloudService) SavePlaylist(ctx context.Context, accessToken, playlistID, title string, trackIDs []string) (*RemotePlaylist, error) {
+	tracks := make([]map[string]json.Number, len(trackIDs))
+	for i, id := range trackIDs {
+		tracks[i] = map[string]json.Number{"id": json.Number(id)}
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"playlist": map[string]interface{}{
+			"title":   title,
+			"sharing": "private",
+			"tracks":  tracks,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	method, endpoint := "POST", soundcloudAPIURL+"/playlists"
+	if playlistID != "" {
+		method, endpoint = "PUT", soundcloudAPIURL+"/playlists/"+url.PathEscape(playlistID)
+	}
+	req, err := newRequest(ctx, method, endpoint, bytes.NewReader(body))
 	if err != nil {
 		return nil, err
 	}
 	req.Header.Set("Authorization", "Bearer "+accessToken)
-	res, err := client.Do(req)
+	req.Header.Set("Content-Type", "application/json")
+	res, err := soundcloudClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
 	defer res.Body.Close()
-	if res.StatusCode != 200 {
-		return nil, fmt.Errorf("API error: %d", res.StatusCode)
+	if res.StatusCode == http.StatusUnauthorized {
+		return nil, ErrUnauthorized
+	}
+	if res.StatusCode == http.StatusNotFound {
+		return nil, ErrPlaylistNotFound
+	}
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusCreated {
+		return nil, fmt.Errorf("playlist API error: %d", res.StatusCode)
+	}
+
+	var playlist map[string]interface{}
+	if err := json.NewDecoder(res.Body).Decode(&playlist); err != nil {
+		return nil, err
 	}
-	var tracks []interface{}
-	err = json.NewDecoder(res.Body).Decode(&tracks)
-	return tracks, err
+	return &RemotePlaylist{ID: FormatID(playlist["id"]), PermalinkURL: stringField(playlist, "permalink_url")}, nil
 }
@@ -0,0 +1,171 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func withSoundcloudAPI(t *testing.T, handler http.HandlerFunc) {
+	srv := httptest.NewServer(handler)
+	original := soundcloudAPIURL
+	soundcloudAPIURL = srv.URL
+	t.Cleanup(func() {
+		soundcloudAPIURL = original
+		srv.Close()
+	})
+}
+
+func TestResolveStream(t *testing.T) {
+	withSoundcloudAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/tracks/123/streams" {
+			t.Errorf("Unexpected path %q", r.URL.Path)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer token" {
+			t.Errorf("Expected bearer token, got %q", got)
+		}
+		w.Write([]byte(`{"http_mp3_128_url": "https://cf-media.example.com/123.mp3?sig=abc"}`))
+	})
+
+	streamURL, err := NewSoundcloudService("", "", "").ResolveStream(context.Background(), "token", "123")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if streamURL != "https://cf-media.example.com/123.mp3?sig=abc" {
+		t.Errorf("Unexpected stream URL %q", streamURL)
+	}
+}
+
+func TestResolveStreamUnavailable(t *testing.T) {
+	withSoundcloudAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/tracks/404/streams" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"hls_mp3_128_url": "https://cf-hls-media.example.com/playlist.m3u8"}`))
+	})
+
+	sc := NewSoundcloudService("", "", "")
+	if _, err := sc.ResolveStream(context.Background(), "token", "404"); err != ErrStreamUnavailable {
+		t.Errorf("Expected ErrStreamUnavailable for a missing track, got %v", err)
+	}
+	if _, err := sc.ResolveStream(context.Background(), "token", "123"); err != ErrStreamUnavailable {
+		t.Errorf("Expected ErrStreamUnavailable without an MP3 stream, got %v", err)
+	}
+}
+
+func TestFormatID(t *testing.T) {
+	if got := FormatID(float64(123456789012)); got != "123456789012" {
+		t.Errorf("Expected plain digits, got %q", got)
+	}
+}
+
+func TestFetchFollowingsFollowsPages(t *testing.T) {
+	var base string
+	withSoundcloudAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/me/followings" {
+			t.Errorf("Unexpected path %q", r.URL.Path)
+		}
+		if r.URL.Query().Get("cursor") == "" {
+			w.Write([]byte(`{"collection": [{"id": 1, "username": "burial"}], "next_href": "` + base + `/me/followings?cursor=2"}`))
+			return
+		}
+		w.Write([]byte(`{"collection": [{"id": 2, "username": "kode9"}], "next_href": null}`))
+	})
+	base = soundcloudAPIURL
+
+	followings, err := NewSoundcloudService("", "", "").FetchFollowings(context.Background(), "token")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(followings) != 2 {
+		t.Fatalf("Expected followings from both pages, got %d", len(followings))
+	}
+	if name := followings[1].(map[string]interface{})["username"]; name != "kode9" {
+		t.Errorf("Expected kode9 from the second page, got %v", name)
+	}
+}
+
+func TestFetchPlaylistsIncludesTracks(t *testing.T) {
+	withSoundcloudAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/me/playlists" || r.URL.Query().Get("show_tracks") != "true" {
+			t.Errorf("Unexpected request %q", r.URL.String())
+		}
+		w.Write([]byte(`{"collection": [{"id": 9, "title": "Sunday", "tracks": [{"id": 1}]}]}`))
+	})
+
+	playlists, err := NewSoundcloudService("", "", "").FetchPlaylists(context.Background(), "token")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(playlists) != 1 {
+		t.Fatalf("Expected one playlist, got %d", len(playlists))
+	}
+}
+
+func TestSoundcloudCallsSendRequestID(t *testing.T) {
+	withSoundcloudAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get(RequestIDHeader); got != "abc-123" {
+			t.Errorf("Expected the request id to be sent, got %q", got)
+		}
+		w.Write([]byte(`{"collection": []}`))
+	})
+
+	ctx := WithRequestID(context.Background(), "abc-123")
+	if _, err := NewSoundcloudService("", "", "").FetchLikes(ctx, "token"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestSoundcloudCallsStopWhenCancelled(t *testing.T) {
+	started := make(chan struct{})
+	withSoundcloudAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-r.Context().Done()
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+	failures := SoundcloudStatus().Failures
+	if _, err := NewSoundcloudService("", "", "").FetchLikes(ctx, "token"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected the fetch to be cancelled, got %v", err)
+	}
+	if got := SoundcloudStatus().Failures; got != failures {
+		t.Errorf("Expected a cancelled call not to count against Soundcloud, got %d failures", got)
+	}
+}
+
+func TestRevokeToken(t *testing.T) {
+	var revoked string
+	withSoundcloudAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/sign-out" {
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		revoked = body["access_token"]
+		if revoked == "expired" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	})
+	original := soundcloudSignOutURL
+	soundcloudSignOutURL = soundcloudAPIURL + "/sign-out"
+	t.Cleanup(func() { soundcloudSignOutURL = original })
+
+	sc := NewSoundcloudService("id", "secret", "")
+	if err := sc.RevokeToken(context.Background(), "live-token"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if revoked != "live-token" {
+		t.Errorf("Expected the token to be signed out, got %q", revoked)
+	}
+	if err := sc.RevokeToken(context.Background(), "expired"); err != nil {
+		t.Errorf("Expected a token Soundcloud already rejects to count as revoked, got %v", err)
+	}
+}
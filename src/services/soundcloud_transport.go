@@ -0,0 +1,328 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrSoundcloudUnavailable is returned without calling Soundcloud while the
+// circuit breaker is open after repeated failures
+var ErrSoundcloudUnavailable = errors.New("soundcloud is unavailable, try again shortly")
+
+// Circuit breaker states
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half_open"
+)
+
+// soundcloudClient is shared by every Soundcloud API call so they are rate
+// limited, retried and broken together. The timeout covers retries.
+var soundcloudClient = &http.Client{
+	Timeout:   60 * time.Second,
+	Transport: NewSoundcloudTransport(http.DefaultTransport),
+}
+
+// SoundcloudTransport is the http.RoundTripper Soundcloud API calls go
+// through. It spaces requests out with a token bucket, retries rate limited
+// and failed requests with backoff, and stops calling Soundcloud for a
+// while once it keeps failing.
+type SoundcloudTransport struct {
+	Base    http.RoundTripper
+	Limiter *RateLimiter
+	Breaker *CircuitBreaker
+	// MaxRetries is how many times a request is retried after a 429, a 5xx
+	// or a network error
+	MaxRetries int
+	// Backoff is the wait before the first retry. It doubles on each
+	// retry, with jitter, unless the response says how long to wait.
+	Backoff time.Duration
+	// MaxWait caps how long a Retry-After is honored. Longer waits give
+	// the response back to the caller instead.
+	MaxWait time.Duration
+}
+
+// NewSoundcloudTransport creates a transport with the limits used for the
+// Soundcloud API: 5 requests a second with bursts of 10, 3 retries, and a
+// breaker that opens after 5 failures in a row for 30 seconds
+func NewSoundcloudTransport(base http.RoundTripper) *SoundcloudTransport {
+	return &SoundcloudTransport{
+		Base:       base,
+		Limiter:    NewRateLimiter(5, 10),
+		Breaker:    NewCircuitBreaker(5, 30*time.Second),
+		MaxRetries: 3,
+		Backoff:    500 * time.Millisecond,
+		MaxWait:    30 * time.Second,
+	}
+}
+
+// RoundTrip sends the request, retrying when Soundcloud asks to slow down
+// or fails. Requests that create things are only retried after a 429,
+// since a 5xx may have come after the playlist was already created.
+func (t *SoundcloudTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		if !t.Breaker.Allow() {
+			return nil, ErrSoundcloudUnavailable
+		}
+		if err := t.Limiter.Wait(req.Context()); err != nil {
+			t.Breaker.Release()
+			return nil, err
+		}
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				t.Breaker.Release()
+				return nil, err
+			}
+			req.Body = body
+		}
+
+		res, err := t.Base.RoundTrip(req)
+		if err != nil && req.Context().Err() != nil {
+			// The caller went away, which says nothing about Soundcloud
+			t.Breaker.Release()
+			return nil, req.Context().Err()
+		}
+		failed := err != nil || res.StatusCode >= 500
+		if failed {
+			t.Breaker.Failure()
+		} else {
+			t.Breaker.Success()
+		}
+
+		retryable := failed && req.Method != http.MethodPost
+		if err == nil && res.StatusCode == http.StatusTooManyRequests {
+			retryable = true
+		}
+		if !retryable || attempt >= t.MaxRetries {
+			return res, err
+		}
+
+		wait := t.backoff(attempt)
+		if res != nil {
+			if after, ok := retryAfter(res.Header.Get("Retry-After")); ok {
+				wait = after
+			}
+			if wait > t.MaxWait {
+				return res, nil
+			}
+			res.Body.Close()
+		}
+		select {
+		case <-time.After(wait):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+// backoff returns the wait before a retry: the base backoff doubled for
+// each earlier retry, plus up to half again as jitter
+func (t *SoundcloudTransport) backoff(attempt int) time.Duration {
+	wait := t.Backoff << attempt
+	if wait <= 0 {
+		return 0
+	}
+	return wait + time.Duration(rand.Int63n(int64(wait)/2+1))
+}
+
+// retryAfter parses a Retry-After header, which is either a number of
+// seconds or an HTTP date
+func retryAfter(header string) (time.Duration, bool) {
+	if header == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(header); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if at, err := http.ParseTime(header); err == nil {
+		wait := time.Until(at)
+		if wait < 0 {
+			wait = 0
+		}
+		return wait, true
+	}
+	return 0, false
+}
+
+// SoundcloudStatus returns the state of the breaker shared by Soundcloud
+// API calls, for health checks and the feed's outage banner
+func SoundcloudStatus() BreakerStatus {
+	if transport, ok := soundcloudClient.Transport.(*SoundcloudTransport); ok {
+		return transport.Breaker.Status()
+	}
+	return BreakerStatus{State: BreakerClosed}
+}
+
+// UseSoundcloudTransport sends Soundcloud API calls through a transport
+// and returns the one it replaces, so tests can put it back
+func UseSoundcloudTransport(transport http.RoundTripper) http.RoundTripper {
+	previous := soundcloudClient.Transport
+	soundcloudClient.Transport = transport
+	return previous
+}
+
+// soundcloudOffline reports whether Soundcloud calls are answered without
+// the network, from recordings or from demo data
+func soundcloudOffline() bool {
+	switch transport := soundcloudClient.Transport.(type) {
+	case *Cassette:
+		return transport.Mode == CassetteReplay
+	case *DemoSoundcloud:
+		return true
+	}
+	return false
+}
+
+// RateLimiter is a token bucket. Tokens refill at a steady rate up to the
+// burst size and each request takes one.
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // Tokens per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter creates a limiter allowing rate requests a second with
+// bursts of up to burst requests
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	return &RateLimiter{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// Wait blocks until a request may be sent, or the context is done
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	for {
+		wait := l.reserve()
+		if wait == 0 {
+			return nil
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// reserve takes a token when one is available and otherwise returns how
+// long until the next one is
+func (l *RateLimiter) reserve() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	if l.tokens >= 1 {
+		l.tokens--
+		return 0
+	}
+	return time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+}
+
+// BreakerStatus is a snapshot of a circuit breaker
+type BreakerStatus struct {
+	State    string    `json:"state"`
+	Failures int       `json:"failures"`
+	OpenedAt time.Time `json:"opened_at"`
+	RetryAt  time.Time `json:"retry_at"`
+}
+
+// Open reports whether calls are currently being failed fast
+func (s BreakerStatus) Open() bool {
+	return s.State == BreakerOpen
+}
+
+// CircuitBreaker stops calls to a failing service. After threshold
+// failures in a row it opens and fails calls fast for the cooldown, then
+// lets a single trial call through. The trial's success closes it again.
+type CircuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	openedAt  time.Time
+	trial     bool // A half-open trial call is in flight
+	now       func() time.Time
+}
+
+// NewCircuitBreaker creates a closed breaker
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{threshold: threshold, cooldown: cooldown, now: time.Now}
+}
+
+// Allow reports whether a call may go ahead
+func (b *CircuitBreaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state() {
+	case BreakerOpen:
+		return false
+	case BreakerHalfOpen:
+		if b.trial {
+			return false
+		}
+		b.trial = true
+	}
+	return true
+}
+
+// Success records a call that worked and closes the breaker
+func (b *CircuitBreaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	b.openedAt = time.Time{}
+	b.trial = false
+}
+
+// Failure records a failed call, opening the breaker at the threshold or
+// when a half-open trial fails
+func (b *CircuitBreaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	if b.trial || b.failures >= b.threshold {
+		b.openedAt = b.now()
+	}
+	b.trial = false
+}
+
+// Release gives back a call that ended before it could tell whether the
+// service works, so a half-open breaker lets another trial through
+func (b *CircuitBreaker) Release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trial = false
+}
+
+// Status returns a snapshot of the breaker
+func (b *CircuitBreaker) Status() BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	status := BreakerStatus{State: b.state(), Failures: b.failures}
+	if !b.openedAt.IsZero() {
+		status.OpenedAt = b.openedAt
+		status.RetryAt = b.openedAt.Add(b.cooldown)
+	}
+	return status
+}
+
+// state works out the breaker's state. The caller holds the lock.
+func (b *CircuitBreaker) state() string {
+	if b.openedAt.IsZero() {
+		return BreakerClosed
+	}
+	if b.now().Sub(b.openedAt) < b.cooldown {
+		return BreakerOpen
+	}
+	return BreakerHalfOpen
+}
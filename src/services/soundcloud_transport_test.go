@@ -0,0 +1,207 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testTransport() *SoundcloudTransport {
+	transport := NewSoundcloudTransport(http.DefaultTransport)
+	transport.Backoff = time.Millisecond
+	transport.Limiter = NewRateLimiter(1000, 1000)
+	return transport
+}
+
+func TestSoundcloudTransportRetriesRateLimits(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		switch calls {
+		case 1:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case 2:
+			w.WriteHeader(http.StatusBadGateway)
+		default:
+			w.Write([]byte(`[]`))
+		}
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: testTransport()}
+	res, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK || calls != 3 {
+		t.Errorf("Expected success on the third call, got %d after %d calls", res.StatusCode, calls)
+	}
+}
+
+func TestSoundcloudTransportDoesNotRetryFailedCreates(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: testTransport()}
+	res, err := client.Post(srv.URL, "application/json", strings.NewReader(`{}`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	res.Body.Close()
+	if calls != 1 {
+		t.Errorf("Expected a failed POST to be sent once, got %d calls", calls)
+	}
+}
+
+func TestSoundcloudTransportGivesUpOnLongRetryAfter(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Retry-After", "3600")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: testTransport()}
+	res, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusTooManyRequests || calls != 1 {
+		t.Errorf("Expected the 429 back without waiting an hour, got %d after %d calls", res.StatusCode, calls)
+	}
+}
+
+func TestSoundcloudTransportOpensBreaker(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	transport := testTransport()
+	client := &http.Client{Transport: transport}
+	res, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	res.Body.Close()
+	if calls != 4 {
+		t.Errorf("Expected the first call and 3 retries, got %d calls", calls)
+	}
+
+	// Two more failures reach the threshold of 5
+	client.Get(srv.URL)
+	if !transport.Breaker.Status().Open() {
+		t.Fatalf("Expected the breaker to open, got %+v", transport.Breaker.Status())
+	}
+	before := calls
+	if _, err := client.Get(srv.URL); !errors.Is(err, ErrSoundcloudUnavailable) {
+		t.Errorf("Expected calls to fail fast, got %v", err)
+	}
+	if calls != before {
+		t.Errorf("Expected no calls to Soundcloud while open, got %d", calls-before)
+	}
+}
+
+func TestCircuitBreakerHalfOpen(t *testing.T) {
+	now := time.Now()
+	breaker := NewCircuitBreaker(2, time.Minute)
+	breaker.now = func() time.Time { return now }
+
+	breaker.Failure()
+	if !breaker.Allow() {
+		t.Fatal("Expected the breaker to stay closed below the threshold")
+	}
+	breaker.Failure()
+	if breaker.Allow() {
+		t.Fatal("Expected the breaker to open at the threshold")
+	}
+
+	now = now.Add(time.Minute)
+	if status := breaker.Status(); status.State != BreakerHalfOpen {
+		t.Fatalf("Expected half open after the cooldown, got %s", status.State)
+	}
+	if !breaker.Allow() || breaker.Allow() {
+		t.Fatal("Expected exactly one trial call while half open")
+	}
+	breaker.Failure()
+	if breaker.Status().State != BreakerOpen {
+		t.Fatal("Expected a failed trial to open the breaker again")
+	}
+
+	now = now.Add(time.Minute)
+	breaker.Allow()
+	breaker.Success()
+	if status := breaker.Status(); status.State != BreakerClosed || status.Failures != 0 {
+		t.Errorf("Expected a successful trial to close the breaker, got %+v", status)
+	}
+}
+
+func TestSoundcloudTransportReleasesCancelledTrial(t *testing.T) {
+	now := time.Now()
+	transport := testTransport()
+	transport.Breaker = NewCircuitBreaker(1, time.Minute)
+	transport.Breaker.now = func() time.Time { return now }
+	transport.Breaker.Failure()
+	now = now.Add(time.Minute)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	transport.Base = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		cancel()
+		return nil, req.Context().Err()
+	})
+	req := httptest.NewRequest(http.MethodGet, "https://api.soundcloud.com/me", nil).WithContext(ctx)
+	if _, err := transport.RoundTrip(req); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected the cancellation, got %v", err)
+	}
+
+	if status := transport.Breaker.Status(); status.State != BreakerHalfOpen {
+		t.Errorf("Expected a cancelled trial to leave the breaker half open, got %s", status.State)
+	}
+	if !transport.Breaker.Allow() {
+		t.Error("Expected another trial call after the cancelled one")
+	}
+}
+
+// roundTripFunc answers requests with a function
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestRateLimiterReserve(t *testing.T) {
+	limiter := NewRateLimiter(10, 2)
+	if limiter.reserve() != 0 || limiter.reserve() != 0 {
+		t.Fatal("Expected the burst to be available straight away")
+	}
+	if wait := limiter.reserve(); wait <= 0 || wait > 100*time.Millisecond {
+		t.Errorf("Expected to wait for the next token, got %v", wait)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	if wait, ok := retryAfter("2"); !ok || wait != 2*time.Second {
+		t.Errorf("Expected 2s, got %v", wait)
+	}
+	date := time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)
+	if wait, ok := retryAfter(date); !ok || wait <= 0 || wait > time.Minute {
+		t.Errorf("Expected about a minute, got %v", wait)
+	}
+	if _, ok := retryAfter("soon"); ok {
+		t.Error("Expected an unparseable header to be ignored")
+	}
+}
@@ -0,0 +1,57 @@
+package services
+
+import "context"
+
+// Source is somewhere tracks come from, such as a Soundcloud listing or a
+// podcast feed. Sources fetch raw items a page at a time and normalize
+// them to maps in the shape of a Soundcloud API track, which is what
+// storage, rules and filtering work with.
+type Source interface {
+	// Fetch returns one page of raw items starting at cursor, which is
+	// empty for the first page, along with the cursor of the next page,
+	// which is empty after the last
+	Fetch(ctx context.Context, cursor string) ([]interface{}, string, error)
+	// Normalize turns a raw item into a track map. Items that are not
+	// playable tracks are skipped.
+	Normalize(item interface{}) (map[string]interface{}, bool)
+}
+
+// maxCollectionPages caps how many pages of a source are fetched, so an
+// account with thousands of likes or a feed with years of episodes cannot
+// stall a sync
+const maxCollectionPages = 25
+
+// FetchItems fetches every page of a source's raw items, stopping early
+// when ctx is done
+func FetchItems(ctx context.Context, source Source) ([]interface{}, error) {
+	var items []interface{}
+	cursor := ""
+	for page := 0; page < maxCollectionPages; page++ {
+		pageItems, next, err := source.Fetch(ctx, cursor)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, pageItems...)
+		if next == "" || next == cursor {
+			break
+		}
+		cursor = next
+	}
+	return items, nil
+}
+
+// FetchTracks fetches every page of a source and normalizes the items to
+// track maps, skipping items that are not tracks
+func FetchTracks(ctx context.Context, source Source) ([]interface{}, error) {
+	items, err := FetchItems(ctx, source)
+	if err != nil {
+		return nil, err
+	}
+	tracks := make([]interface{}, 0, len(items))
+	for _, item := range items {
+		if track, ok := source.Normalize(item); ok {
+			tracks = append(tracks, track)
+		}
+	}
+	return tracks, nil
+}
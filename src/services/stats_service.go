@@ -0,0 +1,300 @@
+package services
+
+import (
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gobuffalo/pop/v6"
+)
+
+// StatsRanges are the periods, in weeks, the dashboard can cover
+var StatsRanges = []int{12, 26, 52}
+
+// DefaultStatsWeeks is the period the dashboard covers unless another is
+// chosen
+const DefaultStatsWeeks = 26
+
+// statsTopTerms is how many genres and tags are followed over time
+const statsTopTerms = 5
+
+// statsTopArtists is how many of the most-posting artists are listed
+const statsTopArtists = 10
+
+// lengthBuckets are the upper bounds, in seconds, of the track length
+// histogram's buckets; tracks past the last bound fall in a final bucket
+var lengthBuckets = []int{5 * 60, 15 * 60, 30 * 60, 60 * 60, 90 * 60, 120 * 60}
+
+// lengthBucketLabels names the length histogram's buckets
+var lengthBucketLabels = []string{"< 5m", "5-15m", "15-30m", "30-60m", "1-1.5h", "1.5-2h", "2h+"}
+
+// Stats summarises a user's stored feed and playback over a period
+type Stats struct {
+	Weeks int
+	Since time.Time
+	// Tracks and Artists are totals over the period
+	Tracks  int
+	Artists int
+	// ListeningHours is an estimate: a listen only keeps its latest
+	// position, so a track heard twice counts once
+	ListeningHours float64
+
+	FeedVolume  []ChartPoint // Tracks posted each week
+	Lengths     []ChartPoint // Tracks in each length bucket
+	TopArtists  []ChartPoint // Tracks posted by the busiest artists
+	Listening   []ChartPoint // Hours listened each week
+	Months      []string     // Labels of the genre and tag series
+	GenreTrends []ChartSeries
+	TagTrends   []ChartSeries
+	weekStarts  []time.Time
+	monthStarts []time.Time
+}
+
+// StatsService computes feed and listening statistics in SQL
+type StatsService struct {
+	DB *pop.Connection
+}
+
+// NewStatsService creates a new service
+func NewStatsService(db *pop.Connection) *StatsService {
+	return &StatsService{DB: db}
+}
+
+// Stats computes a user's statistics over the last number of weeks,
+// counting tracks by when they were posted. Duplicate uploads of a
+// recording are counted once.
+func (ss *StatsService) Stats(userID string, weeks int) (*Stats, error) {
+	if weeks <= 0 {
+		weeks = DefaultStatsWeeks
+	}
+	stats := newStats(time.Now(), weeks)
+
+	if err := ss.feedVolume(userID, stats); err != nil {
+		return nil, err
+	}
+	if err := ss.lengths(userID, stats); err != nil {
+		return nil, err
+	}
+	if err := ss.topArtists(userID, stats); err != nil {
+		return nil, err
+	}
+	if err := ss.listening(userID, stats); err != nil {
+		return nil, err
+	}
+
+	genres := `SELECT lower(trim(genre)) AS term, post_time FROM soundcloud_tracks
+		WHERE user_id = ? AND post_time >= ? AND duplicate_of IS NULL AND trim(genre) <> ''`
+	trends, err := ss.termTrends(genres, userID, stats)
+	if err != nil {
+		return nil, err
+	}
+	stats.GenreTrends = trends
+
+	// Soundcloud tag lists separate tags with spaces and quote tags that
+	// contain them
+	tags := `SELECT lower(coalesce(m[1], m[2])) AS term, post_time
+		FROM soundcloud_tracks, regexp_matches(coalesce(tag_list, ''), '"([^"]+)"|([^\s"]+)', 'g') AS m
+		WHERE user_id = ? AND post_time >= ? AND duplicate_of IS NULL`
+	trends, err = ss.termTrends(tags, userID, stats)
+	if err != nil {
+		return nil, err
+	}
+	stats.TagTrends = trends
+	return stats, nil
+}
+
+// newStats lays out the weeks and months a period covers, ending with the
+// ones containing now
+func newStats(now time.Time, weeks int) *Stats {
+	now = now.UTC()
+	// Weeks start on Monday, as date_trunc('week') does
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	thisWeek := day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	stats := &Stats{Weeks: weeks, Since: thisWeek.AddDate(0, 0, -7*(weeks-1))}
+	for week := stats.Since; !week.After(thisWeek); week = week.AddDate(0, 0, 7) {
+		stats.weekStarts = append(stats.weekStarts, week)
+	}
+	for month := time.Date(stats.Since.Year(), stats.Since.Month(), 1, 0, 0, 0, 0, time.UTC); !month.After(now); month = month.AddDate(0, 1, 0) {
+		stats.monthStarts = append(stats.monthStarts, month)
+		stats.Months = append(stats.Months, month.Format("Jan 06"))
+	}
+	return stats
+}
+
+// weekLabel labels a week on the charts
+func weekLabel(week time.Time) string {
+	return week.Format("Jan 2")
+}
+
+// feedVolume counts the tracks posted each week
+func (ss *StatsService) feedVolume(userID string, stats *Stats) error {
+	var rows []struct {
+		Week   time.Time `db:"week"`
+		Tracks int       `db:"tracks"`
+	}
+	err := ss.DB.RawQuery(`SELECT date_trunc('week', post_time) AS week, count(*) AS tracks FROM soundcloud_tracks
+		WHERE user_id = ? AND post_time >= ? AND duplicate_of IS NULL
+		GROUP BY week`, userID, stats.Since).All(&rows)
+	if err != nil {
+		return err
+	}
+	counts := map[time.Time]float64{}
+	for _, row := range rows {
+		counts[row.Week.UTC()] = float64(row.Tracks)
+		stats.Tracks += row.Tracks
+	}
+	for _, week := range stats.weekStarts {
+		stats.FeedVolume = append(stats.FeedVolume, ChartPoint{Label: weekLabel(week), Value: counts[week]})
+	}
+	return nil
+}
+
+// lengths buckets the period's tracks by length
+func (ss *StatsService) lengths(userID string, stats *Stats) error {
+	var rows []struct {
+		Bucket int `db:"bucket"`
+		Tracks int `db:"tracks"`
+	}
+	err := ss.DB.RawQuery(`SELECT width_bucket(length, ?::int[]) AS bucket, count(*) AS tracks FROM soundcloud_tracks
+		WHERE user_id = ? AND post_time >= ? AND duplicate_of IS NULL AND length > 0
+		GROUP BY bucket`, intArray(lengthBuckets), userID, stats.Since).All(&rows)
+	if err != nil {
+		return err
+	}
+	counts := make([]float64, len(lengthBucketLabels))
+	for _, row := range rows {
+		if row.Bucket >= 0 && row.Bucket < len(counts) {
+			counts[row.Bucket] = float64(row.Tracks)
+		}
+	}
+	for i, label := range lengthBucketLabels {
+		stats.Lengths = append(stats.Lengths, ChartPoint{Label: label, Value: counts[i]})
+	}
+	return nil
+}
+
+// topArtists finds the artists who posted the most tracks
+func (ss *StatsService) topArtists(userID string, stats *Stats) error {
+	var rows []struct {
+		Artist string `db:"artist"`
+		Tracks int    `db:"tracks"`
+	}
+	err := ss.DB.RawQuery(`SELECT artist, count(*) AS tracks FROM soundcloud_tracks
+		WHERE user_id = ? AND post_time >= ? AND duplicate_of IS NULL AND coalesce(artist, '') <> ''
+		GROUP BY artist ORDER BY tracks DESC, artist LIMIT ?`, userID, stats.Since, statsTopArtists).All(&rows)
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		stats.TopArtists = append(stats.TopArtists, ChartPoint{Label: row.Artist, Value: float64(row.Tracks)})
+	}
+	var totals []struct {
+		Artists int `db:"artists"`
+	}
+	err = ss.DB.RawQuery(`SELECT count(DISTINCT artist) AS artists FROM soundcloud_tracks
+		WHERE user_id = ? AND post_time >= ? AND duplicate_of IS NULL AND coalesce(artist, '') <> ''`,
+		userID, stats.Since).All(&totals)
+	if err != nil {
+		return err
+	}
+	if len(totals) > 0 {
+		stats.Artists = totals[0].Artists
+	}
+	return nil
+}
+
+// listening totals the hours listened each week. A heard track counts its
+// full length and one in progress counts up to its resume position, in the
+// week it was last played.
+func (ss *StatsService) listening(userID string, stats *Stats) error {
+	var rows []struct {
+		Week    time.Time `db:"week"`
+		Seconds int       `db:"seconds"`
+	}
+	err := ss.DB.RawQuery(`SELECT date_trunc('week', coalesce(listens.heard_at, listens.updated_at)) AS week,
+			sum(CASE WHEN listens.heard THEN soundcloud_tracks.length ELSE listens.position END) AS seconds
+		FROM listens JOIN soundcloud_tracks ON soundcloud_tracks.id = listens.track_id
+		WHERE listens.user_id = ? AND coalesce(listens.heard_at, listens.updated_at) >= ?
+		GROUP BY week`, userID, stats.Since).All(&rows)
+	if err != nil {
+		return err
+	}
+	hours := map[time.Time]float64{}
+	for _, row := range rows {
+		hours[row.Week.UTC()] = float64(row.Seconds) / 3600
+		stats.ListeningHours += float64(row.Seconds) / 3600
+	}
+	for _, week := range stats.weekStarts {
+		stats.Listening = append(stats.Listening, ChartPoint{Label: weekLabel(week), Value: hours[week]})
+	}
+	return nil
+}
+
+// termTrends counts the most common terms selected by query, which returns
+// a term and post_time per occurrence, in each month of the period
+func (ss *StatsService) termTrends(query, userID string, stats *Stats) ([]ChartSeries, error) {
+	var rows []termCount
+	err := ss.DB.RawQuery(`WITH occurrences AS (`+query+`),
+			top AS (SELECT term FROM occurrences GROUP BY term ORDER BY count(*) DESC, term LIMIT ?)
+		SELECT occurrences.term, date_trunc('month', occurrences.post_time) AS month, count(*) AS tracks
+		FROM occurrences JOIN top ON top.term = occurrences.term
+		GROUP BY occurrences.term, month`, userID, stats.Since, statsTopTerms).All(&rows)
+	if err != nil {
+		return nil, err
+	}
+	return trendSeries(rows, stats.monthStarts), nil
+}
+
+// termCount is how often a term occurred in a month
+type termCount struct {
+	Term   string    `db:"term"`
+	Month  time.Time `db:"month"`
+	Tracks int       `db:"tracks"`
+}
+
+// trendSeries arranges monthly term counts into one series per term, most
+// common first, with a value for every month
+func trendSeries(rows []termCount, months []time.Time) []ChartSeries {
+	index := map[time.Time]int{}
+	for i, month := range months {
+		index[month] = i
+	}
+	series := map[string]*ChartSeries{}
+	totals := map[string]int{}
+	var terms []string
+	for _, row := range rows {
+		s, ok := series[row.Term]
+		if !ok {
+			s = &ChartSeries{Name: row.Term, Values: make([]float64, len(months))}
+			series[row.Term] = s
+			terms = append(terms, row.Term)
+		}
+		if i, ok := index[row.Month.UTC()]; ok {
+			s.Values[i] += float64(row.Tracks)
+		}
+		totals[row.Term] += row.Tracks
+	}
+	sort.Slice(terms, func(i, j int) bool {
+		if totals[terms[i]] != totals[terms[j]] {
+			return totals[terms[i]] > totals[terms[j]]
+		}
+		return terms[i] < terms[j]
+	})
+	var trends []ChartSeries
+	for _, term := range terms {
+		trends = append(trends, *series[term])
+	}
+	return trends
+}
+
+// intArray formats ints as a Postgres array literal
+func intArray(values []int) string {
+	b := []byte{'{'}
+	for i, v := range values {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b = strconv.AppendInt(b, int64(v), 10)
+	}
+	return string(append(b, '}'))
+}
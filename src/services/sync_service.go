@@ -0,0 +1,209 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gobuffalo/pop/v6"
+	"github.com/gofrs/uuid"
+	"github.com/jbhicks/sound-cistern/src/models"
+)
+
+// SyncService refreshes a user's feed from Soundcloud and reports progress
+// to their live feed connections
+type SyncService struct {
+	DB         *pop.Connection
+	Soundcloud *SoundcloudService
+	Events     *FeedBroker
+	// FailureDB records failed account syncs. It should commit on its own,
+	// so a failure is kept when the sync's transaction rolls back; DB is
+	// used when it is nil.
+	FailureDB *pop.Connection
+}
+
+// SyncResult is what a sync fetched and what the user's rules made of it
+type SyncResult struct {
+	// Tracks is the full feed
+	Tracks []interface{}
+	// NewTracks are the stream tracks and podcast episodes not seen before
+	// that no rule hid
+	NewTracks []interface{}
+	// RuleMatches holds the new tracks notify rules matched, by the alert
+	// they are delivered through
+	RuleMatches map[uuid.UUID][]interface{}
+}
+
+// NewSyncService creates a new service
+func NewSyncService(db *pop.Connection, soundcloud *SoundcloudService, events *FeedBroker) *SyncService {
+	return &SyncService{
+		DB:         db,
+		Soundcloud: soundcloud,
+		Events:     events,
+	}
+}
+
+// Sync fetches the feed, likes, playlists and followed artists of each of
+// the user's linked accounts and the episodes of their podcast
+// subscriptions and stores them, then runs the user's rules on the stream
+// tracks and episodes that had not been seen before. Accounts that need to be
+// connected again are skipped, and one account failing does not stop the
+// others. Newly seen tracks that no rule hid are published oldest first so a
+// client prepending them ends up with the newest at the top. Cancelling ctx
+// abandons the sync without counting it against the account being fetched.
+func (ss *SyncService) Sync(ctx context.Context, userID string) (*SyncResult, error) {
+	ss.publishStatus(userID, SyncStatusRunning, "Syncing with Soundcloud...")
+
+	// Queries stop along with the request or job that started the sync
+	db := ss.DB.WithContext(ctx)
+	accountService := NewAccountService(db)
+	failures := accountService
+	if ss.FailureDB != nil {
+		failures = NewAccountService(ss.FailureDB.WithContext(ctx))
+	}
+	accounts, err := accountService.Accounts(userID)
+	if err != nil {
+		ss.publishStatus(userID, SyncStatusFailed, "Sync failed: could not load linked accounts")
+		return nil, err
+	}
+
+	var (
+		tracks     []interface{}
+		newTracks  []interface{}
+		followings []interface{}
+		synced     int
+		lastErr    = ErrNoUsableAccount
+	)
+	followingsComplete := true
+	seenFollowings := map[string]bool{}
+	for i := range accounts {
+		account := &accounts[i]
+		if account.NeedsReauth {
+			followingsComplete = false
+			continue
+		}
+		result, err := ss.syncAccount(ctx, account)
+		if err != nil && ctx.Err() != nil {
+			ss.publishStatus(userID, SyncStatusFailed, "Sync cancelled")
+			return nil, ctx.Err()
+		}
+		if err != nil {
+			lastErr = err
+			followingsComplete = false
+			ss.publishStatus(userID, SyncStatusFailed, fmt.Sprintf("Sync of %s failed: %v", account.DisplayName(), err))
+			if rerr := failures.RecordFailure(account, err); rerr != nil {
+				return nil, rerr
+			}
+			continue
+		}
+		if err := accountService.RecordSync(account); err != nil {
+			return nil, err
+		}
+		synced++
+		tracks = result.tracks
+		newTracks = append(newTracks, result.newTracks...)
+		for _, f := range result.followings {
+			following, ok := f.(map[string]interface{})
+			if !ok || seenFollowings[FormatID(following["id"])] {
+				continue
+			}
+			seenFollowings[FormatID(following["id"])] = true
+			followings = append(followings, following)
+		}
+	}
+
+	episodes, podcasts, err := NewPodcastService(db).RefreshAll(ctx, userID)
+	if err != nil {
+		ss.publishStatus(userID, SyncStatusFailed, "Sync failed: could not store podcast episodes")
+		return nil, err
+	}
+	newTracks = append(newTracks, episodes...)
+	synced += podcasts
+
+	if synced == 0 {
+		ss.publishStatus(userID, SyncStatusFailed, "Sync failed: no connected Soundcloud account")
+		return nil, lastErr
+	}
+
+	// Artists only drop out of the followed list when every account was
+	// fetched, so a failing account does not unfollow its artists here
+	if followingsComplete {
+		if _, err := NewFollowingService(db).Store(userID, followings); err != nil {
+			ss.publishStatus(userID, SyncStatusFailed, "Sync failed: could not store followed artists")
+			return nil, err
+		}
+	}
+
+	rules, err := NewRuleService(db).Apply(userID, newTracks)
+	if err != nil {
+		ss.publishStatus(userID, SyncStatusFailed, "Sync failed: could not apply rules")
+		return nil, err
+	}
+	newTracks = rules.Visible
+
+	for i := len(newTracks) - 1; i >= 0; i-- {
+		if track, ok := newTracks[i].(map[string]interface{}); ok {
+			ss.publish(userID, FeedEvent{Type: FeedEventTrack, Track: track})
+		}
+	}
+	ss.publishStatus(userID, SyncStatusDone, fmt.Sprintf("Sync complete: %d new tracks", len(newTracks)))
+
+	return &SyncResult{Tracks: tracks, NewTracks: newTracks, RuleMatches: rules.Notify}, nil
+}
+
+// accountSync is what one linked account contributed to a sync
+type accountSync struct {
+	// tracks is the merged cached feed after the account was added
+	tracks     []interface{}
+	newTracks  []interface{}
+	followings []interface{}
+}
+
+// syncAccount fetches and stores one linked account's feed, likes and
+// playlists and returns its followed artists for the caller to store
+func (ss *SyncService) syncAccount(ctx context.Context, account *models.SoundcloudAccount) (*accountSync, error) {
+	userID := account.UserID.String()
+	feed, err := ss.Soundcloud.FetchUserFeed(ctx, account.AccessToken)
+	if err != nil {
+		return nil, fmt.Errorf("could not reach Soundcloud: %w", err)
+	}
+
+	feedService := NewFeedService(ss.DB)
+	newTracks, err := feedService.StoreAccountTracks(ctx, account, feed)
+	if err != nil {
+		return nil, fmt.Errorf("could not store tracks: %w", err)
+	}
+	tracks, err := feedService.CacheAccountFeed(ctx, userID, account.ID, feed)
+	if err != nil {
+		return nil, fmt.Errorf("could not cache feed: %w", err)
+	}
+
+	followings, err := ss.Soundcloud.FetchFollowings(ctx, account.AccessToken)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch followed artists: %w", err)
+	}
+	likes, err := ss.Soundcloud.FetchLikes(ctx, account.AccessToken)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch likes: %w", err)
+	}
+	playlists, err := ss.Soundcloud.FetchPlaylists(ctx, account.AccessToken)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch playlists: %w", err)
+	}
+	if _, err := feedService.StoreAccountLibrary(ctx, account, likes, playlists); err != nil {
+		return nil, fmt.Errorf("could not store likes and playlists: %w", err)
+	}
+
+	return &accountSync{tracks: tracks, newTracks: newTracks, followings: followings}, nil
+}
+
+// publishStatus sends a sync status change to the user's live feed
+func (ss *SyncService) publishStatus(userID, status, message string) {
+	ss.publish(userID, FeedEvent{Type: FeedEventSyncStatus, Status: status, Message: message})
+}
+
+// publish sends an event when a broker is configured
+func (ss *SyncService) publish(userID string, event FeedEvent) {
+	if ss.Events != nil {
+		ss.Events.Publish(userID, event)
+	}
+}
@@ -0,0 +1,253 @@
+package services
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// encryptedTokenPrefix marks a stored token as sealed by a TokenCipher.
+// Tokens without it were stored before encryption was configured.
+const encryptedTokenPrefix = "enc:v1:"
+
+// tokenKeySize is the length of key-encryption and data keys (AES-256)
+const tokenKeySize = 32
+
+var (
+	// ErrUnknownTokenKey is returned when a token was sealed under a key
+	// that is not configured
+	ErrUnknownTokenKey = errors.New("token encrypted with an unknown key")
+	// ErrTokenCipherMissing is returned when an encrypted token is read
+	// with no keys configured
+	ErrTokenCipherMissing = errors.New("token is encrypted but no encryption keys are configured")
+)
+
+// TokenCipher encrypts stored OAuth tokens with envelope encryption. Each
+// token is sealed with its own random data key using AES-GCM, and the data
+// key is sealed with a configured key-encryption key. Keys have ids so they
+// can be rotated: tokens are always sealed with the primary key, and any
+// configured key can open them.
+type TokenCipher struct {
+	Primary string
+	keys    map[string]cipher.AEAD
+}
+
+// tokenCipher seals the tokens AccountService stores. Without one, tokens
+// are stored as they are.
+var tokenCipher *TokenCipher
+
+// NewTokenCipher creates a cipher from keys by id. The primary key seals
+// new tokens.
+func NewTokenCipher(primary string, keys map[string][]byte) (*TokenCipher, error) {
+	tc := &TokenCipher{Primary: primary, keys: map[string]cipher.AEAD{}}
+	for id, key := range keys {
+		if id == "" || strings.Contains(id, ":") {
+			return nil, fmt.Errorf("invalid token key id %q", id)
+		}
+		aead, err := newTokenAEAD(key)
+		if err != nil {
+			return nil, fmt.Errorf("token key %q: %w", id, err)
+		}
+		tc.keys[id] = aead
+	}
+	if _, ok := tc.keys[primary]; !ok {
+		return nil, fmt.Errorf("primary token key %q is not configured", primary)
+	}
+	return tc, nil
+}
+
+// ParseTokenKeys creates a cipher from a comma-separated list of
+// id:base64-key pairs, as in TOKEN_ENCRYPTION_KEYS. The first key is the
+// primary; the rest are older keys kept so existing tokens can be read.
+func ParseTokenKeys(spec string) (*TokenCipher, error) {
+	keys := map[string][]byte{}
+	primary := ""
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		id, encoded, ok := strings.Cut(pair, ":")
+		if !ok {
+			return nil, fmt.Errorf("token key %q is not id:key", pair)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("token key %q: %w", id, err)
+		}
+		if _, dup := keys[id]; dup {
+			return nil, fmt.Errorf("token key %q is listed twice", id)
+		}
+		keys[id] = key
+		if primary == "" {
+			primary = id
+		}
+	}
+	if primary == "" {
+		return nil, errors.New("no token keys given")
+	}
+	return NewTokenCipher(primary, keys)
+}
+
+// UseTokenKeys seals stored tokens with the given keys from now on. No
+// keys stores them as they are.
+func UseTokenKeys(spec string) error {
+	if strings.TrimSpace(spec) == "" {
+		tokenCipher = nil
+		return nil
+	}
+	tc, err := ParseTokenKeys(spec)
+	if err != nil {
+		return err
+	}
+	tokenCipher = tc
+	return nil
+}
+
+// NewTokenKey returns a random key, base64 encoded for TOKEN_ENCRYPTION_KEYS
+func NewTokenKey() (string, error) {
+	key := make([]byte, tokenKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// Encrypt seals a token under the primary key. The result reads
+// enc:v1:<key id>:<sealed data key>:<sealed token>.
+func (tc *TokenCipher) Encrypt(token string) (string, error) {
+	dataKey := make([]byte, tokenKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+	sealedKey, err := sealGCM(tc.keys[tc.Primary], dataKey, []byte(tc.Primary))
+	if err != nil {
+		return "", err
+	}
+	aead, err := newTokenAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	sealedToken, err := sealGCM(aead, []byte(token), nil)
+	if err != nil {
+		return "", err
+	}
+	return encryptedTokenPrefix + tc.Primary + ":" +
+		base64.RawURLEncoding.EncodeToString(sealedKey) + ":" +
+		base64.RawURLEncoding.EncodeToString(sealedToken), nil
+}
+
+// Decrypt opens a token sealed by Encrypt under any configured key.
+// Tokens stored before encryption are returned as they are.
+func (tc *TokenCipher) Decrypt(value string) (string, error) {
+	if !TokenEncrypted(value) {
+		return value, nil
+	}
+	parts := strings.Split(strings.TrimPrefix(value, encryptedTokenPrefix), ":")
+	if len(parts) != 3 {
+		return "", errors.New("malformed encrypted token")
+	}
+	kek, ok := tc.keys[parts[0]]
+	if !ok {
+		return "", fmt.Errorf("%w %q", ErrUnknownTokenKey, parts[0])
+	}
+	sealedKey, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", fmt.Errorf("malformed encrypted token: %w", err)
+	}
+	sealedToken, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", fmt.Errorf("malformed encrypted token: %w", err)
+	}
+	dataKey, err := openGCM(kek, sealedKey, []byte(parts[0]))
+	if err != nil {
+		return "", err
+	}
+	aead, err := newTokenAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	token, err := openGCM(aead, sealedToken, nil)
+	if err != nil {
+		return "", err
+	}
+	return string(token), nil
+}
+
+// NeedsRotation reports whether a stored token is in plain text or sealed
+// under a key other than the primary
+func (tc *TokenCipher) NeedsRotation(value string) bool {
+	return value != "" && TokenKeyID(value) != tc.Primary
+}
+
+// TokenEncrypted reports whether a stored token is sealed
+func TokenEncrypted(value string) bool {
+	return strings.HasPrefix(value, encryptedTokenPrefix)
+}
+
+// TokenKeyID returns the id of the key a stored token is sealed under, or
+// "" for a token in plain text
+func TokenKeyID(value string) string {
+	if !TokenEncrypted(value) {
+		return ""
+	}
+	id, _, _ := strings.Cut(strings.TrimPrefix(value, encryptedTokenPrefix), ":")
+	return id
+}
+
+// sealToken encrypts a token for storage with the configured cipher
+func sealToken(token string) (string, error) {
+	if tokenCipher == nil || token == "" {
+		return token, nil
+	}
+	return tokenCipher.Encrypt(token)
+}
+
+// openToken decrypts a stored token with the configured cipher
+func openToken(value string) (string, error) {
+	if !TokenEncrypted(value) {
+		return value, nil
+	}
+	if tokenCipher == nil {
+		return "", ErrTokenCipherMissing
+	}
+	return tokenCipher.Decrypt(value)
+}
+
+// newTokenAEAD creates AES-GCM for a 256-bit key
+func newTokenAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != tokenKeySize {
+		return nil, fmt.Errorf("key must be %d bytes, got %d", tokenKeySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealGCM encrypts with a random nonce, which is prepended to the result
+func sealGCM(aead cipher.AEAD, plaintext, additional []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additional), nil
+}
+
+// openGCM decrypts the output of sealGCM
+func openGCM(aead cipher.AEAD, sealed, additional []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("malformed encrypted token")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, additional)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting token: %w", err)
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,83 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func testTokenKeys(t *testing.T, ids ...string) string {
+	var pairs []string
+	for _, id := range ids {
+		key, err := NewTokenKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		pairs = append(pairs, id+":"+key)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func TestTokenCipherRoundTrip(t *testing.T) {
+	tc, err := ParseTokenKeys(testTokenKeys(t, "k1"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	sealed, err := tc.Encrypt("live-token-123")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if strings.Contains(sealed, "live-token-123") || TokenKeyID(sealed) != "k1" {
+		t.Errorf("Expected a token sealed under k1, got %q", sealed)
+	}
+	if again, _ := tc.Encrypt("live-token-123"); again == sealed {
+		t.Error("Expected each encryption to use a fresh data key")
+	}
+	token, err := tc.Decrypt(sealed)
+	if err != nil || token != "live-token-123" {
+		t.Errorf("Expected the token back, got %q, %v", token, err)
+	}
+
+	if token, _ := tc.Decrypt("stored-before-encryption"); token != "stored-before-encryption" {
+		t.Errorf("Expected plain text tokens to pass through, got %q", token)
+	}
+
+	tampered := sealed[:len(sealed)-2] + "AA"
+	if _, err := tc.Decrypt(tampered); err == nil {
+		t.Error("Expected a tampered token to be rejected")
+	}
+}
+
+func TestTokenCipherRotation(t *testing.T) {
+	oldKeys := testTokenKeys(t, "k1")
+	old, _ := ParseTokenKeys(oldKeys)
+	sealed, _ := old.Encrypt("live-token-123")
+
+	rotated, err := ParseTokenKeys(testTokenKeys(t, "k2") + "," + oldKeys)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !rotated.NeedsRotation(sealed) || !rotated.NeedsRotation("plain") {
+		t.Error("Expected tokens under older keys or in plain text to need rotation")
+	}
+	token, err := rotated.Decrypt(sealed)
+	if err != nil || token != "live-token-123" {
+		t.Fatalf("Expected an older key to still open tokens, got %q, %v", token, err)
+	}
+	resealed, _ := rotated.Encrypt(token)
+	if rotated.NeedsRotation(resealed) {
+		t.Error("Expected a token sealed under the primary key to be current")
+	}
+
+	if _, err := old.Decrypt(resealed); !errors.Is(err, ErrUnknownTokenKey) {
+		t.Errorf("Expected ErrUnknownTokenKey, got %v", err)
+	}
+}
+
+func TestParseTokenKeysRejectsBadKeys(t *testing.T) {
+	for _, spec := range []string{"", "k1", "k1:not-base64!", "k1:c2hvcnQ=", testTokenKeys(t, "k1", "k1")} {
+		if _, err := ParseTokenKeys(spec); err == nil {
+			t.Errorf("Expected %q to be rejected", spec)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package services
+
+import (
+	"context"
+	"github.com/gobuffalo/nulls"
+	"github.com/gofrs/uuid"
+	"github.com/jbhicks/sound-cistern/src/models"
+)
+
+// Where stored tracks come from
+const (
+	SourceStream    = "stream"
+	SourceLikes     = "likes"
+	SourcePlaylists = "playlists"
+	SourcePodcasts  = "podcasts"
+)
+
+// Sources lists every track source in display order
+var Sources = []string{SourceStream, SourceLikes, SourcePlaylists, SourcePodcasts}
+
+// SourceLabels are the display names of track sources
+var SourceLabels = map[string]string{
+	SourceStream:    "Stream",
+	SourceLikes:     "Likes",
+	SourcePlaylists: "Playlists",
+	SourcePodcasts:  "Podcasts",
+}
+
+// TrackOrigin is the source stored tracks are tagged with, for playlists
+// and podcasts which playlist or subscription they are in, and which
+// linked account they came through
+type TrackOrigin struct {
+	Source        string
+	PlaylistID    string
+	PlaylistTitle string
+	AccountID     nulls.UUID
+}
+
+// StoreLibrary stores the user's liked tracks and the tracks in their
+// playlists, replacing the likes and playlist tags from the last sync so
+// unliked tracks and removed playlist entries drop out. It returns how
+// many tracks were tagged.
+func (fs *FeedService) StoreLibrary(ctx context.Context, userID string, likes, playlists []interface{}) (int, error) {
+	fs = fs.with(ctx)
+	return fs.storeLibrary(userID, nulls.UUID{}, likes, playlists)
+}
+
+// StoreAccountLibrary stores the likes and playlists of one linked account,
+// replacing only that account's likes and playlist tags
+func (fs *FeedService) StoreAccountLibrary(ctx context.Context, account *models.SoundcloudAccount, likes, playlists []interface{}) (int, error) {
+	fs = fs.with(ctx)
+	return fs.storeLibrary(account.UserID.String(), nulls.NewUUID(account.ID), likes, playlists)
+}
+
+// storeLibrary stores likes and playlists tagged with the account they
+// came through, if any
+func (fs *FeedService) storeLibrary(userID string, accountID nulls.UUID, likes, playlists []interface{}) (int, error) {
+	for _, source := range []string{SourceLikes, SourcePlaylists} {
+		if err := fs.clearSource(userID, accountID, source); err != nil {
+			return 0, err
+		}
+	}
+
+	if _, err := fs.storeTracks(userID, likes, TrackOrigin{Source: SourceLikes, AccountID: accountID}); err != nil {
+		return 0, err
+	}
+	tagged := len(likes)
+
+	for _, p := range playlists {
+		playlist, ok := p.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		tracks, _ := playlist["tracks"].([]interface{})
+		origin := TrackOrigin{
+			Source:        SourcePlaylists,
+			PlaylistID:    FormatID(playlist["id"]),
+			PlaylistTitle: stringField(playlist, "title"),
+			AccountID:     accountID,
+		}
+		if _, err := fs.storeTracks(userID, tracks, origin); err != nil {
+			return tagged, err
+		}
+		tagged += len(tracks)
+	}
+	return tagged, nil
+}
+
+// TrackSources returns the sources of stored tracks keyed by track id
+func (fs *FeedService) TrackSources(ctx context.Context, trackIDs []uuid.UUID) (map[uuid.UUID]models.TrackSources, error) {
+	fs = fs.with(ctx)
+	sources := map[uuid.UUID]models.TrackSources{}
+	if len(trackIDs) == 0 {
+		return sources, nil
+	}
+
+	ids := make([]interface{}, len(trackIDs))
+	for i, id := range trackIDs {
+		ids[i] = id
+	}
+	rows := models.TrackSources{}
+	if err := fs.DB.Where("track_id IN (?)", ids...).Order("created_at asc").All(&rows); err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		sources[row.TrackID] = append(sources[row.TrackID], row)
+	}
+	return sources, nil
+}
+
+// tagSource records that a stored track came from origin
+func (fs *FeedService) tagSource(trackID uuid.UUID, origin TrackOrigin) error {
+	existing := &models.TrackSource{}
+	q := fs.DB.Where("track_id = ? AND source = ? AND playlist_id = ?", trackID, origin.Source, origin.PlaylistID)
+	if origin.AccountID.Valid {
+		q = q.Where("account_id = ?", origin.AccountID.UUID)
+	} else {
+		q = q.Where("account_id IS NULL")
+	}
+	if err := q.First(existing); err == nil {
+		if existing.PlaylistTitle == origin.PlaylistTitle {
+			return nil
+		}
+		existing.PlaylistTitle = origin.PlaylistTitle
+		return fs.DB.Update(existing)
+	}
+	return fs.DB.Create(&models.TrackSource{
+		ID:            uuid.Must(uuid.NewV4()),
+		TrackID:       trackID,
+		Source:        origin.Source,
+		PlaylistID:    origin.PlaylistID,
+		PlaylistTitle: origin.PlaylistTitle,
+		AccountID:     origin.AccountID,
+	})
+}
+
+// clearSource removes one source's tags from all of a user's tracks that
+// came through the given account, or through no account when it is unset
+func (fs *FeedService) clearSource(userID string, accountID nulls.UUID, source string) error {
+	userUUID, err := uuid.FromString(userID)
+	if err != nil {
+		return err
+	}
+	query := "DELETE FROM track_sources WHERE source = ? AND track_id IN (SELECT id FROM soundcloud_tracks WHERE user_id = ?)"
+	if !accountID.Valid {
+		return fs.DB.RawQuery(query+" AND account_id IS NULL", source, userUUID).Exec()
+	}
+	return fs.DB.RawQuery(query+" AND account_id = ?", source, userUUID, accountID.UUID).Exec()
+}
+
+// trackSourceNames returns the distinct sources of a track map. Tracks
+// that were never stored come from the stream.
+func trackSourceNames(track map[string]interface{}) []string {
+	switch sources := track["sources"].(type) {
+	case []string:
+		return sources
+	case []interface{}:
+		names := make([]string, 0, len(sources))
+		for _, s := range sources {
+			if name, ok := s.(string); ok {
+				names = append(names, name)
+			}
+		}
+		return names
+	}
+	return []string{SourceStream}
+}
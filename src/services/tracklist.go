@@ -0,0 +1,152 @@
+package services
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gobuffalo/pop/v6"
+	"github.com/gofrs/uuid"
+	"github.com/jbhicks/sound-cistern/src/models"
+)
+
+// minTracklistEntries is how many timestamped lines a description needs
+// before it is treated as a tracklist. A single time in a description is
+// more often "live from 22:00" than a track.
+const minTracklistEntries = 2
+
+// TracklistEntry is one track parsed from a mix description
+type TracklistEntry struct {
+	Start  int // Seconds into the mix
+	Artist string
+	Title  string
+}
+
+var (
+	// timestampPattern matches 12:34 or 1:02:03, optionally in brackets
+	timestampPattern = `[\[(]?(\d{1,2}(?::\d{2}){1,2})[\])]?`
+	// listMarkerPattern matches bullets and track numbers such as "01." or "3)"
+	listMarkerPattern = `(?:[-*•]\s*)?(?:#?\d{1,3}[.)]\s+)?`
+
+	leadingTimestamp  = regexp.MustCompile(`^\s*` + listMarkerPattern + timestampPattern + `\s*[-–—|.:]?\s*(.+?)\s*$`)
+	trailingTimestamp = regexp.MustCompile(`^\s*` + listMarkerPattern + `(.+?)\s*[-–—|]?\s*` + timestampPattern + `\s*$`)
+	artistSeparator   = regexp.MustCompile(`\s+[-–—~]\s+`)
+)
+
+// ParseTracklist extracts timestamped tracklist entries from a description.
+// Timestamps may lead or trail each line, in m:ss or h:mm:ss form, with or
+// without brackets and track numbers. Artist and title are split on the
+// first dash; lines without one keep the whole text as the title.
+func ParseTracklist(description string) []TracklistEntry {
+	var entries []TracklistEntry
+	for _, line := range strings.Split(description, "\n") {
+		entry, ok := parseTracklistLine(line)
+		if ok {
+			entries = append(entries, entry)
+		}
+	}
+	if len(entries) < minTracklistEntries {
+		return nil
+	}
+	return entries
+}
+
+// parseTracklistLine parses one description line as a tracklist entry
+func parseTracklistLine(line string) (TracklistEntry, bool) {
+	var stamp, text string
+	if m := leadingTimestamp.FindStringSubmatch(line); m != nil {
+		stamp, text = m[1], m[2]
+	} else if m := trailingTimestamp.FindStringSubmatch(line); m != nil {
+		stamp, text = m[2], m[1]
+	} else {
+		return TracklistEntry{}, false
+	}
+
+	start, ok := parseTimestamp(stamp)
+	if !ok {
+		return TracklistEntry{}, false
+	}
+
+	text = strings.Trim(strings.TrimSpace(text), `"'`)
+	if text == "" {
+		return TracklistEntry{}, false
+	}
+
+	entry := TracklistEntry{Start: start, Title: text}
+	if parts := artistSeparator.Split(text, 2); len(parts) == 2 {
+		entry.Artist = strings.TrimSpace(parts[0])
+		entry.Title = strings.TrimSpace(parts[1])
+	}
+	return entry, true
+}
+
+// parseTimestamp converts m:ss or h:mm:ss into seconds
+func parseTimestamp(stamp string) (int, bool) {
+	parts := strings.Split(stamp, ":")
+	seconds := 0
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return 0, false
+		}
+		// Every part after the first is minutes or seconds
+		if i > 0 && n >= 60 {
+			return 0, false
+		}
+		seconds = seconds*60 + n
+	}
+	return seconds, true
+}
+
+// tracklistMatches reports whether any tracklist entry in a description
+// mentions query, ignoring case
+func tracklistMatches(description, query string) bool {
+	query = strings.ToLower(query)
+	for _, entry := range ParseTracklist(description) {
+		if strings.Contains(strings.ToLower(entry.Artist), query) || strings.Contains(strings.ToLower(entry.Title), query) {
+			return true
+		}
+	}
+	return false
+}
+
+// TracklistService stores tracklists parsed from track descriptions
+type TracklistService struct {
+	DB *pop.Connection
+}
+
+// NewTracklistService creates a new service
+func NewTracklistService(db *pop.Connection) *TracklistService {
+	return &TracklistService{DB: db}
+}
+
+// Extract replaces a stored track's tracklist with the one parsed from its
+// description and returns how many entries were found
+func (ts *TracklistService) Extract(track *models.Track) (int, error) {
+	if err := ts.DB.RawQuery("DELETE FROM tracklist_entries WHERE track_id = ?", track.ID).Exec(); err != nil {
+		return 0, err
+	}
+
+	entries := ParseTracklist(track.Description.String)
+	for i, entry := range entries {
+		err := ts.DB.Create(&models.TracklistEntry{
+			ID:       uuid.Must(uuid.NewV4()),
+			TrackID:  track.ID,
+			Position: i,
+			Start:    entry.Start,
+			Artist:   entry.Artist,
+			Title:    entry.Title,
+		})
+		if err != nil {
+			return i, err
+		}
+	}
+	return len(entries), nil
+}
+
+// Entries returns a stored track's tracklist in order
+func (ts *TracklistService) Entries(trackID uuid.UUID) (models.TracklistEntries, error) {
+	entries := models.TracklistEntries{}
+	err := ts.DB.Where("track_id = ?", trackID).Order("position asc").All(&entries)
+	return entries, err
+}
@@ -0,0 +1,51 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseTracklist(t *testing.T) {
+	description := `Recorded live at the warehouse, 3 hours of deep cuts.
+
+Tracklist:
+00:00 Burial - Archangel
+[04:32] Four Tet – Two Thousand And Seventeen
+01. (9:15) Floating Points — Silhouettes (I, II & III)
+1:02:03 | Aphex Twin - Xtal
+Unknown ID 1:15:40
+Objekt - Theme From Q [1:21:00]`
+
+	want := []TracklistEntry{
+		{Start: 0, Artist: "Burial", Title: "Archangel"},
+		{Start: 272, Artist: "Four Tet", Title: "Two Thousand And Seventeen"},
+		{Start: 555, Artist: "Floating Points", Title: "Silhouettes (I, II & III)"},
+		{Start: 3723, Artist: "Aphex Twin", Title: "Xtal"},
+		{Start: 4540, Title: "Unknown ID"},
+		{Start: 4860, Artist: "Objekt", Title: "Theme From Q"},
+	}
+
+	got := ParseTracklist(description)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %+v, got %+v", want, got)
+	}
+}
+
+func TestParseTracklistIgnoresStrayTimes(t *testing.T) {
+	if got := ParseTracklist("Live from the garden, doors at 22:00 - see you there"); got != nil {
+		t.Errorf("Expected a single time not to be a tracklist, got %+v", got)
+	}
+	if got := ParseTracklist("00:00 Intro\n99:99 Broken - Stamp"); got != nil {
+		t.Errorf("Expected invalid timestamps to be skipped, got %+v", got)
+	}
+}
+
+func TestTracklistMatches(t *testing.T) {
+	description := "00:00 Burial - Archangel\n04:32 Four Tet - Angel Echoes"
+	if !tracklistMatches(description, "four tet") {
+		t.Error("Expected artist search to match case-insensitively")
+	}
+	if tracklistMatches(description, "Aphex") {
+		t.Error("Expected no match for an artist not in the tracklist")
+	}
+}